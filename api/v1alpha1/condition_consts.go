@@ -18,6 +18,45 @@ const (
 
 	// LoadBalancerNotAvailableReason is used to indicate that the load balancer isn't available.
 	LoadBalancerNotAvailableReason = "LoadBalancerNotAvailable"
+
+	// LoadBalancerBackendsSyncedCondition indicates that the load balancer forwards traffic to the
+	// API servers of the current control plane machines.
+	LoadBalancerBackendsSyncedCondition clusterv1.ConditionType = "LoadBalancerBackendsSynced"
+
+	// LoadBalancerBackendsSyncFailedReason indicates that the backends couldn't be changed using the
+	// HAProxy runtime API.
+	LoadBalancerBackendsSyncFailedReason = "LoadBalancerBackendsSyncFailed"
+)
+
+const (
+	// ControlPlaneEndpointAllocatedCondition indicates that the host address of the control plane
+	// endpoint has been allocated from the IP address pool.
+	ControlPlaneEndpointAllocatedCondition clusterv1.ConditionType = "ControlPlaneEndpointAllocated"
+
+	// WaitingForIPAddressReason indicates that an IP address has been claimed from the pool but
+	// hasn't been allocated yet.
+	WaitingForIPAddressReason = "WaitingForIPAddress"
+
+	// IPAddressClaimFailedReason indicates that there was an error claiming an IP address from the pool.
+	IPAddressClaimFailedReason = "IPAddressClaimFailed"
+)
+
+const (
+	// CredentialsValidCondition indicates that the credentials for connecting to the flintlock
+	// hosts can be loaded and that the TLS certificates are valid.
+	CredentialsValidCondition clusterv1.ConditionType = "CredentialsValid"
+
+	// InvalidCredentialsReason indicates that a credentials secret is missing, incomplete or
+	// contains a certificate that isn't valid.
+	InvalidCredentialsReason = "InvalidCredentials"
+
+	// ClientCertificateNotExpiringCondition indicates that the client certificates for connecting to
+	// the flintlock hosts aren't about to expire. It's set to false with a warning severity when less
+	// than the warning window remains so that the certificates can be renewed before they expire.
+	ClientCertificateNotExpiringCondition clusterv1.ConditionType = "ClientCertificateNotExpiring"
+
+	// ClientCertificateExpiringReason indicates that a client certificate expires within the warning window.
+	ClientCertificateExpiringReason = "ClientCertificateExpiring"
 )
 
 const (
@@ -40,6 +79,18 @@ const (
 	// for reconciliation.
 	MicrovmUnknownStateReason = "MicrovmUnknownState"
 
+	// MACAddressAllocationFailedReason indicates that mac addresses couldn't be allocated to the
+	// network interfaces of the microvm or that a mac address is already in use on the host.
+	MACAddressAllocationFailedReason = "MACAddressAllocationFailed"
+
+	// MicrovmPlacementFailedReason indicates that the microvm couldn't be placed on a host, for example
+	// because the placement constraints don't allow it on any of the hosts.
+	MicrovmPlacementFailedReason = "MicrovmPlacementFailed"
+
+	// MicrovmMetadataTooLargeReason indicates that the metadata of the microvm, including the bootstrap
+	// data, is larger than the metadata service accepts.
+	MicrovmMetadataTooLargeReason = "MicrovmMetadataTooLarge"
+
 	// WaitingForClusterInfraReason indicates that the microvm reconciliation is waiting for
 	// the cluster infrastructure to be ready before proceeding.
 	WaitingForClusterInfraReason = "WaitingForClusterInfra"
@@ -48,3 +99,18 @@ const (
 	// to be available before proceeding.
 	WaitingForBootstrapDataReason = "WaitingForBoostrapData"
 )
+
+const (
+	// MicrovmMachinePoolReadyCondition indicates that the pool has the desired number of running microvms.
+	MicrovmMachinePoolReadyCondition clusterv1.ConditionType = "MicrovmMachinePoolReady"
+
+	// MicrovmMachinePoolScalingReason indicates that microvms are being added to or removed from the pool.
+	MicrovmMachinePoolScalingReason = "MicrovmMachinePoolScaling"
+
+	// MicrovmMachinePoolUpdatingReason indicates that microvms in the pool are being replaced
+	// because the pool spec has changed.
+	MicrovmMachinePoolUpdatingReason = "MicrovmMachinePoolUpdating"
+
+	// MicrovmMachinePoolDeletingReason indicates that the microvms of the pool are being deleted.
+	MicrovmMachinePoolDeletingReason = "MicrovmMachinePoolDeleting"
+)
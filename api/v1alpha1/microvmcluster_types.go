@@ -4,12 +4,18 @@
 package v1alpha1
 
 import (
-	flclient "github.com/liquidmetal-dev/controller-pkg/client"
 	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
+const (
+	// ClusterFinalizer allows ReconcileMicrovmCluster to clean up resources associated with MicrovmCluster
+	// before removing it from the apiserver.
+	ClusterFinalizer = "microvmcluster.infrastructure.cluster.x-k8s.io"
+)
+
 // MicrovmClusterSpec defines the desired state of MicrovmCluster.
 type MicrovmClusterSpec struct {
 	// ControlPlaneEndpoint represents the endpoint used to communicate with the control plane.
@@ -19,6 +25,12 @@ type MicrovmClusterSpec struct {
 	//
 	// +optional
 	ControlPlaneEndpoint clusterv1.APIEndpoint `json:"controlPlaneEndpoint"`
+	// ControlPlaneEndpointPoolRef is a reference to an IP address pool (for example an InClusterIPPool)
+	// that the host address of the ControlPlaneEndpoint will be claimed from using an IPAddressClaim.
+	// It's only used if the ControlPlaneEndpoint host isn't set. The port of the ControlPlaneEndpoint
+	// will default to 6443 if not set.
+	// +optional
+	ControlPlaneEndpointPoolRef *corev1.TypedLocalObjectReference `json:"controlPlaneEndpointPoolRef,omitempty"`
 	// SSHPublicKeys is a list of SSHPublicKeys and their associated users.
 	// If specified these keys will be applied to all machine created unless you
 	// specify different keys at the machine level.
@@ -27,9 +39,45 @@ type MicrovmClusterSpec struct {
 	// Placement specifies how machines for the cluster should be placed onto hosts (i.e. where the microvms are created).
 	// +kubebuilder:validation:Required
 	Placement Placement `json:"placement"`
+	// UserDataFragments are cloud-init user-data fragments that are added to the bootstrap data of all
+	// the machines of the cluster, for example to add CA certificates or sysctls. Fragments aren't
+	// supported with Ignition bootstrap data.
+	// +optional
+	UserDataFragments []UserDataFragment `json:"userDataFragments,omitempty"`
+	// HostnameTemplate is a Go template for the hostname of the machines of the cluster, including
+	// the microvms of machine pools. The fields .ClusterName, .MachineName, .Namespace and
+	// .FailureDomain can be used in the template, for example "{{ .ClusterName }}-{{ .MachineName }}".
+	// The machine name, or the name of the pool microvm, is used if it's not set.
+	// +optional
+	HostnameTemplate string `json:"hostnameTemplate,omitempty"`
+	// Metadata are additional keys that are added to the cloud-init meta-data of the machines of the
+	// cluster, including the microvms of machine pools, where they can be read by scripts in the guest using ds.meta_data.<key>. The values
+	// are Go templates that can use the same fields as HostnameTemplate. The keys instance_id,
+	// local_hostname, platform and vm_host are reserved.
+	// +optional
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// CompressUserData enables gzip compression of the cloud-init user-data of the machines, which
+	// reduces the size of large bootstrap configurations. The compressed user-data is sent in an
+	// application/x-gzip MIME part that cloud-init decompresses. Ignition configs aren't compressed.
+	// +optional
+	CompressUserData bool `json:"compressUserData,omitempty"`
+	// MACAddressPrefix is the prefix of the mac addresses that will be allocated to the network interfaces
+	// of the microvms that don't specify a mac address. It can be between 1 and 5 octets and can be
+	// overridden for each host. It must be a locally administered unicast prefix, i.e. the first octet has
+	// the 0x02 bit set and the 0x01 bit clear. If not set the prefix 02 is used. The allocated addresses
+	// are only recorded for this cluster, so clusters that share hosts should use different prefixes
+	// if the prefix leaves few random bits.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[0-9a-fA-F]{2}(:[0-9a-fA-F]{2}){0,4}$`
+	MACAddressPrefix string `json:"macAddressPrefix,omitempty"`
 	// MicrovmProxy is the proxy server details to use when calling the microvm service. This is an
 	// alteranative to using the http proxy environment variables and applied purely to the grpc service.
-	MicrovmProxy *flclient.Proxy `json:"microvmProxy,omitempty"`
+	// It can be overridden for each host.
+	MicrovmProxy *MicrovmProxy `json:"microvmProxy,omitempty"`
+	// Auth configures how to authenticate with the microvm service. If it's not set the tokens in the
+	// BasicAuthSecret of the static pool, or the basic auth secret of the identity, are used.
+	// +optional
+	Auth *HostAuth `json:"auth,omitempty"`
 
 	// mTLS Configuration:
 	//
@@ -64,6 +112,16 @@ type MicrovmClusterSpec struct {
 	// 		-----END CERTIFICATE-----
 	// +optional
 	TLSSecretRef string `json:"tlsSecretRef,omitempty"`
+	// IdentityRef is a reference to a MicrovmClusterIdentity that holds the credentials for connecting
+	// to the flintlock hosts. It can't be used with TLSSecretRef or the BasicAuthSecret of the static
+	// pool, and the namespace of the cluster must be allowed to use the identity.
+	// +optional
+	IdentityRef *MicrovmClusterIdentityReference `json:"identityRef,omitempty"`
+	// LoadBalancer is the configuration of a load balancer for the control plane that will be
+	// created and managed by the provider. If not supplied then you must provide your own load
+	// balancer for the ControlPlaneEndpoint (for example by using kube-vip).
+	// +optional
+	LoadBalancer *LoadBalancerSpec `json:"loadBalancer,omitempty"`
 }
 
 type SSHPublicKey struct {
@@ -89,6 +147,17 @@ type MicrovmClusterStatus struct {
 	// FailureDomains is a list of the failure domains that CAPI should spread the machines across. For
 	// the CAPMVM provider this equates to host machines that can run microvms using Flintlock.
 	FailureDomains clusterv1.FailureDomains `json:"failureDomains,omitempty"`
+
+	// LoadBalancer is the observed state of the control plane load balancer if it's managed
+	// by the provider.
+	// +optional
+	LoadBalancer *LoadBalancerStatus `json:"loadBalancer,omitempty"`
+
+	// CredentialsVersion is a hash of the versions of the secrets with the credentials for connecting
+	// to the hosts. It changes when the credentials are rotated, which reconciles the machines of the
+	// cluster so that they use the new credentials.
+	// +optional
+	CredentialsVersion string `json:"credentialsVersion,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,85 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MicrovmClusterIdentityKind is the kind of the MicrovmClusterIdentity resource.
+const MicrovmClusterIdentityKind = "MicrovmClusterIdentity"
+
+// MicrovmClusterIdentitySpec defines the credentials for connecting to flintlock hosts that are
+// shared by the MicrovmClusters in the allowed namespaces.
+type MicrovmClusterIdentitySpec struct {
+	// AllowedNamespaces is used to identify which namespaces are allowed to use the identity. If it's
+	// nil no namespaces can use the identity, and if it's empty all the namespaces can use it.
+	// +optional
+	AllowedNamespaces *AllowedNamespaces `json:"allowedNamespaces,omitempty"`
+
+	// BasicAuthSecretRef is a reference to a secret that contains the basic auth tokens for the
+	// hosts, keyed by the host address without the port. The format is the same as the secret
+	// referenced by the BasicAuthSecret of a static pool.
+	// +optional
+	BasicAuthSecretRef *corev1.SecretReference `json:"basicAuthSecretRef,omitempty"`
+
+	// TLSSecretRef is a reference to a secret that contains the tls.crt, tls.key and ca.crt used to
+	// connect to the hosts with TLS. The format is the same as the secret referenced by the
+	// TLSSecretRef of a MicrovmCluster.
+	// +optional
+	TLSSecretRef *corev1.SecretReference `json:"tlsSecretRef,omitempty"`
+}
+
+// AllowedNamespaces are the namespaces that are allowed to use an identity. A namespace is allowed
+// if it's in the list or matches the selector.
+type AllowedNamespaces struct {
+	// NamespaceList is a list of the names of the allowed namespaces.
+	// +optional
+	NamespaceList []string `json:"list,omitempty"`
+
+	// Selector is a label selector for the allowed namespaces. An empty selector matches all the
+	// namespaces.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// MicrovmClusterIdentityReference is a reference to the identity used to connect to the flintlock hosts.
+type MicrovmClusterIdentityReference struct {
+	// Kind of the identity.
+	// +kubebuilder:validation:Enum=MicrovmClusterIdentity
+	Kind string `json:"kind"`
+
+	// Name of the identity.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+// +kubebuilder:resource:path=microvmclusteridentities,scope=Cluster,categories=cluster-api,shortName=mvmci
+
+// MicrovmClusterIdentity is the Schema for the microvmclusteridentities API. It allows the
+// credentials for the flintlock hosts to be shared by clusters in different namespaces without
+// copying the secrets into each namespace.
+type MicrovmClusterIdentity struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MicrovmClusterIdentitySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MicrovmClusterIdentityList contains a list of MicrovmClusterIdentity.
+type MicrovmClusterIdentityList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MicrovmClusterIdentity `json:"items"`
+}
+
+//nolint:gochecknoinits // Maybe we can remove it, now just ignore.
+func init() {
+	SchemeBuilder.Register(&MicrovmClusterIdentity{}, &MicrovmClusterIdentityList{})
+}
@@ -14,6 +14,9 @@ const (
 	// MachineFinalizer allows ReconcileMicrovmMachine to clean up resources associated with MicrovmMachine
 	// before removing it from the apiserver.
 	MachineFinalizer = "microvmmachine.infrastructure.cluster.x-k8s.io"
+
+	// HostLabel is the label that is applied to the Node of a machine to identify the host its microvm is on.
+	HostLabel = "microvm.liquidmetal.dev/host"
 )
 
 // MicrovmMachineSpec defines the desired state of MicrovmMachine.
@@ -27,6 +30,34 @@ type MicrovmMachineSpec struct {
 	// +optional
 	SSHPublicKeys []microvm.SSHPublicKey `json:"sshPublicKeys,omitempty"`
 
+	// UserDataFragments are cloud-init user-data fragments that are added to the bootstrap data of the
+	// machine after any fragments from the cluster.
+	// +optional
+	UserDataFragments []UserDataFragment `json:"userDataFragments,omitempty"`
+
+	// HostnameTemplate is a Go template for the hostname of the machine. It takes precedence over the
+	// template for the cluster.
+	// +optional
+	HostnameTemplate string `json:"hostnameTemplate,omitempty"`
+
+	// Metadata are additional keys that are added to the cloud-init meta-data of the machine. They
+	// are merged with the metadata for the cluster and take precedence over it.
+	// +optional
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// NetworkInterfaceAddressPools references the IP address pools that static addresses will be claimed
+	// from for the network interfaces of the microvm. The addresses are claimed using IPAddressClaims
+	// before the microvm is created and are released when the machine is deleted.
+	// +optional
+	// +listType=map
+	// +listMapKey=guestDeviceName
+	NetworkInterfaceAddressPools []NetworkInterfaceAddressPool `json:"networkInterfaceAddressPools,omitempty"`
+
+	// AntiAffinity spreads the machines of a group, for example the replicas of a MachineDeployment,
+	// across the hosts. The machine is placed on the host with the fewest machines of its group.
+	// +optional
+	AntiAffinity *AntiAffinity `json:"antiAffinity,omitempty"`
+
 	// ProviderID is the unique identifier as specified by the cloud provider.
 	ProviderID *string `json:"providerID,omitempty"`
 }
@@ -44,6 +75,17 @@ type MicrovmMachineStatus struct {
 	// Addresses contains the microvm associated addresses.
 	Addresses []clusterv1.MachineAddress `json:"addresses,omitempty"`
 
+	// FailureDomain is the failure domain (i.e. host) that the microvm is placed on. It's recorded
+	// as soon as the host is chosen and is used from then on, even if the failure domains of the
+	// cluster change.
+	// +optional
+	FailureDomain string `json:"failureDomain,omitempty"`
+
+	// NetworkInterfaceMACs are the mac addresses allocated to the network interfaces that don't specify
+	// a mac address, keyed by the guest device name.
+	// +optional
+	NetworkInterfaceMACs map[string]string `json:"networkInterfaceMacs,omitempty"`
+
 	// FailureReason will be set in the event that there is a terminal problem
 	// reconciling the Machine and will contain a succinct value suitable
 	// for machine interpretation.
@@ -89,6 +131,7 @@ type MicrovmMachineStatus struct {
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="FailureDomain",type="string",JSONPath=".status.failureDomain",description="Host the microvm is placed on"
 //+k8s:defaulter-gen=true
 
 // MicrovmMachine is the Schema for the microvmmachines API.
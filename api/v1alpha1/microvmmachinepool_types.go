@@ -0,0 +1,108 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package v1alpha1
+
+import (
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/errors"
+)
+
+const (
+	// MachinePoolFinalizer allows ReconcileMicrovmMachinePool to clean up the microvms of the pool
+	// before removing it from the apiserver.
+	MachinePoolFinalizer = "microvmmachinepool.infrastructure.cluster.x-k8s.io"
+)
+
+// MicrovmMachinePoolSpec defines the desired state of MicrovmMachinePool.
+type MicrovmMachinePoolSpec struct {
+	// VMSpec is the spec used for every microvm in the pool. Changing it will cause the
+	// existing microvms to be replaced one at a time.
+	microvm.VMSpec `json:",inline"`
+
+	// SSHPublicKeys is list of SSH public keys that will be used with stated users
+	// on the microvms of this pool.
+	// If specified they will take precedence over any SSH keys specified at
+	// the cluster level.
+	// +optional
+	SSHPublicKeys []microvm.SSHPublicKey `json:"sshPublicKeys,omitempty"`
+
+	// ProviderIDList is the list of the provider ids of the microvms in the pool.
+	// +optional
+	ProviderIDList []string `json:"providerIDList,omitempty"`
+}
+
+// MicrovmMachinePoolStatus defines the observed state of MicrovmMachinePool.
+type MicrovmMachinePoolStatus struct {
+	// Ready is true when the pool has the desired number of running microvms.
+	// +optional
+	// +kubebuilder:default=false
+	Ready bool `json:"ready"`
+
+	// Replicas is the most recently observed number of running microvms in the pool.
+	// +optional
+	Replicas int32 `json:"replicas"`
+
+	// Instances contains the details of the microvms that belong to the pool.
+	// +optional
+	Instances []MicrovmInstance `json:"instances,omitempty"`
+
+	// FailureReason will be set in the event that there is a terminal problem
+	// reconciling the MachinePool and will contain a succinct value suitable
+	// for machine interpretation.
+	// +optional
+	FailureReason *errors.MachineStatusError `json:"failureReason,omitempty"`
+
+	// FailureMessage will be set in the event that there is a terminal problem
+	// reconciling the MachinePool and will contain a more verbose string suitable
+	// for logging and human consumption.
+	// +optional
+	FailureMessage *string `json:"failureMessage,omitempty"`
+
+	// Conditions defines current service state of the MicrovmMachinePool.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:resource:path=microvmmachinepools,scope=Namespaced,categories=cluster-api,shortName=mvmmp
+// +kubebuilder:printcolumn:name="Cluster",type="string",JSONPath=".metadata.labels.cluster\\.x-k8s\\.io/cluster-name",description="Cluster to which this MicrovmMachinePool belongs"
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".status.replicas",description="Number of running microvms"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.ready",description="Machine pool is ready"
+
+// MicrovmMachinePool is the Schema for the microvmmachinepools API.
+type MicrovmMachinePool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MicrovmMachinePoolSpec   `json:"spec,omitempty"`
+	Status MicrovmMachinePoolStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the observations of the operational state of the MicrovmMachinePool resource.
+func (r *MicrovmMachinePool) GetConditions() clusterv1.Conditions {
+	return r.Status.Conditions
+}
+
+// SetConditions sets the underlying service state of the MicrovmMachinePool to the predescribed clusterv1.Conditions.
+func (r *MicrovmMachinePool) SetConditions(conditions clusterv1.Conditions) {
+	r.Status.Conditions = conditions
+}
+
+//+kubebuilder:object:root=true
+
+// MicrovmMachinePoolList contains a list of MicrovmMachinePool.
+type MicrovmMachinePoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MicrovmMachinePool `json:"items"`
+}
+
+//nolint:gochecknoinits // Maybe we can remove it, now just ignore.
+func init() {
+	SchemeBuilder.Register(&MicrovmMachinePool{}, &MicrovmMachinePoolList{})
+}
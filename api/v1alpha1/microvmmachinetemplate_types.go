@@ -4,17 +4,53 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // MicrovmMachineTemplateSpec defines the desired state of MicrovmMachineTemplate.
 type MicrovmMachineTemplateSpec struct {
 	Template MicrovmMachineTemplateResource `json:"template"`
+
+	// NodeLabels are the labels of the nodes of the machines created from the template, which the
+	// cluster autoscaler uses when scaling MachineDeployments from zero. They're published with the
+	// capacity.cluster-autoscaler.kubernetes.io/labels annotation on the MachineDeployments using the
+	// template. The labels aren't added to the nodes, they must also be set in the bootstrap config.
+	// +optional
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+
+	// NodeTaints are the taints of the nodes of the machines created from the template, which the
+	// cluster autoscaler uses when scaling MachineDeployments from zero. They're published with the
+	// capacity.cluster-autoscaler.kubernetes.io/taints annotation on the MachineDeployments using the
+	// template. The taints aren't added to the nodes, they must also be set in the bootstrap config.
+	// +optional
+	NodeTaints []corev1.Taint `json:"nodeTaints,omitempty"`
+}
+
+// MicrovmMachineTemplateStatus defines the observed state of MicrovmMachineTemplate. It's used by the
+// cluster autoscaler to scale MachineDeployments from zero.
+type MicrovmMachineTemplateStatus struct {
+	// Capacity is the resources of the machines created from the template, which is the cpu and
+	// memory of the microvms.
+	// +optional
+	Capacity corev1.ResourceList `json:"capacity,omitempty"`
+
+	// NodeInfo is information about the nodes of the machines created from the template.
+	// +optional
+	NodeInfo *NodeInfo `json:"nodeInfo,omitempty"`
+}
+
+// NodeInfo is information about the nodes of the machines created from a template.
+type NodeInfo struct {
+	// OperatingSystem is the operating system of the nodes.
+	// +optional
+	OperatingSystem string `json:"operatingSystem,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:storageversion
 // +kubebuilder:resource:path=microvmmachinetemplates,scope=Namespaced,categories=cluster-api,shortName=mvmmt
+// +kubebuilder:subresource:status
 // +k8s:defaulter-gen=true
 
 // MicrovmMachineTemplate is the Schema for the microvmmachinetemplates API.
@@ -22,7 +58,8 @@ type MicrovmMachineTemplate struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec MicrovmMachineTemplateSpec `json:"spec,omitempty"`
+	Spec   MicrovmMachineTemplateSpec   `json:"spec,omitempty"`
+	Status MicrovmMachineTemplateStatus `json:"status,omitempty"`
 }
 
 //+kubebuilder:object:root=true
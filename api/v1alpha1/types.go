@@ -4,6 +4,12 @@
 package v1alpha1
 
 import (
+	"fmt"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
@@ -55,12 +61,50 @@ type StaticPoolPlacement struct {
 	// 	1.2.4.5: YWRtaW4=
 	// 	myhost: MWYyZDFlMmU2N2Rm
 	BasicAuthSecret string `json:"basicAuthSecret,omitempty"`
+	// MaxControlPlanePerHost is the maximum number of control plane machines of the cluster that
+	// can be placed on a host. There's no limit if it's not set.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxControlPlanePerHost *int32 `json:"maxControlPlanePerHost,omitempty"`
+	// ControlPlaneHostSelector selects the hosts that control plane machines can be placed on using
+	// the labels of the hosts. It's applied to the hosts that have ControlPlaneAllowed set.
+	// +optional
+	ControlPlaneHostSelector *metav1.LabelSelector `json:"controlPlaneHostSelector,omitempty"`
+	// SeparateWorkers keeps the worker machines off the hosts that control plane machines can be
+	// placed on, unless all the other hosts are full.
+	// +optional
+	SeparateWorkers bool `json:"separateWorkers,omitempty"`
+}
+
+// AllowsControlPlane returns true if control plane machines can be placed on the host.
+func (p *StaticPoolPlacement) AllowsControlPlane(host MicrovmHost) (bool, error) {
+	if !host.ControlPlaneAllowed {
+		return false, nil
+	}
+
+	if p.ControlPlaneHostSelector == nil {
+		return true, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(p.ControlPlaneHostSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid control plane host selector: %w", err)
+	}
+
+	return selector.Matches(labels.Set(host.Labels)), nil
 }
 
 type MicrovmHost struct {
-	// Name is an optional name for the host.
+	// Name is an optional name for the host. It's applied to the Nodes of the machines on the host
+	// with the microvm.liquidmetal.dev/host label, which is the endpoint if it's not set.
 	// +optional
 	Name string `json:"name,omitempty"`
+	// Zone is the zone the host is in, for example its rack or data centre. It's applied to the
+	// Nodes of the machines on the host with the topology.kubernetes.io/zone label.
+	// +optional
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?$`
+	Zone string `json:"zone,omitempty"`
 	// Endpoint is the API endpoint for the microvm service (i.e. flintlock)
 	// including the port.
 	// +kubebuilder:validation:Required
@@ -69,6 +113,175 @@ type MicrovmHost struct {
 	// addition to worker nodes.
 	// +kubebuilder:default=true
 	ControlPlaneAllowed bool `json:"controlplaneAllowed"`
+	// Labels are used to select the host in the placement of the machines.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// MaxMachines is the maximum number of machines of the cluster that can be placed on this host.
+	// There's no limit if it's not set.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxMachines *int32 `json:"maxMachines,omitempty"`
+	// MACAddressPrefix overrides the prefix of the mac addresses allocated to the microvms created on this
+	// host. It must be a locally administered unicast prefix.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[0-9a-fA-F]{2}(:[0-9a-fA-F]{2}){0,4}$`
+	MACAddressPrefix string `json:"macAddressPrefix,omitempty"`
+	// TLS overrides the TLS configuration of the cluster when connecting to this host.
+	// +optional
+	TLS *HostTLSConfig `json:"tls,omitempty"`
+	// Proxy overrides the MicrovmProxy of the cluster when connecting to this host. The NoProxy list
+	// of the cluster isn't applied to the host when it's set.
+	// +optional
+	Proxy *MicrovmProxy `json:"proxy,omitempty"`
+}
+
+// MicrovmProxy is a HTTP proxy that supports CONNECT that is used to connect to the microvm service.
+type MicrovmProxy struct {
+	// Endpoint is the URL of the proxy, for example http://proxy.example.com:3128. An https URL
+	// connects to the proxy using TLS, which is verified with the system CAs.
+	// +kubebuilder:validation:Required
+	Endpoint string `json:"endpoint"`
+	// CredentialsSecretRef is the name of a secret in the namespace of the cluster that contains the
+	// username and password keys used to authenticate with the proxy using basic auth.
+	// +optional
+	CredentialsSecretRef string `json:"credentialsSecretRef,omitempty"`
+	// NoProxy is a list of hosts that are connected to directly instead of through the proxy. An entry
+	// can be a host name or IP address, optionally with a port, a domain that also matches its
+	// subdomains (for example example.com or .example.com), a CIDR or * to match all hosts.
+	// +optional
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
+// HostTLSConfig overrides the TLS configuration used to connect to a single host. Anything that
+// isn't set falls back to the TLSSecretRef of the cluster, or the TLS secret of its identity.
+type HostTLSConfig struct {
+	// SecretRef is the name of a secret in the namespace of the cluster that contains the client
+	// cert and key (tls.crt and tls.key) and the CA (ca.crt) used for this host. It has the same
+	// format as the TLSSecretRef of the cluster and replaces it for this host.
+	// +optional
+	SecretRef string `json:"secretRef,omitempty"`
+	// CASecretRef is the name of a secret in the namespace of the cluster that contains the CA
+	// (ca.crt) used to verify the certificate of this host. It takes precedence over the CA in
+	// SecretRef or the TLSSecretRef of the cluster.
+	// +optional
+	CASecretRef string `json:"caSecretRef,omitempty"`
+	// ServerName is the name used to verify the certificate of this host. It defaults to the host
+	// of the Endpoint and is needed when the endpoint is an IP address that isn't in the certificate.
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// AntiAffinityType is how the machines are grouped for anti-affinity.
+// +kubebuilder:validation:Enum=MachineDeployment;LabelKey
+type AntiAffinityType string
+
+const (
+	// AntiAffinityMachineDeployment groups the machines by their MachineDeployment.
+	AntiAffinityMachineDeployment AntiAffinityType = "MachineDeployment"
+	// AntiAffinityLabelKey groups the machines by the value of a label.
+	AntiAffinityLabelKey AntiAffinityType = "LabelKey"
+)
+
+// AntiAffinity configures the group of machines that a machine is spread across the hosts with.
+type AntiAffinity struct {
+	// Type is how the machines are grouped.
+	// +kubebuilder:validation:Required
+	Type AntiAffinityType `json:"type"`
+	// LabelKey is the key of the label of the machines whose value is the group. It's required
+	// with the LabelKey type.
+	// +optional
+	LabelKey string `json:"labelKey,omitempty"`
+}
+
+// HostAuthType is a method of authenticating with the microvm service.
+// +kubebuilder:validation:Enum=BasicAuth;ServiceAccountToken;TokenExchange;MTLS
+type HostAuthType string
+
+const (
+	// HostAuthBasic sends the static token for the host from the basic auth secret.
+	HostAuthBasic HostAuthType = "BasicAuth"
+	// HostAuthServiceAccountToken sends a token of the service account of the controller as a
+	// bearer token. The audience of the token is the endpoint of the host, so a token can't be
+	// replayed to other hosts.
+	HostAuthServiceAccountToken HostAuthType = "ServiceAccountToken"
+	// HostAuthTokenExchange exchanges a token of the service account of the controller, whose
+	// audience is the token exchange endpoint, for a short-lived token at the endpoint and sends
+	// that as a bearer token. The audience requested for the exchanged token is the endpoint of
+	// the host.
+	HostAuthTokenExchange HostAuthType = "TokenExchange"
+	// HostAuthMTLS only uses the client certificate to authenticate, so TLS must be configured.
+	HostAuthMTLS HostAuthType = "MTLS"
+)
+
+// HostAuth configures how to authenticate with the microvm service on the hosts.
+type HostAuth struct {
+	// Type is the method used to authenticate.
+	// +kubebuilder:default=BasicAuth
+	Type HostAuthType `json:"type"`
+	// TokenExchange is the endpoint used to exchange the service account token with the
+	// TokenExchange type.
+	// +optional
+	TokenExchange *TokenExchangeSource `json:"tokenExchange,omitempty"`
+}
+
+// TokenExchangeSource describes an OAuth 2.0 token exchange (RFC 8693) endpoint.
+type TokenExchangeSource struct {
+	// Endpoint is the URL of the token exchange endpoint.
+	// +kubebuilder:validation:MinLength=1
+	Endpoint string `json:"endpoint"`
+}
+
+// AuthType returns the method used to authenticate, which defaults to basic auth.
+func (a *HostAuth) AuthType() HostAuthType {
+	if a == nil || a.Type == "" {
+		return HostAuthBasic
+	}
+
+	return a.Type
+}
+
+// LoadBalancerSpec is the configuration of a load balancer for the control plane. The load balancer
+// is made up of microvms running HAProxy, which forwards traffic to the API servers of the control
+// plane machines, and keepalived, which assigns the ControlPlaneEndpoint host address to one of the
+// load balancer microvms.
+//
+// The addresses of the API servers are changed on the running load balancer using the HAProxy
+// runtime API, which is reached through the ControlPlaneEndpoint host address and secured with
+// certificates that are stored in a secret owned by the MicrovmCluster. The load balancer microvms
+// are only replaced when the rest of the spec changes.
+type LoadBalancerSpec struct {
+	// Replicas is the number of load balancer microvms to create. If there are 2 then the
+	// ControlPlaneEndpoint host address will move between them if one fails.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=2
+	// +kubebuilder:default=1
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// APIServerPort is the port the API servers of the control plane machines are listening on.
+	// +kubebuilder:default=6443
+	// +optional
+	APIServerPort int32 `json:"apiServerPort,omitempty"`
+	// RuntimeAPIPort is the port of the HAProxy runtime API on the load balancer microvms, which
+	// must be reachable from the management cluster.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default=9999
+	// +optional
+	RuntimeAPIPort int32 `json:"runtimeAPIPort,omitempty"`
+	// Microvm is the spec of the load balancer microvms. The root volume image must have
+	// HAProxy and keepalived installed.
+	// +kubebuilder:validation:Required
+	Microvm microvm.VMSpec `json:"microvm"`
+}
+
+// LoadBalancerStatus is the observed state of the control plane load balancer.
+type LoadBalancerStatus struct {
+	// Backends are the addresses of the API servers the load balancer was last synced with.
+	// +optional
+	Backends []string `json:"backends,omitempty"`
+	// Instances contains the details of the load balancer microvms.
+	// +optional
+	Instances []MicrovmInstance `json:"instances,omitempty"`
 }
 
 // TLSConfig represents config for connecting to TLS enabled hosts.
@@ -77,3 +290,74 @@ type TLSConfig struct {
 	Key    []byte `json:"key"`
 	CACert []byte `json:"caCert"`
 }
+
+// MicrovmInstance describes a single microvm that is managed as one of a group of identical
+// microvms, for example the microvms of a machine pool.
+type MicrovmInstance struct {
+	// Name is the name of the microvm.
+	Name string `json:"name"`
+
+	// FailureDomain is the failure domain (i.e. host) that the microvm was created on.
+	FailureDomain string `json:"failureDomain"`
+
+	// NetworkInterfaceMACs are the mac addresses allocated to the network interfaces of the microvm
+	// that don't specify one, keyed by the guest device name.
+	// +optional
+	NetworkInterfaceMACs map[string]string `json:"networkInterfaceMacs,omitempty"`
+
+	// Ordinal is the position of the microvm in the group. It's unique amongst the microvms of the
+	// group that aren't being deleted.
+	// +optional
+	Ordinal int32 `json:"ordinal,omitempty"`
+
+	// UID is the unique identifier of the microvm as assigned by flintlock.
+	// +optional
+	UID string `json:"uid,omitempty"`
+
+	// SpecHash is the hash of the spec that the microvm was created from. It is used
+	// to determine if the microvm needs replacing.
+	SpecHash string `json:"specHash"`
+
+	// VMState indicates the state of the microvm.
+	// +optional
+	VMState *microvm.VMState `json:"vmState,omitempty"`
+
+	// Ready is true when the microvm is running.
+	// +optional
+	Ready bool `json:"ready"`
+
+	// Deleting is true when the microvm has been removed from the group and is being deleted.
+	// +optional
+	Deleting bool `json:"deleting,omitempty"`
+}
+
+// NetworkInterfaceAddressPool references the IP address pool to allocate the static address of a
+// network interface from.
+type NetworkInterfaceAddressPool struct {
+	// GuestDeviceName is the name of the network interface in the microvm that the address is for.
+	// +kubebuilder:validation:Required
+	GuestDeviceName string `json:"guestDeviceName"`
+	// PoolRef is a reference to the pool (for example an InClusterIPPool) to claim the address from.
+	// +kubebuilder:validation:Required
+	PoolRef corev1.TypedLocalObjectReference `json:"poolRef"`
+	// Nameservers are the nameservers to configure for the network interface.
+	// +optional
+	Nameservers []string `json:"nameservers,omitempty"`
+}
+
+// UserDataFragment references a cloud-init user-data fragment that is combined with the bootstrap data
+// in a MIME multi-part archive. Exactly one of SecretRef and ConfigMapRef must be set and the referenced
+// object must be in the same namespace.
+type UserDataFragment struct {
+	// SecretRef selects the key of a Secret that contains the fragment.
+	// +optional
+	SecretRef *corev1.SecretKeySelector `json:"secretRef,omitempty"`
+	// ConfigMapRef selects the key of a ConfigMap that contains the fragment.
+	// +optional
+	ConfigMapRef *corev1.ConfigMapKeySelector `json:"configMapRef,omitempty"`
+	// ContentType is the MIME type of the fragment.
+	// +kubebuilder:validation:Enum=text/cloud-config;text/x-shellscript;text/cloud-boothook
+	// +kubebuilder:default=text/cloud-config
+	// +optional
+	ContentType string `json:"contentType,omitempty"`
+}
@@ -4,9 +4,128 @@
 package v1alpha1
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
+const maxMACAddressPrefixLength = 5
+
+var (
+	errInvalidMACAddressPrefix   = errors.New("mac address prefix must be between 1 and 5 octets separated by colons")
+	errMulticastMACAddressPrefix = errors.New("mac address prefix must be for unicast addresses")
+	errGlobalMACAddressPrefix    = errors.New("mac address prefix must be locally administered")
+)
+
+// reservedMetadataKeys are the instance meta-data keys that are set by the provider and flintlock.
+var reservedMetadataKeys = map[string]bool{
+	"instance_id":    true,
+	"local_hostname": true,
+	"platform":       true,
+	"vm_host":        true,
+}
+
+// metadataKeyPattern matches keys that can be used as ds.meta_data.<key> in cloud-init templates.
+var metadataKeyPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// InstanceTemplateValues are the fields that can be used in the hostname and metadata templates.
+type InstanceTemplateValues struct {
+	ClusterName   string
+	MachineName   string
+	Namespace     string
+	FailureDomain string
+}
+
+// RenderInstanceTemplate renders a hostname or metadata template with the values for a machine.
+func RenderInstanceTemplate(text string, values InstanceTemplateValues) (string, error) {
+	tmpl, err := template.New("instance").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing template: %w", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, values); err != nil {
+		return "", fmt.Errorf("rendering template: %w", err)
+	}
+
+	return rendered.String(), nil
+}
+
+// IsReservedMetadataKey returns true if the instance meta-data key is set by the provider or flintlock.
+func IsReservedMetadataKey(key string) bool {
+	return reservedMetadataKeys[key]
+}
+
+func validateInstanceMetadata(fieldPath *field.Path, hostnameTemplate string, metadata map[string]string) []*field.Error {
+	var errs field.ErrorList
+
+	if hostnameTemplate != "" {
+		if _, err := RenderInstanceTemplate(hostnameTemplate, InstanceTemplateValues{}); err != nil {
+			errs = append(errs, field.Invalid(fieldPath.Child("hostnameTemplate"), hostnameTemplate, err.Error()))
+		}
+	}
+
+	for key, value := range metadata {
+		keyPath := fieldPath.Child("metadata").Key(key)
+
+		switch {
+		case IsReservedMetadataKey(key):
+			errs = append(errs, field.Forbidden(keyPath, "the key is reserved"))
+		case !metadataKeyPattern.MatchString(key):
+			errs = append(errs, field.Invalid(keyPath, key, "keys must contain only letters, digits and underscores"))
+		default:
+			if _, err := RenderInstanceTemplate(value, InstanceTemplateValues{}); err != nil {
+				errs = append(errs, field.Invalid(keyPath, value, err.Error()))
+			}
+		}
+	}
+
+	return errs
+}
+
+// ParseMACAddressPrefix parses a mac address prefix such as 02:00:00 into its octets. The first octet
+// must have the locally administered bit (0x02) set and the multicast bit (0x01) clear, as the
+// allocated mac addresses would otherwise be multicast or could clash with a vendor's addresses.
+func ParseMACAddressPrefix(prefix string) ([]byte, error) {
+	octets := strings.Split(prefix, ":")
+	if len(octets) > maxMACAddressPrefixLength {
+		return nil, errInvalidMACAddressPrefix
+	}
+
+	parsed := make([]byte, 0, len(octets))
+
+	for _, octet := range octets {
+		b, err := hex.DecodeString(octet)
+		if err != nil || len(b) != 1 {
+			return nil, errInvalidMACAddressPrefix
+		}
+
+		parsed = append(parsed, b[0])
+	}
+
+	if parsed[0]&1 == 1 {
+		return nil, errMulticastMACAddressPrefix
+	}
+
+	if parsed[0]&2 == 0 {
+		return nil, errGlobalMACAddressPrefix
+	}
+
+	return parsed, nil
+}
+
 func (p *Placement) Validate() []*field.Error {
 	var errs field.ErrorList
 
@@ -14,6 +133,266 @@ func (p *Placement) Validate() []*field.Error {
 	if p.StaticPool == nil {
 		fieldPath := field.NewPath("spec", "placement")
 		errs = append(errs, field.Forbidden(fieldPath, "you must supply configuration for a placement option"))
+
+		return errs
+	}
+
+	if p.StaticPool.ControlPlaneHostSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(p.StaticPool.ControlPlaneHostSelector); err != nil {
+			fieldPath := field.NewPath("spec", "placement", "staticPool", "controlPlaneHostSelector")
+			errs = append(errs, field.Invalid(fieldPath, p.StaticPool.ControlPlaneHostSelector, err.Error()))
+		}
+	}
+
+	for i, host := range p.StaticPool.Hosts {
+		if host.MACAddressPrefix == "" {
+			continue
+		}
+
+		if _, err := ParseMACAddressPrefix(host.MACAddressPrefix); err != nil {
+			fieldPath := field.NewPath("spec", "placement", "staticPool", "hosts").Index(i).Child("macAddressPrefix")
+			errs = append(errs, field.Invalid(fieldPath, host.MACAddressPrefix, err.Error()))
+		}
+	}
+
+	return errs
+}
+
+func (c *MicrovmClusterSpec) ValidateMACAddressPrefix() []*field.Error {
+	var errs field.ErrorList
+
+	if c.MACAddressPrefix == "" {
+		return errs
+	}
+
+	if _, err := ParseMACAddressPrefix(c.MACAddressPrefix); err != nil {
+		fieldPath := field.NewPath("spec", "macAddressPrefix")
+		errs = append(errs, field.Invalid(fieldPath, c.MACAddressPrefix, err.Error()))
+	}
+
+	return errs
+}
+
+func (c *MicrovmClusterSpec) ValidateInstanceMetadata() []*field.Error {
+	return validateInstanceMetadata(field.NewPath("spec"), c.HostnameTemplate, c.Metadata)
+}
+
+func (c *MicrovmClusterSpec) ValidateIdentityRef() []*field.Error {
+	var errs field.ErrorList
+
+	if c.IdentityRef == nil {
+		return errs
+	}
+
+	fieldPath := field.NewPath("spec", "identityRef")
+
+	if c.TLSSecretRef != "" {
+		errs = append(errs, field.Forbidden(fieldPath, "an identity can't be used with tlsSecretRef"))
+	}
+
+	if c.Placement.StaticPool != nil && c.Placement.StaticPool.BasicAuthSecret != "" {
+		errs = append(errs, field.Forbidden(fieldPath, "an identity can't be used with the basicAuthSecret of the static pool"))
+	}
+
+	return errs
+}
+
+func (c *MicrovmClusterSpec) ValidateAuth() []*field.Error {
+	var errs field.ErrorList
+
+	authType := c.Auth.AuthType()
+	fieldPath := field.NewPath("spec", "auth")
+
+	if authType != HostAuthBasic && c.Placement.StaticPool != nil && c.Placement.StaticPool.BasicAuthSecret != "" {
+		errs = append(errs, field.Forbidden(fieldPath.Child("type"), "the basicAuthSecret of the static pool can only be used with BasicAuth"))
+	}
+
+	switch authType {
+	case HostAuthMTLS:
+		if c.TLSSecretRef == "" && c.IdentityRef == nil {
+			errs = append(errs, field.Required(field.NewPath("spec", "tlsSecretRef"), "tls is required for MTLS"))
+		}
+	case HostAuthBasic, HostAuthServiceAccountToken, HostAuthTokenExchange:
+	}
+
+	if authType != HostAuthTokenExchange {
+		return errs
+	}
+
+	if c.Auth.TokenExchange == nil {
+		return append(errs, field.Required(fieldPath.Child("tokenExchange"), "a token exchange endpoint is required for TokenExchange"))
+	}
+
+	endpoint, err := url.Parse(c.Auth.TokenExchange.Endpoint)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+		errs = append(errs, field.Invalid(fieldPath.Child("tokenExchange", "endpoint"), c.Auth.TokenExchange.Endpoint, "must be an http or https url"))
+	}
+
+	return errs
+}
+
+func (c *MicrovmClusterSpec) ValidateProxy() []*field.Error {
+	return c.ValidateProxyUpdate(nil)
+}
+
+// ValidateProxyUpdate validates the proxies that are new or have changed since old, so that
+// clusters with proxies that were accepted before the checks were added can still be updated.
+func (c *MicrovmClusterSpec) ValidateProxyUpdate(old *MicrovmClusterSpec) []*field.Error {
+	var errs field.ErrorList
+
+	if c.MicrovmProxy != nil && (old == nil || !reflect.DeepEqual(c.MicrovmProxy, old.MicrovmProxy)) {
+		errs = append(errs, c.MicrovmProxy.validate(field.NewPath("spec", "microvmProxy"))...)
+	}
+
+	if c.Placement.StaticPool == nil {
+		return errs
+	}
+
+	oldProxies := map[string]*MicrovmProxy{}
+	if old != nil && old.Placement.StaticPool != nil {
+		for _, host := range old.Placement.StaticPool.Hosts {
+			oldProxies[host.Endpoint] = host.Proxy
+		}
+	}
+
+	for i, host := range c.Placement.StaticPool.Hosts {
+		if host.Proxy == nil {
+			continue
+		}
+
+		if oldProxy, ok := oldProxies[host.Endpoint]; ok && reflect.DeepEqual(host.Proxy, oldProxy) {
+			continue
+		}
+
+		errs = append(errs, host.Proxy.validate(field.NewPath("spec", "placement", "staticPool", "hosts").Index(i).Child("proxy"))...)
+	}
+
+	return errs
+}
+
+func (p *MicrovmProxy) validate(fieldPath *field.Path) []*field.Error {
+	var errs field.ErrorList
+
+	endpoint, err := url.Parse(p.Endpoint)
+
+	switch {
+	case err != nil || endpoint.Host == "":
+		errs = append(errs, field.Invalid(fieldPath.Child("endpoint"), p.Endpoint, "must be a url with a host, for example http://proxy.example.com:3128"))
+	case endpoint.Scheme != "http" && endpoint.Scheme != "https":
+		errs = append(errs, field.Invalid(fieldPath.Child("endpoint"), p.Endpoint,
+			fmt.Sprintf("unsupported scheme %q, must be http or https", endpoint.Scheme)))
+	}
+
+	for i, entry := range p.NoProxy {
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				errs = append(errs, field.Invalid(fieldPath.Child("noProxy").Index(i), entry, "must be a valid cidr"))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (l *LoadBalancerSpec) Validate() []*field.Error {
+	var errs field.ErrorList
+
+	// The same spec is used for all the load balancer microvms so they can't share a mac address.
+	for i, iface := range l.Microvm.NetworkInterfaces {
+		if iface.GuestMAC != "" {
+			fieldPath := field.NewPath("spec", "loadBalancer", "microvm", "networkInterfaces").Index(i).Child("guestMac")
+			errs = append(errs, field.Forbidden(fieldPath, "a mac address can't be set for the load balancer microvms"))
+		}
+	}
+
+	return errs
+}
+
+func (m *MicrovmMachineSpec) Validate(fieldPath *field.Path) []*field.Error {
+	var errs field.ErrorList
+
+	interfaces := map[string]string{}
+	for _, iface := range m.NetworkInterfaces {
+		interfaces[iface.GuestDeviceName] = iface.Address
+	}
+
+	for i, pool := range m.NetworkInterfaceAddressPools {
+		poolPath := fieldPath.Child("networkInterfaceAddressPools").Index(i).Child("guestDeviceName")
+
+		address, ok := interfaces[pool.GuestDeviceName]
+
+		switch {
+		case !ok:
+			errs = append(errs, field.NotFound(poolPath, pool.GuestDeviceName))
+		case address != "":
+			errs = append(errs, field.Forbidden(poolPath, "the network interface already has a static address"))
+		}
+	}
+
+	errs = append(errs, validateInstanceMetadata(fieldPath, m.HostnameTemplate, m.Metadata)...)
+
+	if m.AntiAffinity != nil {
+		errs = append(errs, m.AntiAffinity.validate(fieldPath.Child("antiAffinity"))...)
+	}
+
+	return errs
+}
+
+// Validate checks the node labels and taints of the template.
+func (t *MicrovmMachineTemplateSpec) Validate(fieldPath *field.Path) []*field.Error {
+	var errs field.ErrorList
+
+	labelsPath := fieldPath.Child("nodeLabels")
+
+	for key, value := range t.NodeLabels {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(labelsPath, key, msg))
+		}
+
+		for _, msg := range validation.IsValidLabelValue(value) {
+			errs = append(errs, field.Invalid(labelsPath.Key(key), value, msg))
+		}
+	}
+
+	for i, taint := range t.NodeTaints {
+		taintPath := fieldPath.Child("nodeTaints").Index(i)
+
+		for _, msg := range validation.IsQualifiedName(taint.Key) {
+			errs = append(errs, field.Invalid(taintPath.Child("key"), taint.Key, msg))
+		}
+
+		if taint.Value != "" {
+			for _, msg := range validation.IsValidLabelValue(taint.Value) {
+				errs = append(errs, field.Invalid(taintPath.Child("value"), taint.Value, msg))
+			}
+		}
+
+		switch taint.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			errs = append(errs, field.NotSupported(taintPath.Child("effect"), taint.Effect, []string{
+				string(corev1.TaintEffectNoSchedule),
+				string(corev1.TaintEffectPreferNoSchedule),
+				string(corev1.TaintEffectNoExecute),
+			}))
+		}
+	}
+
+	return errs
+}
+
+func (a *AntiAffinity) validate(fieldPath *field.Path) []*field.Error {
+	var errs field.ErrorList
+
+	switch {
+	case a.Type == AntiAffinityLabelKey && a.LabelKey == "":
+		errs = append(errs, field.Required(fieldPath.Child("labelKey"), "a label key is required with the LabelKey type"))
+	case a.Type == AntiAffinityLabelKey:
+		for _, msg := range validation.IsQualifiedName(a.LabelKey) {
+			errs = append(errs, field.Invalid(fieldPath.Child("labelKey"), a.LabelKey, msg))
+		}
+	case a.LabelKey != "":
+		errs = append(errs, field.Forbidden(fieldPath.Child("labelKey"), "a label key can only be used with the LabelKey type"))
 	}
 
 	return errs
@@ -0,0 +1,60 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// The microvm-cloud-controller-manager runs the cloud node controllers in a workload cluster
+// with a cloud provider that checks the microvms of the nodes on the flintlock hosts. Nodes are
+// removed when their microvm is deleted and their addresses and topology labels are kept in sync.
+package main
+
+import (
+	"os"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider/app"
+	"k8s.io/cloud-provider/app/config"
+	"k8s.io/cloud-provider/names"
+	"k8s.io/cloud-provider/options"
+	"k8s.io/component-base/cli"
+	cliflag "k8s.io/component-base/cli/flag"
+	_ "k8s.io/component-base/metrics/prometheus/clientgo" // load all the prometheus client-go plugins
+	_ "k8s.io/component-base/metrics/prometheus/version"  // for version metric registration
+	"k8s.io/klog/v2"
+
+	// Registers the microvm cloud provider.
+	_ "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/cloudprovider"
+)
+
+func main() {
+	ccmOptions, err := options.NewCloudControllerManagerOptions()
+	if err != nil {
+		klog.Fatalf("unable to initialize command options: %v", err)
+	}
+
+	fss := cliflag.NamedFlagSets{}
+	command := app.NewCloudControllerManagerCommand(
+		ccmOptions,
+		cloudInitializer,
+		app.DefaultInitFuncConstructors,
+		names.CCMControllerAliases(),
+		fss,
+		wait.NeverStop,
+	)
+
+	os.Exit(cli.Run(command))
+}
+
+func cloudInitializer(cfg *config.CompletedConfig) cloudprovider.Interface {
+	cloudConfig := cfg.ComponentConfig.KubeCloudShared.CloudProvider
+
+	cloud, err := cloudprovider.InitCloudProvider(cloudConfig.Name, cloudConfig.CloudConfigFile)
+	if err != nil {
+		klog.Fatalf("cloud provider could not be initialized: %v", err)
+	}
+
+	if cloud == nil {
+		klog.Fatalf("cloud provider %s isn't registered", cloudConfig.Name)
+	}
+
+	return cloud
+}
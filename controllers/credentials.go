@@ -0,0 +1,173 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/defaults"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
+)
+
+const (
+	// microvmClusterSecretsField indexes the MicrovmClusters by the secrets referenced by their spec.
+	microvmClusterSecretsField = "spec.secretRefs"
+	// microvmClusterIdentityField indexes the MicrovmClusters by the name of their identity.
+	microvmClusterIdentityField = "spec.identityRef.name"
+	// identitySecretsField indexes the MicrovmClusterIdentities by the secrets they reference.
+	identitySecretsField = "spec.secretRefs"
+)
+
+// indexCredentialSecrets adds the indexes used to find the MicrovmClusters that use a secret.
+func indexCredentialSecrets(ctx context.Context, indexer client.FieldIndexer) error {
+	if err := indexer.IndexField(ctx, &infrav1.MicrovmCluster{}, microvmClusterSecretsField, microvmClusterBySecret); err != nil {
+		return fmt.Errorf("indexing microvmclusters by secret: %w", err)
+	}
+
+	if err := indexer.IndexField(ctx, &infrav1.MicrovmCluster{}, microvmClusterIdentityField, microvmClusterByIdentity); err != nil {
+		return fmt.Errorf("indexing microvmclusters by identity: %w", err)
+	}
+
+	if err := indexer.IndexField(ctx, &infrav1.MicrovmClusterIdentity{}, identitySecretsField, identityBySecret); err != nil {
+		return fmt.Errorf("indexing microvmclusteridentities by secret: %w", err)
+	}
+
+	return nil
+}
+
+// microvmClusterBySecret is the indexer of the secrets referenced by the spec of the MicrovmClusters.
+// It may include secrets that aren't used, for example the basic auth secret when TLS is used, as
+// the clusters are checked again when a secret changes.
+func microvmClusterBySecret(o client.Object) []string {
+	mvmCluster, ok := o.(*infrav1.MicrovmCluster)
+	if !ok {
+		return nil
+	}
+
+	spec := mvmCluster.Spec
+	names := []string{spec.TLSSecretRef}
+
+	if spec.MicrovmProxy != nil {
+		names = append(names, spec.MicrovmProxy.CredentialsSecretRef)
+	}
+
+	if pool := spec.Placement.StaticPool; pool != nil {
+		names = append(names, pool.BasicAuthSecret)
+
+		for _, host := range pool.Hosts {
+			if host.TLS != nil {
+				names = append(names, host.TLS.SecretRef, host.TLS.CASecretRef)
+			}
+
+			if host.Proxy != nil {
+				names = append(names, host.Proxy.CredentialsSecretRef)
+			}
+		}
+	}
+
+	var keys []string
+
+	for _, name := range names {
+		key := types.NamespacedName{Namespace: mvmCluster.Namespace, Name: name}.String()
+		if name != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// microvmClusterByIdentity is the indexer of the identity of the MicrovmClusters.
+func microvmClusterByIdentity(o client.Object) []string {
+	mvmCluster, ok := o.(*infrav1.MicrovmCluster)
+	if !ok || mvmCluster.Spec.IdentityRef == nil {
+		return nil
+	}
+
+	return []string{mvmCluster.Spec.IdentityRef.Name}
+}
+
+// identityBySecret is the indexer of the secrets referenced by the MicrovmClusterIdentities.
+func identityBySecret(o client.Object) []string {
+	identity, ok := o.(*infrav1.MicrovmClusterIdentity)
+	if !ok {
+		return nil
+	}
+
+	var keys []string
+
+	for _, ref := range []*corev1.SecretReference{identity.Spec.BasicAuthSecretRef, identity.Spec.TLSSecretRef} {
+		if ref != nil && ref.Name != "" {
+			keys = append(keys, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}.String())
+		}
+	}
+
+	return keys
+}
+
+// microvmClustersUsingSecret returns the MicrovmClusters that use the secret to connect to their hosts.
+// The secret can be in a different namespace to the cluster when it belongs to a cluster identity.
+func microvmClustersUsingSecret(ctx context.Context, c client.Client, secret client.Object) ([]*infrav1.MicrovmCluster, error) {
+	secretKey := client.ObjectKeyFromObject(secret)
+
+	mvmClusters := &infrav1.MicrovmClusterList{}
+	if err := c.List(ctx, mvmClusters, client.MatchingFields{microvmClusterSecretsField: secretKey.String()}); err != nil {
+		return nil, fmt.Errorf("listing microvm clusters: %w", err)
+	}
+
+	identities := &infrav1.MicrovmClusterIdentityList{}
+	if err := c.List(ctx, identities, client.MatchingFields{identitySecretsField: secretKey.String()}); err != nil {
+		return nil, fmt.Errorf("listing microvm cluster identities: %w", err)
+	}
+
+	for _, identity := range identities.Items {
+		identityClusters := &infrav1.MicrovmClusterList{}
+		if err := c.List(ctx, identityClusters, client.MatchingFields{microvmClusterIdentityField: identity.Name}); err != nil {
+			return nil, fmt.Errorf("listing microvm clusters: %w", err)
+		}
+
+		mvmClusters.Items = append(mvmClusters.Items, identityClusters.Items...)
+	}
+
+	var result []*infrav1.MicrovmCluster
+
+	seen := map[types.NamespacedName]bool{}
+
+	for i := range mvmClusters.Items {
+		mvmCluster := &mvmClusters.Items[i]
+
+		clusterKey := client.ObjectKeyFromObject(mvmCluster)
+		if seen[clusterKey] {
+			continue
+		}
+
+		seen[clusterKey] = true
+
+		keys, err := scope.CredentialSecrets(ctx, c, mvmCluster)
+		if err != nil {
+			// The cluster can't use its credentials so there is nothing to update.
+			ctrl.LoggerFrom(ctx).V(defaults.LogLevelDebug).Info(
+				"skipping microvm cluster with unusable credentials",
+				"microvmcluster", clusterKey,
+				"reason", err.Error(),
+			)
+
+			continue
+		}
+
+		if slices.Contains(keys, secretKey) {
+			result = append(result, mvmCluster)
+		}
+	}
+
+	return result, nil
+}
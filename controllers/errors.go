@@ -12,4 +12,9 @@ var (
 	errMicrovmUnknownState          = errors.New("microvm is in an unknown/unsupported state")
 	errExpectedMicrovmCluster       = errors.New("expected microvm cluster")
 	errNoPlacement                  = errors.New("no placement specified")
+	errNoFailureDomains             = errors.New("no failure domains available for the machine pool")
+	errNoReachableFailureDomains    = errors.New("none of the failure domains for the machine pool can be reached")
+	errMACAddressInUse              = errors.New("mac address is already in use")
+	errMACAddressesExhausted        = errors.New("unable to allocate an unused mac address")
+	errMetadataTooLarge             = errors.New("microvm metadata is too large")
 )
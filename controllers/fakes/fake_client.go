@@ -5,7 +5,7 @@ import (
 	"context"
 	"sync"
 
-	"github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
 	"github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/emptypb"
@@ -483,4 +483,4 @@ func (fake *FakeClient) recordInvocation(key string, args []interface{}) {
 	fake.invocations[key] = append(fake.invocations[key], args)
 }
 
-var _ client.Client = new(FakeClient)
+var _ flintlock.Client = new(FakeClient)
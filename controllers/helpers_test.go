@@ -5,13 +5,26 @@ package controllers_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
 
 	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
 	"gopkg.in/yaml.v2"
 
-	flclient "github.com/liquidmetal-dev/controller-pkg/client"
 	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,15 +32,22 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/pointer"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/api/v1beta1/index"
+	"sigs.k8s.io/cluster-api/controllers/clustercache"
 	fakeremote "sigs.k8s.io/cluster-api/controllers/remote/fake"
+	expclusterv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util/conditions"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/controllers"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/controllers/fakes"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/haproxy"
 	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
 	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
 	"github.com/liquidmetal-dev/flintlock/client/cloudinit/userdata"
@@ -37,9 +57,16 @@ const (
 	testClusterName         = "tenant1"
 	testClusterNamespace    = "ns1"
 	testMachineName         = "machine1"
+	testMachineTemplateName = "machine-template1"
 	testMachineUID          = "ABCDEF123456"
 	testBootstrapSecretName = "bootstrap"
 	testbootStrapData       = "somesamplebootstrapsdata"
+	testMachinePoolName     = "pool1"
+)
+
+var (
+	errFakeHostUnreachable = errors.New("host unreachable")
+	errFakeResponseLost    = errors.New("response lost")
 )
 
 func defaultClusterObjects() clusterObjects {
@@ -85,11 +112,16 @@ func (co clusterObjects) AsRuntimeObjects() []runtime.Object {
 }
 
 func reconcileMachine(client client.Client, mockAPIClient flclient.Client) (ctrl.Result, error) {
+	return reconcileMachineWithMetadataLimit(client, mockAPIClient, 0)
+}
+
+func reconcileMachineWithMetadataLimit(client client.Client, mockAPIClient flclient.Client, limit int) (ctrl.Result, error) {
 	machineController := &controllers.MicrovmMachineReconciler{
 		Client: client,
 		MvmClientFunc: func(address string, opts ...flclient.Options) (flclient.Client, error) {
 			return mockAPIClient, nil
 		},
+		MetadataSizeLimit: limit,
 	}
 
 	request := ctrl.Request{
@@ -103,9 +135,29 @@ func reconcileMachine(client client.Client, mockAPIClient flclient.Client) (ctrl
 }
 
 func reconcileCluster(client client.Client) (ctrl.Result, error) {
+	return reconcileClusterWithMvmClient(client, nil)
+}
+
+func reconcileClusterWithMvmClient(client client.Client, mockAPIClient flclient.Client) (ctrl.Result, error) {
+	return reconcileClusterWithLoadBalancer(client, mockAPIClient, newFakeHAProxy())
+}
+
+func reconcileClusterWithLoadBalancer(
+	client client.Client,
+	mockAPIClient flclient.Client,
+	lb *fakeHAProxy,
+) (ctrl.Result, error) {
 	clusterController := &controllers.MicrovmClusterReconciler{
 		Client:             client,
 		RemoteClientGetter: fakeremote.NewClusterClient,
+		MvmClientFunc: func(address string, opts ...flclient.Options) (flclient.Client, error) {
+			return mockAPIClient, nil
+		},
+		HAProxyRuntimeFunc: func(address string, _ *tls.Config) haproxy.Runtime {
+			lb.addresses = append(lb.addresses, address)
+
+			return lb
+		},
 	}
 
 	request := ctrl.Request{
@@ -118,6 +170,83 @@ func reconcileCluster(client client.Client) (ctrl.Result, error) {
 	return clusterController.Reconcile(context.TODO(), request)
 }
 
+// fakeClusterCache is a cluster cache whose workload cluster clients are the client of the
+// management cluster.
+type fakeClusterCache struct {
+	clustercache.ClusterCache
+
+	client  client.Client
+	watches []string
+}
+
+func (f *fakeClusterCache) GetClient(_ context.Context, _ client.ObjectKey) (client.Client, error) {
+	return f.client, nil
+}
+
+func (f *fakeClusterCache) Watch(_ context.Context, _ client.ObjectKey, watcher clustercache.Watcher) error {
+	f.watches = append(f.watches, watcher.Name())
+
+	return nil
+}
+
+func reconcileNode(client client.Client) (ctrl.Result, error) {
+	_, result, err := reconcileNodeWithCache(client)
+
+	return result, err
+}
+
+func reconcileNodeWithCache(client client.Client) (*fakeClusterCache, ctrl.Result, error) {
+	clusterCache := &fakeClusterCache{client: client}
+	nodeController := &controllers.MicrovmNodeReconciler{
+		Client:       client,
+		ClusterCache: clusterCache,
+	}
+
+	request := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      testMachineName,
+			Namespace: testClusterNamespace,
+		},
+	}
+
+	result, err := nodeController.Reconcile(context.TODO(), request)
+
+	return clusterCache, result, err
+}
+
+func reconcileMachineTemplate(client client.Client) (ctrl.Result, error) {
+	templateController := &controllers.MicrovmMachineTemplateReconciler{
+		Client: client,
+	}
+
+	request := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      testMachineTemplateName,
+			Namespace: testClusterNamespace,
+		},
+	}
+
+	return templateController.Reconcile(context.TODO(), request)
+}
+
+func reconcileMachinePool(client client.Client, mockAPIClient flclient.Client) (ctrl.Result, error) {
+	poolController := &controllers.MicrovmMachinePoolReconciler{
+		Client: client,
+		MvmClientFunc: func(address string, opts ...flclient.Options) (flclient.Client, error) {
+			return mockAPIClient, nil
+		},
+	}
+
+	request := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      testMachinePoolName,
+			Namespace: testClusterNamespace,
+		},
+	}
+
+	return poolController.Reconcile(context.TODO(), request)
+}
+
 func getCluster(ctx context.Context, c client.Client, name, namespace string) (*clusterv1.Cluster, error) {
 	clusterKey := client.ObjectKey{
 		Name:      name,
@@ -151,6 +280,17 @@ func getMicrovmMachine(c client.Client, name, namespace string) (*infrav1.Microv
 	return machine, err
 }
 
+func getMicrovmMachinePool(c client.Client, name, namespace string) (*infrav1.MicrovmMachinePool, error) {
+	poolKey := client.ObjectKey{
+		Name:      name,
+		Namespace: namespace,
+	}
+
+	pool := &infrav1.MicrovmMachinePool{}
+	err := c.Get(context.TODO(), poolKey, pool)
+	return pool, err
+}
+
 func getMachine(c client.Client, name, namespace string) (*clusterv1.Machine, error) {
 	clusterKey := client.ObjectKey{
 		Name:      name,
@@ -167,9 +307,21 @@ func createFakeClient(g *WithT, objects []runtime.Object) client.Client {
 
 	g.Expect(infrav1.AddToScheme(scheme)).To(Succeed())
 	g.Expect(clusterv1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(expclusterv1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(ipamv1.AddToScheme(scheme)).To(Succeed())
 	g.Expect(corev1.AddToScheme(scheme)).To(Succeed())
 
-	return fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objects...).Build()
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRuntimeObjects(objects...).
+		WithIndex(&corev1.Node{}, index.NodeProviderIDField, index.NodeByProviderID).
+		WithStatusSubresource(
+			&infrav1.MicrovmCluster{},
+			&infrav1.MicrovmMachine{},
+			&infrav1.MicrovmMachinePool{},
+			&infrav1.MicrovmMachineTemplate{},
+		).
+		Build()
 }
 
 func createMicrovmCluster() *infrav1.MicrovmCluster {
@@ -203,6 +355,69 @@ func createMicrovmCluster() *infrav1.MicrovmCluster {
 	}
 }
 
+func createLoadBalancedMicrovmCluster(replicas int32) *infrav1.MicrovmCluster {
+	mvmCluster := createMicrovmCluster()
+	mvmCluster.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{
+		Host: "192.168.8.15",
+		Port: 6443,
+	}
+	mvmCluster.Spec.LoadBalancer = &infrav1.LoadBalancerSpec{
+		Replicas: replicas,
+		Microvm:  createMicrovmMachine().Spec.VMSpec,
+	}
+
+	return mvmCluster
+}
+
+func createIPAMMicrovmCluster() *infrav1.MicrovmCluster {
+	mvmCluster := createMicrovmCluster()
+	mvmCluster.Spec.ControlPlaneEndpointPoolRef = &corev1.TypedLocalObjectReference{
+		APIGroup: pointer.String("ipam.cluster.x-k8s.io"),
+		Kind:     "InClusterIPPool",
+		Name:     "pool1",
+	}
+
+	return mvmCluster
+}
+
+// allocateIPAddress acts as the IPAM provider and fulfills the named claim with the address.
+func allocateIPAddress(g *WithT, c client.Client, claimName, address string) {
+	claim := &ipamv1.IPAddressClaim{}
+	g.Expect(c.Get(context.TODO(), client.ObjectKey{Name: claimName, Namespace: testClusterNamespace}, claim)).To(Succeed())
+
+	ipAddress := &ipamv1.IPAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimName,
+			Namespace: testClusterNamespace,
+		},
+		Spec: ipamv1.IPAddressSpec{
+			ClaimRef: corev1.LocalObjectReference{Name: claimName},
+			PoolRef:  claim.Spec.PoolRef,
+			Address:  address,
+			Prefix:   24,
+			Gateway:  "192.168.8.1",
+		},
+	}
+	g.Expect(c.Create(context.TODO(), ipAddress)).To(Succeed())
+
+	claim.Status.AddressRef = corev1.LocalObjectReference{Name: ipAddress.Name}
+	g.Expect(c.Update(context.TODO(), claim)).To(Succeed())
+}
+
+func withInterfaceAddressPool(mvmMachine *infrav1.MicrovmMachine) {
+	mvmMachine.Spec.NetworkInterfaceAddressPools = []infrav1.NetworkInterfaceAddressPool{
+		{
+			GuestDeviceName: "eth0",
+			PoolRef: corev1.TypedLocalObjectReference{
+				APIGroup: pointer.String("ipam.cluster.x-k8s.io"),
+				Kind:     "InClusterIPPool",
+				Name:     "pool1",
+			},
+			Nameservers: []string{"1.1.1.1"},
+		},
+	}
+}
+
 func createCluster() *clusterv1.Cluster {
 	return &clusterv1.Cluster{
 		ObjectMeta: metav1.ObjectMeta{
@@ -292,6 +507,66 @@ func createMachine() *clusterv1.Machine {
 	}
 }
 
+func createMicrovmMachinePool() *infrav1.MicrovmMachinePool {
+	return &infrav1.MicrovmMachinePool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testMachinePoolName,
+			Namespace: testClusterNamespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: expclusterv1.GroupVersion.String(),
+					Kind:       "MachinePool",
+					Name:       testMachinePoolName,
+				},
+			},
+		},
+		Spec: infrav1.MicrovmMachinePoolSpec{
+			VMSpec: createMicrovmMachine().Spec.VMSpec,
+		},
+	}
+}
+
+func createMachinePool(replicas int32) *expclusterv1.MachinePool {
+	return &expclusterv1.MachinePool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testMachinePoolName,
+			Namespace: testClusterNamespace,
+			Labels: map[string]string{
+				clusterv1.ClusterNameLabel: testClusterName,
+			},
+		},
+		Spec: expclusterv1.MachinePoolSpec{
+			ClusterName: testClusterName,
+			Replicas:    pointer.Int32(replicas),
+			Template: clusterv1.MachineTemplateSpec{
+				Spec: clusterv1.MachineSpec{
+					ClusterName: testClusterName,
+					InfrastructureRef: corev1.ObjectReference{
+						Name: testMachinePoolName,
+					},
+					Bootstrap: clusterv1.Bootstrap{
+						DataSecretName: pointer.String(testBootstrapSecretName),
+					},
+				},
+			},
+		},
+	}
+}
+
+func createControlPlaneMachine(name, address string) *clusterv1.Machine {
+	machine := createMachine()
+	machine.Name = name
+	machine.Labels[clusterv1.MachineControlPlaneLabel] = ""
+	machine.Status.Addresses = clusterv1.MachineAddresses{
+		{
+			Type:    clusterv1.MachineInternalIP,
+			Address: address,
+		},
+	}
+
+	return machine
+}
+
 func createBootsrapSecret() *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -311,6 +586,84 @@ func createBootsrapSecret() *corev1.Secret {
 	}
 }
 
+// createTLSSecret creates a secret with a client certificate that is valid until notAfter and the
+// self-signed CA that issued it.
+func createTLSSecret(g *WithT, name string, notAfter time.Time) *corev1.Secret {
+	notBefore := time.Now().Add(-time.Hour)
+	if notAfter.Before(notBefore) {
+		notBefore = notAfter.Add(-24 * time.Hour)
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "flintlock-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "capmvm"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caTemplate, &clientKey.PublicKey, caKey)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testClusterNamespace,
+		},
+		Data: map[string][]byte{
+			"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}),
+			"tls.key": pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+			"ca.crt":  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		},
+	}
+}
+
+// gaugeValue returns the value of the gauge with the namespace and name labels from the controller metrics.
+func gaugeValue(g *WithT, metricName, namespace, name string) (float64, bool) {
+	families, err := crmetrics.Registry.Gather()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for _, family := range families {
+		if family.GetName() != metricName {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["namespace"] == namespace && labels["name"] == name {
+				return metric.GetGauge().GetValue(), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
 func withExistingMicrovm(fc *fakes.FakeClient, mvmState flintlocktypes.MicroVMStatus_MicroVMState) {
 	fc.GetMicroVMReturns(&flintlockv1.GetMicroVMResponse{
 		Microvm: &flintlocktypes.MicroVM{
@@ -341,6 +694,130 @@ func withCreateMicrovmSuccess(fc *fakes.FakeClient) {
 	}, nil)
 }
 
+// fakeFlintlockHost records the microvms created through the fake flintlock client so that
+// they can be returned from later calls.
+type fakeFlintlockHost struct {
+	created  int
+	microvms map[string]flintlocktypes.MicroVMStatus_MicroVMState
+	names    map[string]string
+
+	// loseCreates creates the microvms but fails the requests as if the responses were lost.
+	loseCreates bool
+	// unreachable fails every request as if the host couldn't be reached.
+	unreachable bool
+}
+
+func newFakeFlintlockHost() (*fakeFlintlockHost, *fakes.FakeClient) {
+	host := &fakeFlintlockHost{
+		microvms: map[string]flintlocktypes.MicroVMStatus_MicroVMState{},
+		names:    map[string]string{},
+	}
+	fc := &fakes.FakeClient{}
+
+	fc.CreateMicroVMCalls(func(_ context.Context, req *flintlockv1.CreateMicroVMRequest, _ ...grpc.CallOption) (*flintlockv1.CreateMicroVMResponse, error) {
+		if host.unreachable {
+			return nil, errFakeHostUnreachable
+		}
+
+		host.created++
+		uid := fmt.Sprintf("uid%d", host.created)
+		host.microvms[uid] = flintlocktypes.MicroVMStatus_PENDING
+		host.names[uid] = req.GetMicrovm().GetId()
+
+		if host.loseCreates {
+			return nil, errFakeResponseLost
+		}
+
+		return &flintlockv1.CreateMicroVMResponse{
+			Microvm: &flintlocktypes.MicroVM{
+				Spec:   &flintlocktypes.MicroVMSpec{Uid: pointer.String(uid)},
+				Status: &flintlocktypes.MicroVMStatus{State: flintlocktypes.MicroVMStatus_PENDING},
+			},
+		}, nil
+	})
+
+	fc.GetMicroVMCalls(func(_ context.Context, req *flintlockv1.GetMicroVMRequest, _ ...grpc.CallOption) (*flintlockv1.GetMicroVMResponse, error) {
+		if host.unreachable {
+			return nil, errFakeHostUnreachable
+		}
+
+		state, ok := host.microvms[req.Uid]
+		if !ok {
+			return &flintlockv1.GetMicroVMResponse{}, nil
+		}
+
+		return &flintlockv1.GetMicroVMResponse{
+			Microvm: &flintlocktypes.MicroVM{
+				Spec:   &flintlocktypes.MicroVMSpec{Uid: pointer.String(req.Uid)},
+				Status: &flintlocktypes.MicroVMStatus{State: state},
+			},
+		}, nil
+	})
+
+	fc.ListMicroVMsCalls(func(_ context.Context, req *flintlockv1.ListMicroVMsRequest, _ ...grpc.CallOption) (*flintlockv1.ListMicroVMsResponse, error) {
+		if host.unreachable {
+			return nil, errFakeHostUnreachable
+		}
+
+		resp := &flintlockv1.ListMicroVMsResponse{}
+
+		for uid, name := range host.names {
+			if _, ok := host.microvms[uid]; !ok || name != req.GetName() {
+				continue
+			}
+
+			resp.Microvm = append(resp.Microvm, &flintlocktypes.MicroVM{
+				Spec:   &flintlocktypes.MicroVMSpec{Id: name, Uid: pointer.String(uid)},
+				Status: &flintlocktypes.MicroVMStatus{State: host.microvms[uid]},
+			})
+		}
+
+		return resp, nil
+	})
+
+	fc.DeleteMicroVMCalls(func(_ context.Context, req *flintlockv1.DeleteMicroVMRequest, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+		if host.unreachable {
+			return nil, errFakeHostUnreachable
+		}
+
+		host.microvms[req.Uid] = flintlocktypes.MicroVMStatus_DELETING
+
+		return &emptypb.Empty{}, nil
+	})
+
+	return host, fc
+}
+
+// startAll marks all the pending microvms as running.
+func (h *fakeFlintlockHost) startAll() {
+	for uid, state := range h.microvms {
+		if state == flintlocktypes.MicroVMStatus_PENDING {
+			h.microvms[uid] = flintlocktypes.MicroVMStatus_CREATED
+		}
+	}
+}
+
+// finishDeletes removes all the microvms that are being deleted.
+func (h *fakeFlintlockHost) finishDeletes() {
+	for uid, state := range h.microvms {
+		if state == flintlocktypes.MicroVMStatus_DELETING {
+			delete(h.microvms, uid)
+		}
+	}
+}
+
+func (h *fakeFlintlockHost) count(state flintlocktypes.MicroVMStatus_MicroVMState) int {
+	total := 0
+
+	for _, s := range h.microvms {
+		if s == state {
+			total++
+		}
+	}
+
+	return total
+}
+
 func assertConditionTrue(g *WithT, from conditions.Getter, conditionType clusterv1.ConditionType) {
 	c := conditions.Get(from, conditionType)
 	g.Expect(c).ToNot(BeNil(), "Conditions expected to be set")
@@ -369,6 +846,153 @@ func assertMachineReconciled(g *WithT, reconciled *infrav1.MicrovmMachine) {
 	g.Expect(reconciled.Status.Ready).To(BeTrue(), "The Ready property must be true when the machine has been reconciled")
 }
 
+func assertHAProxyBackends(g *WithT, userDataRaw string, expectedBackends ...string) {
+	data, err := base64.StdEncoding.DecodeString(userDataRaw)
+	g.Expect(err).NotTo(HaveOccurred(), "expect user data to be base64 encoded")
+
+	userData := &userdata.UserData{}
+	g.Expect(yaml.Unmarshal(data, userData)).To(Succeed(), "expect user data to unmarshall to cloud-init userdata")
+
+	var haproxyConfig string
+
+	for _, file := range userData.WriteFiles {
+		if file.Path == "/etc/haproxy/haproxy.cfg" {
+			content, err := base64.StdEncoding.DecodeString(file.Content)
+			g.Expect(err).NotTo(HaveOccurred(), "expect haproxy config to be base64 encoded")
+
+			haproxyConfig = string(content)
+		}
+	}
+
+	g.Expect(haproxyConfig).NotTo(BeEmpty(), "expect the haproxy config to be written")
+	g.Expect(haproxyConfig).To(ContainSubstring("stats socket ipv4@*:9999 level admin ssl crt"), "expect the runtime api to be enabled")
+	g.Expect(strings.Count(haproxyConfig, "  server ")).To(Equal(16), "expect a server for each slot")
+	g.Expect(strings.Count(haproxyConfig, " disabled\n")).To(Equal(16-len(expectedBackends)), "expect the unused slots to be disabled")
+
+	for i, backend := range expectedBackends {
+		g.Expect(haproxyConfig).To(ContainSubstring(fmt.Sprintf("server cp%d %s\n", i, backend)))
+	}
+}
+
+// keepalivedPriority returns the priority in the keepalived config of the user data.
+func keepalivedPriority(g *WithT, userDataRaw string) string {
+	data, err := base64.StdEncoding.DecodeString(userDataRaw)
+	g.Expect(err).NotTo(HaveOccurred(), "expect user data to be base64 encoded")
+
+	userData := &userdata.UserData{}
+	g.Expect(yaml.Unmarshal(data, userData)).To(Succeed(), "expect user data to unmarshall to cloud-init userdata")
+
+	for _, file := range userData.WriteFiles {
+		if file.Path != "/etc/keepalived/keepalived.conf" {
+			continue
+		}
+
+		content, err := base64.StdEncoding.DecodeString(file.Content)
+		g.Expect(err).NotTo(HaveOccurred(), "expect keepalived config to be base64 encoded")
+
+		for _, line := range strings.Split(string(content), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "priority" {
+				return fields[1]
+			}
+		}
+	}
+
+	g.Expect(false).To(BeTrue(), "expect the keepalived config to have a priority")
+
+	return ""
+}
+
+// fakeHAProxy is the runtime API of the HAProxy instance that has the control plane endpoint
+// address. It has the same server slots as the HAProxy config of the load balancer microvms.
+type fakeHAProxy struct {
+	servers   []haproxy.Server
+	addresses []string
+	// err fails every command as if the runtime API couldn't be reached.
+	err error
+}
+
+func newFakeHAProxy(backends ...string) *fakeHAProxy {
+	lb := &fakeHAProxy{}
+
+	for i := 0; i < 16; i++ {
+		server := haproxy.Server{Name: fmt.Sprintf("cp%d", i), Address: "127.0.0.1:6443"}
+		if i < len(backends) {
+			server.Address = backends[i]
+			server.Enabled = true
+		}
+
+		lb.servers = append(lb.servers, server)
+	}
+
+	return lb
+}
+
+func (f *fakeHAProxy) Execute(_ context.Context, command string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+
+	args := strings.Fields(command)
+
+	if strings.HasPrefix(command, "show servers state kube-apiserver") {
+		resp := "1\n# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_port\n"
+
+		for i, server := range f.servers {
+			host, port, _ := net.SplitHostPort(server.Address)
+
+			admin := 5
+			if server.Enabled {
+				admin = 4
+			}
+
+			resp += fmt.Sprintf("3 kube-apiserver %d %s %s 2 %d %s\n", i+1, server.Name, host, admin, port)
+		}
+
+		return resp, nil
+	}
+
+	if len(args) < 4 || args[0] != "set" || args[1] != "server" {
+		return "Unknown command.\n", nil
+	}
+
+	server := f.server(strings.TrimPrefix(args[2], "kube-apiserver/"))
+	if server == nil {
+		return "No such server.\n", nil
+	}
+
+	switch {
+	case args[3] == "addr" && len(args) == 7:
+		server.Address = net.JoinHostPort(args[4], args[6])
+	case args[3] == "state" && len(args) == 5:
+		server.Enabled = args[4] == "ready"
+	}
+
+	return "", nil
+}
+
+func (f *fakeHAProxy) server(name string) *haproxy.Server {
+	for i := range f.servers {
+		if f.servers[i].Name == name {
+			return &f.servers[i]
+		}
+	}
+
+	return nil
+}
+
+// backends returns the addresses of the enabled servers.
+func (f *fakeHAProxy) backends() []string {
+	backends := []string{}
+
+	for _, server := range f.servers {
+		if server.Enabled {
+			backends = append(backends, server.Address)
+		}
+	}
+
+	return backends
+}
+
 func assertNoMachineFinalizer(g *WithT, reconciled *infrav1.MicrovmMachine) {
 	g.Expect(hasMachineFinalizer(reconciled)).To(BeFalse(), "Expect not to have the mvm machine finalizer")
 }
@@ -0,0 +1,103 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// +kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=ipaddressclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=ipaddresses,verbs=get;list;watch
+
+// ensureIPAddressClaim makes sure that an IPAddressClaim exists against the supplied pool and returns
+// the IPAddress allocated to it. If the address hasn't been allocated yet then nil is returned. The
+// claim is owned by the supplied object so that it's released if the owner is removed.
+func ensureIPAddressClaim(
+	ctx context.Context,
+	c client.Client,
+	owner client.Object,
+	name string,
+	clusterName string,
+	poolRef corev1.TypedLocalObjectReference,
+) (*ipamv1.IPAddress, error) {
+	claim := &ipamv1.IPAddressClaim{}
+	claimKey := client.ObjectKey{Namespace: owner.GetNamespace(), Name: name}
+
+	err := c.Get(ctx, claimKey, claim)
+
+	switch {
+	case apierrors.IsNotFound(err):
+		claim = &ipamv1.IPAddressClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      claimKey.Name,
+				Namespace: claimKey.Namespace,
+				Labels: map[string]string{
+					clusterv1.ClusterNameLabel: clusterName,
+				},
+			},
+			Spec: ipamv1.IPAddressClaimSpec{
+				ClusterName: clusterName,
+				PoolRef:     poolRef,
+			},
+		}
+
+		if err := controllerutil.SetControllerReference(owner, claim, c.Scheme()); err != nil {
+			return nil, fmt.Errorf("setting owner of ip address claim %s: %w", claimKey, err)
+		}
+
+		if err := c.Create(ctx, claim); err != nil {
+			return nil, fmt.Errorf("creating ip address claim %s: %w", claimKey, err)
+		}
+
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("getting ip address claim %s: %w", claimKey, err)
+	}
+
+	if claim.Status.AddressRef.Name == "" {
+		return nil, nil
+	}
+
+	address := &ipamv1.IPAddress{}
+	addressKey := client.ObjectKey{Namespace: claim.Namespace, Name: claim.Status.AddressRef.Name}
+
+	if err := c.Get(ctx, addressKey, address); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("getting ip address %s: %w", addressKey, err)
+	}
+
+	return address, nil
+}
+
+// releaseIPAddressClaim deletes the IPAddressClaim so that the address is returned to the pool.
+func releaseIPAddressClaim(ctx context.Context, c client.Client, namespace, name string) error {
+	claim := &ipamv1.IPAddressClaim{}
+	claimKey := client.ObjectKey{Namespace: namespace, Name: name}
+
+	if err := c.Get(ctx, claimKey, claim); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("getting ip address claim %s: %w", claimKey, err)
+	}
+
+	if err := c.Delete(ctx, claim); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting ip address claim %s: %w", claimKey, err)
+	}
+
+	return nil
+}
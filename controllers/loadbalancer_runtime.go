@@ -0,0 +1,161 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/haproxy"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
+)
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=create;update
+
+const (
+	runtimeCACertKey     = "ca.crt"
+	runtimeServerCertKey = "server.pem"
+)
+
+// getLoadBalancerRuntimeCredentials returns the certificates for the HAProxy runtime API of the load
+// balancer. They're created the first time, and again if the ControlPlaneEndpoint host address
+// changes, which also replaces the load balancer microvms as the runtime CA is part of their spec.
+func getLoadBalancerRuntimeCredentials(
+	ctx context.Context,
+	c client.Client,
+	cScope *scope.ClusterScope,
+) (*haproxy.Credentials, error) {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: cScope.Namespace(), Name: cScope.LoadBalancerRuntimeSecretName()}
+	host := cScope.ControlPlaneEndpoint().Host
+
+	err := c.Get(ctx, key, secret)
+
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels: map[string]string{
+					clusterv1.ClusterNameLabel: cScope.ClusterName(),
+				},
+			},
+			Type: corev1.SecretTypeTLS,
+		}
+
+		// The certificates are removed along with the cluster.
+		if err := controllerutil.SetOwnerReference(cScope.MvmCluster, secret, c.Scheme()); err != nil {
+			return nil, fmt.Errorf("setting owner of load balancer runtime secret %s: %w", key, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("getting load balancer runtime secret %s: %w", key, err)
+	default:
+		creds := runtimeCredentialsFromSecret(secret)
+		if serverCertificateValidFor(creds.ServerCert, host) {
+			return creds, nil
+		}
+
+		cScope.Info("control plane endpoint changed, recreating the load balancer runtime certificates", "host", host)
+	}
+
+	creds, err := haproxy.NewCredentials(cScope.LoadBalancerRuntimeSecretName(), host, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("creating load balancer runtime certificates: %w", err)
+	}
+
+	secret.Data = map[string][]byte{
+		runtimeCACertKey:        creds.CACert,
+		runtimeServerCertKey:    creds.ServerCert,
+		corev1.TLSCertKey:       creds.ClientCert,
+		corev1.TLSPrivateKeyKey: creds.ClientKey,
+	}
+
+	if secret.ResourceVersion == "" {
+		err = c.Create(ctx, secret)
+	} else {
+		err = c.Update(ctx, secret)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("saving load balancer runtime secret %s: %w", key, err)
+	}
+
+	return creds, nil
+}
+
+func runtimeCredentialsFromSecret(secret *corev1.Secret) *haproxy.Credentials {
+	return &haproxy.Credentials{
+		CACert:     secret.Data[runtimeCACertKey],
+		ServerCert: secret.Data[runtimeServerCertKey],
+		ClientCert: secret.Data[corev1.TLSCertKey],
+		ClientKey:  secret.Data[corev1.TLSPrivateKeyKey],
+	}
+}
+
+// serverCertificateValidFor returns true if the server certificate, which is followed by its key,
+// can be parsed and is valid for host.
+func serverCertificateValidFor(serverCert []byte, host string) bool {
+	block, _ := pem.Decode(serverCert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return false
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+
+	return cert.VerifyHostname(host) == nil
+}
+
+// syncLoadBalancerBackends changes the backends of the running load balancer using the HAProxy
+// runtime API. The runtime API is reached through the ControlPlaneEndpoint host address, so only
+// the microvm that has the address is changed. The other microvms are changed when they take over
+// the address, as the backends are synced periodically.
+func (r *MicrovmClusterReconciler) syncLoadBalancerBackends(
+	ctx context.Context,
+	cScope *scope.ClusterScope,
+	creds *haproxy.Credentials,
+	backends []string,
+) error {
+	// Disabling every server would stop all traffic to the control plane, so the existing
+	// backends are kept until there's a control plane machine with an address.
+	if len(backends) == 0 {
+		return nil
+	}
+
+	tlsConfig, err := creds.ClientTLSConfig(cScope.ControlPlaneEndpoint().Host)
+	if err != nil {
+		return err
+	}
+
+	runtimeFunc := r.HAProxyRuntimeFunc
+	if runtimeFunc == nil {
+		runtimeFunc = haproxy.NewRuntime
+	}
+
+	runtime := runtimeFunc(cScope.LoadBalancerRuntimeAddress(), tlsConfig)
+
+	changed, err := haproxy.SyncServers(ctx, runtime, scope.LoadBalancerBackend, backends)
+	if err != nil {
+		return fmt.Errorf("syncing load balancer backends: %w", err)
+	}
+
+	if changed {
+		cScope.Info("load balancer backends changed", "backends", backends)
+	}
+
+	return nil
+}
@@ -0,0 +1,351 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+
+const (
+	macAddressLength         = 6
+	maxMACAllocationAttempts = 10
+	macAddressRegistryKeySep = "-"
+	macAddressOwnerSeparator = "/"
+)
+
+// macAddressRegistry records the mac addresses allocated to the network interfaces of the microvms
+// of a cluster in a ConfigMap so that the same mac address is never allocated twice. The keys of the
+// ConfigMap are the mac addresses and the values are the owning microvm and network interface. The
+// microvms are those of the machines, the machine pools and the load balancer.
+//
+// The registry only covers a single cluster. Microvms of other clusters on the same host are found
+// by listing the microvms on the host, but a microvm that another cluster is creating at the same
+// time isn't listed yet, so two clusters sharing a host can be allocated the same mac address. This
+// is unlikely with the default prefix, which leaves 40 random bits, but clusters that share hosts
+// should be given different prefixes if a long prefix is used.
+type macAddressRegistry struct {
+	configMap *corev1.ConfigMap
+	changed   bool
+}
+
+// getMACAddressRegistry returns the registry for the cluster. The ConfigMap for the registry is
+// created when it's first saved.
+func getMACAddressRegistry(
+	ctx context.Context,
+	c client.Client,
+	mvmCluster *infrav1.MicrovmCluster,
+	clusterName string,
+) (*macAddressRegistry, error) {
+	configMap := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: mvmCluster.Namespace, Name: scope.MACAddressRegistryName(mvmCluster)}
+
+	err := c.Get(ctx, key, configMap)
+
+	switch {
+	case apierrors.IsNotFound(err):
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels: map[string]string{
+					clusterv1.ClusterNameLabel: clusterName,
+				},
+			},
+		}
+
+		// The registry is removed along with the cluster.
+		if err := controllerutil.SetOwnerReference(mvmCluster, configMap, c.Scheme()); err != nil {
+			return nil, fmt.Errorf("setting owner of mac address registry %s: %w", key, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("getting mac address registry %s: %w", key, err)
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+
+	return &macAddressRegistry{configMap: configMap}, nil
+}
+
+// save persists the registry if it has changed. An update fails if the registry has been changed
+// since it was read, which stops two machines being allocated the same mac address.
+func (m *macAddressRegistry) save(ctx context.Context, c client.Client) error {
+	if !m.changed {
+		return nil
+	}
+
+	var err error
+	if m.configMap.ResourceVersion == "" {
+		err = c.Create(ctx, m.configMap)
+	} else {
+		err = c.Update(ctx, m.configMap)
+	}
+
+	if err != nil {
+		return fmt.Errorf("saving mac address registry %s: %w", m.configMap.Name, err)
+	}
+
+	m.changed = false
+
+	return nil
+}
+
+// owner returns the owner of the mac address or an empty string if it isn't allocated.
+func (m *macAddressRegistry) owner(mac string) string {
+	return m.configMap.Data[macAddressRegistryKey(mac)]
+}
+
+// allocatedTo returns the mac address allocated to the owner or an empty string.
+func (m *macAddressRegistry) allocatedTo(owner string) string {
+	for key, value := range m.configMap.Data {
+		if value == owner {
+			return strings.ReplaceAll(key, macAddressRegistryKeySep, ":")
+		}
+	}
+
+	return ""
+}
+
+func (m *macAddressRegistry) record(mac, owner string) {
+	m.configMap.Data[macAddressRegistryKey(mac)] = owner
+	m.changed = true
+}
+
+func (m *macAddressRegistry) free(mac string) {
+	delete(m.configMap.Data, macAddressRegistryKey(mac))
+	m.changed = true
+}
+
+// freeMicrovm frees all the mac addresses allocated to the network interfaces of a microvm.
+func (m *macAddressRegistry) freeMicrovm(name string) {
+	for key, value := range m.configMap.Data {
+		if strings.HasPrefix(value, name+macAddressOwnerSeparator) {
+			delete(m.configMap.Data, key)
+			m.changed = true
+		}
+	}
+}
+
+// allocate allocates a random mac address with the prefix to the owner. Mac addresses that are
+// already allocated or are in use on the host are skipped.
+func (m *macAddressRegistry) allocate(prefix []byte, owner string, inUse map[string]bool) (string, error) {
+	for i := 0; i < maxMACAllocationAttempts; i++ {
+		hw := make(net.HardwareAddr, macAddressLength)
+		if _, err := rand.Read(hw); err != nil {
+			return "", fmt.Errorf("generating mac address: %w", err)
+		}
+
+		copy(hw, prefix)
+
+		mac := hw.String()
+		if m.owner(mac) != "" || inUse[mac] {
+			continue
+		}
+
+		m.record(mac, owner)
+
+		return mac, nil
+	}
+
+	return "", errMACAddressesExhausted
+}
+
+// macAddressAllocator allocates mac addresses to the network interfaces of the microvms of a
+// cluster that don't specify one.
+type macAddressAllocator struct {
+	logr.Logger
+
+	client      client.Client
+	clientFunc  flclient.FactoryFunc
+	creds       hostCredentials
+	mvmCluster  *infrav1.MicrovmCluster
+	clusterName string
+}
+
+// allocate allocates mac addresses to the network interfaces of the named microvm that don't
+// specify one and checks that none of the mac addresses are already in use on the host the microvm
+// will be created on. It returns the allocated mac addresses keyed by the guest device name.
+func (a *macAddressAllocator) allocate(
+	ctx context.Context,
+	name string,
+	failureDomain string,
+	interfaces []microvm.NetworkInterface,
+) (map[string]string, error) {
+	inUse, err := a.hostMACAddresses(ctx, failureDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	registry, err := getMACAddressRegistry(ctx, a.client, a.mvmCluster, a.clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := infrav1.ParseMACAddressPrefix(scope.MACAddressPrefix(a.mvmCluster, failureDomain))
+	if err != nil {
+		return nil, fmt.Errorf("parsing mac address prefix: %w", err)
+	}
+
+	allocated := map[string]string{}
+
+	for _, iface := range interfaces {
+		owner := name + macAddressOwnerSeparator + iface.GuestDeviceName
+
+		if iface.GuestMAC != "" {
+			hw, err := net.ParseMAC(iface.GuestMAC)
+			if err != nil {
+				return nil, fmt.Errorf("parsing mac address of network interface %s: %w", iface.GuestDeviceName, err)
+			}
+
+			mac := hw.String()
+
+			if existing := registry.owner(mac); inUse[mac] || (existing != "" && existing != owner) {
+				return nil, fmt.Errorf("%w: %s", errMACAddressInUse, mac)
+			}
+
+			if registry.owner(mac) == "" {
+				registry.record(mac, owner)
+			}
+
+			continue
+		}
+
+		mac := registry.allocatedTo(owner)
+		if mac != "" && inUse[mac] {
+			a.Info("allocated mac address is in use on the host, allocating another", "mac", mac)
+			registry.free(mac)
+
+			mac = ""
+		}
+
+		if mac == "" {
+			if mac, err = registry.allocate(prefix, owner, inUse); err != nil {
+				return nil, err
+			}
+		}
+
+		allocated[iface.GuestDeviceName] = mac
+	}
+
+	if err := registry.save(ctx, a.client); err != nil {
+		return nil, err
+	}
+
+	if len(allocated) == 0 {
+		return nil, nil
+	}
+
+	return allocated, nil
+}
+
+// release frees the mac addresses allocated to the network interfaces of the named microvm.
+func (a *macAddressAllocator) release(ctx context.Context, name string) error {
+	registry, err := getMACAddressRegistry(ctx, a.client, a.mvmCluster, a.clusterName)
+	if err != nil {
+		return err
+	}
+
+	if registry.configMap.ResourceVersion == "" {
+		return nil
+	}
+
+	registry.freeMicrovm(name)
+
+	return registry.save(ctx, a.client)
+}
+
+// hostMACAddresses returns the mac addresses of the network interfaces of all the microvms on the host.
+func (a *macAddressAllocator) hostMACAddresses(ctx context.Context, addr string) (map[string]bool, error) {
+	mvmClient, err := newMicrovmClient(a.clientFunc, addr, a.creds)
+	if err != nil {
+		return nil, err
+	}
+	defer mvmClient.Close()
+
+	resp, err := mvmClient.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("listing microvms on host %s: %w", addr, err)
+	}
+
+	inUse := map[string]bool{}
+
+	for _, mvm := range resp.GetMicrovm() {
+		for _, iface := range mvm.GetSpec().GetInterfaces() {
+			if hw, err := net.ParseMAC(iface.GetGuestMac()); err == nil {
+				inUse[hw.String()] = true
+			}
+		}
+	}
+
+	return inUse, nil
+}
+
+// reconcileMACAddresses allocates mac addresses to the network interfaces of the machine that don't
+// specify one. The allocated mac addresses are recorded in the status of the machine.
+func (r *MicrovmMachineReconciler) reconcileMACAddresses(
+	ctx context.Context,
+	machineScope *scope.MachineScope,
+	failureDomain string,
+) error {
+	allocated, err := r.macAddressAllocator(machineScope).allocate(
+		ctx,
+		machineScope.Name(),
+		failureDomain,
+		machineScope.MvmMachine.Spec.NetworkInterfaces,
+	)
+	if err != nil {
+		return err
+	}
+
+	machineScope.MvmMachine.Status.NetworkInterfaceMACs = allocated
+
+	return nil
+}
+
+// releaseMACAddresses frees the mac addresses allocated to the network interfaces of the machine.
+func (r *MicrovmMachineReconciler) releaseMACAddresses(ctx context.Context, machineScope *scope.MachineScope) error {
+	if err := r.macAddressAllocator(machineScope).release(ctx, machineScope.Name()); err != nil {
+		return err
+	}
+
+	machineScope.MvmMachine.Status.NetworkInterfaceMACs = nil
+
+	return nil
+}
+
+func (r *MicrovmMachineReconciler) macAddressAllocator(machineScope *scope.MachineScope) *macAddressAllocator {
+	return &macAddressAllocator{
+		Logger:      machineScope.Logger,
+		client:      r.Client,
+		clientFunc:  r.MvmClientFunc,
+		creds:       machineScope,
+		mvmCluster:  machineScope.MvmCluster,
+		clusterName: machineScope.ClusterName(),
+	}
+}
+
+func macAddressRegistryKey(mac string) string {
+	return strings.ReplaceAll(mac, ":", macAddressRegistryKeySep)
+}
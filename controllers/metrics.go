@@ -0,0 +1,23 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// clientCertificateExpirySeconds is the time until the first of the client certificates of a cluster
+// expires. It's negative once a certificate has expired.
+var clientCertificateExpirySeconds = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "capmvm_microvmcluster_client_certificate_expiry_seconds",
+		Help: "Seconds until the first of the client certificates used to connect to the flintlock hosts of the MicrovmCluster expires.",
+	},
+	[]string{"namespace", "name"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(clientCertificateExpirySeconds)
+}
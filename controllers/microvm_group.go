@@ -0,0 +1,442 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/utils/ptr"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+const instanceNameSuffixLength = 5
+
+// microvmGroup manages a group of identical microvms that are tracked in the status of a
+// resource, e.g. the microvms of a machine pool. Flintlock has no concept of a group of
+// microvms so the instances recorded in the status are the only record of the members.
+type microvmGroup struct {
+	logr.Logger
+
+	// name is used as the prefix for the names of the microvms.
+	name string
+	// instances points at the instances recorded in the status of the owning resource.
+	instances *[]infrav1.MicrovmInstance
+	// failureDomains are the failure domains the microvms can be spread across.
+	failureDomains []string
+
+	clientFunc flclient.FactoryFunc
+	creds      hostCredentials
+	// scopeFor returns the scope used by the microvm service for an instance.
+	scopeFor func(instance *infrav1.MicrovmInstance) microvmScope
+	// persist saves the instances, it's called before and after each microvm is created.
+	persist func() error
+	// macs allocates the mac addresses of the network interfaces of the microvms.
+	macs *macAddressAllocator
+	// metadataSizeLimit is the maximum size in bytes of the metadata of each microvm, 0 disables
+	// the check.
+	metadataSizeLimit int
+
+	// unreachable are the failure domains whose hosts couldn't be reached while syncing.
+	unreachable map[string]bool
+}
+
+// sync refreshes the state of every microvm in the group from its host. Microvms that no
+// longer exist are removed from the group, failed microvms and microvms marked for deletion
+// are deleted. The microvms on a host that can't be reached are marked as not ready and the
+// rest of the group is still synced.
+func (g *microvmGroup) sync(ctx context.Context) {
+	instances := []infrav1.MicrovmInstance{}
+
+	for i := range *g.instances {
+		instance := (*g.instances)[i]
+
+		exists, err := g.syncInstance(ctx, &instance)
+		if err != nil {
+			g.Error(err, "failed syncing microvm", "instance", instance.Name, "failureDomain", instance.FailureDomain)
+			g.markUnreachable(&instance)
+
+			exists = true
+		}
+
+		if !exists && g.macs != nil {
+			// The instance is kept until its mac addresses are released so that they aren't leaked.
+			if err := g.macs.release(ctx, instance.Name); err != nil {
+				g.Error(err, "failed releasing mac addresses", "instance", instance.Name)
+
+				exists = true
+			}
+		}
+
+		if exists {
+			instances = append(instances, instance)
+		}
+	}
+
+	*g.instances = instances
+}
+
+func (g *microvmGroup) syncInstance(ctx context.Context, instance *infrav1.MicrovmInstance) (bool, error) {
+	if instance.UID == "" {
+		// The instance is recorded before its microvm is created, so the microvm may exist even
+		// though its uid was never saved.
+		adopted, err := g.adoptMicrovm(ctx, instance)
+		if err != nil {
+			return true, err
+		}
+
+		if !adopted {
+			if instance.Deleting {
+				return false, nil
+			}
+
+			return true, g.createMicrovm(ctx, instance)
+		}
+	}
+
+	mvmSvc, err := g.getMicrovmService(instance)
+	if err != nil {
+		return true, fmt.Errorf("getting microvm service for %s: %w", instance.Name, err)
+	}
+	defer mvmSvc.Close()
+
+	mvm, err := mvmSvc.Get(ctx)
+	if err != nil && !isSpecNotFound(err) {
+		return true, fmt.Errorf("failed getting microvm %s: %w", instance.Name, err)
+	}
+
+	if mvm == nil {
+		g.Info("microvm no longer exists, removing from group", "instance", instance.Name)
+
+		return false, nil
+	}
+
+	instance.VMState, instance.Ready = microvmInstanceState(mvm.Status.State)
+
+	if mvm.Status.State == flintlocktypes.MicroVMStatus_FAILED {
+		g.Info("microvm has failed and will be replaced", "instance", instance.Name)
+
+		instance.Deleting = true
+	}
+
+	if instance.Deleting {
+		instance.Ready = false
+
+		if mvm.Status.State != flintlocktypes.MicroVMStatus_DELETING {
+			g.Info("deleting microvm", "instance", instance.Name)
+
+			if _, err := mvmSvc.Delete(ctx); err != nil {
+				return true, fmt.Errorf("deleting microvm %s: %w", instance.Name, err)
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// adoptMicrovm looks for the microvm of an instance whose uid wasn't saved on its host using the
+// name of the instance. It returns true if the microvm exists.
+func (g *microvmGroup) adoptMicrovm(ctx context.Context, instance *infrav1.MicrovmInstance) (bool, error) {
+	mvmClient, err := newMicrovmClient(g.clientFunc, instance.FailureDomain, g.creds)
+	if err != nil {
+		return false, fmt.Errorf("getting microvm client for %s: %w", instance.Name, err)
+	}
+	defer mvmClient.Close()
+
+	resp, err := mvmClient.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{
+		Namespace: g.scopeFor(instance).Namespace(),
+		Name:      ptr.To(instance.Name),
+	})
+	if err != nil {
+		return false, fmt.Errorf("listing microvms for %s: %w", instance.Name, err)
+	}
+
+	for _, mvm := range resp.GetMicrovm() {
+		if mvm.GetSpec().GetId() == instance.Name && mvm.GetSpec().GetUid() != "" {
+			g.Info("adopting existing microvm", "instance", instance.Name, "UID", mvm.GetSpec().GetUid())
+
+			instance.UID = mvm.GetSpec().GetUid()
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// markUnreachable marks an instance whose host couldn't be reached as not ready. The host is
+// avoided when new microvms are created.
+func (g *microvmGroup) markUnreachable(instance *infrav1.MicrovmInstance) {
+	instance.VMState = &microvm.VMStateUnknown
+	instance.Ready = false
+
+	if g.unreachable == nil {
+		g.unreachable = map[string]bool{}
+	}
+
+	g.unreachable[instance.FailureDomain] = true
+}
+
+// scale creates and deletes microvms so that the group ends up with the desired number of running
+// microvms created from the latest spec. When the spec changes the microvms are replaced one at a
+// time and an old microvm is only removed once its replacement is running.
+func (g *microvmGroup) scale(ctx context.Context, desired int, specHash string) error {
+	active, ready, outdated := g.count(specHash)
+
+	toCreate := 0
+
+	switch {
+	case active < desired:
+		toCreate = desired - active
+	case outdated > 0 && active == desired && ready == active:
+		// Surge by one microvm to start replacing the outdated microvms.
+		toCreate = 1
+	}
+
+	for i := 0; i < toCreate; i++ {
+		if err := g.createInstance(ctx, specHash); err != nil {
+			return err
+		}
+	}
+
+	if active <= desired {
+		return nil
+	}
+
+	for _, instance := range g.deletionCandidates(specHash) {
+		if active <= desired {
+			break
+		}
+
+		if instance.Ready && ready <= desired {
+			// Wait for the replacements to be ready before removing running microvms.
+			break
+		}
+
+		g.Info("removing microvm from group", "instance", instance.Name)
+
+		instance.Deleting = true
+		active--
+
+		if instance.Ready {
+			ready--
+		}
+
+		if _, err := g.syncInstance(ctx, instance); err != nil {
+			// The microvm is deleted by a later sync once its host can be reached.
+			g.Error(err, "failed deleting microvm", "instance", instance.Name)
+			g.markUnreachable(instance)
+		}
+	}
+
+	return nil
+}
+
+// deleteAll starts the deletion of all the microvms in the group. It returns true once all the
+// microvms are gone.
+func (g *microvmGroup) deleteAll(ctx context.Context) bool {
+	for i := range *g.instances {
+		(*g.instances)[i].Deleting = true
+	}
+
+	g.sync(ctx)
+
+	return len(*g.instances) == 0
+}
+
+func (g *microvmGroup) createInstance(ctx context.Context, specHash string) error {
+	failureDomain, err := g.selectFailureDomain()
+	if err != nil {
+		return err
+	}
+
+	instance := infrav1.MicrovmInstance{
+		Name:          fmt.Sprintf("%s-%s", g.name, utilrand.String(instanceNameSuffixLength)),
+		FailureDomain: failureDomain,
+		Ordinal:       g.nextOrdinal(),
+		SpecHash:      specHash,
+	}
+
+	if g.macs != nil {
+		interfaces := g.scopeFor(&instance).GetMicrovmSpec().NetworkInterfaces
+
+		instance.NetworkInterfaceMACs, err = g.macs.allocate(ctx, instance.Name, failureDomain, interfaces)
+		if err != nil {
+			return fmt.Errorf("allocating mac addresses for %s: %w", instance.Name, err)
+		}
+	}
+
+	*g.instances = append(*g.instances, instance)
+
+	// Record the instance before its microvm is created so that the microvm is adopted by the next
+	// sync if the response from the host is lost or a later step fails.
+	if err := g.persist(); err != nil {
+		return err
+	}
+
+	if err := g.createMicrovm(ctx, &(*g.instances)[len(*g.instances)-1]); err != nil {
+		return err
+	}
+
+	return g.persist()
+}
+
+// nextOrdinal returns the lowest ordinal that isn't used by an instance that isn't being deleted.
+func (g *microvmGroup) nextOrdinal() int32 {
+	used := map[int32]bool{}
+
+	for _, instance := range *g.instances {
+		if !instance.Deleting {
+			used[instance.Ordinal] = true
+		}
+	}
+
+	var ordinal int32
+	for used[ordinal] {
+		ordinal++
+	}
+
+	return ordinal
+}
+
+// createMicrovm creates the microvm of an instance on its host.
+func (g *microvmGroup) createMicrovm(ctx context.Context, instance *infrav1.MicrovmInstance) error {
+	mvmSvc, err := g.getMicrovmService(instance)
+	if err != nil {
+		return fmt.Errorf("getting microvm service for %s: %w", instance.Name, err)
+	}
+	defer mvmSvc.Close()
+
+	g.Info("creating microvm", "instance", instance.Name, "failureDomain", instance.FailureDomain)
+
+	mvm, err := mvmSvc.Create(ctx)
+	if err != nil {
+		return fmt.Errorf("creating microvm %s: %w", instance.Name, err)
+	}
+
+	instance.UID = *mvm.Spec.Uid
+	instance.VMState, instance.Ready = microvmInstanceState(mvm.Status.State)
+
+	return nil
+}
+
+// count returns the number of microvms that aren't being deleted, how many of those are
+// running and how many were created from an old spec.
+func (g *microvmGroup) count(specHash string) (int, int, int) {
+	active, ready, outdated := 0, 0, 0
+
+	for _, instance := range *g.instances {
+		if instance.Deleting {
+			continue
+		}
+
+		active++
+
+		if instance.Ready {
+			ready++
+		}
+
+		if instance.SpecHash != specHash {
+			outdated++
+		}
+	}
+
+	return active, ready, outdated
+}
+
+// deletionCandidates returns the microvms that aren't being deleted in the order they should
+// be removed from the group: outdated before current and not running before running.
+func (g *microvmGroup) deletionCandidates(specHash string) []*infrav1.MicrovmInstance {
+	candidates := []*infrav1.MicrovmInstance{}
+
+	for i := range *g.instances {
+		if !(*g.instances)[i].Deleting {
+			candidates = append(candidates, &(*g.instances)[i])
+		}
+	}
+
+	priority := func(instance *infrav1.MicrovmInstance) int {
+		p := 0
+		if instance.SpecHash == specHash {
+			p += 2
+		}
+
+		if instance.Ready {
+			p++
+		}
+
+		return p
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return priority(candidates[i]) < priority(candidates[j])
+	})
+
+	return candidates
+}
+
+// selectFailureDomain returns the failure domain with the fewest microvms from the group so that
+// the microvms are spread evenly across the hosts. Hosts that couldn't be reached are skipped.
+func (g *microvmGroup) selectFailureDomain() (string, error) {
+	if len(g.failureDomains) == 0 {
+		return "", errNoFailureDomains
+	}
+
+	counts := map[string]int{}
+
+	for _, instance := range *g.instances {
+		if !instance.Deleting {
+			counts[instance.FailureDomain]++
+		}
+	}
+
+	reachable := []string{}
+
+	for _, fd := range g.failureDomains {
+		if !g.unreachable[fd] {
+			reachable = append(reachable, fd)
+		}
+	}
+
+	if len(reachable) == 0 {
+		return "", errNoReachableFailureDomains
+	}
+
+	selected := reachable[0]
+
+	for _, fd := range reachable[1:] {
+		if counts[fd] < counts[selected] {
+			selected = fd
+		}
+	}
+
+	return selected, nil
+}
+
+func (g *microvmGroup) getMicrovmService(instance *infrav1.MicrovmInstance) (*microvmService, error) {
+	return newMicrovmService(g.clientFunc, instance.FailureDomain, g.creds, g.scopeFor(instance), g.metadataSizeLimit)
+}
+
+func microvmInstanceState(state flintlocktypes.MicroVMStatus_MicroVMState) (*microvm.VMState, bool) {
+	switch state {
+	case flintlocktypes.MicroVMStatus_CREATED:
+		return &microvm.VMStateRunning, true
+	case flintlocktypes.MicroVMStatus_PENDING:
+		return &microvm.VMStatePending, false
+	case flintlocktypes.MicroVMStatus_FAILED:
+		return &microvm.VMStateFailed, false
+	case flintlocktypes.MicroVMStatus_DELETING:
+		return &microvm.VMStateDeleted, false
+	default:
+		return &microvm.VMStateUnknown, false
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+// hostCredentials is implemented by the scopes that can supply what is needed to
+// connect to a flintlock host.
+type hostCredentials interface {
+	// GetHostAuth returns the client option that authenticates with the given host.
+	GetHostAuth(addr string) (flclient.Options, error)
+	// GetTLSConfig returns the TLS config to use when connecting to the given host.
+	GetTLSConfig(addr string) (*flclient.TLSConfig, error)
+	// GetMicrovmProxy returns the proxy to use when connecting to the given host, or nil if
+	// the host is connected to directly.
+	GetMicrovmProxy(addr string) (*flclient.Proxy, error)
+}
+
+// microvmScope is implemented by the scopes of the microvms that the provider creates.
+type microvmScope interface {
+	// Name returns the name of the microvm.
+	Name() string
+	// Namespace returns the namespace of the microvm.
+	Namespace() string
+	// GetMicrovmSpec returns the spec of the microvm.
+	GetMicrovmSpec() microvm.VMSpec
+	// GetMicrovmCreateSpec returns the flintlock spec used to create the microvm on the host
+	// with hostID.
+	GetMicrovmCreateSpec(hostID string) (*flintlocktypes.MicroVMSpec, error)
+	// GetInstanceID returns the UID of the microvm.
+	GetInstanceID() string
+}
+
+// microvmService creates, gets and deletes the microvm described by a scope on a flintlock host.
+type microvmService struct {
+	scope  microvmScope
+	client flclient.Client
+	hostID string
+	// metadataSizeLimit is the maximum size in bytes of the metadata of the microvm, 0 disables
+	// the check.
+	metadataSizeLimit int
+}
+
+// newMicrovmService creates a microvm service for the microvm described by svcScope that
+// will connect to the flintlock host at addr.
+func newMicrovmService(
+	clientFunc flclient.FactoryFunc,
+	addr string,
+	creds hostCredentials,
+	svcScope microvmScope,
+	metadataSizeLimit int,
+) (*microvmService, error) {
+	client, err := newMicrovmClient(clientFunc, addr, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &microvmService{
+		scope:             svcScope,
+		client:            client,
+		hostID:            addr,
+		metadataSizeLimit: metadataSizeLimit,
+	}, nil
+}
+
+// Create creates the microvm using the spec built by the scope. The microvm isn't created if its
+// metadata is larger than the limit.
+func (s *microvmService) Create(ctx context.Context) (*flintlocktypes.MicroVM, error) {
+	spec, err := s.scope.GetMicrovmCreateSpec(s.hostID)
+	if err != nil {
+		return nil, fmt.Errorf("building microvm spec: %w", err)
+	}
+
+	if err := checkMetadataSize(spec, s.metadataSizeLimit); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.CreateMicroVM(ctx, &flintlockv1.CreateMicroVMRequest{Microvm: spec})
+	if err != nil {
+		return nil, fmt.Errorf("creating microvm: %w", err)
+	}
+
+	return resp.GetMicrovm(), nil
+}
+
+// Get returns the microvm.
+func (s *microvmService) Get(ctx context.Context) (*flintlocktypes.MicroVM, error) {
+	resp, err := s.client.GetMicroVM(ctx, &flintlockv1.GetMicroVMRequest{Uid: s.scope.GetInstanceID()})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetMicrovm(), nil
+}
+
+// Delete deletes the microvm.
+func (s *microvmService) Delete(ctx context.Context) (*emptypb.Empty, error) {
+	return s.client.DeleteMicroVM(ctx, &flintlockv1.DeleteMicroVMRequest{Uid: s.scope.GetInstanceID()})
+}
+
+// Close closes the connection to the host.
+func (s *microvmService) Close() {
+	s.client.Close()
+}
+
+// newMicrovmClient creates a client for the flintlock host at addr.
+func newMicrovmClient(
+	clientFunc flclient.FactoryFunc,
+	addr string,
+	creds hostCredentials,
+) (flclient.Client, error) {
+	if clientFunc == nil {
+		return nil, errClientFactoryFuncRequired
+	}
+
+	hostAuth, err := creds.GetHostAuth(addr)
+	if err != nil {
+		return nil, fmt.Errorf("getting host auth: %w", err)
+	}
+
+	tls, err := creds.GetTLSConfig(addr)
+	if err != nil {
+		return nil, fmt.Errorf("getting tls config: %w", err)
+	}
+
+	proxy, err := creds.GetMicrovmProxy(addr)
+	if err != nil {
+		return nil, fmt.Errorf("getting proxy: %w", err)
+	}
+
+	clientOpts := []flclient.Options{
+		flclient.WithProxy(proxy),
+		hostAuth,
+		flclient.WithTLS(tls),
+	}
+
+	client, err := clientFunc(addr, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating microvm client: %w", err)
+	}
+
+	return client, nil
+}
+
+// checkMetadataSize returns an error if the metadata of the microvm, which includes the bootstrap
+// data, is larger than the limit. Otherwise the creation would fail in the metadata service with an
+// error that doesn't explain the cause. A limit of 0 disables the check.
+func checkMetadataSize(spec *flintlocktypes.MicroVMSpec, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	// The metadata service limits the size of all the metadata, not just the user-data, and it's
+	// served as JSON so that is used as the measure of its size.
+	data, err := json.Marshal(spec.GetMetadata())
+	if err != nil {
+		return fmt.Errorf("marshalling microvm metadata: %w", err)
+	}
+
+	if len(data) > limit {
+		return fmt.Errorf("%w: %d bytes is more than the limit of %d bytes", errMetadataTooLarge, len(data), limit)
+	}
+
+	return nil
+}
@@ -11,9 +11,11 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/controllers/remote"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
 	"sigs.k8s.io/cluster-api/util/conditions"
@@ -22,17 +24,30 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/defaults"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/haproxy"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
 )
 
 const (
-	requeuePeriod = 30 * time.Second
+	requeuePeriod        = 30 * time.Second
+	defaultAPIServerPort = 6443
+
+	// loadBalancerSyncPeriod is how often the backends of the load balancer are synced. The changes
+	// made using the runtime API are lost if HAProxy restarts or another microvm takes over the
+	// ControlPlaneEndpoint host address.
+	loadBalancerSyncPeriod = time.Minute
+
+	// DefaultCertificateExpiryWarningWindow is how long before a client certificate expires that
+	// the ClientCertificateNotExpiring condition is set to false.
+	DefaultCertificateExpiryWarningWindow = 30 * 24 * time.Hour
 )
 
 // MicrovmClusterReconciler reconciles a MicrovmCluster object.
@@ -43,12 +58,29 @@ type MicrovmClusterReconciler struct {
 	WatchFilterValue string
 
 	RemoteClientGetter remote.ClusterClientGetter
+	MvmClientFunc      flclient.FactoryFunc
+
+	// HAProxyRuntimeFunc creates the clients for the runtime API of the load balancer.
+	// haproxy.NewRuntime is used if it's nil.
+	HAProxyRuntimeFunc haproxy.RuntimeFactoryFunc
+
+	// ServiceAccount is the service account of the controller, whose tokens are used to
+	// authenticate with the hosts with the bearer token auth types.
+	ServiceAccount types.NamespacedName
+
+	// CertificateExpiryWarningWindow is how long before a client certificate expires that the
+	// ClientCertificateNotExpiring condition is set to false. DefaultCertificateExpiryWarningWindow
+	// is used if it's 0.
+	CertificateExpiryWarningWindow time.Duration
 }
 
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmclusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmclusters/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmclusters/finalizers,verbs=update
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmclusteridentities,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines;machines/status,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -92,7 +124,8 @@ func (r *MicrovmClusterReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	scope, err := scope.NewClusterScope(cluster,
 		mvmCluster,
 		r.Client,
-		scope.WithClusterLogger(log.WithValues("microvmcluster", req.NamespacedName)))
+		scope.WithClusterLogger(log.WithValues("microvmcluster", req.NamespacedName)),
+		scope.WithClusterServiceAccount(r.ServiceAccount))
 	if err != nil {
 		log.Error(err, "creating cluster scope")
 
@@ -115,12 +148,39 @@ func (r *MicrovmClusterReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 }
 
 func (r *MicrovmClusterReconciler) reconcileDelete(
-	_ context.Context,
+	ctx context.Context,
 	clusterScope *scope.ClusterScope,
 ) (reconcile.Result, error) {
 	clusterScope.Info("Reconciling MicrovmCluster delete")
 
-	// We currently do not do any Cluster creation so there is nothing to delete.
+	if clusterScope.MvmCluster.Status.LoadBalancer != nil {
+		conditions.MarkFalse(
+			clusterScope.MvmCluster,
+			infrav1.LoadBalancerAvailableCondition,
+			clusterv1.DeletingReason,
+			clusterv1.ConditionSeverityInfo,
+			"",
+		)
+
+		if !r.loadBalancerGroup(clusterScope, nil, nil).deleteAll(ctx) {
+			return reconcile.Result{RequeueAfter: requeuePeriod}, nil
+		}
+
+		clusterScope.MvmCluster.Status.LoadBalancer = nil
+	}
+
+	if clusterScope.MvmCluster.Spec.ControlPlaneEndpointPoolRef != nil {
+		err := releaseIPAddressClaim(ctx, r.Client, clusterScope.Namespace(), clusterScope.ControlPlaneEndpointClaimName())
+		if err != nil {
+			return reconcile.Result{}, fmt.Errorf("releasing control plane endpoint address: %w", err)
+		}
+	}
+
+	clientCertificateExpirySeconds.DeleteLabelValues(clusterScope.Namespace(), clusterScope.MvmCluster.Name)
+
+	// By this point Flintlock has no record of any of the load balancer microvms and the
+	// endpoint address has been released, so we are good to clear the finalizer
+	controllerutil.RemoveFinalizer(clusterScope.MvmCluster, infrav1.ClusterFinalizer)
 
 	return reconcile.Result{}, nil
 }
@@ -131,6 +191,15 @@ func (r *MicrovmClusterReconciler) reconcileNormal(
 ) (reconcile.Result, error) {
 	cScope.Info("Reconciling MicrovmCluster")
 
+	controllerutil.AddFinalizer(cScope.MvmCluster, infrav1.ClusterFinalizer)
+
+	if cScope.MvmCluster.Spec.ControlPlaneEndpointPoolRef != nil && cScope.MvmCluster.Spec.ControlPlaneEndpoint.Host == "" {
+		allocated, err := r.reconcileControlPlaneEndpoint(ctx, cScope)
+		if err != nil || !allocated {
+			return reconcile.Result{}, err
+		}
+	}
+
 	if cScope.Cluster.Spec.ControlPlaneEndpoint.IsZero() && cScope.MvmCluster.Spec.ControlPlaneEndpoint.IsZero() {
 		return reconcile.Result{}, errControlplaneEndpointRequired
 	}
@@ -141,6 +210,21 @@ func (r *MicrovmClusterReconciler) reconcileNormal(
 		return reconcile.Result{}, fmt.Errorf("setting failuredomains: %w", err)
 	}
 
+	r.reconcileCredentials(cScope)
+
+	if cScope.LoadBalancerEnabled() {
+		return r.reconcileLoadBalancer(ctx, cScope)
+	}
+
+	if cScope.MvmCluster.Status.LoadBalancer != nil {
+		// The load balancer is no longer managed by us so remove any microvms we created.
+		if r.loadBalancerGroup(cScope, nil, nil).deleteAll(ctx) {
+			cScope.MvmCluster.Status.LoadBalancer = nil
+		}
+	}
+
+	conditions.Delete(cScope.MvmCluster, infrav1.LoadBalancerBackendsSyncedCondition)
+
 	available := r.isAPIServerAvailable(ctx, cScope)
 	if !available {
 		conditions.MarkFalse(
@@ -159,6 +243,237 @@ func (r *MicrovmClusterReconciler) reconcileNormal(
 	return reconcile.Result{}, nil
 }
 
+// reconcileControlPlaneEndpoint claims an address for the control plane endpoint from the IP address
+// pool and sets the endpoint once the address has been allocated. It returns true once the endpoint
+// has been set.
+func (r *MicrovmClusterReconciler) reconcileControlPlaneEndpoint(
+	ctx context.Context,
+	cScope *scope.ClusterScope,
+) (bool, error) {
+	address, err := ensureIPAddressClaim(
+		ctx,
+		r.Client,
+		cScope.MvmCluster,
+		cScope.ControlPlaneEndpointClaimName(),
+		cScope.ClusterName(),
+		*cScope.MvmCluster.Spec.ControlPlaneEndpointPoolRef,
+	)
+	if err != nil {
+		conditions.MarkFalse(
+			cScope.MvmCluster,
+			infrav1.ControlPlaneEndpointAllocatedCondition,
+			infrav1.IPAddressClaimFailedReason,
+			clusterv1.ConditionSeverityError,
+			err.Error(),
+		)
+
+		return false, fmt.Errorf("claiming control plane endpoint address: %w", err)
+	}
+
+	if address == nil {
+		cScope.Info("waiting for control plane endpoint address to be allocated")
+		conditions.MarkFalse(
+			cScope.MvmCluster,
+			infrav1.ControlPlaneEndpointAllocatedCondition,
+			infrav1.WaitingForIPAddressReason,
+			clusterv1.ConditionSeverityInfo,
+			"",
+		)
+
+		return false, nil
+	}
+
+	port := cScope.MvmCluster.Spec.ControlPlaneEndpoint.Port
+	if port == 0 {
+		port = defaultAPIServerPort
+	}
+
+	cScope.Info("control plane endpoint address allocated", "address", address.Spec.Address)
+	cScope.MvmCluster.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{
+		Host: address.Spec.Address,
+		Port: port,
+	}
+
+	conditions.MarkTrue(cScope.MvmCluster, infrav1.ControlPlaneEndpointAllocatedCondition)
+
+	return true, nil
+}
+
+// reconcileLoadBalancer makes sure the load balancer microvms for the control plane exist and
+// forward traffic to the current control plane machines. The microvms are created with the current
+// control plane machines and are changed using the HAProxy runtime API when the machines change.
+func (r *MicrovmClusterReconciler) reconcileLoadBalancer(
+	ctx context.Context,
+	cScope *scope.ClusterScope,
+) (reconcile.Result, error) {
+	backends, err := cScope.GetControlPlaneBackends(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	runtimeCreds, err := getLoadBalancerRuntimeCredentials(ctx, r.Client, cScope)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	specHash, err := cScope.LoadBalancerSpecHash(runtimeCreds)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	group := r.loadBalancerGroup(cScope, backends, runtimeCreds)
+
+	group.sync(ctx)
+
+	if err := group.scale(ctx, int(cScope.LoadBalancerReplicas()), specHash); err != nil {
+		conditions.MarkFalse(
+			cScope.MvmCluster,
+			infrav1.LoadBalancerAvailableCondition,
+			infrav1.LoadBalancerFailedReason,
+			clusterv1.ConditionSeverityError,
+			err.Error(),
+		)
+
+		return reconcile.Result{}, fmt.Errorf("scaling load balancer: %w", err)
+	}
+
+	active, ready, outdated := group.count(specHash)
+	if ready == 0 {
+		conditions.MarkFalse(
+			cScope.MvmCluster,
+			infrav1.LoadBalancerAvailableCondition,
+			infrav1.LoadBalancerNotAvailableReason,
+			clusterv1.ConditionSeverityInfo,
+			"no control plane load balancer microvms are running",
+		)
+
+		return reconcile.Result{RequeueAfter: requeuePeriod}, nil
+	}
+
+	conditions.MarkTrue(cScope.MvmCluster, infrav1.LoadBalancerAvailableCondition)
+
+	if err := r.syncLoadBalancerBackends(ctx, cScope, runtimeCreds, backends); err != nil {
+		cScope.Error(err, "failed to sync load balancer backends")
+		conditions.MarkFalse(
+			cScope.MvmCluster,
+			infrav1.LoadBalancerBackendsSyncedCondition,
+			infrav1.LoadBalancerBackendsSyncFailedReason,
+			clusterv1.ConditionSeverityWarning,
+			err.Error(),
+		)
+
+		return reconcile.Result{RequeueAfter: requeuePeriod}, nil
+	}
+
+	if len(backends) > 0 {
+		cScope.LoadBalancerStatus().Backends = backends
+	}
+
+	conditions.MarkTrue(cScope.MvmCluster, infrav1.LoadBalancerBackendsSyncedCondition)
+
+	if outdated > 0 || ready != active || len(cScope.LoadBalancerStatus().Instances) != active {
+		return reconcile.Result{RequeueAfter: requeuePeriod}, nil
+	}
+
+	return reconcile.Result{RequeueAfter: loadBalancerSyncPeriod}, nil
+}
+
+// reconcileCredentials checks the credentials for connecting to the hosts and records the result in
+// the CredentialsValid and ClientCertificateNotExpiring conditions. Invalid credentials don't stop the reconciliation as they are
+// reported again by anything that connects to the hosts.
+func (r *MicrovmClusterReconciler) reconcileCredentials(cScope *scope.ClusterScope) {
+	r.reconcileCertificateExpiry(cScope)
+
+	// Changing the version updates the MicrovmCluster, which queues its MicrovmMachines so that
+	// they connect to their hosts with the new credentials.
+	version, err := cScope.CredentialsVersion()
+	if err != nil {
+		cScope.Error(err, "failed to get the version of the credentials for the microvm hosts")
+	} else {
+		cScope.MvmCluster.Status.CredentialsVersion = version
+	}
+
+	if err := cScope.ValidateCredentials(); err != nil {
+		cScope.Error(err, "credentials for the microvm hosts are invalid")
+		conditions.MarkFalse(
+			cScope.MvmCluster,
+			infrav1.CredentialsValidCondition,
+			infrav1.InvalidCredentialsReason,
+			clusterv1.ConditionSeverityError,
+			err.Error(),
+		)
+
+		return
+	}
+
+	conditions.MarkTrue(cScope.MvmCluster, infrav1.CredentialsValidCondition)
+}
+
+// reconcileCertificateExpiry records when the client certificates expire and warns when they expire
+// within the warning window.
+func (r *MicrovmClusterReconciler) reconcileCertificateExpiry(cScope *scope.ClusterScope) {
+	metricLabels := []string{cScope.Namespace(), cScope.MvmCluster.Name}
+
+	expiry, err := cScope.ClientCertificateExpiry()
+	if err != nil || expiry == nil {
+		// Invalid certificates are already reported by the CredentialsValid condition.
+		clientCertificateExpirySeconds.DeleteLabelValues(metricLabels...)
+		conditions.Delete(cScope.MvmCluster, infrav1.ClientCertificateNotExpiringCondition)
+
+		return
+	}
+
+	remaining := time.Until(*expiry)
+	clientCertificateExpirySeconds.WithLabelValues(metricLabels...).Set(remaining.Seconds())
+
+	window := r.CertificateExpiryWarningWindow
+	if window == 0 {
+		window = DefaultCertificateExpiryWarningWindow
+	}
+
+	if remaining < window {
+		conditions.MarkFalse(
+			cScope.MvmCluster,
+			infrav1.ClientCertificateNotExpiringCondition,
+			infrav1.ClientCertificateExpiringReason,
+			clusterv1.ConditionSeverityWarning,
+			"client certificate expires at %s",
+			expiry.UTC().Format(time.RFC3339),
+		)
+
+		return
+	}
+
+	conditions.MarkTrue(cScope.MvmCluster, infrav1.ClientCertificateNotExpiringCondition)
+}
+
+func (r *MicrovmClusterReconciler) loadBalancerGroup(
+	cScope *scope.ClusterScope,
+	backends []string,
+	runtimeCreds *haproxy.Credentials,
+) *microvmGroup {
+	return &microvmGroup{
+		Logger:         cScope.Logger,
+		name:           fmt.Sprintf("%s-lb", cScope.ClusterName()),
+		instances:      &cScope.LoadBalancerStatus().Instances,
+		failureDomains: cScope.LoadBalancerFailureDomains(),
+		clientFunc:     r.MvmClientFunc,
+		creds:          cScope,
+		scopeFor: func(instance *infrav1.MicrovmInstance) microvmScope {
+			return cScope.LoadBalancerInstanceScope(instance, backends, runtimeCreds)
+		},
+		persist: cScope.Patch,
+		macs: &macAddressAllocator{
+			Logger:      cScope.Logger,
+			client:      r.Client,
+			clientFunc:  r.MvmClientFunc,
+			creds:       cScope,
+			mvmCluster:  cScope.MvmCluster,
+			clusterName: cScope.ClusterName(),
+		},
+	}
+}
+
 func (r *MicrovmClusterReconciler) isAPIServerAvailable(ctx context.Context, clusterScope *scope.ClusterScope) bool {
 	clusterScope.
 		V(defaults.LogLevelDebug).
@@ -203,17 +518,22 @@ func (r *MicrovmClusterReconciler) setFailureDomains(clusterScope *scope.Cluster
 		failureDomains := clusterv1.FailureDomains{}
 
 		for _, host := range placement.StaticPool.Hosts {
+			controlPlane, err := placement.StaticPool.AllowsControlPlane(host)
+			if err != nil {
+				return err
+			}
+
 			clusterScope.
 				V(defaults.LogLevelTrace).
 				Info(
 					"adding failure domain",
 					"endpoint", host.Endpoint,
 					"name", host.Name,
-					"controlplane", host.ControlPlaneAllowed,
+					"controlplane", controlPlane,
 				)
 
 			failureDomains[host.Endpoint] = clusterv1.FailureDomainSpec{
-				ControlPlane: host.ControlPlaneAllowed,
+				ControlPlane: controlPlane,
 			}
 		}
 
@@ -224,6 +544,70 @@ func (r *MicrovmClusterReconciler) setFailureDomains(clusterScope *scope.Cluster
 	return nil
 }
 
+// controlPlaneMachineToMicrovmCluster maps control plane machines to the MicrovmCluster of their
+// cluster so that the load balancer can be kept up to date.
+func (r *MicrovmClusterReconciler) controlPlaneMachineToMicrovmCluster(ctx context.Context, o client.Object) []ctrl.Request {
+	machine, ok := o.(*clusterv1.Machine)
+	if !ok || !util.IsControlPlaneMachine(machine) {
+		return nil
+	}
+
+	cluster, err := util.GetClusterFromMetadata(ctx, r.Client, machine.ObjectMeta)
+	if err != nil {
+		return nil
+	}
+
+	infraRef := cluster.Spec.InfrastructureRef
+	if infraRef == nil || infraRef.GroupVersionKind().GroupKind() != infrav1.GroupVersion.WithKind("MicrovmCluster").GroupKind() {
+		return nil
+	}
+
+	return []ctrl.Request{
+		{
+			NamespacedName: client.ObjectKey{
+				Namespace: cluster.Namespace,
+				Name:      infraRef.Name,
+			},
+		},
+	}
+}
+
+// secretToMicrovmClusters maps a secret to the MicrovmClusters that use it to connect to their
+// hosts so that changed credentials are checked straight away. The MicrovmMachines of the clusters
+// are reconciled when the CredentialsVersion in the status of their cluster changes.
+func (r *MicrovmClusterReconciler) secretToMicrovmClusters(ctx context.Context, o client.Object) []ctrl.Request {
+	mvmClusters, err := microvmClustersUsingSecret(ctx, r.Client, o)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to get microvm clusters for secret", "secret", client.ObjectKeyFromObject(o))
+
+		return nil
+	}
+
+	result := make([]ctrl.Request, 0, len(mvmClusters))
+	for _, mvmCluster := range mvmClusters {
+		result = append(result, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(mvmCluster)})
+	}
+
+	return result
+}
+
+// identityToMicrovmClusters maps a MicrovmClusterIdentity to the MicrovmClusters that reference it.
+func (r *MicrovmClusterReconciler) identityToMicrovmClusters(ctx context.Context, o client.Object) []ctrl.Request {
+	mvmClusters := &infrav1.MicrovmClusterList{}
+	if err := r.List(ctx, mvmClusters, client.MatchingFields{microvmClusterIdentityField: o.GetName()}); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to list microvm clusters")
+
+		return nil
+	}
+
+	result := make([]ctrl.Request, 0, len(mvmClusters.Items))
+	for i := range mvmClusters.Items {
+		result = append(result, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&mvmClusters.Items[i])})
+	}
+
+	return result
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *MicrovmClusterReconciler) SetupWithManager(
 	ctx context.Context,
@@ -236,6 +620,10 @@ func (r *MicrovmClusterReconciler) SetupWithManager(
 		r.RemoteClientGetter = remote.NewClusterClient
 	}
 
+	if err := indexCredentialSecrets(ctx, mgr.GetFieldIndexer()); err != nil {
+		return err
+	}
+
 	builder := ctrl.NewControllerManagedBy(mgr).
 		WithOptions(options).
 		For(&infrav1.MicrovmCluster{}).
@@ -253,6 +641,19 @@ func (r *MicrovmClusterReconciler) SetupWithManager(
 			builder.WithPredicates(
 				predicates.ClusterUnpaused(mgr.GetScheme(), log),
 			),
+		).
+		Owns(&ipamv1.IPAddressClaim{}).
+		Watches(
+			&clusterv1.Machine{},
+			handler.EnqueueRequestsFromMapFunc(r.controlPlaneMachineToMicrovmCluster),
+		).
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.secretToMicrovmClusters),
+		).
+		Watches(
+			&infrav1.MicrovmClusterIdentity{},
+			handler.EnqueueRequestsFromMapFunc(r.identityToMicrovmClusters),
 		)
 
 	if err := builder.Complete(r); err != nil {
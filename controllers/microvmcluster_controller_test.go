@@ -5,6 +5,7 @@ package controllers_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -14,11 +15,14 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util/conditions"
 
 	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
 )
 
 func TestClusterReconciliationNoEndpoint(t *testing.T) {
@@ -276,3 +280,467 @@ func TestClusterReconciliationDelete(t *testing.T) {
 	_, err = getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
 	g.Expect(err).NotTo(HaveOccurred())
 }
+
+func TestClusterReconciliationLoadBalancer(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := []runtime.Object{
+		createCluster(),
+		createLoadBalancedMicrovmCluster(2),
+		createControlPlaneMachine("cp1", "10.0.0.1"),
+	}
+
+	host, fc := newFakeFlintlockHost()
+	lb := newFakeHAProxy("10.0.0.1:6443")
+	client := createFakeClient(g, objects)
+
+	result, err := reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(30*time.Second), "Expect a requeue while the load balancer is starting")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(2), "Expect a microvm to be created for each load balancer replica")
+	g.Expect(lb.addresses).To(BeEmpty(), "Expect the runtime api not to be used until a load balancer is running")
+
+	_, createReq, _ := fc.CreateMicroVMArgsForCall(0)
+	assertHAProxyBackends(g, createReq.Microvm.Metadata["user-data"], "10.0.0.1:6443")
+	g.Expect(createReq.Microvm.Labels).To(HaveKeyWithValue("control-plane-load-balancer", testClusterName))
+	g.Expect(createReq.Microvm.Interfaces[0].GuestMac).To(HaveValue(HavePrefix("02:")), "Expect a mac address to be allocated")
+
+	_, otherReq, _ := fc.CreateMicroVMArgsForCall(1)
+	g.Expect(keepalivedPriority(g, otherReq.Microvm.Metadata["user-data"])).NotTo(
+		Equal(keepalivedPriority(g, createReq.Microvm.Metadata["user-data"])),
+		"Expect each replica to have a different keepalived priority",
+	)
+
+	reconciled, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Finalizers).To(ContainElement(infrav1.ClusterFinalizer))
+	g.Expect(reconciled.Status.LoadBalancer).NotTo(BeNil())
+	g.Expect(reconciled.Status.LoadBalancer.Instances).To(HaveLen(2))
+	assertConditionFalse(g, reconciled, infrav1.LoadBalancerAvailableCondition, infrav1.LoadBalancerNotAvailableReason)
+
+	secret := &corev1.Secret{}
+	g.Expect(client.Get(context.TODO(), types.NamespacedName{Name: testClusterName + "-lb-runtime", Namespace: testClusterNamespace}, secret)).To(Succeed())
+	g.Expect(secret.OwnerReferences).To(HaveLen(1), "Expect the runtime api certificates to be owned by the cluster")
+	g.Expect(secret.Data).To(HaveKey("ca.crt"))
+
+	host.startAll()
+
+	result, err = reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(time.Minute), "Expect the backends to be synced periodically")
+	g.Expect(lb.addresses).To(ContainElement("192.168.8.15:9999"), "Expect the runtime api to be reached using the control plane endpoint")
+
+	reconciled, err = getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Status.LoadBalancer.Backends).To(Equal([]string{"10.0.0.1:6443"}))
+	assertConditionTrue(g, reconciled, infrav1.LoadBalancerAvailableCondition)
+	assertConditionTrue(g, reconciled, infrav1.LoadBalancerBackendsSyncedCondition)
+}
+
+func TestClusterReconciliationLoadBalancerBackendsChange(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := []runtime.Object{
+		createCluster(),
+		createLoadBalancedMicrovmCluster(1),
+		createControlPlaneMachine("cp1", "10.0.0.1"),
+	}
+
+	host, fc := newFakeFlintlockHost()
+	lb := newFakeHAProxy("10.0.0.1:6443")
+	client := createFakeClient(g, objects)
+
+	_, err := reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	host.startAll()
+
+	g.Expect(client.Create(context.TODO(), createControlPlaneMachine("cp2", "10.0.0.2"))).To(Succeed())
+
+	_, err = reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(1), "Expect the load balancer not to be replaced")
+	g.Expect(lb.backends()).To(ConsistOf("10.0.0.1:6443", "10.0.0.2:6443"), "Expect the new machine to be added using the runtime api")
+
+	reconciled, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Status.LoadBalancer.Backends).To(Equal([]string{"10.0.0.1:6443", "10.0.0.2:6443"}))
+	assertConditionTrue(g, reconciled, infrav1.LoadBalancerBackendsSyncedCondition)
+}
+
+func TestClusterReconciliationLoadBalancerControlPlaneRollout(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := []runtime.Object{
+		createCluster(),
+		createLoadBalancedMicrovmCluster(1),
+		createControlPlaneMachine("cp1", "10.0.0.1"),
+	}
+
+	host, fc := newFakeFlintlockHost()
+	lb := newFakeHAProxy("10.0.0.1:6443")
+	client := createFakeClient(g, objects)
+
+	_, err := reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	host.startAll()
+
+	// The rolling update adds the new machine and then removes the old one.
+	g.Expect(client.Create(context.TODO(), createControlPlaneMachine("cp2", "10.0.0.2"))).To(Succeed())
+
+	_, err = reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(lb.backends()).To(ConsistOf("10.0.0.1:6443", "10.0.0.2:6443"))
+
+	cp1, err := getMachine(client, "cp1", testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(client.Delete(context.TODO(), cp1)).To(Succeed())
+
+	_, err = reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(lb.backends()).To(ConsistOf("10.0.0.2:6443"), "Expect the old machine to be removed using the runtime api")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(1), "Expect the load balancer not to be replaced")
+	g.Expect(fc.DeleteMicroVMCallCount()).To(Equal(0), "Expect the load balancer not to be replaced")
+	g.Expect(host.count(flintlocktypes.MicroVMStatus_CREATED)).To(Equal(1), "Expect the running load balancer to be kept")
+
+	reconciled, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Status.LoadBalancer.Backends).To(Equal([]string{"10.0.0.2:6443"}))
+	assertConditionTrue(g, reconciled, infrav1.LoadBalancerAvailableCondition)
+}
+
+func TestClusterReconciliationLoadBalancerSyncFailed(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := []runtime.Object{
+		createCluster(),
+		createLoadBalancedMicrovmCluster(1),
+		createControlPlaneMachine("cp1", "10.0.0.1"),
+	}
+
+	host, fc := newFakeFlintlockHost()
+	lb := newFakeHAProxy("10.0.0.1:6443")
+	client := createFakeClient(g, objects)
+
+	_, err := reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	host.startAll()
+
+	g.Expect(client.Create(context.TODO(), createControlPlaneMachine("cp2", "10.0.0.2"))).To(Succeed())
+	lb.err = errors.New("connection refused")
+
+	result, err := reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(30*time.Second), "Expect the sync to be retried")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(1), "Expect the load balancer not to be replaced")
+
+	reconciled, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Status.LoadBalancer.Backends).To(BeEmpty(), "Expect the backends not to be recorded until they're synced")
+	assertConditionTrue(g, reconciled, infrav1.LoadBalancerAvailableCondition)
+	assertConditionFalse(g, reconciled, infrav1.LoadBalancerBackendsSyncedCondition, infrav1.LoadBalancerBackendsSyncFailedReason)
+
+	lb.err = nil
+
+	_, err = reconcileClusterWithLoadBalancer(client, fc, lb)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(lb.backends()).To(ConsistOf("10.0.0.1:6443", "10.0.0.2:6443"))
+}
+
+func TestClusterReconciliationDeleteLoadBalancer(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := []runtime.Object{
+		createCluster(),
+		createLoadBalancedMicrovmCluster(2),
+	}
+
+	host, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, objects)
+
+	_, err := reconcileClusterWithMvmClient(client, fc)
+	g.Expect(err).NotTo(HaveOccurred())
+	host.startAll()
+
+	mvmCluster, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(client.Delete(context.TODO(), mvmCluster)).To(Succeed())
+
+	result, err := reconcileClusterWithMvmClient(client, fc)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(30*time.Second), "Expect a requeue while the load balancer is deleted")
+	g.Expect(fc.DeleteMicroVMCallCount()).To(Equal(2))
+
+	_, err = getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Expect the finalizer to be kept until the load balancer is gone")
+
+	host.finishDeletes()
+
+	_, err = reconcileClusterWithMvmClient(client, fc)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Expect the microvm cluster to be removed once the finalizer is cleared")
+}
+
+func TestClusterReconciliationControlPlaneEndpointFromPool(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := []runtime.Object{
+		createCluster(),
+		createIPAMMicrovmCluster(),
+	}
+
+	client := createFakeClient(g, objects)
+	result, err := reconcileCluster(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling while waiting for an address should not error")
+	g.Expect(result.IsZero()).To(BeTrue(), "Expect no requeue, the claim being fulfilled triggers a reconcile")
+
+	claim := &ipamv1.IPAddressClaim{}
+	claimKey := types.NamespacedName{Name: testClusterName + "-control-plane-endpoint", Namespace: testClusterNamespace}
+	g.Expect(client.Get(context.TODO(), claimKey, claim)).To(Succeed(), "Expect an address to be claimed from the pool")
+	g.Expect(claim.Spec.PoolRef.Name).To(Equal("pool1"))
+	g.Expect(claim.Labels).To(HaveKeyWithValue(clusterv1.ClusterNameLabel, testClusterName))
+
+	reconciled, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Spec.ControlPlaneEndpoint.IsZero()).To(BeTrue())
+	assertConditionFalse(g, reconciled, infrav1.ControlPlaneEndpointAllocatedCondition, infrav1.WaitingForIPAddressReason)
+
+	allocateIPAddress(g, client, claimKey.Name, "192.168.8.20")
+
+	_, err = reconcileCluster(client)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	reconciled, err = getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Spec.ControlPlaneEndpoint).To(Equal(clusterv1.APIEndpoint{Host: "192.168.8.20", Port: 6443}))
+	assertConditionTrue(g, reconciled, infrav1.ControlPlaneEndpointAllocatedCondition)
+}
+
+func TestClusterReconciliationDeleteReleasesControlPlaneEndpoint(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := []runtime.Object{
+		createCluster(),
+		createIPAMMicrovmCluster(),
+	}
+
+	client := createFakeClient(g, objects)
+	_, err := reconcileCluster(client)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	claimKey := types.NamespacedName{Name: testClusterName + "-control-plane-endpoint", Namespace: testClusterNamespace}
+	allocateIPAddress(g, client, claimKey.Name, "192.168.8.20")
+
+	_, err = reconcileCluster(client)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	mvmCluster, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(client.Delete(context.TODO(), mvmCluster)).To(Succeed())
+
+	_, err = reconcileCluster(client)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	err = client.Get(context.TODO(), claimKey, &ipamv1.IPAddressClaim{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Expect the address claim to be released")
+
+	_, err = getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Expect the microvm cluster to be removed once the finalizer is cleared")
+}
+
+func TestClusterReconciliationCredentials(t *testing.T) {
+	tt := []struct {
+		name     string
+		secret   func(g *WithT) *corev1.Secret
+		expected func(g *WithT, reconciled *infrav1.MicrovmCluster)
+	}{
+		{
+			name: "valid tls secret",
+			secret: func(g *WithT) *corev1.Secret {
+				return createTLSSecret(g, "flintlock-tls", time.Now().Add(time.Hour))
+			},
+			expected: func(g *WithT, reconciled *infrav1.MicrovmCluster) {
+				assertConditionTrue(g, reconciled, infrav1.CredentialsValidCondition)
+			},
+		},
+		{
+			name: "expired tls certificate",
+			secret: func(g *WithT) *corev1.Secret {
+				return createTLSSecret(g, "flintlock-tls", time.Now().Add(-time.Hour))
+			},
+			expected: func(g *WithT, reconciled *infrav1.MicrovmCluster) {
+				assertConditionFalse(g, reconciled, infrav1.CredentialsValidCondition, infrav1.InvalidCredentialsReason)
+			},
+		},
+		{
+			name: "key doesn't match the certificate",
+			secret: func(g *WithT) *corev1.Secret {
+				secret := createTLSSecret(g, "flintlock-tls", time.Now().Add(time.Hour))
+				secret.Data["tls.key"] = createTLSSecret(g, "other", time.Now().Add(time.Hour)).Data["tls.key"]
+
+				return secret
+			},
+			expected: func(g *WithT, reconciled *infrav1.MicrovmCluster) {
+				assertConditionFalse(g, reconciled, infrav1.CredentialsValidCondition, infrav1.InvalidCredentialsReason)
+			},
+		},
+		{
+			name: "ca can't be parsed",
+			secret: func(g *WithT) *corev1.Secret {
+				secret := createTLSSecret(g, "flintlock-tls", time.Now().Add(time.Hour))
+				secret.Data["ca.crt"] = []byte("not a certificate")
+
+				return secret
+			},
+			expected: func(g *WithT, reconciled *infrav1.MicrovmCluster) {
+				assertConditionFalse(g, reconciled, infrav1.CredentialsValidCondition, infrav1.InvalidCredentialsReason)
+			},
+		},
+		{
+			name: "missing tls secret",
+			secret: func(g *WithT) *corev1.Secret {
+				return nil
+			},
+			expected: func(g *WithT, reconciled *infrav1.MicrovmCluster) {
+				assertConditionFalse(g, reconciled, infrav1.CredentialsValidCondition, infrav1.InvalidCredentialsReason)
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			mvmCluster := createMicrovmCluster()
+			mvmCluster.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{
+				Host: "192.168.8.15",
+				Port: 6443,
+			}
+			mvmCluster.Spec.TLSSecretRef = "flintlock-tls"
+
+			objects := []runtime.Object{
+				createCluster(),
+				mvmCluster,
+				&corev1.NodeList{},
+			}
+			if secret := tc.secret(g); secret != nil {
+				objects = append(objects, secret)
+			}
+
+			client := createFakeClient(g, objects)
+			_, err := reconcileCluster(client)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			reconciled, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+			g.Expect(err).NotTo(HaveOccurred())
+			tc.expected(g, reconciled)
+		})
+	}
+}
+
+func TestClusterReconciliationCertificateExpiry(t *testing.T) {
+	tt := []struct {
+		name      string
+		expiresIn time.Duration
+		expected  func(g *WithT, reconciled *infrav1.MicrovmCluster)
+	}{
+		{
+			name:      "certificate expires after the warning window",
+			expiresIn: 60 * 24 * time.Hour,
+			expected: func(g *WithT, reconciled *infrav1.MicrovmCluster) {
+				assertConditionTrue(g, reconciled, infrav1.ClientCertificateNotExpiringCondition)
+			},
+		},
+		{
+			name:      "certificate expires within the warning window",
+			expiresIn: 10 * 24 * time.Hour,
+			expected: func(g *WithT, reconciled *infrav1.MicrovmCluster) {
+				assertConditionFalse(g, reconciled, infrav1.ClientCertificateNotExpiringCondition, infrav1.ClientCertificateExpiringReason)
+				g.Expect(conditions.GetSeverity(reconciled, infrav1.ClientCertificateNotExpiringCondition)).
+					To(HaveValue(Equal(clusterv1.ConditionSeverityWarning)))
+				assertConditionTrue(g, reconciled, infrav1.CredentialsValidCondition)
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			mvmCluster := createMicrovmCluster()
+			mvmCluster.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{
+				Host: "192.168.8.15",
+				Port: 6443,
+			}
+			mvmCluster.Spec.TLSSecretRef = "flintlock-tls"
+
+			objects := []runtime.Object{
+				createCluster(),
+				mvmCluster,
+				&corev1.NodeList{},
+				createTLSSecret(g, "flintlock-tls", time.Now().Add(tc.expiresIn)),
+			}
+
+			client := createFakeClient(g, objects)
+			_, err := reconcileCluster(client)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			reconciled, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+			g.Expect(err).NotTo(HaveOccurred())
+			tc.expected(g, reconciled)
+
+			expirySeconds, found := gaugeValue(g, "capmvm_microvmcluster_client_certificate_expiry_seconds", testClusterNamespace, testClusterName)
+			g.Expect(found).To(BeTrue())
+			g.Expect(expirySeconds).To(BeNumerically("~", tc.expiresIn.Seconds(), 60))
+		})
+	}
+}
+
+func TestClusterReconciliationCredentialsVersion(t *testing.T) {
+	g := NewWithT(t)
+
+	mvmCluster := createMicrovmCluster()
+	mvmCluster.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{
+		Host: "192.168.8.15",
+		Port: 6443,
+	}
+	mvmCluster.Spec.TLSSecretRef = "flintlock-tls"
+
+	objects := []runtime.Object{
+		createCluster(),
+		mvmCluster,
+		&corev1.NodeList{},
+		createTLSSecret(g, "flintlock-tls", time.Now().Add(time.Hour)),
+	}
+
+	client := createFakeClient(g, objects)
+	_, err := reconcileCluster(client)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	reconciled, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Status.CredentialsVersion).NotTo(BeEmpty())
+
+	version := reconciled.Status.CredentialsVersion
+
+	_, err = reconcileCluster(client)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	reconciled, err = getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Status.CredentialsVersion).To(Equal(version), "Expect the version not to change without a change to the secret")
+
+	rotated := createTLSSecret(g, "flintlock-tls", time.Now().Add(2*time.Hour))
+	secret := &corev1.Secret{}
+	g.Expect(client.Get(context.TODO(), types.NamespacedName{Name: "flintlock-tls", Namespace: testClusterNamespace}, secret)).To(Succeed())
+	secret.Data = rotated.Data
+	g.Expect(client.Update(context.TODO(), secret)).To(Succeed())
+
+	_, err = reconcileCluster(client)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	reconciled, err = getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(reconciled.Status.CredentialsVersion).NotTo(Equal(version), "Expect the version to change when the secret is rotated")
+}
@@ -5,18 +5,21 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/go-logr/logr"
-	flclient "github.com/liquidmetal-dev/controller-pkg/client"
-	flservice "github.com/liquidmetal-dev/controller-pkg/services/microvm"
 	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
 	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
 	"sigs.k8s.io/cluster-api/util/collections"
@@ -33,6 +36,7 @@ import (
 
 	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/defaults"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
 )
 
@@ -44,6 +48,12 @@ type MicrovmMachineReconciler struct {
 	WatchFilterValue string
 
 	MvmClientFunc flclient.FactoryFunc
+	// MetadataSizeLimit is the maximum size in bytes of all the metadata of a microvm as JSON, not
+	// just the user-data, 0 disables the check.
+	MetadataSizeLimit int
+	// ServiceAccount is the service account of the controller, whose tokens are used to
+	// authenticate with the hosts with the bearer token auth types.
+	ServiceAccount types.NamespacedName
 }
 
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmmachines,verbs=get;list;watch;create;update;patch;delete
@@ -125,7 +135,7 @@ func (r *MicrovmMachineReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		MicroVMMachine: mvmMachine,
 		Client:         r.Client,
 		Context:        ctx,
-	})
+	}, scope.WithMachineServiceAccount(r.ServiceAccount))
 	if err != nil {
 		log.Error(err, "failed to create machine scope")
 
@@ -153,6 +163,11 @@ func (r *MicrovmMachineReconciler) reconcileDelete(
 ) (reconcile.Result, error) {
 	machineScope.Info("Reconciling MicrovmMachine delete")
 
+	if machineScope.MvmMachine.Status.FailureDomain == "" && machineScope.GetProviderID() == "" {
+		// The host is recorded before the microvm is created, so the machine was never placed.
+		return r.finalizeDelete(ctx, machineScope)
+	}
+
 	failureDomain, err := machineScope.GetFailureDomain()
 	if err != nil {
 		machineScope.Error(err, "failed to get the failure domain")
@@ -160,6 +175,24 @@ func (r *MicrovmMachineReconciler) reconcileDelete(
 		return ctrl.Result{}, err
 	}
 
+	if machineScope.GetProviderID() == "" {
+		// The microvm may have been created without its provider id being saved, so it's adopted
+		// to be deleted rather than being left on the host.
+		existing, findErr := r.findMicrovm(ctx, failureDomain, machineScope)
+		if findErr != nil {
+			machineScope.Error(findErr, "failed looking for an existing microvm")
+
+			return ctrl.Result{}, findErr
+		}
+
+		if existing == nil {
+			return r.finalizeDelete(ctx, machineScope)
+		}
+
+		machineScope.Info("adopting existing microvm to delete it", "UID", existing.GetSpec().GetUid())
+		machineScope.SetProviderID(failureDomain, existing.GetSpec().GetUid())
+	}
+
 	mvmSvc, err := r.getMicrovmService(failureDomain, machineScope)
 	if err != nil {
 		machineScope.Error(err, "failed to get microvm service")
@@ -198,8 +231,33 @@ func (r *MicrovmMachineReconciler) reconcileDelete(
 		return ctrl.Result{RequeueAfter: requeuePeriod}, nil
 	}
 
-	// By this point Flintlock has no record of the MvM, so we are good to clear
-	// the finalizer
+	return r.finalizeDelete(ctx, machineScope)
+}
+
+// finalizeDelete releases the mac and ip addresses of the machine and removes the finalizer once
+// its microvm has been deleted.
+func (r *MicrovmMachineReconciler) finalizeDelete(
+	ctx context.Context,
+	machineScope *scope.MachineScope,
+) (reconcile.Result, error) {
+	if err := r.releaseMACAddresses(ctx, machineScope); err != nil {
+		machineScope.Error(err, "failed to release mac addresses")
+
+		return ctrl.Result{}, err
+	}
+
+	for _, pool := range machineScope.MvmMachine.Spec.NetworkInterfaceAddressPools {
+		claimName := machineScope.InterfaceAddressClaimName(pool.GuestDeviceName)
+
+		if err := releaseIPAddressClaim(ctx, r.Client, machineScope.Namespace(), claimName); err != nil {
+			machineScope.Error(err, "failed to release ip address", "interface", pool.GuestDeviceName)
+
+			return ctrl.Result{}, err
+		}
+	}
+
+	// By this point Flintlock has no record of the MvM and its mac and ip addresses
+	// have been released, so we are good to clear the finalizer
 	controllerutil.RemoveFinalizer(machineScope.MvmMachine, infrav1.MachineFinalizer)
 
 	machineScope.Info("microvm deleted")
@@ -240,13 +298,40 @@ func (r *MicrovmMachineReconciler) reconcileNormal(
 		"machine", machineScope.MvmMachine.Name,
 		"secret", machineScope.Machine.Spec.Bootstrap.DataSecretName)
 
+	allocated, err := r.reconcileIPAddresses(ctx, machineScope)
+	if err != nil {
+		machineScope.Error(err, "failed to claim ip addresses")
+
+		return ctrl.Result{}, err
+	}
+
+	if !allocated {
+		machineScope.Info("Waiting for ip addresses to be allocated")
+		machineScope.SetNotReady(infrav1.WaitingForIPAddressReason, clusterv1.ConditionSeverityInfo, "")
+
+		return ctrl.Result{}, nil
+	}
+
 	failureDomain, err := machineScope.GetFailureDomain()
 	if err != nil {
 		machineScope.Error(err, "failed to get the failure domain")
+		machineScope.SetNotReady(infrav1.MicrovmPlacementFailedReason, clusterv1.ConditionSeverityError, err.Error())
 
 		return ctrl.Result{}, err
 	}
 
+	if machineScope.MvmMachine.Status.FailureDomain == "" {
+		// Record the host before the microvm is created so it's looked for on the same host,
+		// even if the failure domains of the cluster change.
+		machineScope.SetFailureDomain(failureDomain)
+
+		if err := machineScope.Patch(); err != nil {
+			machineScope.Error(err, "failed to patch object")
+
+			return ctrl.Result{}, err
+		}
+	}
+
 	mvmSvc, err := r.getMicrovmService(failureDomain, machineScope)
 	if err != nil {
 		machineScope.Error(err, "failed to get microvm service")
@@ -278,17 +363,46 @@ func (r *MicrovmMachineReconciler) reconcileNormal(
 	}
 
 	if microvm == nil {
+		// The microvm may have been created without its provider id being saved, e.g. if the
+		// controller restarted or the response from the host was lost.
+		var findErr error
+
+		microvm, findErr = r.findMicrovm(ctx, failureDomain, machineScope)
+		if findErr != nil {
+			machineScope.Error(findErr, "failed looking for an existing microvm")
+
+			return ctrl.Result{}, findErr
+		}
+
+		if microvm != nil {
+			machineScope.Info("adopting existing microvm", "UID", microvm.GetSpec().GetUid())
+		}
+	}
+
+	if microvm == nil {
+		if err := r.reconcileMACAddresses(ctx, machineScope, failureDomain); err != nil {
+			machineScope.Error(err, "failed to allocate mac addresses")
+			machineScope.SetNotReady(infrav1.MACAddressAllocationFailedReason, clusterv1.ConditionSeverityError, err.Error())
+
+			return ctrl.Result{}, err
+		}
+
 		machineScope.Info("creating microvm")
 
 		var createErr error
 
 		microvm, createErr = mvmSvc.Create(ctx)
 		if createErr != nil {
+			if errors.Is(createErr, errMetadataTooLarge) {
+				machineScope.SetNotReady(infrav1.MicrovmMetadataTooLargeReason, clusterv1.ConditionSeverityError, createErr.Error())
+			}
+
 			return ctrl.Result{}, createErr
 		}
 	}
 
 	machineScope.SetProviderID(failureDomain, *microvm.Spec.Uid)
+	machineScope.SetAddresses()
 
 	if err := machineScope.Patch(); err != nil {
 		machineScope.Error(err, "unable to patch microvm machine")
@@ -299,36 +413,80 @@ func (r *MicrovmMachineReconciler) reconcileNormal(
 	return r.parseMicroVMState(machineScope, microvm.Status.State)
 }
 
-func (r *MicrovmMachineReconciler) getMicrovmService(
-	addr string,
+// reconcileIPAddresses claims the static addresses for the network interfaces that reference an IP
+// address pool and sets them on the machine scope. It returns true once all the addresses have
+// been allocated.
+func (r *MicrovmMachineReconciler) reconcileIPAddresses(
+	ctx context.Context,
 	machineScope *scope.MachineScope,
-) (*flservice.Service, error) {
-	if r.MvmClientFunc == nil {
-		return nil, errClientFactoryFuncRequired
+) (bool, error) {
+	allocated := true
+
+	for _, pool := range machineScope.MvmMachine.Spec.NetworkInterfaceAddressPools {
+		address, err := ensureIPAddressClaim(
+			ctx,
+			r.Client,
+			machineScope.MvmMachine,
+			machineScope.InterfaceAddressClaimName(pool.GuestDeviceName),
+			machineScope.ClusterName(),
+			pool.PoolRef,
+		)
+		if err != nil {
+			return false, err
+		}
+
+		if address == nil {
+			allocated = false
+
+			continue
+		}
+
+		machineScope.SetInterfaceAddress(pool.GuestDeviceName, scope.InterfaceAddress{
+			Address:     fmt.Sprintf("%s/%d", address.Spec.Address, address.Spec.Prefix),
+			Gateway:     address.Spec.Gateway,
+			Nameservers: pool.Nameservers,
+		})
 	}
 
-	token, err := machineScope.GetBasicAuthToken(addr)
+	return allocated, nil
+}
+
+// findMicrovm returns the microvm on the host that was created for the machine, or nil if there
+// isn't one. The microvm is identified by its name, namespace and the uid of the machine.
+func (r *MicrovmMachineReconciler) findMicrovm(
+	ctx context.Context,
+	addr string,
+	machineScope *scope.MachineScope,
+) (*flintlocktypes.MicroVM, error) {
+	mvmClient, err := newMicrovmClient(r.MvmClientFunc, addr, machineScope)
 	if err != nil {
-		return nil, fmt.Errorf("getting basic auth token: %w", err)
+		return nil, err
 	}
+	defer mvmClient.Close()
 
-	tls, err := machineScope.GetTLSConfig()
+	resp, err := mvmClient.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{
+		Namespace: machineScope.Namespace(),
+		Name:      ptr.To(machineScope.Name()),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("getting tls config: %w", err)
+		return nil, fmt.Errorf("listing microvms on host %s: %w", addr, err)
 	}
 
-	clientOpts := []flclient.Options{
-		flclient.WithProxy(machineScope.MvmCluster.Spec.MicrovmProxy),
-		flclient.WithBasicAuth(token),
-		flclient.WithTLS(tls),
+	for _, mvm := range resp.GetMicrovm() {
+		uid, ok := mvm.GetSpec().GetLabels()[scope.MachineUIDLabel]
+		if ok && uid == string(machineScope.MvmMachine.UID) {
+			return mvm, nil
+		}
 	}
 
-	client, err := r.MvmClientFunc(addr, clientOpts...)
-	if err != nil {
-		return nil, fmt.Errorf("creating microvm client: %w", err)
-	}
+	return nil, nil
+}
 
-	return flservice.New(machineScope, client, addr), nil
+func (r *MicrovmMachineReconciler) getMicrovmService(
+	addr string,
+	machineScope *scope.MachineScope,
+) (*microvmService, error) {
+	return newMicrovmService(r.MvmClientFunc, addr, machineScope, machineScope, r.MetadataSizeLimit)
 }
 
 func (r *MicrovmMachineReconciler) parseMicroVMState(
@@ -398,6 +556,7 @@ func (r *MicrovmMachineReconciler) SetupWithManager(
 	builder := ctrl.NewControllerManagedBy(mgr).
 		WithOptions(options).
 		For(&infrav1.MicrovmMachine{}).
+		Owns(&ipamv1.IPAddressClaim{}).
 		WithEventFilter(predicates.ResourceNotPausedAndHasFilterLabel(mgr.GetScheme(), log, r.WatchFilterValue)).
 		WithEventFilter(predicates.ResourceIsNotExternallyManaged(mgr.GetScheme(), log)).
 		Watches(
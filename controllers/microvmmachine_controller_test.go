@@ -4,24 +4,42 @@
 package controllers_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
 	"testing"
 	"time"
 
 	. "github.com/onsi/gomega"
 
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/pointer"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
 	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/userdata"
+	"gopkg.in/yaml.v2"
 
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/controllers"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/controllers/fakes"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -273,7 +291,7 @@ func TestMachineReconcileNoVmCreateSucceeds(t *testing.T) {
 
 	_, createReq, _ := fakeAPIClient.CreateMicroVMArgsForCall(0)
 	g.Expect(createReq.Microvm).ToNot(BeNil())
-	g.Expect(createReq.Microvm.Labels).To(HaveLen(1))
+	g.Expect(createReq.Microvm.Labels).To(HaveLen(2))
 	g.Expect(createReq.Microvm.Metadata).To(HaveLen(3))
 	expectedBootstrapData := base64.StdEncoding.EncodeToString([]byte(testbootStrapData))
 	g.Expect(createReq.Microvm.Metadata).To(HaveKeyWithValue("user-data", expectedBootstrapData))
@@ -340,7 +358,7 @@ func TestMachineReconcileNoVmCreateClusterSSHSucceeds(t *testing.T) {
 
 	_, createReq, _ := fakeAPIClient.CreateMicroVMArgsForCall(0)
 	g.Expect(createReq.Microvm).ToNot(BeNil())
-	g.Expect(createReq.Microvm.Labels).To(HaveLen(1))
+	g.Expect(createReq.Microvm.Labels).To(HaveLen(2))
 	g.Expect(createReq.Microvm.Metadata).To(HaveLen(3))
 
 	expectedBootstrapData := base64.StdEncoding.EncodeToString([]byte(testbootStrapData))
@@ -378,7 +396,7 @@ func TestMachineReconcileNoVmCreateClusterMachineSSHSucceeds(t *testing.T) {
 
 	_, createReq, _ := fakeAPIClient.CreateMicroVMArgsForCall(0)
 	g.Expect(createReq.Microvm).ToNot(BeNil())
-	g.Expect(createReq.Microvm.Labels).To(HaveLen(1))
+	g.Expect(createReq.Microvm.Labels).To(HaveLen(2))
 	g.Expect(createReq.Microvm.Metadata).To(HaveLen(3))
 
 	expectedBootstrapData := base64.StdEncoding.EncodeToString([]byte(testbootStrapData))
@@ -509,3 +527,485 @@ func TestMachineReconcileDeleteDeleteErrors(t *testing.T) {
 	// assertConditionFalse(g, reconciled, infrav1.MicrovmReadyCondition, infrav1.MicrovmDeleteFailedReason)
 	// assertMachineNotReady(g, reconciled)
 }
+
+func TestMachineReconcileNoVmCreateWithPoolAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	withInterfaceAddressPool(apiObjects.MvmMachine)
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	result, err := reconcileMachine(client, &fakeAPIClient)
+
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling while waiting for an address should not return error")
+	g.Expect(result.IsZero()).To(BeTrue(), "Expect no requeue, the claim being fulfilled triggers a reconcile")
+	g.Expect(fakeAPIClient.CreateMicroVMCallCount()).To(Equal(0), "Expect no microvm to be created before the address is allocated")
+
+	claimName := testMachineName + "-eth0"
+	claim := &ipamv1.IPAddressClaim{}
+	g.Expect(client.Get(context.TODO(), types.NamespacedName{Name: claimName, Namespace: testClusterNamespace}, claim)).To(Succeed())
+	g.Expect(claim.Spec.PoolRef.Name).To(Equal("pool1"))
+
+	allocateIPAddress(g, client, claimName, "192.168.8.30")
+
+	_, err = reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when creating microvm should not return error")
+	g.Expect(fakeAPIClient.CreateMicroVMCallCount()).To(Equal(1))
+
+	_, createReq, _ := fakeAPIClient.CreateMicroVMArgsForCall(0)
+	g.Expect(createReq.Microvm.Interfaces).To(HaveLen(1))
+	address := createReq.Microvm.Interfaces[0].Address
+	g.Expect(address).NotTo(BeNil(), "Expect the interface to have a static address")
+	g.Expect(address.Address).To(Equal("192.168.8.30/24"))
+	g.Expect(address.Gateway).To(Equal(pointer.String("192.168.8.1")))
+	g.Expect(address.Nameservers).To(Equal([]string{"1.1.1.1"}))
+
+	reconciled, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine should not fail")
+	g.Expect(reconciled.Spec.NetworkInterfaces[0].Address).To(BeEmpty(), "Expect the spec to be unchanged")
+	g.Expect(reconciled.Status.Addresses).To(Equal([]clusterv1.MachineAddress{
+		{Type: clusterv1.MachineInternalIP, Address: "192.168.8.30"},
+	}))
+}
+
+func TestMachineReconcileDeleteReleasesPoolAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	withInterfaceAddressPool(apiObjects.MvmMachine)
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	claimKey := types.NamespacedName{Name: testMachineName + "-eth0", Namespace: testClusterNamespace}
+	g.Expect(client.Get(context.TODO(), claimKey, &ipamv1.IPAddressClaim{})).To(Succeed())
+
+	mvmMachine, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	mvmMachine.Finalizers = []string{v1alpha1.MachineFinalizer}
+	g.Expect(client.Update(context.TODO(), mvmMachine)).To(Succeed())
+	g.Expect(client.Delete(context.TODO(), mvmMachine)).To(Succeed())
+
+	_, err = reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when deleting microvm should not return error")
+
+	err = client.Get(context.TODO(), claimKey, &ipamv1.IPAddressClaim{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Expect the address claim to be released")
+
+	_, err = getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+}
+
+func TestMachineReconcileNoVmCreateAllocatesMACAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.MvmCluster.Spec.MACAddressPrefix = "06:aa:bb"
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when creating microvm should not return error")
+	g.Expect(fakeAPIClient.ListMicroVMsCallCount()).To(Equal(2), "Expect the host to be checked for the microvm and its mac addresses")
+
+	reconciled, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine should not fail")
+	g.Expect(reconciled.Status.NetworkInterfaceMACs).To(HaveKey("eth0"))
+
+	mac := reconciled.Status.NetworkInterfaceMACs["eth0"]
+	g.Expect(mac).To(HavePrefix("06:aa:bb:"))
+
+	_, createReq, _ := fakeAPIClient.CreateMicroVMArgsForCall(0)
+	g.Expect(createReq.Microvm.Interfaces[0].GuestMac).To(Equal(pointer.String(mac)))
+
+	registry := &corev1.ConfigMap{}
+	registryKey := types.NamespacedName{Name: testClusterName + "-mac-addresses", Namespace: testClusterNamespace}
+	g.Expect(client.Get(context.TODO(), registryKey, registry)).To(Succeed())
+	g.Expect(registry.Data).To(Equal(map[string]string{
+		strings.ReplaceAll(mac, ":", "-"): testMachineName + "/eth0",
+	}))
+}
+
+func TestMachineReconcileNoVmCreateMACAddressInUseOnHost(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.MvmMachine.Spec.NetworkInterfaces[0].GuestMAC = "06:AA:BB:00:00:01"
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+	fakeAPIClient.ListMicroVMsReturns(&flintlockv1.ListMicroVMsResponse{
+		Microvm: []*flintlocktypes.MicroVM{
+			{
+				Spec: &flintlocktypes.MicroVMSpec{
+					Interfaces: []*flintlocktypes.NetworkInterface{
+						{DeviceId: "eth0", GuestMac: pointer.String("06:aa:bb:00:00:01")},
+					},
+				},
+			},
+		},
+	}, nil)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).To(HaveOccurred(), "Reconciling when the mac address is in use should return an error")
+	g.Expect(fakeAPIClient.CreateMicroVMCallCount()).To(Equal(0), "Expect no microvm to be created")
+
+	reconciled, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine should not fail")
+	assertConditionFalse(g, reconciled, v1alpha1.MicrovmReadyCondition, v1alpha1.MACAddressAllocationFailedReason)
+}
+
+func TestMachineReconcileNoVmRecordsFailureDomain(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when creating microvm should not return error")
+
+	reconciled, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine should not fail")
+	g.Expect(reconciled.Status.FailureDomain).To(Equal("127.0.0.1:9090"))
+}
+
+func TestMachineReconcileNoVmUsesRecordedFailureDomain(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.MvmMachine.Status.FailureDomain = "127.0.0.2:9090"
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	var addresses []string
+
+	machineController := &controllers.MicrovmMachineReconciler{
+		Client: createFakeClient(g, apiObjects.AsRuntimeObjects()),
+		MvmClientFunc: func(address string, opts ...flclient.Options) (flclient.Client, error) {
+			addresses = append(addresses, address)
+
+			return &fakeAPIClient, nil
+		},
+	}
+
+	_, err := machineController.Reconcile(context.TODO(), ctrl.Request{
+		NamespacedName: types.NamespacedName{Name: testMachineName, Namespace: testClusterNamespace},
+	})
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when creating microvm should not return error")
+	g.Expect(addresses).NotTo(BeEmpty())
+	g.Expect(addresses).To(HaveEach(Equal("127.0.0.2:9090")), "Expect only the recorded host to be called")
+
+	reconciled, err := getMicrovmMachine(machineController.Client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine should not fail")
+
+	expectedProviderID := fmt.Sprintf("microvm://127.0.0.2:9090/%s", testMachineUID)
+	g.Expect(reconciled.Spec.ProviderID).To(Equal(pointer.String(expectedProviderID)))
+}
+
+func TestMachineReconcileNoVmAdoptsExistingMicrovm(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.MvmMachine.UID = "machine-uid"
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+	fakeAPIClient.ListMicroVMsReturns(&flintlockv1.ListMicroVMsResponse{
+		Microvm: []*flintlocktypes.MicroVM{
+			{
+				Spec: &flintlocktypes.MicroVMSpec{
+					Id:     testMachineName,
+					Uid:    pointer.String("other-uid"),
+					Labels: map[string]string{scope.MachineUIDLabel: "other-machine-uid"},
+				},
+			},
+			{
+				Spec: &flintlocktypes.MicroVMSpec{
+					Id:     testMachineName,
+					Uid:    pointer.String(testMachineUID),
+					Labels: map[string]string{scope.MachineUIDLabel: "machine-uid"},
+				},
+				Status: &flintlocktypes.MicroVMStatus{
+					State: flintlocktypes.MicroVMStatus_PENDING,
+				},
+			},
+		},
+	}, nil)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	result, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when the microvm already exists should not return error")
+	g.Expect(result.IsZero()).To(BeFalse(), "Expect requeue to be requested while the microvm is pending")
+	g.Expect(fakeAPIClient.CreateMicroVMCallCount()).To(Equal(0), "Expect the existing microvm to be adopted")
+
+	_, listReq, _ := fakeAPIClient.ListMicroVMsArgsForCall(0)
+	g.Expect(listReq.Namespace).To(Equal(testClusterNamespace))
+	g.Expect(listReq.Name).To(Equal(pointer.String(testMachineName)))
+
+	reconciled, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine should not fail")
+
+	expectedProviderID := fmt.Sprintf("microvm://127.0.0.1:9090/%s", testMachineUID)
+	g.Expect(reconciled.Spec.ProviderID).To(Equal(pointer.String(expectedProviderID)))
+}
+
+func TestMachineReconcileDeleteBeforeAdoption(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.MvmMachine.UID = "machine-uid"
+	apiObjects.MvmMachine.Status.FailureDomain = "127.0.0.1:9090"
+	apiObjects.MvmMachine.DeletionTimestamp = &metav1.Time{
+		Time: time.Now(),
+	}
+	apiObjects.MvmMachine.Finalizers = []string{v1alpha1.MachineFinalizer}
+
+	fakeAPIClient := fakes.FakeClient{}
+	withExistingMicrovm(&fakeAPIClient, flintlocktypes.MicroVMStatus_CREATED)
+	fakeAPIClient.ListMicroVMsReturns(&flintlockv1.ListMicroVMsResponse{
+		Microvm: []*flintlocktypes.MicroVM{
+			{
+				Spec: &flintlocktypes.MicroVMSpec{
+					Id:     testMachineName,
+					Uid:    pointer.String(testMachineUID),
+					Labels: map[string]string{scope.MachineUIDLabel: "machine-uid"},
+				},
+			},
+		},
+	}, nil)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+
+	result, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when deleting microvm should not return error")
+	g.Expect(result.RequeueAfter).To(Equal(30*time.Second), "Expect a requeue while the microvm is deleted")
+	g.Expect(fakeAPIClient.DeleteMicroVMCallCount()).To(Equal(1), "Expect the microvm created before the provider id was saved to be deleted")
+
+	_, deleteReq, _ := fakeAPIClient.DeleteMicroVMArgsForCall(0)
+	g.Expect(deleteReq.Uid).To(Equal(testMachineUID))
+
+	reconciled, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine should not fail")
+	assertMachineFinalizer(g, reconciled)
+
+	expectedProviderID := fmt.Sprintf("microvm://127.0.0.1:9090/%s", testMachineUID)
+	g.Expect(reconciled.Spec.ProviderID).To(Equal(pointer.String(expectedProviderID)))
+}
+
+func TestMachineReconcileDeleteBeforeCreate(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.MvmMachine.UID = "machine-uid"
+	apiObjects.MvmMachine.Status.FailureDomain = "127.0.0.1:9090"
+	apiObjects.MvmMachine.DeletionTimestamp = &metav1.Time{
+		Time: time.Now(),
+	}
+	apiObjects.MvmMachine.Finalizers = []string{v1alpha1.MachineFinalizer}
+
+	fakeAPIClient := fakes.FakeClient{}
+	fakeAPIClient.ListMicroVMsReturns(&flintlockv1.ListMicroVMsResponse{}, nil)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+
+	_, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when deleting microvm should not return error")
+	g.Expect(fakeAPIClient.ListMicroVMsCallCount()).To(Equal(1), "Expect the host to be checked for the microvm")
+	g.Expect(fakeAPIClient.GetMicroVMCallCount()).To(Equal(0))
+	g.Expect(fakeAPIClient.DeleteMicroVMCallCount()).To(Equal(0))
+
+	_, err = getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Expect the finalizer to be removed when there's no microvm")
+}
+
+func TestMachineReconcileDeleteFreesMACAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	mvmMachine, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(client.Delete(context.TODO(), mvmMachine)).To(Succeed())
+
+	withMissingMicrovm(&fakeAPIClient)
+
+	_, err = reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when deleting microvm should not return error")
+
+	registry := &corev1.ConfigMap{}
+	registryKey := types.NamespacedName{Name: testClusterName + "-mac-addresses", Namespace: testClusterNamespace}
+	g.Expect(client.Get(context.TODO(), registryKey, registry)).To(Succeed())
+	g.Expect(registry.Data).To(BeEmpty(), "Expect the mac address to be freed")
+}
+
+func TestMachineReconcileNoVmCreateIgnitionSucceeds(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.BootstrapSecret.Data = map[string][]byte{
+		"value":  []byte(`{"ignition":{"version":"3.3.0"}}`),
+		"format": []byte("ignition"),
+	}
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when creating microvm should not return error")
+
+	_, createReq, _ := fakeAPIClient.CreateMicroVMArgsForCall(0)
+	g.Expect(createReq.Microvm.Metadata).NotTo(HaveKey("vendor-data"), "Expect no cloud-init vendor data for ignition")
+	g.Expect(createReq.Microvm.Metadata).To(HaveKey("meta-data"))
+
+	userData, err := base64.StdEncoding.DecodeString(createReq.Microvm.Metadata["user-data"])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(userData)).To(ContainSubstring(`"path":"/etc/hostname"`), "Expect the hostname to be added to the ignition config")
+}
+
+func TestMachineReconcileNoVmCreateMetadataTooLarge(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.BootstrapSecret.Data["value"] = []byte(strings.Repeat("a", 2048))
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachineWithMetadataLimit(client, &fakeAPIClient, 1024)
+	g.Expect(err).To(HaveOccurred(), "Reconciling when the metadata is too large should return an error")
+	g.Expect(fakeAPIClient.CreateMicroVMCallCount()).To(Equal(0), "Expect the microvm not to be created")
+
+	reconciled, err := getMicrovmMachine(client, testMachineName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine should not fail")
+	assertConditionFalse(g, reconciled, v1alpha1.MicrovmReadyCondition, v1alpha1.MicrovmMetadataTooLargeReason)
+}
+
+func TestMachineReconcileNoVmCreateCompressedUserData(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.MvmCluster.Spec.CompressUserData = true
+	apiObjects.BootstrapSecret.Data["value"] = []byte("#cloud-config\n" + strings.Repeat("runcmd: []\n", 400))
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachineWithMetadataLimit(client, &fakeAPIClient, 2048)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when the compressed metadata fits should not return an error")
+	g.Expect(fakeAPIClient.CreateMicroVMCallCount()).To(Equal(1))
+
+	_, createReq, _ := fakeAPIClient.CreateMicroVMArgsForCall(0)
+	userData, err := base64.StdEncoding.DecodeString(createReq.Microvm.Metadata["user-data"])
+	g.Expect(err).NotTo(HaveOccurred())
+
+	msg, err := mail.ReadMessage(bytes.NewReader(userData))
+	g.Expect(err).NotTo(HaveOccurred(), "Expect the user data to be a MIME archive")
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(mediaType).To(Equal("multipart/mixed"))
+
+	part, err := multipart.NewReader(msg.Body, params["boundary"]).NextPart()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(part.Header.Get("Content-Type")).To(Equal("application/x-gzip"))
+	g.Expect(part.Header.Get("Content-Transfer-Encoding")).To(Equal("base64"))
+
+	reader, err := gzip.NewReader(base64.NewDecoder(base64.StdEncoding, part))
+	g.Expect(err).NotTo(HaveOccurred(), "Expect the user data part to be gzip compressed")
+
+	decompressed, err := io.ReadAll(reader)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(decompressed).To(Equal(apiObjects.BootstrapSecret.Data["value"]))
+}
+
+func TestMachineReconcileNoVmCreateWithInstanceMetadata(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+	apiObjects.MvmCluster.Spec.HostnameTemplate = "{{ .ClusterName }}-{{ .MachineName }}"
+	apiObjects.MvmCluster.Spec.Metadata = map[string]string{
+		"region": "eu-west",
+		"rack":   "r1",
+	}
+	apiObjects.MvmMachine.Spec.Metadata = map[string]string{
+		"rack":           "r2",
+		"failure_domain": "{{ .FailureDomain }}",
+	}
+
+	fakeAPIClient := fakes.FakeClient{}
+	withMissingMicrovm(&fakeAPIClient)
+	withCreateMicrovmSuccess(&fakeAPIClient)
+
+	client := createFakeClient(g, apiObjects.AsRuntimeObjects())
+	_, err := reconcileMachine(client, &fakeAPIClient)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when creating a microvm with instance metadata should not return an error")
+	g.Expect(fakeAPIClient.CreateMicroVMCallCount()).To(Equal(1))
+
+	_, createReq, _ := fakeAPIClient.CreateMicroVMArgsForCall(0)
+
+	metaData, err := base64.StdEncoding.DecodeString(createReq.Microvm.Metadata["meta-data"])
+	g.Expect(err).NotTo(HaveOccurred())
+
+	instanceData := map[string]string{}
+	g.Expect(yaml.Unmarshal(metaData, &instanceData)).To(Succeed())
+	g.Expect(instanceData).To(HaveKeyWithValue("local_hostname", "tenant1-machine1"))
+	g.Expect(instanceData).To(HaveKeyWithValue("vm_host", "127.0.0.1:9090"))
+	g.Expect(instanceData).To(HaveKeyWithValue("region", "eu-west"))
+	g.Expect(instanceData).To(HaveKeyWithValue("rack", "r2"))
+	g.Expect(instanceData).To(HaveKeyWithValue("failure_domain", "127.0.0.1:9090"))
+
+	vendorData, err := base64.StdEncoding.DecodeString(createReq.Microvm.Metadata["vendor-data"])
+	g.Expect(err).NotTo(HaveOccurred())
+
+	cloudConfig := &userdata.UserData{}
+	g.Expect(yaml.Unmarshal(vendorData, cloudConfig)).To(Succeed())
+	g.Expect(cloudConfig.HostName).To(Equal("tenant1-machine1"))
+	g.Expect(string(vendorData)).To(HavePrefix("#cloud-config\n"))
+}
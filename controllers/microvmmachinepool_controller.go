@@ -0,0 +1,340 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expclusterv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	exputil "sigs.k8s.io/cluster-api/exp/util"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/predicates"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
+)
+
+// MicrovmMachinePoolReconciler reconciles a MicrovmMachinePool object.
+type MicrovmMachinePoolReconciler struct {
+	client.Client
+	Scheme           *runtime.Scheme
+	Recorder         record.EventRecorder
+	WatchFilterValue string
+
+	MvmClientFunc flclient.FactoryFunc
+	// MetadataSizeLimit is the maximum size in bytes of all the metadata of a microvm as JSON, not
+	// just the user-data, 0 disables the check.
+	MetadataSizeLimit int
+	// ServiceAccount is the service account of the controller, whose tokens are used to
+	// authenticate with the hosts with the bearer token auth types.
+	ServiceAccount types.NamespacedName
+}
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmmachinepools,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmmachinepools/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmmachinepools/finalizers,verbs=update
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinepools;machinepools/status,verbs=get;list;watch
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *MicrovmMachinePoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	mvmPool := &infrav1.MicrovmMachinePool{}
+	if err := r.Get(ctx, req.NamespacedName, mvmPool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		log.Error(err, "error getting microvmmachinepool", "id", req.NamespacedName)
+
+		return ctrl.Result{}, fmt.Errorf("unable to reconcile: %w", err)
+	}
+
+	machinePool, err := exputil.GetOwnerMachinePool(ctx, r.Client, mvmPool.ObjectMeta)
+	if err != nil {
+		log.Error(err, "getting owning machine pool")
+
+		return ctrl.Result{}, fmt.Errorf("unable to get machine pool owner: %w", err)
+	}
+
+	if machinePool == nil {
+		log.Info("MachinePool controller has not set OwnerRef")
+
+		return ctrl.Result{}, nil
+	}
+
+	log = log.WithValues("machinePool", machinePool.Name)
+
+	cluster, err := util.GetClusterFromMetadata(ctx, r.Client, machinePool.ObjectMeta)
+	if err != nil {
+		log.Info("MachinePool is missing cluster label or cluster does not exist")
+
+		return ctrl.Result{}, nil //nolint:nilerr // We ignore it intentionally.
+	}
+
+	if annotations.IsPaused(cluster, mvmPool) {
+		log.Info("MicrovmMachinePool or linked Cluster is marked as paused. Won't reconcile")
+
+		return ctrl.Result{}, nil
+	}
+
+	log = log.WithValues("cluster", cluster.Name)
+
+	mvmCluster := &infrav1.MicrovmCluster{}
+	mvmClusterName := client.ObjectKey{
+		Namespace: cluster.Spec.InfrastructureRef.Namespace,
+		Name:      cluster.Spec.InfrastructureRef.Name,
+	}
+
+	if getErr := r.Client.Get(ctx, mvmClusterName, mvmCluster); getErr != nil {
+		if apierrors.IsNotFound(getErr) {
+			log.Info("MicrovmCluster is not ready yet")
+
+			return ctrl.Result{}, nil
+		}
+
+		log.Error(getErr, "error getting microvmcluster", "id", mvmClusterName)
+
+		return ctrl.Result{}, fmt.Errorf("error getting microvmcluster: %w", getErr)
+	}
+
+	poolScope, err := scope.NewMachinePoolScope(scope.MachinePoolScopeParams{
+		Cluster:            cluster,
+		MicroVMCluster:     mvmCluster,
+		MachinePool:        machinePool,
+		MicroVMMachinePool: mvmPool,
+		Client:             r.Client,
+		Context:            ctx,
+	}, scope.WithMachinePoolLogger(log), scope.WithMachinePoolServiceAccount(r.ServiceAccount))
+	if err != nil {
+		log.Error(err, "failed to create machine pool scope")
+
+		return ctrl.Result{}, fmt.Errorf("failed to create machine pool scope: %w", err)
+	}
+
+	defer func() {
+		if patchErr := poolScope.Patch(); patchErr != nil {
+			log.Error(patchErr, "failed to patch microvm machine pool")
+		}
+	}()
+
+	if !mvmPool.ObjectMeta.DeletionTimestamp.IsZero() {
+		log.Info("Deleting machine pool")
+
+		return r.reconcileDelete(ctx, poolScope)
+	}
+
+	return r.reconcileNormal(ctx, poolScope)
+}
+
+func (r *MicrovmMachinePoolReconciler) reconcileDelete(
+	ctx context.Context,
+	poolScope *scope.MachinePoolScope,
+) (reconcile.Result, error) {
+	poolScope.Info("Reconciling MicrovmMachinePool delete")
+
+	poolScope.SetNotReady(infrav1.MicrovmMachinePoolDeletingReason, clusterv1.ConditionSeverityInfo, "")
+
+	deleted := r.microvmGroup(poolScope).deleteAll(ctx)
+
+	r.setProviderIDs(poolScope)
+
+	if !deleted {
+		return ctrl.Result{RequeueAfter: requeuePeriod}, nil
+	}
+
+	// By this point Flintlock has no record of any of the microvms, so we are good to clear
+	// the finalizer
+	controllerutil.RemoveFinalizer(poolScope.MvmMachinePool, infrav1.MachinePoolFinalizer)
+
+	poolScope.Info("microvm machine pool deleted")
+
+	return ctrl.Result{}, nil
+}
+
+func (r *MicrovmMachinePoolReconciler) reconcileNormal(
+	ctx context.Context,
+	poolScope *scope.MachinePoolScope,
+) (reconcile.Result, error) {
+	poolScope.Info("Reconciling MicrovmMachinePool")
+
+	if !poolScope.Cluster.Status.InfrastructureReady {
+		poolScope.Info("Cluster infrastructure is not ready")
+		poolScope.SetNotReady(infrav1.WaitingForClusterInfraReason, clusterv1.ConditionSeverityInfo, "")
+
+		return ctrl.Result{}, nil
+	}
+
+	if poolScope.MachinePool.Spec.Template.Spec.Bootstrap.DataSecretName == nil {
+		poolScope.Info("Bootstrap secret is not ready")
+		poolScope.SetNotReady(infrav1.WaitingForBootstrapDataReason, clusterv1.ConditionSeverityInfo, "")
+
+		return ctrl.Result{}, nil
+	}
+
+	controllerutil.AddFinalizer(poolScope.MvmMachinePool, infrav1.MachinePoolFinalizer)
+
+	if err := poolScope.Patch(); err != nil {
+		poolScope.Error(err, "unable to patch microvm machine pool")
+
+		return ctrl.Result{}, err
+	}
+
+	specHash, err := poolScope.SpecHash(r.MetadataSizeLimit)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	group := r.microvmGroup(poolScope)
+
+	group.sync(ctx)
+
+	err = group.scale(ctx, int(poolScope.DesiredReplicas()), specHash)
+
+	r.setProviderIDs(poolScope)
+
+	if err != nil {
+		reason := infrav1.MicrovmProvisionFailedReason
+		if errors.Is(err, errMetadataTooLarge) {
+			reason = infrav1.MicrovmMetadataTooLargeReason
+		}
+
+		poolScope.SetNotReady(reason, clusterv1.ConditionSeverityError, err.Error())
+
+		return ctrl.Result{}, err
+	}
+
+	return r.setPoolStatus(poolScope, group, specHash), nil
+}
+
+func (r *MicrovmMachinePoolReconciler) microvmGroup(poolScope *scope.MachinePoolScope) *microvmGroup {
+	return &microvmGroup{
+		Logger:         poolScope.Logger,
+		name:           poolScope.Name(),
+		instances:      &poolScope.MvmMachinePool.Status.Instances,
+		failureDomains: poolScope.FailureDomains(),
+		clientFunc:     r.MvmClientFunc,
+		creds:          poolScope,
+		scopeFor: func(instance *infrav1.MicrovmInstance) microvmScope {
+			return poolScope.InstanceScope(instance)
+		},
+		persist:           poolScope.Patch,
+		metadataSizeLimit: r.MetadataSizeLimit,
+		macs: &macAddressAllocator{
+			Logger:      poolScope.Logger,
+			client:      r.Client,
+			clientFunc:  r.MvmClientFunc,
+			creds:       poolScope,
+			mvmCluster:  poolScope.MvmCluster,
+			clusterName: poolScope.ClusterName(),
+		},
+	}
+}
+
+func (r *MicrovmMachinePoolReconciler) setProviderIDs(poolScope *scope.MachinePoolScope) {
+	providerIDs := []string{}
+
+	for i := range poolScope.MvmMachinePool.Status.Instances {
+		instance := &poolScope.MvmMachinePool.Status.Instances[i]
+		if instance.Deleting {
+			continue
+		}
+
+		if providerID := poolScope.InstanceScope(instance).GetProviderID(); providerID != "" {
+			providerIDs = append(providerIDs, providerID)
+		}
+	}
+
+	sort.Strings(providerIDs)
+
+	poolScope.MvmMachinePool.Spec.ProviderIDList = providerIDs
+}
+
+func (r *MicrovmMachinePoolReconciler) setPoolStatus(
+	poolScope *scope.MachinePoolScope,
+	group *microvmGroup,
+	specHash string,
+) ctrl.Result {
+	desired := int(poolScope.DesiredReplicas())
+	active, ready, outdated := group.count(specHash)
+
+	poolScope.MvmMachinePool.Status.Replicas = int32(ready) //nolint: gosec // the number of microvms will never overflow
+
+	switch {
+	case outdated > 0:
+		poolScope.SetNotReady(infrav1.MicrovmMachinePoolUpdatingReason, clusterv1.ConditionSeverityInfo,
+			"%d of %d microvms need replacing", outdated, active)
+	case active != desired || ready != desired:
+		poolScope.SetNotReady(infrav1.MicrovmMachinePoolScalingReason, clusterv1.ConditionSeverityInfo,
+			"%d of %d microvms are running", ready, desired)
+	default:
+		poolScope.SetReady()
+
+		if len(poolScope.MvmMachinePool.Status.Instances) == active {
+			return ctrl.Result{}
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: requeuePeriod}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MicrovmMachinePoolReconciler) SetupWithManager(
+	ctx context.Context,
+	mgr ctrl.Manager,
+	options controller.Options,
+) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	clusterToObjectFunc, err := util.ClusterToTypedObjectsMapper(
+		r.Client,
+		&infrav1.MicrovmMachinePoolList{},
+		mgr.GetScheme(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create mapper for Cluster to MicrovmMachinePools: %w", err)
+	}
+
+	builder := ctrl.NewControllerManagedBy(mgr).
+		WithOptions(options).
+		For(&infrav1.MicrovmMachinePool{}).
+		WithEventFilter(predicates.ResourceNotPausedAndHasFilterLabel(mgr.GetScheme(), log, r.WatchFilterValue)).
+		WithEventFilter(predicates.ResourceIsNotExternallyManaged(mgr.GetScheme(), log)).
+		Watches(
+			&expclusterv1.MachinePool{},
+			handler.EnqueueRequestsFromMapFunc(
+				exputil.MachinePoolToInfrastructureMapFunc(ctx, infrav1.GroupVersion.WithKind("MicrovmMachinePool")),
+			),
+		).
+		Watches(
+			&clusterv1.Cluster{},
+			handler.EnqueueRequestsFromMapFunc(clusterToObjectFunc),
+			builder.WithPredicates(predicates.ClusterPausedTransitionsOrInfrastructureReady(mgr.GetScheme(), log)),
+		)
+
+	if err := builder.Complete(r); err != nil {
+		return fmt.Errorf("creating microvm machine pool controller: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,444 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers_test
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expclusterv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+)
+
+func defaultMachinePoolObjects(replicas int32) []runtime.Object {
+	cluster := createCluster()
+	cluster.Status.FailureDomains["127.0.0.2:9090"] = clusterv1.FailureDomainSpec{}
+
+	return []runtime.Object{
+		cluster,
+		createMicrovmCluster(),
+		createMachinePool(replicas),
+		createMicrovmMachinePool(),
+		createBootsrapSecret(),
+	}
+}
+
+func setMachinePoolReplicas(g *WithT, c client.Client, replicas int32) {
+	machinePool := &expclusterv1.MachinePool{}
+	g.Expect(c.Get(context.TODO(), client.ObjectKey{Name: testMachinePoolName, Namespace: testClusterNamespace}, machinePool)).To(Succeed())
+
+	machinePool.Spec.Replicas = pointer.Int32(replicas)
+	g.Expect(c.Update(context.TODO(), machinePool)).To(Succeed())
+}
+
+func TestMachinePoolReconcileNoMachinePoolOwnerRef(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := defaultMachinePoolObjects(1)
+	objects[3].(*infrav1.MicrovmMachinePool).OwnerReferences = nil
+
+	client := createFakeClient(g, objects)
+	result, err := reconcileMachinePool(client, nil)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when microvm machine pool has no owner ref should not error")
+	g.Expect(result.IsZero()).To(BeTrue(), "Expect no requeue to be requested")
+}
+
+func TestMachinePoolReconcileBootstrapNotReady(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := defaultMachinePoolObjects(1)
+	objects[2].(*expclusterv1.MachinePool).Spec.Template.Spec.Bootstrap.DataSecretName = nil
+
+	_, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, objects)
+	result, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling when bootstrap data is not ready should not error")
+	g.Expect(result.IsZero()).To(BeTrue(), "Expect no requeue to be requested")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(0), "Expect no microvms to be created")
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	assertConditionFalse(g, reconciled, infrav1.MicrovmMachinePoolReadyCondition, infrav1.WaitingForBootstrapDataReason)
+}
+
+func TestMachinePoolReconcileScaleUp(t *testing.T) {
+	g := NewWithT(t)
+
+	host, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, defaultMachinePoolObjects(3))
+
+	result, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	g.Expect(result.RequeueAfter).To(Equal(30*time.Second), "Expect a requeue while the microvms are starting")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(3), "Expect a microvm to be created for each replica")
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Finalizers).To(ContainElement(infrav1.MachinePoolFinalizer))
+	g.Expect(reconciled.Status.Instances).To(HaveLen(3))
+	g.Expect(reconciled.Spec.ProviderIDList).To(HaveLen(3))
+	g.Expect(reconciled.Status.Ready).To(BeFalse())
+	assertConditionFalse(g, reconciled, infrav1.MicrovmMachinePoolReadyCondition, infrav1.MicrovmMachinePoolScalingReason)
+
+	perHost := map[string]int{}
+	for _, instance := range reconciled.Status.Instances {
+		perHost[instance.FailureDomain]++
+	}
+	g.Expect(perHost).To(Equal(map[string]int{"127.0.0.1:9090": 2, "127.0.0.2:9090": 1}), "Expect the microvms to be spread across the hosts")
+
+	host.startAll()
+
+	result, err = reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	g.Expect(result.IsZero()).To(BeTrue(), "Expect no requeue when the pool is ready")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(3), "Expect no more microvms to be created")
+
+	reconciled, err = getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Ready).To(BeTrue())
+	g.Expect(reconciled.Status.Replicas).To(Equal(int32(3)))
+	assertConditionTrue(g, reconciled, infrav1.MicrovmMachinePoolReadyCondition)
+}
+
+func TestMachinePoolReconcileAllocatesMACAddresses(t *testing.T) {
+	g := NewWithT(t)
+
+	_, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, defaultMachinePoolObjects(2))
+
+	_, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Instances).To(HaveLen(2))
+
+	registry := &corev1.ConfigMap{}
+	registryKey := types.NamespacedName{Name: testClusterName + "-mac-addresses", Namespace: testClusterNamespace}
+	g.Expect(client.Get(context.TODO(), registryKey, registry)).To(Succeed())
+
+	macs := map[string]bool{}
+
+	for i, instance := range reconciled.Status.Instances {
+		mac := instance.NetworkInterfaceMACs["eth0"]
+		g.Expect(mac).To(HavePrefix("02:"), "Expect a mac address to be allocated to the instance")
+		g.Expect(registry.Data).To(HaveKeyWithValue(strings.ReplaceAll(mac, ":", "-"), instance.Name+"/eth0"))
+
+		_, createReq, _ := fc.CreateMicroVMArgsForCall(i)
+		g.Expect(createReq.Microvm.Interfaces[0].GuestMac).To(Equal(pointer.String(mac)))
+
+		macs[mac] = true
+	}
+
+	g.Expect(macs).To(HaveLen(2), "Expect each instance to have a different mac address")
+}
+
+func TestMachinePoolReconcileScaleDown(t *testing.T) {
+	g := NewWithT(t)
+
+	host, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, defaultMachinePoolObjects(3))
+
+	_, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	host.startAll()
+
+	setMachinePoolReplicas(g, client, 1)
+
+	_, err = reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	g.Expect(fc.DeleteMicroVMCallCount()).To(Equal(2), "Expect the extra microvms to be deleted")
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Spec.ProviderIDList).To(HaveLen(1))
+	g.Expect(reconciled.Status.Replicas).To(Equal(int32(1)))
+
+	host.finishDeletes()
+
+	_, err = reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+
+	reconciled, err = getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Instances).To(HaveLen(1), "Expect deleted microvms to be removed from the pool")
+}
+
+func TestMachinePoolReconcileSpecChangeReplacesMicrovms(t *testing.T) {
+	g := NewWithT(t)
+
+	host, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, defaultMachinePoolObjects(2))
+
+	_, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	host.startAll()
+
+	pool, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	pool.Spec.VCPU = 4
+	g.Expect(client.Update(context.TODO(), pool)).To(Succeed())
+
+	_, err = reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(3), "Expect a single replacement microvm to be created")
+	g.Expect(fc.DeleteMicroVMCallCount()).To(Equal(0), "Expect no microvm to be deleted before the replacement is running")
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	assertConditionFalse(g, reconciled, infrav1.MicrovmMachinePoolReadyCondition, infrav1.MicrovmMachinePoolUpdatingReason)
+
+	for i := 0; i < 4; i++ {
+		host.startAll()
+		host.finishDeletes()
+
+		_, err = reconcileMachinePool(client, fc)
+		g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	}
+
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(4), "Expect each microvm to be replaced once")
+	g.Expect(fc.DeleteMicroVMCallCount()).To(Equal(2), "Expect each old microvm to be deleted")
+	g.Expect(host.count(flintlocktypes.MicroVMStatus_CREATED)).To(Equal(2))
+
+	reconciled, err = getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Ready).To(BeTrue())
+	g.Expect(reconciled.Status.Instances).To(HaveLen(2))
+}
+
+func TestMachinePoolReconcileInstanceMetadata(t *testing.T) {
+	g := NewWithT(t)
+
+	objects := defaultMachinePoolObjects(1)
+	mvmCluster := objects[1].(*infrav1.MicrovmCluster)
+	mvmCluster.Spec.HostnameTemplate = "{{ .ClusterName }}-{{ .MachineName }}"
+	mvmCluster.Spec.Metadata = map[string]string{"failure_domain": "{{ .FailureDomain }}"}
+
+	_, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, objects)
+
+	_, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(1))
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Instances).To(HaveLen(1))
+
+	instance := reconciled.Status.Instances[0]
+
+	_, createReq, _ := fc.CreateMicroVMArgsForCall(0)
+
+	metaData, err := base64.StdEncoding.DecodeString(createReq.Microvm.Metadata["meta-data"])
+	g.Expect(err).NotTo(HaveOccurred())
+
+	instanceData := map[string]string{}
+	g.Expect(yaml.Unmarshal(metaData, &instanceData)).To(Succeed())
+	g.Expect(instanceData).To(HaveKeyWithValue("local_hostname", testClusterName+"-"+instance.Name))
+	g.Expect(instanceData).To(HaveKeyWithValue("failure_domain", instance.FailureDomain))
+
+	vendorData, err := base64.StdEncoding.DecodeString(createReq.Microvm.Metadata["vendor-data"])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(vendorData)).To(ContainSubstring("hostname: " + testClusterName + "-" + instance.Name))
+}
+
+func TestMachinePoolReconcileClusterChangeReplacesMicrovms(t *testing.T) {
+	tt := []struct {
+		name   string
+		change func(mvmCluster *infrav1.MicrovmCluster)
+	}{
+		{
+			name:   "compression",
+			change: func(mvmCluster *infrav1.MicrovmCluster) { mvmCluster.Spec.CompressUserData = true },
+		},
+		{
+			name: "user data fragments",
+			change: func(mvmCluster *infrav1.MicrovmCluster) {
+				mvmCluster.Spec.UserDataFragments = []infrav1.UserDataFragment{{
+					SecretRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "sysctls"},
+						Key:                  "value",
+					},
+					ContentType: "text/cloud-config",
+				}}
+			},
+		},
+		{
+			name:   "hostname template",
+			change: func(mvmCluster *infrav1.MicrovmCluster) { mvmCluster.Spec.HostnameTemplate = "{{ .MachineName }}-node" },
+		},
+		{
+			name:   "instance metadata",
+			change: func(mvmCluster *infrav1.MicrovmCluster) { mvmCluster.Spec.Metadata = map[string]string{"rack": "r1"} },
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			fragment := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "sysctls", Namespace: testClusterNamespace},
+				Data:       map[string][]byte{"value": []byte("#cloud-config\n")},
+			}
+
+			host, fc := newFakeFlintlockHost()
+			client := createFakeClient(g, append(defaultMachinePoolObjects(1), fragment))
+
+			_, err := reconcileMachinePool(client, fc)
+			g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+			host.startAll()
+
+			mvmCluster, err := getMicrovmCluster(context.TODO(), client, testClusterName, testClusterNamespace)
+			g.Expect(err).NotTo(HaveOccurred(), "Getting microvm cluster should not fail")
+			tc.change(mvmCluster)
+			g.Expect(client.Update(context.TODO(), mvmCluster)).To(Succeed())
+
+			_, err = reconcileMachinePool(client, fc)
+			g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+			g.Expect(fc.CreateMicroVMCallCount()).To(Equal(2), "Expect a replacement microvm to be created")
+
+			reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+			g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+			g.Expect(reconciled.Status.Instances).To(HaveLen(2))
+			g.Expect(reconciled.Status.Instances[0].SpecHash).NotTo(Equal(reconciled.Status.Instances[1].SpecHash))
+		})
+	}
+}
+
+func TestMachinePoolReconcileDelete(t *testing.T) {
+	g := NewWithT(t)
+
+	host, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, defaultMachinePoolObjects(2))
+
+	_, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	host.startAll()
+
+	pool, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(client.Delete(context.TODO(), pool)).To(Succeed())
+
+	result, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool deletion should not error")
+	g.Expect(result.RequeueAfter).To(Equal(30*time.Second), "Expect a requeue while the microvms are deleted")
+	g.Expect(fc.DeleteMicroVMCallCount()).To(Equal(2), "Expect all the microvms to be deleted")
+
+	pool, err = getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(pool.Finalizers).To(ContainElement(infrav1.MachinePoolFinalizer), "Expect the finalizer to be kept until the microvms are gone")
+
+	host.finishDeletes()
+
+	_, err = reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool deletion should not error")
+
+	_, err = getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).To(HaveOccurred(), "Expect the microvm machine pool to be removed once the finalizer is cleared")
+
+	registry := &corev1.ConfigMap{}
+	registryKey := types.NamespacedName{Name: testClusterName + "-mac-addresses", Namespace: testClusterNamespace}
+	g.Expect(client.Get(context.TODO(), registryKey, registry)).To(Succeed())
+	g.Expect(registry.Data).To(BeEmpty(), "Expect the mac addresses of the microvms to be released")
+}
+
+func TestMachinePoolReconcileAdoptsMicrovmsWhenCreateResponseLost(t *testing.T) {
+	g := NewWithT(t)
+
+	host, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, defaultMachinePoolObjects(2))
+
+	host.loseCreates = true
+
+	_, err := reconcileMachinePool(client, fc)
+	g.Expect(err).To(HaveOccurred(), "Reconciling microvm machine pool should error when the create response is lost")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(1))
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Instances).To(HaveLen(1), "Expect the instance to be recorded before the microvm is created")
+	g.Expect(reconciled.Status.Instances[0].UID).To(BeEmpty())
+
+	host.loseCreates = false
+
+	_, err = reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(2), "Expect only the missing microvm to be created")
+	g.Expect(host.microvms).To(HaveLen(2))
+
+	reconciled, err = getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Instances).To(HaveLen(2))
+	g.Expect(reconciled.Status.Instances[0].UID).To(Equal("uid1"), "Expect the existing microvm to be adopted")
+	g.Expect(reconciled.Spec.ProviderIDList).To(HaveLen(2))
+}
+
+func TestMachinePoolReconcileCreatesRecordedMicrovmThatDoesNotExist(t *testing.T) {
+	g := NewWithT(t)
+
+	host, fc := newFakeFlintlockHost()
+	objects := defaultMachinePoolObjects(1)
+	objects[3].(*infrav1.MicrovmMachinePool).Status.Instances = []infrav1.MicrovmInstance{
+		{Name: "pool1-abcde", FailureDomain: "127.0.0.1:9090"},
+	}
+	client := createFakeClient(g, objects)
+
+	_, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(1), "Expect the recorded microvm to be created")
+	g.Expect(host.names).To(HaveKeyWithValue("uid1", "pool1-abcde"))
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Instances).To(HaveLen(1))
+	g.Expect(reconciled.Status.Instances[0].UID).To(Equal("uid1"))
+}
+
+func TestMachinePoolReconcileHostUnreachable(t *testing.T) {
+	g := NewWithT(t)
+
+	host, fc := newFakeFlintlockHost()
+	client := createFakeClient(g, defaultMachinePoolObjects(2))
+
+	_, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+	host.startAll()
+
+	host.unreachable = true
+
+	result, err := reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error when a host can't be reached")
+	g.Expect(result.RequeueAfter).To(Equal(30*time.Second), "Expect a requeue until the host can be reached")
+	g.Expect(fc.CreateMicroVMCallCount()).To(Equal(2), "Expect no microvms to be created")
+
+	reconciled, err := getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Instances).To(HaveLen(2), "Expect the microvms to be kept in the pool")
+	g.Expect(reconciled.Status.Replicas).To(Equal(int32(0)))
+
+	host.unreachable = false
+
+	_, err = reconcileMachinePool(client, fc)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling microvm machine pool should not error")
+
+	reconciled, err = getMicrovmMachinePool(client, testMachinePoolName, testClusterNamespace)
+	g.Expect(err).NotTo(HaveOccurred(), "Getting microvm machine pool should not fail")
+	g.Expect(reconciled.Status.Ready).To(BeTrue())
+	g.Expect(reconciled.Status.Replicas).To(Equal(int32(2)))
+}
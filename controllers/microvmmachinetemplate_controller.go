@@ -0,0 +1,240 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	annotationsutil "sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/cluster-api/util/predicates"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+const (
+	nodeOperatingSystem = "linux"
+
+	// autoscalerLabelsAnnotation and autoscalerTaintsAnnotation are read by the cluster autoscaler
+	// from a MachineDeployment to find the labels and taints of its nodes when scaling from zero.
+	autoscalerLabelsAnnotation = "capacity.cluster-autoscaler.kubernetes.io/labels"
+	autoscalerTaintsAnnotation = "capacity.cluster-autoscaler.kubernetes.io/taints"
+)
+
+// MicrovmMachineTemplateReconciler publishes the capacity of the machines created from a
+// MicrovmMachineTemplate so that the cluster autoscaler can scale MachineDeployments from zero.
+type MicrovmMachineTemplateReconciler struct {
+	client.Client
+	WatchFilterValue string
+}
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmmachinetemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=microvmmachinetemplates/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinedeployments,verbs=get;list;watch;patch
+
+// Reconcile sets the capacity in the status of the template from the microvm spec, and publishes the
+// node labels and taints of the template on the MachineDeployments that use it.
+func (r *MicrovmMachineTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	template := &infrav1.MicrovmMachineTemplate{}
+	if err := r.Get(ctx, req.NamespacedName, template); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, fmt.Errorf("getting microvmmachinetemplate: %w", err)
+	}
+
+	if !template.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.reconcileMachineDeployments(ctx, template); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	spec := template.Spec.Template.Spec
+
+	capacity := corev1.ResourceList{
+		corev1.ResourceCPU:    *resource.NewQuantity(spec.VCPU, resource.DecimalSI),
+		corev1.ResourceMemory: *resource.NewQuantity(spec.MemoryMb*1024*1024, resource.BinarySI),
+	}
+	nodeInfo := &infrav1.NodeInfo{OperatingSystem: nodeOperatingSystem}
+
+	if equality.Semantic.DeepEqual(template.Status.Capacity, capacity) &&
+		equality.Semantic.DeepEqual(template.Status.NodeInfo, nodeInfo) {
+		return ctrl.Result{}, nil
+	}
+
+	patchHelper, err := patch.NewHelper(template, r.Client)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("creating patch helper: %w", err)
+	}
+
+	template.Status.Capacity = capacity
+	template.Status.NodeInfo = nodeInfo
+
+	if err := patchHelper.Patch(ctx, template); err != nil {
+		return ctrl.Result{}, fmt.Errorf("patching microvmmachinetemplate: %w", err)
+	}
+
+	log.Info("Updated microvmmachinetemplate capacity", "cpu", spec.VCPU, "memoryMb", spec.MemoryMb)
+
+	return ctrl.Result{}, nil
+}
+
+// reconcileMachineDeployments sets the autoscaler annotations on the MachineDeployments that use the
+// template. The annotations are left alone if the template has no node labels or taints, so that
+// they can still be set on the MachineDeployments directly.
+func (r *MicrovmMachineTemplateReconciler) reconcileMachineDeployments(
+	ctx context.Context,
+	template *infrav1.MicrovmMachineTemplate,
+) error {
+	annotations := map[string]string{}
+
+	if len(template.Spec.NodeLabels) > 0 {
+		annotations[autoscalerLabelsAnnotation] = formatNodeLabels(template.Spec.NodeLabels)
+	}
+
+	if len(template.Spec.NodeTaints) > 0 {
+		annotations[autoscalerTaintsAnnotation] = formatNodeTaints(template.Spec.NodeTaints)
+	}
+
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.List(ctx, machineDeployments, client.InNamespace(template.Namespace)); err != nil {
+		return fmt.Errorf("listing machine deployments: %w", err)
+	}
+
+	for i := range machineDeployments.Items {
+		md := &machineDeployments.Items[i]
+		if !usesMachineTemplate(md, template) || hasAnnotations(md, annotations) {
+			continue
+		}
+
+		patchHelper, err := patch.NewHelper(md, r.Client)
+		if err != nil {
+			return fmt.Errorf("creating patch helper: %w", err)
+		}
+
+		annotationsutil.AddAnnotations(md, annotations)
+
+		if err := patchHelper.Patch(ctx, md); err != nil {
+			return fmt.Errorf("patching machine deployment %s: %w", md.Name, err)
+		}
+
+		log.FromContext(ctx).Info("Updated machine deployment autoscaler annotations", "machineDeployment", md.Name)
+	}
+
+	return nil
+}
+
+func usesMachineTemplate(md *clusterv1.MachineDeployment, template *infrav1.MicrovmMachineTemplate) bool {
+	ref := md.Spec.Template.Spec.InfrastructureRef
+
+	return ref.Name == template.Name &&
+		ref.GroupVersionKind().GroupKind() == infrav1.GroupVersion.WithKind("MicrovmMachineTemplate").GroupKind()
+}
+
+func hasAnnotations(o metav1.Object, annotations map[string]string) bool {
+	for key, value := range annotations {
+		if o.GetAnnotations()[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// formatNodeLabels formats the labels as key1=value1,key2=value2, sorted by key.
+func formatNodeLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	formatted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		formatted = append(formatted, key+"="+labels[key])
+	}
+
+	return strings.Join(formatted, ",")
+}
+
+// formatNodeTaints formats the taints as key1=value1:Effect,key2=value2:Effect.
+func formatNodeTaints(taints []corev1.Taint) string {
+	formatted := make([]string, 0, len(taints))
+	for _, taint := range taints {
+		formatted = append(formatted, fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect))
+	}
+
+	return strings.Join(formatted, ",")
+}
+
+// machineDeploymentToMicrovmMachineTemplate maps a MachineDeployment to the MicrovmMachineTemplate it
+// uses, so that new MachineDeployments get the autoscaler annotations.
+func machineDeploymentToMicrovmMachineTemplate(_ context.Context, o client.Object) []ctrl.Request {
+	md, ok := o.(*clusterv1.MachineDeployment)
+	if !ok {
+		return nil
+	}
+
+	ref := md.Spec.Template.Spec.InfrastructureRef
+	if ref.GroupVersionKind().GroupKind() != infrav1.GroupVersion.WithKind("MicrovmMachineTemplate").GroupKind() {
+		return nil
+	}
+
+	return []ctrl.Request{
+		{
+			NamespacedName: client.ObjectKey{
+				Namespace: md.Namespace,
+				Name:      ref.Name,
+			},
+		},
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MicrovmMachineTemplateReconciler) SetupWithManager(
+	ctx context.Context,
+	mgr ctrl.Manager,
+	options controller.Options,
+) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	err := ctrl.NewControllerManagedBy(mgr).
+		WithOptions(options).
+		For(&infrav1.MicrovmMachineTemplate{}).
+		WithEventFilter(predicates.ResourceNotPausedAndHasFilterLabel(mgr.GetScheme(), log, r.WatchFilterValue)).
+		Watches(
+			&clusterv1.MachineDeployment{},
+			handler.EnqueueRequestsFromMapFunc(machineDeploymentToMicrovmMachineTemplate),
+		).
+		Complete(r)
+	if err != nil {
+		return fmt.Errorf("creating microvm machine template controller: %w", err)
+	}
+
+	return nil
+}
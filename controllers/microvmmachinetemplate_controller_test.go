@@ -0,0 +1,145 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+func createMicrovmMachineTemplate() *infrav1.MicrovmMachineTemplate {
+	return &infrav1.MicrovmMachineTemplate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testMachineTemplateName,
+			Namespace: testClusterNamespace,
+		},
+		Spec: infrav1.MicrovmMachineTemplateSpec{
+			Template: infrav1.MicrovmMachineTemplateResource{
+				Spec: createMicrovmMachine().Spec,
+			},
+		},
+	}
+}
+
+func getMicrovmMachineTemplate(g *WithT, c client.Client) *infrav1.MicrovmMachineTemplate {
+	template := &infrav1.MicrovmMachineTemplate{}
+	key := client.ObjectKey{Name: testMachineTemplateName, Namespace: testClusterNamespace}
+	g.Expect(c.Get(context.TODO(), key, template)).To(Succeed())
+
+	return template
+}
+
+func TestMachineTemplateReconcileSetsCapacity(t *testing.T) {
+	g := NewWithT(t)
+
+	client := createFakeClient(g, []runtime.Object{createMicrovmMachineTemplate()})
+
+	result, err := reconcileMachineTemplate(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling the template should not return an error")
+	g.Expect(result.IsZero()).To(BeTrue())
+
+	template := getMicrovmMachineTemplate(g, client)
+	g.Expect(template.Status.Capacity.Cpu().Equal(resource.MustParse("2"))).To(BeTrue())
+	g.Expect(template.Status.Capacity.Memory().Equal(resource.MustParse("2Gi"))).To(BeTrue())
+	g.Expect(template.Status.NodeInfo).To(Equal(&infrav1.NodeInfo{OperatingSystem: "linux"}))
+}
+
+func TestMachineTemplateReconcileUpdatesCapacity(t *testing.T) {
+	g := NewWithT(t)
+
+	template := createMicrovmMachineTemplate()
+	template.Status.Capacity = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1"),
+		corev1.ResourceMemory: resource.MustParse("512Mi"),
+	}
+	template.Spec.Template.Spec.VCPU = 4
+	template.Spec.Template.Spec.MemoryMb = 8192
+
+	client := createFakeClient(g, []runtime.Object{template})
+
+	_, err := reconcileMachineTemplate(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling the template should not return an error")
+
+	reconciled := getMicrovmMachineTemplate(g, client)
+	g.Expect(reconciled.Status.Capacity.Cpu().Equal(resource.MustParse("4"))).To(BeTrue())
+	g.Expect(reconciled.Status.Capacity.Memory().Equal(resource.MustParse("8Gi"))).To(BeTrue())
+}
+
+func TestMachineTemplateReconcilePublishesNodeLabelsAndTaints(t *testing.T) {
+	g := NewWithT(t)
+
+	template := createMicrovmMachineTemplate()
+	template.Spec.NodeLabels = map[string]string{
+		"node-role.kubernetes.io/worker": "",
+		"example.com/pool":               "gpu",
+	}
+	template.Spec.NodeTaints = []corev1.Taint{
+		{Key: "example.com/gpu", Value: "true", Effect: corev1.TaintEffectNoSchedule},
+	}
+
+	usingTemplate := createMachineDeployment("md1", "MicrovmMachineTemplate", testMachineTemplateName)
+	otherTemplate := createMachineDeployment("md2", "MicrovmMachineTemplate", "other-template")
+	otherKind := createMachineDeployment("md3", "OtherMachineTemplate", testMachineTemplateName)
+
+	client := createFakeClient(g, []runtime.Object{template, usingTemplate, otherTemplate, otherKind})
+
+	_, err := reconcileMachineTemplate(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling the template should not return an error")
+
+	md := &clusterv1.MachineDeployment{}
+	g.Expect(client.Get(context.TODO(), types.NamespacedName{Name: "md1", Namespace: testClusterNamespace}, md)).To(Succeed())
+	g.Expect(md.Annotations).To(HaveKeyWithValue("capacity.cluster-autoscaler.kubernetes.io/labels",
+		"example.com/pool=gpu,node-role.kubernetes.io/worker="))
+	g.Expect(md.Annotations).To(HaveKeyWithValue("capacity.cluster-autoscaler.kubernetes.io/taints",
+		"example.com/gpu=true:NoSchedule"))
+
+	for _, name := range []string{"md2", "md3"} {
+		md := &clusterv1.MachineDeployment{}
+		g.Expect(client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: testClusterNamespace}, md)).To(Succeed())
+		g.Expect(md.Annotations).To(BeEmpty(), "Expect machine deployments using other templates not to be annotated")
+	}
+}
+
+func createMachineDeployment(name, templateKind, templateName string) *clusterv1.MachineDeployment {
+	return &clusterv1.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testClusterNamespace,
+		},
+		Spec: clusterv1.MachineDeploymentSpec{
+			ClusterName: testClusterName,
+			Template: clusterv1.MachineTemplateSpec{
+				Spec: clusterv1.MachineSpec{
+					ClusterName: testClusterName,
+					InfrastructureRef: corev1.ObjectReference{
+						APIVersion: infrav1.GroupVersion.String(),
+						Kind:       templateKind,
+						Name:       templateName,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMachineTemplateReconcileMissingTemplate(t *testing.T) {
+	g := NewWithT(t)
+
+	client := createFakeClient(g, nil)
+
+	_, err := reconcileMachineTemplate(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling a deleted template should not return an error")
+}
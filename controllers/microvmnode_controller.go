@@ -0,0 +1,286 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/api/v1beta1/index"
+	"sigs.k8s.io/cluster-api/controllers/clustercache"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/predicates"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
+)
+
+// microvmMachineProviderIDField is the field index of the provider id of the MicrovmMachines.
+const microvmMachineProviderIDField = "spec.providerID"
+
+// MicrovmNodeReconciler applies topology labels to the Nodes in the workload clusters so that
+// workloads can be spread across the hosts that the microvms are on. The Nodes of each workload
+// cluster are watched through the cluster cache, so the labels are applied as soon as a Node
+// registers and again if they are changed.
+type MicrovmNodeReconciler struct {
+	client.Client
+	WatchFilterValue string
+
+	// ClusterCache is used to get the clients for the workload clusters and to watch their Nodes.
+	ClusterCache clustercache.ClusterCache
+
+	controller controller.Controller
+}
+
+// Reconcile applies the topology labels for a MicrovmMachine to its Node.
+func (r *MicrovmNodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	mvmMachine := &infrav1.MicrovmMachine{}
+	if err := r.Get(ctx, req.NamespacedName, mvmMachine); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, fmt.Errorf("getting microvmmachine: %w", err)
+	}
+
+	if !mvmMachine.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	cluster, err := util.GetClusterFromMetadata(ctx, r.Client, mvmMachine.ObjectMeta)
+	if err != nil {
+		log.Info("MicrovmMachine is missing cluster label or cluster does not exist")
+
+		return ctrl.Result{}, nil //nolint:nilerr // We ignore it intentionally.
+	}
+
+	if annotations.IsPaused(cluster, mvmMachine) {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.watchClusterNodes(ctx, cluster); err != nil {
+		if errors.Is(err, clustercache.ErrClusterNotConnected) {
+			log.V(5).Info("Waiting for the connection to the workload cluster")
+
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, fmt.Errorf("watching nodes of the workload cluster: %w", err)
+	}
+
+	if mvmMachine.Spec.ProviderID == nil || *mvmMachine.Spec.ProviderID == "" {
+		return ctrl.Result{}, nil
+	}
+
+	machine, err := util.GetOwnerMachine(ctx, r.Client, mvmMachine.ObjectMeta)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("getting owning machine: %w", err)
+	}
+
+	if machine == nil {
+		return ctrl.Result{}, nil
+	}
+
+	mvmCluster := &infrav1.MicrovmCluster{}
+	mvmClusterName := client.ObjectKey{
+		Namespace: cluster.Spec.InfrastructureRef.Namespace,
+		Name:      cluster.Spec.InfrastructureRef.Name,
+	}
+
+	if err := r.Get(ctx, mvmClusterName, mvmCluster); err != nil {
+		return ctrl.Result{}, fmt.Errorf("getting microvmcluster: %w", err)
+	}
+
+	machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+		Cluster:        cluster,
+		MicroVMCluster: mvmCluster,
+		Machine:        machine,
+		MicroVMMachine: mvmMachine,
+		Client:         r.Client,
+		Context:        ctx,
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to create machine scope: %w", err)
+	}
+
+	labels, err := machineScope.TopologyLabels()
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("getting topology labels: %w", err)
+	}
+
+	if len(labels) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	remoteClient, err := r.ClusterCache.GetClient(ctx, util.ObjectKey(cluster))
+	if err != nil {
+		if errors.Is(err, clustercache.ErrClusterNotConnected) {
+			log.V(5).Info("Waiting for the connection to the workload cluster")
+
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, fmt.Errorf("getting workload cluster client: %w", err)
+	}
+
+	node, err := getNodeByProviderID(ctx, remoteClient, *mvmMachine.Spec.ProviderID)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if node == nil {
+		log.V(2).Info("Node not registered yet")
+
+		return ctrl.Result{}, nil
+	}
+
+	log = log.WithValues("node", node.Name)
+
+	original := node.DeepCopy()
+	changed := false
+
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+
+	for key, value := range labels {
+		if node.Labels[key] != value {
+			node.Labels[key] = value
+			changed = true
+		}
+	}
+
+	if !changed {
+		return ctrl.Result{}, nil
+	}
+
+	if err := remoteClient.Patch(ctx, node, client.MergeFrom(original)); err != nil {
+		return ctrl.Result{}, fmt.Errorf("patching node labels: %w", err)
+	}
+
+	log.Info("Applied topology labels to node", "labels", labels)
+
+	return ctrl.Result{}, nil
+}
+
+// watchClusterNodes watches the Nodes of the workload cluster. It's a no-op if the Nodes are
+// already watched.
+func (r *MicrovmNodeReconciler) watchClusterNodes(ctx context.Context, cluster *clusterv1.Cluster) error {
+	return r.ClusterCache.Watch(ctx, util.ObjectKey(cluster), clustercache.NewWatcher(clustercache.WatcherOptions{
+		Name:         "microvmnode-watchNodes",
+		Watcher:      r.controller,
+		Kind:         &corev1.Node{},
+		EventHandler: handler.EnqueueRequestsFromMapFunc(r.nodeToMicrovmMachine(cluster)),
+	}))
+}
+
+// nodeToMicrovmMachine maps a Node of the workload cluster to the MicrovmMachine with the same
+// provider id.
+func (r *MicrovmNodeReconciler) nodeToMicrovmMachine(cluster *clusterv1.Cluster) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []ctrl.Request {
+		node, ok := o.(*corev1.Node)
+		if !ok || node.Spec.ProviderID == "" {
+			return nil
+		}
+
+		mvmMachines := &infrav1.MicrovmMachineList{}
+		if err := r.List(ctx, mvmMachines,
+			client.InNamespace(cluster.Namespace),
+			client.MatchingLabels{clusterv1.ClusterNameLabel: cluster.Name},
+			client.MatchingFields{microvmMachineProviderIDField: node.Spec.ProviderID},
+		); err != nil {
+			return nil
+		}
+
+		requests := make([]ctrl.Request, 0, len(mvmMachines.Items))
+		for _, mvmMachine := range mvmMachines.Items {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&mvmMachine)})
+		}
+
+		return requests
+	}
+}
+
+// clusterToMicrovmMachines maps a Cluster to its MicrovmMachines so that the Nodes are watched
+// again when the connection to the workload cluster is recreated.
+func (r *MicrovmNodeReconciler) clusterToMicrovmMachines(ctx context.Context, o client.Object) []ctrl.Request {
+	mvmMachines := &infrav1.MicrovmMachineList{}
+	if err := r.List(ctx, mvmMachines,
+		client.InNamespace(o.GetNamespace()),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: o.GetName()},
+	); err != nil {
+		return nil
+	}
+
+	requests := make([]ctrl.Request, 0, len(mvmMachines.Items))
+	for _, mvmMachine := range mvmMachines.Items {
+		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&mvmMachine)})
+	}
+
+	return requests
+}
+
+// getNodeByProviderID returns the Node with the provider id or nil if it hasn't registered yet.
+func getNodeByProviderID(ctx context.Context, c client.Client, providerID string) (*corev1.Node, error) {
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes, client.MatchingFields{index.NodeProviderIDField: providerID}); err != nil {
+		return nil, fmt.Errorf("listing nodes with provider id %s: %w", providerID, err)
+	}
+
+	if len(nodes.Items) == 0 {
+		return nil, nil
+	}
+
+	return &nodes.Items[0], nil
+}
+
+// microvmMachineByProviderID is the indexer of the provider id of the MicrovmMachines.
+func microvmMachineByProviderID(o client.Object) []string {
+	mvmMachine, ok := o.(*infrav1.MicrovmMachine)
+	if !ok || mvmMachine.Spec.ProviderID == nil || *mvmMachine.Spec.ProviderID == "" {
+		return nil
+	}
+
+	return []string{*mvmMachine.Spec.ProviderID}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MicrovmNodeReconciler) SetupWithManager(
+	ctx context.Context,
+	mgr ctrl.Manager,
+	options controller.Options,
+) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &infrav1.MicrovmMachine{}, microvmMachineProviderIDField, microvmMachineByProviderID); err != nil {
+		return fmt.Errorf("indexing microvmmachines by provider id: %w", err)
+	}
+
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		Named("microvmnode").
+		WithOptions(options).
+		For(&infrav1.MicrovmMachine{}).
+		WithEventFilter(predicates.ResourceNotPausedAndHasFilterLabel(mgr.GetScheme(), log, r.WatchFilterValue)).
+		WatchesRawSource(r.ClusterCache.GetClusterSource("microvmnode", r.clusterToMicrovmMachines)).
+		Build(r)
+	if err != nil {
+		return fmt.Errorf("creating microvm node controller: %w", err)
+	}
+
+	r.controller = c
+
+	return nil
+}
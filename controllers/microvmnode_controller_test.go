@@ -0,0 +1,109 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0.
+
+package controllers_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+const testNodeName = "node1"
+
+func createNode(providerID string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   testNodeName,
+			Labels: map[string]string{"kubernetes.io/os": "linux"},
+		},
+		Spec: corev1.NodeSpec{ProviderID: providerID},
+	}
+}
+
+func getNode(g *WithT, c client.Client) *corev1.Node {
+	node := &corev1.Node{}
+	g.Expect(c.Get(context.TODO(), client.ObjectKey{Name: testNodeName}, node)).To(Succeed())
+
+	return node
+}
+
+func defaultNodeObjects() clusterObjects {
+	apiObjects := defaultClusterObjects()
+	apiObjects.MvmMachine.Labels = map[string]string{clusterv1.ClusterNameLabel: testClusterName}
+
+	return apiObjects
+}
+
+func TestNodeReconcileAppliesTopologyLabels(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultNodeObjects()
+	apiObjects.MvmCluster.Spec.Placement.StaticPool.Hosts[0].Zone = "rack1"
+
+	client := createFakeClient(g, append(apiObjects.AsRuntimeObjects(), createNode(testMachineUID)))
+
+	clusterCache, result, err := reconcileNodeWithCache(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling the node should not return an error")
+	g.Expect(result.IsZero()).To(BeTrue())
+	g.Expect(clusterCache.watches).To(ConsistOf("microvmnode-watchNodes"), "Expect the nodes of the workload cluster to be watched")
+
+	node := getNode(g, client)
+	g.Expect(node.Labels).To(HaveKeyWithValue(corev1.LabelTopologyZone, "rack1"))
+	g.Expect(node.Labels).To(HaveKeyWithValue(infrav1.HostLabel, "host1"))
+	g.Expect(node.Labels).To(HaveKeyWithValue("kubernetes.io/os", "linux"), "Expect existing labels to be kept")
+}
+
+func TestNodeReconcileHostWithoutNameOrZone(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultNodeObjects()
+	apiObjects.MvmCluster.Spec.Placement.StaticPool.Hosts[0].Name = ""
+
+	client := createFakeClient(g, append(apiObjects.AsRuntimeObjects(), createNode(testMachineUID)))
+
+	_, err := reconcileNode(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling the node should not return an error")
+
+	node := getNode(g, client)
+	g.Expect(node.Labels).To(HaveKeyWithValue(infrav1.HostLabel, "127.0.0.1-9090"))
+	g.Expect(node.Labels).NotTo(HaveKey(corev1.LabelTopologyZone), "Expect no zone if the host has no zone")
+}
+
+func TestNodeReconcileNodeNotRegistered(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultNodeObjects()
+
+	client := createFakeClient(g, append(apiObjects.AsRuntimeObjects(), createNode("microvm://127.0.0.1:9090/other")))
+
+	clusterCache, _, err := reconcileNodeWithCache(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling before the node has registered should not return an error")
+	g.Expect(clusterCache.watches).NotTo(BeEmpty(), "Expect the nodes to be watched so the labels are applied when it registers")
+
+	node := getNode(g, client)
+	g.Expect(node.Labels).NotTo(HaveKey(infrav1.HostLabel))
+}
+
+func TestNodeReconcileNoProviderID(t *testing.T) {
+	g := NewWithT(t)
+
+	apiObjects := defaultNodeObjects()
+	apiObjects.MvmMachine.Spec.ProviderID = nil
+
+	client := createFakeClient(g, append(apiObjects.AsRuntimeObjects(), createNode("")))
+
+	_, err := reconcileNode(client)
+	g.Expect(err).NotTo(HaveOccurred(), "Reconciling before the microvm is created should not return an error")
+
+	node := getNode(g, client)
+	g.Expect(node.Labels).NotTo(HaveKey(infrav1.HostLabel))
+}
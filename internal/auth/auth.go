@@ -0,0 +1,79 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package auth contains the authenticators used to connect to the flintlock hosts and the sources
+// of the tokens they send.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+// Authenticator returns the client option that authenticates with the flintlock host at addr.
+type Authenticator interface {
+	ClientOption(ctx context.Context, addr string) (flclient.Options, error)
+}
+
+// Token is a bearer token and when it expires.
+type Token struct {
+	Value  string
+	Expiry time.Time
+}
+
+// TokenSource returns bearer tokens.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// BasicTokenFunc returns the basic auth token for the host at addr.
+type BasicTokenFunc func(ctx context.Context, addr string) (string, error)
+
+// Basic returns an authenticator that sends a static token for each host using basic auth.
+func Basic(tokenFunc BasicTokenFunc) Authenticator {
+	return basicAuthenticator(tokenFunc)
+}
+
+type basicAuthenticator BasicTokenFunc
+
+func (b basicAuthenticator) ClientOption(ctx context.Context, addr string) (flclient.Options, error) {
+	token, err := b(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("getting basic auth token: %w", err)
+	}
+
+	return flclient.WithBasicAuth(token), nil
+}
+
+// Bearer returns an authenticator that sends the tokens from the source as bearer tokens.
+func Bearer(source TokenSource) Authenticator {
+	return &bearerAuthenticator{source: source}
+}
+
+type bearerAuthenticator struct {
+	source TokenSource
+}
+
+func (b *bearerAuthenticator) ClientOption(ctx context.Context, _ string) (flclient.Options, error) {
+	token, err := b.source.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting bearer token: %w", err)
+	}
+
+	return flclient.WithBearerToken(token.Value), nil
+}
+
+// MTLS returns an authenticator that doesn't send any credentials as the hosts authenticate the
+// client with its certificate.
+func MTLS() Authenticator {
+	return mtlsAuthenticator{}
+}
+
+type mtlsAuthenticator struct{}
+
+func (mtlsAuthenticator) ClientOption(_ context.Context, _ string) (flclient.Options, error) {
+	return flclient.WithoutAuth(), nil
+}
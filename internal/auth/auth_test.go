@@ -0,0 +1,127 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/auth"
+)
+
+type staticTokenSource struct {
+	token *auth.Token
+	calls int
+}
+
+func (s *staticTokenSource) Token(_ context.Context) (*auth.Token, error) {
+	s.calls++
+
+	return s.token, nil
+}
+
+func TestServiceAccountTokenSource(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "flintlock", Namespace: "ns1"},
+	}
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(serviceAccount).Build()
+
+	source := &auth.ServiceAccountTokenSource{
+		Client:         client,
+		ServiceAccount: types.NamespacedName{Name: "flintlock", Namespace: "ns1"},
+		Audience:       "flintlock",
+	}
+
+	token, err := source.Token(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(token.Value).NotTo(BeEmpty())
+	g.Expect(token.Expiry).To(BeTemporally(">", time.Now()))
+
+	source.ServiceAccount.Name = "missing"
+	_, err = source.Token(context.TODO())
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestExchangeTokenSource(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" ||
+			r.PostForm.Get("subject_token") != "subject" ||
+			r.PostForm.Get("audience") != "flintlock" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_request"}`))
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":      "exchanged",
+			"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+			"token_type":        "Bearer",
+			"expires_in":        300,
+		})
+	}))
+	defer server.Close()
+
+	subject := &staticTokenSource{token: &auth.Token{Value: "subject", Expiry: time.Now().Add(time.Hour)}}
+	source := &auth.ExchangeTokenSource{
+		Endpoint: server.URL,
+		Audience: "flintlock",
+		Subject:  subject,
+	}
+
+	token, err := source.Token(context.TODO())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(token.Value).To(Equal("exchanged"))
+	g.Expect(token.Expiry).To(BeTemporally("~", time.Now().Add(5*time.Minute), time.Minute))
+
+	source.Audience = "other"
+	_, err = source.Token(context.TODO())
+	g.Expect(err).To(MatchError(ContainSubstring("invalid_request")))
+}
+
+func TestCache(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := auth.NewCache()
+
+	longLived := &staticTokenSource{token: &auth.Token{Value: "long", Expiry: time.Now().Add(time.Hour)}}
+	for range 3 {
+		token, err := cache.Source("long", longLived).Token(context.TODO())
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(token.Value).To(Equal("long"))
+	}
+	g.Expect(longLived.calls).To(Equal(1))
+
+	expiring := &staticTokenSource{token: &auth.Token{Value: "expiring", Expiry: time.Now().Add(30 * time.Second)}}
+	for range 3 {
+		_, err := cache.Source("expiring", expiring).Token(context.TODO())
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	g.Expect(expiring.calls).To(Equal(3))
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minTokenLifetime is the lifetime that a cached token must have left to be reused.
+const minTokenLifetime = time.Minute
+
+// Cache reuses tokens until they are about to expire. Each reconcile creates a new client so the
+// cache stops a new token being requested for every reconcile.
+type Cache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+	now    func() time.Time
+}
+
+type cachedToken struct {
+	token  *Token
+	issued time.Time
+}
+
+// NewCache creates an empty cache.
+func NewCache() *Cache {
+	return &Cache{
+		tokens: map[string]cachedToken{},
+		now:    time.Now,
+	}
+}
+
+// Source returns a token source that caches the tokens from source under key. The key must
+// identify everything that the tokens depend on.
+func (c *Cache) Source(key string, source TokenSource) TokenSource {
+	return &cachingTokenSource{cache: c, key: key, source: source}
+}
+
+type cachingTokenSource struct {
+	cache  *Cache
+	key    string
+	source TokenSource
+}
+
+// Token returns the cached token if it's valid for at least a fifth of its lifetime, and at least
+// a minute, otherwise a new token is fetched.
+func (s *cachingTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.cache.mu.Lock()
+	cached, ok := s.cache.tokens[s.key]
+	s.cache.mu.Unlock()
+
+	now := s.cache.now()
+	if ok && cached.valid(now) {
+		return cached.token, nil
+	}
+
+	token, err := s.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.mu.Lock()
+	s.cache.tokens[s.key] = cachedToken{token: token, issued: now}
+	s.cache.mu.Unlock()
+
+	return token, nil
+}
+
+func (c cachedToken) valid(now time.Time) bool {
+	if c.token.Expiry.IsZero() {
+		return false
+	}
+
+	remaining := c.token.Expiry.Sub(now)
+	lifetime := c.token.Expiry.Sub(c.issued)
+
+	return remaining >= minTokenLifetime && remaining >= lifetime/5
+}
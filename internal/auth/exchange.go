@@ -0,0 +1,110 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	jwtTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+
+	defaultExchangeTimeout = 10 * time.Second
+	maxErrorBodySize       = 1024
+)
+
+var (
+	errTokenExchangeFailed = errors.New("token exchange failed")
+	errNoAccessToken       = errors.New("token exchange response has no access token")
+)
+
+// ExchangeTokenSource exchanges the tokens from another source for short-lived tokens at an OAuth 2.0
+// token exchange (RFC 8693) endpoint.
+type ExchangeTokenSource struct {
+	// Endpoint is the URL of the token exchange endpoint.
+	Endpoint string
+	// Audience is the audience requested for the exchanged tokens. It isn't sent if it's empty.
+	Audience string
+	// Subject is the source of the tokens that are exchanged.
+	Subject TokenSource
+	// HTTPClient is used to call the endpoint. A client with a timeout is used if it's nil.
+	HTTPClient *http.Client
+}
+
+// exchangeResponse is the successful response of the token exchange endpoint.
+type exchangeResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token exchanges a token from the subject source for a new token.
+func (e *ExchangeTokenSource) Token(ctx context.Context) (*Token, error) {
+	subject, err := e.Subject.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting subject token: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"subject_token":        {subject.Value},
+		"subject_token_type":   {jwtTokenType},
+		"requested_token_type": {accessTokenType},
+	}
+
+	if e.Audience != "" {
+		form.Set("audience", e.Audience)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating token exchange request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := e.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultExchangeTimeout}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling token exchange endpoint %s: %w", e.Endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+
+		return nil, fmt.Errorf("%w: %s: %s", errTokenExchangeFailed, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	exchanged := &exchangeResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(exchanged); err != nil {
+		return nil, fmt.Errorf("decoding token exchange response: %w", err)
+	}
+
+	if exchanged.AccessToken == "" {
+		return nil, errNoAccessToken
+	}
+
+	token := &Token{Value: exchanged.AccessToken, Expiry: subject.Expiry}
+	if exchanged.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(exchanged.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ServiceAccountTokenSource requests tokens for a service account from the API server.
+type ServiceAccountTokenSource struct {
+	// Client is used to create the token requests.
+	Client client.Client
+	// ServiceAccount is the key of the service account.
+	ServiceAccount types.NamespacedName
+	// Audience is the intended audience of the tokens.
+	Audience string
+	// ExpirationSeconds is the requested lifetime of the tokens. The API server default is used if it's 0.
+	ExpirationSeconds int64
+}
+
+// Token requests a new token for the service account.
+func (s *ServiceAccountTokenSource) Token(ctx context.Context) (*Token, error) {
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.ServiceAccount.Name,
+			Namespace: s.ServiceAccount.Namespace,
+		},
+	}
+
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences: []string{s.Audience},
+		},
+	}
+
+	if s.ExpirationSeconds > 0 {
+		tokenRequest.Spec.ExpirationSeconds = &s.ExpirationSeconds
+	}
+
+	if err := s.Client.SubResource("token").Create(ctx, serviceAccount, tokenRequest); err != nil {
+		return nil, fmt.Errorf("requesting token for service account %s: %w", s.ServiceAccount, err)
+	}
+
+	return &Token{
+		Value:  tokenRequest.Status.Token,
+		Expiry: tokenRequest.Status.ExpirationTimestamp.Time,
+	}, nil
+}
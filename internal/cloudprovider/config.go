@@ -0,0 +1,97 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package cloudprovider
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"sigs.k8s.io/yaml"
+
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+// Config is the configuration of the cloud provider, which is read from the file passed to the
+// cloud controller manager with --cloud-config.
+type Config struct {
+	// BasicAuthToken is the token used to authenticate with all the flintlock hosts.
+	BasicAuthToken string `json:"basicAuthToken,omitempty"`
+	// HostBasicAuthTokens are tokens for individual hosts keyed by the host address. They take
+	// precedence over BasicAuthToken.
+	HostBasicAuthTokens map[string]string `json:"hostBasicAuthTokens,omitempty"`
+	// TLS is the paths of the certificates used to connect to flintlock hosts that use TLS.
+	TLS *TLSFiles `json:"tls,omitempty"`
+	// Proxy is the proxy used to connect to the flintlock hosts.
+	Proxy *flclient.Proxy `json:"proxy,omitempty"`
+	// Region is the region reported for all the nodes.
+	Region string `json:"region,omitempty"`
+	// HostZones are the zones of the hosts keyed by the host address, which should match the zones
+	// of the hosts in the MicrovmClusters. No zone is reported for the nodes on other hosts.
+	HostZones map[string]string `json:"hostZones,omitempty"`
+}
+
+// TLSFiles are the paths of PEM encoded files for a TLS connection.
+type TLSFiles struct {
+	CertFile   string `json:"certFile"`
+	KeyFile    string `json:"keyFile"`
+	CACertFile string `json:"caCertFile,omitempty"`
+}
+
+// readConfig reads the configuration. The configuration is optional so an empty configuration is
+// returned if there's no config.
+func readConfig(r io.Reader) (*Config, error) {
+	cfg := &Config{}
+
+	if r == nil {
+		return cfg, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading cloud config: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing cloud config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// basicAuthToken returns the token for the host.
+func (c *Config) basicAuthToken(host string) string {
+	if token, ok := c.HostBasicAuthTokens[host]; ok {
+		return token
+	}
+
+	return c.BasicAuthToken
+}
+
+// tlsConfig loads the TLS certificates.
+func (c *Config) tlsConfig() (*flclient.TLSConfig, error) {
+	if c.TLS == nil {
+		return nil, nil
+	}
+
+	cert, err := os.ReadFile(c.TLS.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading tls certificate: %w", err)
+	}
+
+	key, err := os.ReadFile(c.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading tls key: %w", err)
+	}
+
+	tls := &flclient.TLSConfig{Cert: cert, Key: key}
+
+	if c.TLS.CACertFile != "" {
+		if tls.CACert, err = os.ReadFile(c.TLS.CACertFile); err != nil {
+			return nil, fmt.Errorf("reading tls ca certificate: %w", err)
+		}
+	}
+
+	return tls, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package cloudprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
+)
+
+var (
+	errMissingProviderID = errors.New("node has no provider id")
+	errInvalidProviderID = errors.New("provider id isn't a microvm provider id")
+)
+
+// instances implements InstancesV2 by getting the microvm for a node from the flintlock host in
+// its provider id, which has the form microvm://<host>/<uid>.
+type instances struct {
+	config     *Config
+	tls        *flclient.TLSConfig
+	clientFunc flclient.FactoryFunc
+}
+
+// InstanceExists returns true if the microvm for the node exists.
+func (i *instances) InstanceExists(ctx context.Context, node *corev1.Node) (bool, error) {
+	mvm, err := i.getMicrovm(ctx, node)
+	if err != nil {
+		return false, err
+	}
+
+	return mvm != nil, nil
+}
+
+// InstanceShutdown returns true if the microvm for the node has failed or is being deleted.
+func (i *instances) InstanceShutdown(ctx context.Context, node *corev1.Node) (bool, error) {
+	mvm, err := i.getMicrovm(ctx, node)
+	if err != nil {
+		return false, err
+	}
+
+	if mvm == nil {
+		return false, cloudprovider.InstanceNotFound
+	}
+
+	switch mvm.GetStatus().GetState() {
+	case flintlocktypes.MicroVMStatus_FAILED, flintlocktypes.MicroVMStatus_DELETING:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// InstanceMetadata returns the addresses, type and topology of the microvm for the node. The zone
+// is the zone configured for the host that the microvm is on. The host label is left to the node
+// controller of the infrastructure provider, which knows the names of the hosts in the static pool.
+func (i *instances) InstanceMetadata(ctx context.Context, node *corev1.Node) (*cloudprovider.InstanceMetadata, error) {
+	mvm, err := i.getMicrovm(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	if mvm == nil {
+		return nil, cloudprovider.InstanceNotFound
+	}
+
+	providerID, _ := scope.NewProviderID(node.Spec.ProviderID)
+	host := providerID.Host()
+
+	spec := mvm.GetSpec()
+
+	metadata := &cloudprovider.InstanceMetadata{
+		ProviderID:    node.Spec.ProviderID,
+		InstanceType:  fmt.Sprintf("%dvcpu-%dmb", spec.GetVcpu(), spec.GetMemoryInMb()),
+		NodeAddresses: []corev1.NodeAddress{{Type: corev1.NodeHostName, Address: node.Name}},
+		Zone:          i.config.HostZones[host],
+		Region:        i.config.Region,
+	}
+
+	ips := staticAddresses(spec)
+	if len(ips) == 0 {
+		ips = nodeAddresses(node)
+	}
+
+	for _, ip := range ips {
+		metadata.NodeAddresses = append(metadata.NodeAddresses, corev1.NodeAddress{
+			Type:    corev1.NodeInternalIP,
+			Address: ip,
+		})
+	}
+
+	return metadata, nil
+}
+
+// staticAddresses returns the IPs of the interfaces of the microvm that have a static address.
+func staticAddresses(spec *flintlocktypes.MicroVMSpec) []string {
+	ips := []string{}
+
+	for _, iface := range spec.GetInterfaces() {
+		address := iface.GetAddress().GetAddress()
+		if address == "" {
+			continue
+		}
+
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			continue
+		}
+
+		ips = append(ips, ip.String())
+	}
+
+	return ips
+}
+
+// nodeAddresses returns the internal IPs of a node whose microvm gets its addresses with DHCP, as
+// flintlock doesn't report the addresses of the microvms. The IPs passed to the kubelet with
+// --node-ip are used, otherwise the internal IPs that the kubelet has already reported.
+func nodeAddresses(node *corev1.Node) []string {
+	ips := []string{}
+
+	if provided := node.Annotations[cloudproviderapi.AnnotationAlphaProvidedIPAddr]; provided != "" {
+		for _, address := range strings.Split(provided, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(address)); ip != nil {
+				ips = append(ips, ip.String())
+			}
+		}
+
+		return ips
+	}
+
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			ips = append(ips, address.Address)
+		}
+	}
+
+	return ips
+}
+
+// getMicrovm gets the microvm for the node from its host. It returns nil if the microvm doesn't exist.
+func (i *instances) getMicrovm(ctx context.Context, node *corev1.Node) (*flintlocktypes.MicroVM, error) {
+	if node.Spec.ProviderID == "" {
+		return nil, fmt.Errorf("%w: %s", errMissingProviderID, node.Name)
+	}
+
+	providerID, err := scope.NewProviderID(node.Spec.ProviderID)
+	if err != nil {
+		return nil, fmt.Errorf("parsing provider id %s: %w", node.Spec.ProviderID, err)
+	}
+
+	host := providerID.Host()
+	if providerID.CloudProvider() != ProviderName || host == "" {
+		return nil, fmt.Errorf("%w: %s", errInvalidProviderID, node.Spec.ProviderID)
+	}
+
+	client, err := i.clientFunc(
+		host,
+		flclient.WithBasicAuth(i.config.basicAuthToken(host)),
+		flclient.WithTLS(i.tls),
+		flclient.WithProxy(i.config.Proxy),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating microvm client for host %s: %w", host, err)
+	}
+	defer client.Close()
+
+	resp, err := client.GetMicroVM(ctx, &flintlockv1.GetMicroVMRequest{Uid: providerID.ID()})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("getting microvm %s from host %s: %w", providerID.ID(), host, err)
+	}
+
+	return resp.GetMicrovm(), nil
+}
+
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound || strings.Contains(err.Error(), "not found")
+}
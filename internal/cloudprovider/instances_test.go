@@ -0,0 +1,213 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package cloudprovider_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/utils/pointer"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/controllers/fakes"
+	microvmcloud "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/cloudprovider"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+const (
+	testHost = "10.0.0.1:9090"
+	testUID  = "01HXYZ"
+)
+
+func newNode(providerID string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine1"},
+		Spec:       corev1.NodeSpec{ProviderID: providerID},
+	}
+}
+
+func newInstances(g *WithT, fakeClient *fakes.FakeClient, hosts *[]string) cloudprovider.InstancesV2 {
+	cfg := &microvmcloud.Config{Region: "lab", HostZones: map[string]string{testHost: "rack1"}}
+
+	cloud, err := microvmcloud.New(cfg, func(address string, _ ...flclient.Options) (flclient.Client, error) {
+		if hosts != nil {
+			*hosts = append(*hosts, address)
+		}
+
+		return fakeClient, nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	instances, ok := cloud.InstancesV2()
+	g.Expect(ok).To(BeTrue())
+
+	return instances
+}
+
+func withMicrovm(fakeClient *fakes.FakeClient, state flintlocktypes.MicroVMStatus_MicroVMState) {
+	fakeClient.GetMicroVMReturns(&flintlockv1.GetMicroVMResponse{
+		Microvm: &flintlocktypes.MicroVM{
+			Spec: &flintlocktypes.MicroVMSpec{
+				Uid:        pointer.String(testUID),
+				Vcpu:       2,
+				MemoryInMb: 2048,
+				Interfaces: []*flintlocktypes.NetworkInterface{
+					{DeviceId: "eth0"},
+					{DeviceId: "eth1", Address: &flintlocktypes.StaticAddress{Address: "192.168.10.5/24"}},
+				},
+			},
+			Status: &flintlocktypes.MicroVMStatus{State: state},
+		},
+	}, nil)
+}
+
+func TestInstanceExists(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient := &fakes.FakeClient{}
+	withMicrovm(fakeClient, flintlocktypes.MicroVMStatus_CREATED)
+
+	var hosts []string
+	instances := newInstances(g, fakeClient, &hosts)
+
+	exists, err := instances.InstanceExists(context.TODO(), newNode("microvm://"+testHost+"/"+testUID))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(exists).To(BeTrue())
+	g.Expect(hosts).To(Equal([]string{testHost}), "Expect the client to connect to the host in the provider id")
+
+	_, req, _ := fakeClient.GetMicroVMArgsForCall(0)
+	g.Expect(req.Uid).To(Equal(testUID))
+}
+
+func TestInstanceExistsMissingMicrovm(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient := &fakes.FakeClient{}
+	instances := newInstances(g, fakeClient, nil)
+
+	fakeClient.GetMicroVMReturns(&flintlockv1.GetMicroVMResponse{}, nil)
+	exists, err := instances.InstanceExists(context.TODO(), newNode("microvm://"+testHost+"/"+testUID))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(exists).To(BeFalse())
+
+	fakeClient.GetMicroVMReturns(nil, status.Error(codes.NotFound, "microvm not found"))
+	exists, err = instances.InstanceExists(context.TODO(), newNode("microvm://"+testHost+"/"+testUID))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(exists).To(BeFalse())
+}
+
+func TestInstanceExistsErrors(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient := &fakes.FakeClient{}
+	instances := newInstances(g, fakeClient, nil)
+
+	for _, providerID := range []string{"", "aws:///i-1234", "microvm://" + testUID} {
+		_, err := instances.InstanceExists(context.TODO(), newNode(providerID))
+		g.Expect(err).To(HaveOccurred(), "Expect an error for provider id %q", providerID)
+	}
+
+	g.Expect(fakeClient.GetMicroVMCallCount()).To(Equal(0))
+
+	fakeClient.GetMicroVMReturns(nil, errors.New("connection refused"))
+	_, err := instances.InstanceExists(context.TODO(), newNode("microvm://"+testHost+"/"+testUID))
+	g.Expect(err).To(HaveOccurred(), "Expect an error when the host can't be reached so the node isn't deleted")
+}
+
+func TestInstanceShutdown(t *testing.T) {
+	g := NewWithT(t)
+
+	tt := []struct {
+		state    flintlocktypes.MicroVMStatus_MicroVMState
+		shutdown bool
+	}{
+		{state: flintlocktypes.MicroVMStatus_PENDING, shutdown: false},
+		{state: flintlocktypes.MicroVMStatus_CREATED, shutdown: false},
+		{state: flintlocktypes.MicroVMStatus_FAILED, shutdown: true},
+		{state: flintlocktypes.MicroVMStatus_DELETING, shutdown: true},
+	}
+
+	for _, tc := range tt {
+		fakeClient := &fakes.FakeClient{}
+		withMicrovm(fakeClient, tc.state)
+
+		shutdown, err := newInstances(g, fakeClient, nil).InstanceShutdown(context.TODO(), newNode("microvm://"+testHost+"/"+testUID))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(shutdown).To(Equal(tc.shutdown), "Unexpected shutdown for state %s", tc.state)
+	}
+}
+
+func TestInstanceMetadata(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient := &fakes.FakeClient{}
+	withMicrovm(fakeClient, flintlocktypes.MicroVMStatus_CREATED)
+
+	providerID := "microvm://" + testHost + "/" + testUID
+	metadata, err := newInstances(g, fakeClient, nil).InstanceMetadata(context.TODO(), newNode(providerID))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(metadata.ProviderID).To(Equal(providerID))
+	g.Expect(metadata.InstanceType).To(Equal("2vcpu-2048mb"))
+	g.Expect(metadata.Zone).To(Equal("rack1"))
+	g.Expect(metadata.Region).To(Equal("lab"))
+	g.Expect(metadata.AdditionalLabels).NotTo(HaveKey(infrav1.HostLabel), "Expect the host label to be left to the node controller")
+	g.Expect(metadata.NodeAddresses).To(ConsistOf(
+		corev1.NodeAddress{Type: corev1.NodeHostName, Address: "machine1"},
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.10.5"},
+	))
+}
+
+func TestInstanceMetadataDHCP(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient := &fakes.FakeClient{}
+	fakeClient.GetMicroVMReturns(&flintlockv1.GetMicroVMResponse{
+		Microvm: &flintlocktypes.MicroVM{
+			Spec: &flintlocktypes.MicroVMSpec{
+				Uid:        pointer.String(testUID),
+				Vcpu:       2,
+				MemoryInMb: 2048,
+				Interfaces: []*flintlocktypes.NetworkInterface{{DeviceId: "eth0"}},
+			},
+			Status: &flintlocktypes.MicroVMStatus{State: flintlocktypes.MicroVMStatus_CREATED},
+		},
+	}, nil)
+
+	providerID := "microvm://" + testHost + "/" + testUID
+	instances := newInstances(g, fakeClient, nil)
+
+	node := newNode(providerID)
+	node.Status.Addresses = []corev1.NodeAddress{
+		{Type: corev1.NodeHostName, Address: "machine1"},
+		{Type: corev1.NodeInternalIP, Address: "192.168.10.20"},
+	}
+
+	metadata, err := instances.InstanceMetadata(context.TODO(), node)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(metadata.NodeAddresses).To(ConsistOf(
+		corev1.NodeAddress{Type: corev1.NodeHostName, Address: "machine1"},
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.10.20"},
+	), "Expect the address reported by the kubelet to be used")
+
+	node.Annotations = map[string]string{"alpha.kubernetes.io/provided-node-ip": "192.168.10.21,fd00::21"}
+
+	metadata, err = instances.InstanceMetadata(context.TODO(), node)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(metadata.NodeAddresses).To(ConsistOf(
+		corev1.NodeAddress{Type: corev1.NodeHostName, Address: "machine1"},
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.10.21"},
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "fd00::21"},
+	), "Expect the node ips passed to the kubelet to be used")
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package cloudprovider implements a Kubernetes cloud provider for clusters of microvms created
+// by flintlock. Only the InstancesV2 interface is implemented, which is used to check that the
+// microvms of nodes still exist and to set their addresses and topology.
+package cloudprovider
+
+import (
+	"fmt"
+	"io"
+
+	cloudprovider "k8s.io/cloud-provider"
+
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+// ProviderName is the name of the cloud provider, which is also the scheme of the provider ids.
+const ProviderName = "microvm"
+
+func init() {
+	cloudprovider.RegisterCloudProvider(ProviderName, func(config io.Reader) (cloudprovider.Interface, error) {
+		cfg, err := readConfig(config)
+		if err != nil {
+			return nil, err
+		}
+
+		return New(cfg, flclient.NewClient)
+	})
+}
+
+type cloud struct {
+	instances *instances
+}
+
+// New creates the cloud provider. The clients for the flintlock hosts are created using clientFunc.
+func New(cfg *Config, clientFunc flclient.FactoryFunc) (cloudprovider.Interface, error) {
+	tls, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading tls config: %w", err)
+	}
+
+	return &cloud{
+		instances: &instances{
+			config:     cfg,
+			tls:        tls,
+			clientFunc: clientFunc,
+		},
+	}, nil
+}
+
+// Initialize doesn't need to do anything as the provider doesn't run any controllers.
+func (c *cloud) Initialize(_ cloudprovider.ControllerClientBuilder, _ <-chan struct{}) {}
+
+// LoadBalancer isn't supported.
+func (c *cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	return nil, false
+}
+
+// Instances isn't supported, InstancesV2 is used instead.
+func (c *cloud) Instances() (cloudprovider.Instances, bool) {
+	return nil, false
+}
+
+// InstancesV2 returns the implementation that looks up microvms on the flintlock hosts.
+func (c *cloud) InstancesV2() (cloudprovider.InstancesV2, bool) {
+	return c.instances, true
+}
+
+// Zones isn't supported, the zone is returned by InstancesV2.
+func (c *cloud) Zones() (cloudprovider.Zones, bool) {
+	return nil, false
+}
+
+// Clusters isn't supported.
+func (c *cloud) Clusters() (cloudprovider.Clusters, bool) {
+	return nil, false
+}
+
+// Routes isn't supported.
+func (c *cloud) Routes() (cloudprovider.Routes, bool) {
+	return nil, false
+}
+
+// ProviderName returns the name of the cloud provider.
+func (c *cloud) ProviderName() string {
+	return ProviderName
+}
+
+// HasClusterID returns true as the provider only manages the nodes of a single cluster.
+func (c *cloud) HasClusterID() bool {
+	return true
+}
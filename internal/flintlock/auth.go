@@ -0,0 +1,26 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package flintlock
+
+import (
+	"context"
+)
+
+// headerAuth adds the authorization header to each request.
+type headerAuth struct {
+	authorization   string
+	requireSecurity bool
+}
+
+// GetRequestMetadata returns the authorization header for the request.
+func (h headerAuth) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": h.authorization,
+	}, nil
+}
+
+// RequireTransportSecurity returns true if the credentials can only be sent over TLS.
+func (h headerAuth) RequireTransportSecurity() bool {
+	return h.requireSecurity
+}
@@ -0,0 +1,251 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package flintlock contains the client used to connect to the flintlock hosts. It's based on the
+// client in controller-pkg but supports the connection settings that can be configured per host.
+package flintlock
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var (
+	errInvalidCACert       = errors.New("no certificates found in ca certificate")
+	errCertificateExpired  = errors.New("client certificate has expired")
+	errCertificateNotValid = errors.New("client certificate is not valid yet")
+	errInvalidClientCert   = errors.New("no certificate found in client certificate")
+)
+
+// Client is a client for the microvm service of a flintlock host.
+type Client interface {
+	flintlockv1.MicroVMClient
+
+	// Close closes the connection to the host.
+	Close()
+}
+
+// FactoryFunc creates a client for the flintlock host at address.
+type FactoryFunc func(address string, opts ...Options) (Client, error)
+
+// Proxy is the HTTP proxy used to connect to a host.
+type Proxy struct {
+	// Endpoint is the URL of the proxy.
+	Endpoint string `json:"endpoint"`
+	// Username is used to authenticate with the proxy using basic auth. The user info in the
+	// endpoint is used if it's empty.
+	Username string `json:"username,omitempty"`
+	// Password is used to authenticate with the proxy using basic auth.
+	Password string `json:"password,omitempty"`
+}
+
+// TLSConfig is the TLS configuration used to connect to a host.
+type TLSConfig struct {
+	// Cert is the PEM encoded client certificate.
+	Cert []byte `json:"cert"`
+	// Key is the PEM encoded key of the client certificate.
+	Key []byte `json:"key"`
+	// CACert is the PEM encoded CA used to verify the host. The system CAs are used if it's empty.
+	CACert []byte `json:"caCert,omitempty"`
+	// ServerName is the name used to verify the certificate of the host. It defaults to the host
+	// in the address.
+	ServerName string `json:"serverName,omitempty"`
+}
+
+type clientConfig struct {
+	authorization string
+	tls           *TLSConfig
+	proxy         *Proxy
+}
+
+// Options configures the connection to a host.
+type Options func(*clientConfig)
+
+// WithBasicAuth sets the token used to authenticate with the host using basic auth. No credentials
+// are sent if the token is empty.
+func WithBasicAuth(t string) Options {
+	return func(c *clientConfig) {
+		c.authorization = ""
+		if t != "" {
+			c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(t))
+		}
+	}
+}
+
+// WithBearerToken sets the bearer token used to authenticate with the host. No credentials are
+// sent if the token is empty.
+func WithBearerToken(t string) Options {
+	return func(c *clientConfig) {
+		c.authorization = ""
+		if t != "" {
+			c.authorization = "Bearer " + t
+		}
+	}
+}
+
+// WithoutAuth doesn't send any credentials with the requests, which is used when the host
+// authenticates the client with its certificate.
+func WithoutAuth() Options {
+	return func(c *clientConfig) {
+		c.authorization = ""
+	}
+}
+
+// WithProxy sets the proxy used to connect to the host.
+func WithProxy(p *Proxy) Options {
+	return func(c *clientConfig) {
+		c.proxy = p
+	}
+}
+
+// WithTLS sets the TLS configuration. An insecure connection is used if it's nil.
+func WithTLS(t *TLSConfig) Options {
+	return func(c *clientConfig) {
+		c.tls = t
+	}
+}
+
+// NewClient creates a client for the flintlock host at address.
+func NewClient(address string, opts ...Options) (Client, error) {
+	cfg := clientConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	creds := insecure.NewCredentials()
+
+	if cfg.tls != nil {
+		var err error
+
+		creds, err = loadTLS(cfg.tls)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+	}
+
+	if cfg.authorization != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(headerAuth{
+			authorization:   cfg.authorization,
+			requireSecurity: cfg.tls != nil,
+		}))
+	}
+
+	if cfg.proxy != nil {
+		dialer, err := newProxyDialer(cfg.proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		dialOpts = append(dialOpts, grpc.WithContextDialer(dialer.dial))
+	}
+
+	//nolint:staticcheck // the same dial as controller-pkg is kept until the client is changed on purpose
+	conn, err := grpc.Dial(address, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating grpc connection: %w", err)
+	}
+
+	return &flintlockClient{
+		MicroVMClient: flintlockv1.NewMicroVMClient(conn),
+		conn:          conn,
+	}, nil
+}
+
+// loadTLS creates the transport credentials for a TLS connection.
+func loadTLS(cfg *TLSConfig) (credentials.TransportCredentials, error) {
+	tlsConfig, err := cfg.config()
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// config returns the crypto/tls configuration.
+func (t *TLSConfig) config() (*tls.Config, error) {
+	certificate, err := tls.X509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, fmt.Errorf("loading client certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{certificate},
+		ServerName:   t.ServerName,
+	}
+
+	if len(t.CACert) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(t.CACert) {
+			return nil, errInvalidCACert
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// Validate checks that the client certificate matches the key and is valid at now, and that the
+// CA can be parsed.
+func (t *TLSConfig) Validate(now time.Time) error {
+	if _, err := t.config(); err != nil {
+		return err
+	}
+
+	leaf, err := t.ClientCertificate()
+	if err != nil {
+		return err
+	}
+
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("%w: expired at %s", errCertificateExpired, leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("%w: valid from %s", errCertificateNotValid, leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// ClientCertificate parses the client certificate. If Cert contains a chain the first certificate
+// is returned.
+func (t *TLSConfig) ClientCertificate() (*x509.Certificate, error) {
+	block, _ := pem.Decode(t.Cert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errInvalidClientCert
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing client certificate: %w", err)
+	}
+
+	return leaf, nil
+}
+
+type flintlockClient struct {
+	flintlockv1.MicroVMClient
+
+	conn *grpc.ClientConn
+}
+
+// Close closes the connection to the host.
+func (c *flintlockClient) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
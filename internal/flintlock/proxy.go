@@ -0,0 +1,130 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package flintlock
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+var (
+	errInvalidProxyEndpoint   = errors.New("proxy endpoint must be a url with a host")
+	errProxyConnectFailed     = errors.New("failed to connect through proxy")
+	errUnsupportedProxyScheme = errors.New("proxy endpoint must be an http or https url")
+)
+
+// proxyDialer connects to the hosts through a HTTP proxy using CONNECT. It's based on the dialer in
+// the flintlock client but can authenticate with the proxy, and connect to it using TLS.
+type proxyDialer struct {
+	address       string
+	authorization string
+	// tlsConfig is set for https proxies.
+	tlsConfig *tls.Config
+}
+
+func newProxyDialer(proxy *Proxy) (*proxyDialer, error) {
+	proxyURL, err := url.Parse(proxy.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy server url %s: %w", proxy.Endpoint, err)
+	}
+
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("%w: %s", errInvalidProxyEndpoint, proxy.Endpoint)
+	}
+
+	dialer := &proxyDialer{address: proxyURL.Host}
+
+	switch proxyURL.Scheme {
+	case "http":
+		if proxyURL.Port() == "" {
+			dialer.address = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	case "https":
+		if proxyURL.Port() == "" {
+			dialer.address = net.JoinHostPort(proxyURL.Hostname(), "443")
+		}
+
+		dialer.tlsConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: proxyURL.Hostname(),
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedProxyScheme, proxy.Endpoint)
+	}
+
+	username, password := proxy.Username, proxy.Password
+	if username == "" && proxyURL.User != nil {
+		username = proxyURL.User.Username()
+		password, _ = proxyURL.User.Password()
+	}
+
+	if username != "" {
+		dialer.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+
+	return dialer, nil
+}
+
+// dial connects to the proxy and asks it to connect to addr.
+func (d *proxyDialer) dial(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.address)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.tlsConfig != nil {
+		tlsConn := tls.Client(conn, d.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+
+			return nil, fmt.Errorf("connecting to proxy using tls: %w", err)
+		}
+
+		conn = tlsConn
+	}
+
+	if err := d.connect(ctx, conn, addr); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (d *proxyDialer) connect(ctx context.Context, conn net.Conn, addr string) error {
+	req := (&http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}).WithContext(ctx)
+
+	if d.authorization != "" {
+		req.Header.Set("Proxy-Authorization", d.authorization)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("writing connect request: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("reading connect response: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", errProxyConnectFailed, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package flintlock_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+// startConnectProxy starts a proxy that records the CONNECT requests it receives and rejects them.
+func startConnectProxy(g *WithT) (string, <-chan *http.Request) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	requests := make(chan *http.Request, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err == nil {
+				requests <- req
+				_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			}
+
+			conn.Close()
+		}
+	}()
+
+	return "http://" + listener.Addr().String(), requests
+}
+
+func TestClientConnectsThroughProxy(t *testing.T) {
+	tt := []struct {
+		name          string
+		proxy         func(endpoint string) *flclient.Proxy
+		authorization string
+	}{
+		{
+			name: "without credentials",
+			proxy: func(endpoint string) *flclient.Proxy {
+				return &flclient.Proxy{Endpoint: endpoint}
+			},
+		},
+		{
+			name: "with credentials",
+			proxy: func(endpoint string) *flclient.Proxy {
+				return &flclient.Proxy{Endpoint: endpoint, Username: "user", Password: "pass"}
+			},
+			authorization: "Basic dXNlcjpwYXNz",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			endpoint, requests := startConnectProxy(g)
+
+			client, err := flclient.NewClient("10.0.0.1:9090", flclient.WithProxy(tc.proxy(endpoint)))
+			g.Expect(err).NotTo(HaveOccurred())
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			_, err = client.GetMicroVM(ctx, &flintlockv1.GetMicroVMRequest{Uid: "1234"})
+			g.Expect(err).To(HaveOccurred())
+
+			var req *http.Request
+			g.Eventually(requests).Should(Receive(&req))
+			g.Expect(req.Method).To(Equal(http.MethodConnect))
+			g.Expect(req.Host).To(Equal("10.0.0.1:9090"))
+			g.Expect(req.Header.Get("Proxy-Authorization")).To(Equal(tc.authorization))
+		})
+	}
+}
+
+func TestClientConnectsToHTTPSProxyUsingTLS(t *testing.T) {
+	g := NewWithT(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer listener.Close()
+
+	records := make(chan byte, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			record := make([]byte, 1)
+			if _, err := conn.Read(record); err == nil {
+				records <- record[0]
+			}
+
+			conn.Close()
+		}
+	}()
+
+	proxy := &flclient.Proxy{Endpoint: "https://" + listener.Addr().String()}
+
+	client, err := flclient.NewClient("10.0.0.1:9090", flclient.WithProxy(proxy))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err = client.GetMicroVM(ctx, &flintlockv1.GetMicroVMRequest{Uid: "1234"})
+	g.Expect(err).To(HaveOccurred())
+
+	// A TLS connection starts with a handshake record instead of the CONNECT request.
+	var record byte
+	g.Eventually(records).Should(Receive(&record))
+	g.Expect(record).To(Equal(byte(0x16)))
+}
+
+func TestClientRejectsUnsupportedProxyScheme(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := flclient.NewClient("10.0.0.1:9090", flclient.WithProxy(&flclient.Proxy{Endpoint: "socks5://127.0.0.1:1080"}))
+	g.Expect(err).To(MatchError(ContainSubstring("must be an http or https url")))
+}
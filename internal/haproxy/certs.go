@@ -0,0 +1,177 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package haproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	// CertificateValidity is how long the runtime API certificates are valid for. They can't be
+	// changed on a running load balancer, so they last as long as the load balancer is expected to.
+	CertificateValidity = 10 * 365 * 24 * time.Hour
+
+	serialNumberBits = 128
+)
+
+var errInvalidCACert = errors.New("no certificates found in ca certificate")
+
+// Credentials are the PEM encoded certificates that secure the runtime API. HAProxy verifies that
+// clients have a certificate signed by the CA, and the controller verifies the certificate of
+// HAProxy using the same CA.
+type Credentials struct {
+	// CACert is the CA that signs the server and client certificates.
+	CACert []byte
+	// ServerCert is the certificate and key used by HAProxy.
+	ServerCert []byte
+	// ClientCert is the certificate used by the controller.
+	ClientCert []byte
+	// ClientKey is the key of the client certificate.
+	ClientKey []byte
+}
+
+// NewCredentials creates a CA and the server and client certificates it signs. The server
+// certificate is valid for host, which is the address the runtime API is connected to.
+func NewCredentials(name, host string, now time.Time) (*Credentials, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating ca key: %w", err)
+	}
+
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name + "-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(CertificateValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, caCert, err := createCertificate(caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	serverTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		NotBefore:   caTemplate.NotBefore,
+		NotAfter:    caTemplate.NotAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		serverTemplate.IPAddresses = []net.IP{ip}
+	} else {
+		serverTemplate.DNSNames = []string{host}
+	}
+
+	serverCert, serverKey, err := newSignedCertificate(serverTemplate, caCert, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name + "-client"},
+		NotBefore:   caTemplate.NotBefore,
+		NotAfter:    caTemplate.NotAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	clientCert, clientKey, err := newSignedCertificate(clientTemplate, caCert, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{
+		CACert:     encodeCertificate(caDER),
+		ServerCert: append(serverCert, serverKey...),
+		ClientCert: clientCert,
+		ClientKey:  clientKey,
+	}, nil
+}
+
+// ClientTLSConfig returns the TLS configuration used by the controller to connect to the runtime
+// API at host.
+func (c *Credentials) ClientTLSConfig(host string) (*tls.Config, error) {
+	certificate, err := tls.X509KeyPair(c.ClientCert, c.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading runtime api client certificate: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(c.CACert) {
+		return nil, errInvalidCACert
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      roots,
+		ServerName:   host,
+	}, nil
+}
+
+func newSignedCertificate(
+	template, ca *x509.Certificate,
+	caKey *ecdsa.PrivateKey,
+) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	der, _, err := createCertificate(template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshalling key: %w", err)
+	}
+
+	return encodeCertificate(der), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+func createCertificate(
+	template, parent *x509.Certificate,
+	pub *ecdsa.PublicKey,
+	signer *ecdsa.PrivateKey,
+) ([]byte, *x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialNumberBits))
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating serial number: %w", err)
+	}
+
+	template.SerialNumber = serial
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating certificate %s: %w", template.Subject.CommonName, err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing certificate %s: %w", template.Subject.CommonName, err)
+	}
+
+	return der, cert, nil
+}
+
+func encodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,334 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package haproxy contains the client for the runtime API of the HAProxy instances that load balance
+// the control plane, which is used to change their backends without replacing the microvms.
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+
+	// adminMaintenanceMask is the mask of the admin state flags that put a server in maintenance.
+	// The flag set by the disabled keyword in the config only records where the maintenance came
+	// from, so it isn't included.
+	adminMaintenanceMask = 0x01 | 0x02 | 0x20 | 0x40
+)
+
+var (
+	errNoServerSlots    = errors.New("not enough server slots for the backends")
+	errInvalidState     = errors.New("invalid server state")
+	errServersNotSynced = errors.New("servers don't match the backends")
+)
+
+// Runtime sends commands to the runtime API of an HAProxy instance.
+type Runtime interface {
+	// Execute sends a single command and returns the response.
+	Execute(ctx context.Context, command string) (string, error)
+}
+
+// RuntimeFactoryFunc creates a client for the runtime API at address.
+type RuntimeFactoryFunc func(address string, tlsConfig *tls.Config) Runtime
+
+// NewRuntime creates a client for the runtime API at address that connects using TLS.
+func NewRuntime(address string, tlsConfig *tls.Config) Runtime {
+	return &runtimeClient{address: address, tlsConfig: tlsConfig}
+}
+
+type runtimeClient struct {
+	address   string
+	tlsConfig *tls.Config
+}
+
+// Execute sends a single command. HAProxy closes the connection once it has responded to a command
+// that isn't sent in interactive mode, so a connection is made for each command.
+func (r *runtimeClient) Execute(ctx context.Context, command string) (string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	dialer := &tls.Dialer{Config: r.tlsConfig}
+
+	conn, err := dialer.DialContext(ctx, "tcp", r.address)
+	if err != nil {
+		return "", fmt.Errorf("connecting to haproxy runtime api: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return "", fmt.Errorf("setting deadline: %w", err)
+		}
+	}
+
+	if _, err := io.WriteString(conn, command+"\n"); err != nil {
+		return "", fmt.Errorf("sending command to haproxy runtime api: %w", err)
+	}
+
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("reading response from haproxy runtime api: %w", err)
+	}
+
+	return string(resp), nil
+}
+
+// Server is the state of a server in a backend.
+type Server struct {
+	Name    string
+	Address string
+	Enabled bool
+}
+
+// Servers returns the servers of a backend, sorted by name.
+func Servers(ctx context.Context, r Runtime, backend string) ([]Server, error) {
+	resp, err := r.Execute(ctx, "show servers state "+backend)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseServersState(resp)
+}
+
+// SyncServers changes the servers of a backend so that the enabled servers are the backends. The
+// backend has a fixed number of servers, which are used as slots: backends that aren't in the
+// backend yet are added by changing the address of a disabled server and enabling it, and servers
+// that aren't in the backends are disabled. The new servers are enabled before the old servers are
+// disabled so that the backend is never empty. It returns true if any server was changed.
+func SyncServers(ctx context.Context, r Runtime, backend string, backends []string) (bool, error) {
+	servers, err := Servers(ctx, r, backend)
+	if err != nil {
+		return false, err
+	}
+
+	wanted := map[string]bool{}
+	for _, b := range backends {
+		wanted[b] = true
+	}
+
+	toEnable := map[string]string{}
+	toDisable := []string{}
+	free := []string{}
+
+	for _, server := range servers {
+		switch {
+		case server.Enabled && wanted[server.Address]:
+			delete(wanted, server.Address)
+		case server.Enabled:
+			toDisable = append(toDisable, server.Name)
+		case wanted[server.Address]:
+			// A disabled server that already has the address of a backend is enabled again.
+			toEnable[server.Name] = ""
+			delete(wanted, server.Address)
+		default:
+			free = append(free, server.Name)
+		}
+	}
+
+	missing := make([]string, 0, len(wanted))
+	for b := range wanted {
+		missing = append(missing, b)
+	}
+
+	sort.Strings(missing)
+
+	// Servers that are being disabled are only reused once the free servers have been used up.
+	if len(missing) > len(free)+len(toDisable) {
+		return false, fmt.Errorf("%w: %d backends, %d servers", errNoServerSlots, len(backends), len(servers))
+	}
+
+	for i, b := range missing {
+		if i < len(free) {
+			toEnable[free[i]] = b
+
+			continue
+		}
+
+		name := toDisable[0]
+		toDisable = toDisable[1:]
+		toEnable[name] = b
+	}
+
+	names := make([]string, 0, len(toEnable))
+	for name := range toEnable {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if addr := toEnable[name]; addr != "" {
+			if err := setServerAddress(ctx, r, backend, name, addr); err != nil {
+				return false, err
+			}
+		}
+
+		if err := execute(ctx, r, fmt.Sprintf("set server %s/%s state ready", backend, name)); err != nil {
+			return false, err
+		}
+	}
+
+	for _, name := range toDisable {
+		if err := execute(ctx, r, fmt.Sprintf("set server %s/%s state maint", backend, name)); err != nil {
+			return false, err
+		}
+	}
+
+	if len(toEnable) == 0 && len(toDisable) == 0 {
+		return false, nil
+	}
+
+	// The responses to the set commands aren't consistent between HAProxy versions, so the servers
+	// are read again to check that they were changed.
+	if err := checkServers(ctx, r, backend, backends); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+func setServerAddress(ctx context.Context, r Runtime, backend, name, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("parsing backend %s: %w", addr, err)
+	}
+
+	return execute(ctx, r, fmt.Sprintf("set server %s/%s addr %s port %s", backend, name, host, port))
+}
+
+func execute(ctx context.Context, r Runtime, command string) error {
+	if _, err := r.Execute(ctx, command); err != nil {
+		return fmt.Errorf("executing %q: %w", command, err)
+	}
+
+	return nil
+}
+
+func checkServers(ctx context.Context, r Runtime, backend string, backends []string) error {
+	servers, err := Servers(ctx, r, backend)
+	if err != nil {
+		return err
+	}
+
+	enabled := []string{}
+
+	for _, server := range servers {
+		if server.Enabled {
+			enabled = append(enabled, server.Address)
+		}
+	}
+
+	want := append([]string{}, backends...)
+
+	sort.Strings(enabled)
+	sort.Strings(want)
+
+	if strings.Join(enabled, ",") != strings.Join(want, ",") {
+		return fmt.Errorf("%w: enabled servers are %v", errServersNotSynced, enabled)
+	}
+
+	return nil
+}
+
+// parseServersState parses the response to the show servers state command. The first line is the
+// version of the format and the second lists the names of the fields, which are used to find the
+// fields as their order differs between HAProxy versions.
+func parseServersState(resp string) ([]Server, error) {
+	scanner := bufio.NewScanner(strings.NewReader(resp))
+
+	var fields map[string]int
+
+	servers := []Server{}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#"):
+			fields = map[string]int{}
+			for i, name := range strings.Fields(strings.TrimPrefix(line, "#")) {
+				fields[name] = i
+			}
+
+			continue
+		case fields == nil:
+			// The version of the format.
+			continue
+		}
+
+		server, err := parseServer(strings.Fields(line), fields)
+		if err != nil {
+			return nil, err
+		}
+
+		servers = append(servers, server)
+	}
+
+	if fields == nil {
+		return nil, fmt.Errorf("%w: %q", errInvalidState, strings.TrimSpace(resp))
+	}
+
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+
+	return servers, nil
+}
+
+func parseServer(values []string, fields map[string]int) (Server, error) {
+	value := func(name string) (string, error) {
+		i, ok := fields[name]
+		if !ok || i >= len(values) {
+			return "", fmt.Errorf("%w: missing %s", errInvalidState, name)
+		}
+
+		return values[i], nil
+	}
+
+	name, err := value("srv_name")
+	if err != nil {
+		return Server{}, err
+	}
+
+	addr, err := value("srv_addr")
+	if err != nil {
+		return Server{}, err
+	}
+
+	port, err := value("srv_port")
+	if err != nil {
+		return Server{}, err
+	}
+
+	adminState, err := value("srv_admin_state")
+	if err != nil {
+		return Server{}, err
+	}
+
+	admin, err := strconv.Atoi(adminState)
+	if err != nil {
+		return Server{}, fmt.Errorf("%w: admin state %q of %s", errInvalidState, adminState, name)
+	}
+
+	return Server{
+		Name:    name,
+		Address: net.JoinHostPort(addr, port),
+		Enabled: admin&adminMaintenanceMask == 0,
+	}, nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package haproxy_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/haproxy"
+)
+
+// serversState is the response of HAProxy 2.8 to show servers state, with a server that was
+// disabled in the config, one that was enabled using the runtime API and one in maintenance.
+const serversState = `1
+# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port srvrecord srv_use_ssl srv_check_port srv_check_addr srv_agent_addr srv_agent_port
+3 kube-apiserver 1 cp0 10.0.0.1 2 0 1 1 87 6 3 4 6 0 0 0 - 6443 - 0 0 - - 0
+3 kube-apiserver 2 cp1 10.0.0.2 2 4 1 1 12 6 3 4 6 0 0 0 - 6443 - 0 0 - - 0
+3 kube-apiserver 3 cp2 127.0.0.1 0 5 1 1 87 1 0 0 14 0 0 0 - 6443 - 0 0 - - 0
+3 kube-apiserver 4 cp3 10.0.0.3 0 1 1 1 3 1 0 0 14 0 0 0 - 6443 - 0 0 - - 0
+`
+
+// fakeRuntime keeps the state of the servers of the kube-apiserver backend.
+type fakeRuntime struct {
+	servers  []haproxy.Server
+	commands []string
+	// ignoreSets doesn't change the servers, as if the commands weren't understood.
+	ignoreSets bool
+}
+
+func newFakeRuntime(slots int, backends ...string) *fakeRuntime {
+	f := &fakeRuntime{}
+
+	for i := 0; i < slots; i++ {
+		server := haproxy.Server{Name: fmt.Sprintf("cp%d", i), Address: "127.0.0.1:6443"}
+		if i < len(backends) {
+			server.Address = backends[i]
+			server.Enabled = true
+		}
+
+		f.servers = append(f.servers, server)
+	}
+
+	return f
+}
+
+func (f *fakeRuntime) Execute(_ context.Context, command string) (string, error) {
+	f.commands = append(f.commands, command)
+
+	if command == "show servers state kube-apiserver" {
+		resp := "1\n# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_port\n"
+
+		for i, server := range f.servers {
+			host, port, _ := net.SplitHostPort(server.Address)
+
+			admin := 5
+			if server.Enabled {
+				admin = 0
+			}
+
+			resp += fmt.Sprintf("3 kube-apiserver %d %s %s 2 %d %s\n", i+1, server.Name, host, admin, port)
+		}
+
+		return resp, nil
+	}
+
+	args := strings.Fields(command)
+	if f.ignoreSets || len(args) < 5 {
+		return "Unknown command.\n", nil
+	}
+
+	for i := range f.servers {
+		server := &f.servers[i]
+		if "kube-apiserver/"+server.Name != args[2] {
+			continue
+		}
+
+		switch args[3] {
+		case "addr":
+			server.Address = net.JoinHostPort(args[4], args[6])
+		case "state":
+			server.Enabled = args[4] == "ready"
+		}
+	}
+
+	return "", nil
+}
+
+func (f *fakeRuntime) enabled() []string {
+	backends := []string{}
+
+	for _, server := range f.servers {
+		if server.Enabled {
+			backends = append(backends, server.Address)
+		}
+	}
+
+	return backends
+}
+
+func TestServers(t *testing.T) {
+	g := NewWithT(t)
+
+	runtime := &staticRuntime{resp: serversState}
+
+	servers, err := haproxy.Servers(context.TODO(), runtime, "kube-apiserver")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(servers).To(Equal([]haproxy.Server{
+		{Name: "cp0", Address: "10.0.0.1:6443", Enabled: true},
+		{Name: "cp1", Address: "10.0.0.2:6443", Enabled: true},
+		{Name: "cp2", Address: "127.0.0.1:6443", Enabled: false},
+		{Name: "cp3", Address: "10.0.0.3:6443", Enabled: false},
+	}))
+
+	runtime.resp = "Can't find backend.\n"
+
+	_, err = haproxy.Servers(context.TODO(), runtime, "kube-apiserver")
+	g.Expect(err).To(HaveOccurred(), "Expect an error if the backend doesn't exist")
+}
+
+func TestSyncServers(t *testing.T) {
+	g := NewWithT(t)
+
+	runtime := newFakeRuntime(4, "10.0.0.1:6443", "10.0.0.2:6443")
+
+	changed, err := haproxy.SyncServers(context.TODO(), runtime, "kube-apiserver", []string{"10.0.0.2:6443", "10.0.0.3:6443"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(changed).To(BeTrue())
+	g.Expect(runtime.enabled()).To(ConsistOf("10.0.0.2:6443", "10.0.0.3:6443"))
+	g.Expect(runtime.commands).To(Equal([]string{
+		"show servers state kube-apiserver",
+		"set server kube-apiserver/cp2 addr 10.0.0.3 port 6443",
+		"set server kube-apiserver/cp2 state ready",
+		"set server kube-apiserver/cp0 state maint",
+		"show servers state kube-apiserver",
+	}), "Expect the new server to be enabled before the old server is disabled")
+
+	runtime.commands = nil
+
+	changed, err = haproxy.SyncServers(context.TODO(), runtime, "kube-apiserver", []string{"10.0.0.2:6443", "10.0.0.3:6443"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(changed).To(BeFalse())
+	g.Expect(runtime.commands).To(HaveLen(1), "Expect only the state to be read when the servers are in sync")
+
+	runtime.commands = nil
+
+	changed, err = haproxy.SyncServers(context.TODO(), runtime, "kube-apiserver", []string{"10.0.0.1:6443", "10.0.0.2:6443"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(changed).To(BeTrue())
+	g.Expect(runtime.enabled()).To(ConsistOf("10.0.0.1:6443", "10.0.0.2:6443"))
+	g.Expect(runtime.commands).To(ContainElement("set server kube-apiserver/cp0 state ready"),
+		"Expect the disabled server with the address to be enabled again")
+	g.Expect(runtime.commands).NotTo(ContainElement(ContainSubstring("addr")))
+}
+
+func TestSyncServersReusesDisabledServers(t *testing.T) {
+	g := NewWithT(t)
+
+	runtime := newFakeRuntime(2, "10.0.0.1:6443", "10.0.0.2:6443")
+
+	changed, err := haproxy.SyncServers(context.TODO(), runtime, "kube-apiserver", []string{"10.0.0.2:6443", "10.0.0.3:6443"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(changed).To(BeTrue())
+	g.Expect(runtime.enabled()).To(ConsistOf("10.0.0.2:6443", "10.0.0.3:6443"),
+		"Expect the server of the old backend to be reused when there are no free servers")
+}
+
+func TestSyncServersErrors(t *testing.T) {
+	g := NewWithT(t)
+
+	runtime := newFakeRuntime(2, "10.0.0.1:6443")
+
+	_, err := haproxy.SyncServers(context.TODO(), runtime, "kube-apiserver", []string{"10.0.0.1:6443", "10.0.0.2:6443", "10.0.0.3:6443"})
+	g.Expect(err).To(MatchError(ContainSubstring("not enough server slots")))
+	g.Expect(runtime.commands).To(HaveLen(1), "Expect no servers to be changed")
+
+	runtime.ignoreSets = true
+
+	_, err = haproxy.SyncServers(context.TODO(), runtime, "kube-apiserver", []string{"10.0.0.2:6443"})
+	g.Expect(err).To(MatchError(ContainSubstring("servers don't match the backends")),
+		"Expect an error if the servers weren't changed")
+}
+
+func TestRuntimeTLS(t *testing.T) {
+	g := NewWithT(t)
+
+	creds, err := haproxy.NewCredentials("tenant1-lb-runtime", "127.0.0.1", time.Now())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	state := newFakeRuntime(2, "10.0.0.1:6443")
+	address := serveRuntime(t, g, creds, state)
+
+	tlsConfig, err := creds.ClientTLSConfig("127.0.0.1")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	changed, err := haproxy.SyncServers(context.TODO(), haproxy.NewRuntime(address, tlsConfig), "kube-apiserver", []string{"10.0.0.2:6443"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(changed).To(BeTrue())
+	g.Expect(state.enabled()).To(ConsistOf("10.0.0.2:6443"))
+
+	other, err := haproxy.NewCredentials("other-lb-runtime", "127.0.0.1", time.Now())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	otherConfig, err := other.ClientTLSConfig("127.0.0.1")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = haproxy.NewRuntime(address, otherConfig).Execute(context.TODO(), "show servers state kube-apiserver")
+	g.Expect(err).To(HaveOccurred(), "Expect the certificates from another CA to be rejected")
+}
+
+// serveRuntime serves the runtime API using the server certificate of the credentials. Like HAProxy
+// it requires a client certificate signed by the CA and closes the connection after each command.
+func serveRuntime(t *testing.T, g *WithT, creds *haproxy.Credentials, runtime haproxy.Runtime) string {
+	t.Helper()
+
+	certificate, err := tls.X509KeyPair(creds.ServerCert, creds.ServerCert)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	clientCAs := x509.NewCertPool()
+	g.Expect(clientCAs.AppendCertsFromPEM(creds.CACert)).To(BeTrue())
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			command, err := bufio.NewReader(conn).ReadString('\n')
+			if err == nil {
+				resp, _ := runtime.Execute(context.TODO(), strings.TrimSpace(command))
+				_, _ = conn.Write([]byte(resp))
+			}
+
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+type staticRuntime struct {
+	resp string
+}
+
+func (s *staticRuntime) Execute(_ context.Context, _ string) (string, error) {
+	return s.resp, nil
+}
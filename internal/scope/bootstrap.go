@@ -0,0 +1,126 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	bootstrapv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+// bootstrapData is the content of the bootstrap data secret created by the bootstrap provider.
+type bootstrapData struct {
+	value  []byte
+	format bootstrapv1.Format
+}
+
+// getBootstrapData reads the bootstrap data secret created by the bootstrap provider. The format
+// defaults to cloud-config if the secret doesn't specify one.
+func getBootstrapData(ctx context.Context, c client.Client, namespace string, secretName *string) (*bootstrapData, error) {
+	if secretName == nil {
+		return nil, errMissingBootstrapDataSecret
+	}
+
+	bootstrapSecret := &corev1.Secret{}
+	secretKey := types.NamespacedName{
+		Namespace: namespace,
+		Name:      *secretName,
+	}
+
+	if err := c.Get(ctx, secretKey, bootstrapSecret); err != nil {
+		return nil, fmt.Errorf("getting bootstrap secret %s: %w", secretKey, err)
+	}
+
+	value, ok := bootstrapSecret.Data["value"]
+	if !ok {
+		return nil, errMissingBootstrapSecretKey
+	}
+
+	format := bootstrapv1.Format(bootstrapSecret.Data["format"])
+	if format == "" {
+		format = bootstrapv1.CloudConfig
+	}
+
+	return &bootstrapData{value: value, format: format}, nil
+}
+
+// bootstrapDataOptions are the options used to build the user-data of a microvm.
+type bootstrapDataOptions struct {
+	// hostname and sshKeys are added to Ignition configs.
+	hostname string
+	sshKeys  []microvm.SSHPublicKey
+	// fragments are merged with cloud-init bootstrap data.
+	fragments []infrav1.UserDataFragment
+	// compress enables gzip compression of cloud-init bootstrap data.
+	compress bool
+}
+
+// getRawBootstrapData reads the bootstrap data secret created by the bootstrap provider
+// and returns its value base64 encoded, ready to be used as the microvm user-data. Cloud-init
+// gets the hostname and SSH keys from the vendor data but Ignition has no equivalent, so for
+// Ignition they are added to the config. Any user-data fragments are combined with cloud-init
+// bootstrap data in a MIME multi-part archive. Cloud-init user-data can optionally be gzip
+// compressed, in an application/x-gzip MIME part that cloud-init decompresses.
+func getRawBootstrapData(
+	ctx context.Context,
+	c client.Client,
+	namespace string,
+	secretName *string,
+	opts bootstrapDataOptions,
+) (string, error) {
+	data, err := getBootstrapData(ctx, c, namespace, secretName)
+	if err != nil {
+		return "", err
+	}
+
+	value := data.value
+
+	switch data.format {
+	case bootstrapv1.CloudConfig:
+		if len(opts.fragments) > 0 {
+			value, err = addUserDataFragments(ctx, c, namespace, value, opts.fragments)
+			if err != nil {
+				return "", fmt.Errorf("adding user data fragments: %w", err)
+			}
+		}
+
+		if opts.compress {
+			value, err = compressUserData(value)
+			if err != nil {
+				return "", err
+			}
+		}
+	case bootstrapv1.Ignition:
+		if len(opts.fragments) > 0 {
+			return "", errFragmentsNotSupported
+		}
+
+		value, err = addIgnitionMetadata(value, opts.hostname, opts.sshKeys)
+		if err != nil {
+			return "", fmt.Errorf("adding metadata to ignition config: %w", err)
+		}
+	default:
+		return "", fmt.Errorf("%w: %s", errUnsupportedBootstrapFormat, data.format)
+	}
+
+	return base64.StdEncoding.EncodeToString(value), nil
+}
+
+// usesIgnition returns true if the bootstrap data is an Ignition config.
+func usesIgnition(ctx context.Context, c client.Client, namespace string, secretName *string) (bool, error) {
+	data, err := getBootstrapData(ctx, c, namespace, secretName)
+	if err != nil {
+		return false, err
+	}
+
+	return data.format == bootstrapv1.Ignition, nil
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2/klogr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util/conditions"
@@ -71,6 +72,14 @@ func WithClusterControllerName(name string) ClusterScopeOption {
 	}
 }
 
+// WithClusterServiceAccount sets the service account of the controller, whose tokens are used to
+// authenticate with the hosts with the bearer token auth types.
+func WithClusterServiceAccount(serviceAccount types.NamespacedName) ClusterScopeOption {
+	return func(s *ClusterScope) {
+		s.serviceAccount = serviceAccount
+	}
+}
+
 // ClusterScope is the scope for reconciling a cluster.
 type ClusterScope struct {
 	logr.Logger
@@ -81,6 +90,7 @@ type ClusterScope struct {
 	client         client.Client
 	patchHelper    *patch.Helper
 	controllerName string
+	serviceAccount types.NamespacedName
 }
 
 // Name returns the name of the resource.
@@ -106,6 +116,8 @@ func (cs *ClusterScope) ControllerName() string {
 // Patch persists the resource and status.
 func (cs *ClusterScope) Patch() error {
 	applicableConditions := []clusterv1.ConditionType{
+		infrav1.ControlPlaneEndpointAllocatedCondition,
+		infrav1.CredentialsValidCondition,
 		infrav1.LoadBalancerAvailableCondition,
 	}
 
@@ -120,7 +132,11 @@ func (cs *ClusterScope) Patch() error {
 		cs.MvmCluster,
 		patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
 			clusterv1.ReadyCondition,
+			infrav1.ControlPlaneEndpointAllocatedCondition,
+			infrav1.CredentialsValidCondition,
+			infrav1.ClientCertificateNotExpiringCondition,
 			infrav1.LoadBalancerAvailableCondition,
+			infrav1.LoadBalancerBackendsSyncedCondition,
 		}})
 	if err != nil {
 		return fmt.Errorf("unable to patch cluster: %w", err)
@@ -134,6 +150,11 @@ func (cs *ClusterScope) Close() error {
 	return cs.Patch()
 }
 
+// ControlPlaneEndpointClaimName returns the name of the IPAddressClaim used for the control plane endpoint.
+func (cs *ClusterScope) ControlPlaneEndpointClaimName() string {
+	return cs.MvmCluster.Name + "-control-plane-endpoint"
+}
+
 // Placement is used to get the placement configuration for the cluster.
 func (cs *ClusterScope) Placement() infrav1.Placement {
 	return cs.MvmCluster.Spec.Placement
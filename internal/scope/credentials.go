@@ -0,0 +1,526 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/auth"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+const (
+	tlsCert = "tls.crt"
+	tlsKey  = "tls.key"
+	caCert  = "ca.crt"
+)
+
+// tokenCache is shared by the scopes so that bearer tokens are reused between reconciles.
+var tokenCache = auth.NewCache()
+
+// serviceAccountTokenLifetime is the requested lifetime of the tokens of the service account of
+// the controller.
+const serviceAccountTokenLifetime int64 = 3600
+
+// getHostAuth returns the client option that authenticates with the host at addr using the auth
+// type of the MvmCluster. serviceAccount is the service account of the controller, whose tokens
+// are used for the bearer token auth types.
+func getHostAuth(
+	ctx context.Context,
+	c client.Client,
+	log logr.Logger,
+	mvmCluster *infrav1.MicrovmCluster,
+	serviceAccount types.NamespacedName,
+	addr string,
+) (flclient.Options, error) {
+	authenticator, err := newAuthenticator(ctx, c, log, mvmCluster, serviceAccount, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return authenticator.ClientOption(ctx, addr)
+}
+
+// newAuthenticator creates the authenticator for the auth type of the MvmCluster.
+func newAuthenticator(
+	ctx context.Context,
+	c client.Client,
+	log logr.Logger,
+	mvmCluster *infrav1.MicrovmCluster,
+	serviceAccount types.NamespacedName,
+	addr string,
+) (auth.Authenticator, error) {
+	hostAuth := mvmCluster.Spec.Auth
+
+	switch hostAuth.AuthType() {
+	case infrav1.HostAuthBasic:
+		return auth.Basic(func(ctx context.Context, addr string) (string, error) {
+			return getBasicAuthToken(ctx, c, log, mvmCluster, addr)
+		}), nil
+	case infrav1.HostAuthServiceAccountToken:
+		source, err := serviceAccountTokenSource(c, serviceAccount, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return auth.Bearer(source), nil
+	case infrav1.HostAuthTokenExchange:
+		if hostAuth.TokenExchange == nil {
+			return nil, fmt.Errorf("%w: token exchange endpoint required", errInvalidHostAuth)
+		}
+
+		endpoint := hostAuth.TokenExchange.Endpoint
+
+		subject, err := serviceAccountTokenSource(c, serviceAccount, endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		return auth.Bearer(tokenCache.Source("exchange/"+endpoint+"/"+addr, &auth.ExchangeTokenSource{
+			Endpoint: endpoint,
+			Audience: addr,
+			Subject:  subject,
+		})), nil
+	case infrav1.HostAuthMTLS:
+		tlsConfig, err := getTLSConfig(ctx, c, log, mvmCluster, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if tlsConfig == nil {
+			return nil, fmt.Errorf("%w: tls is required for mtls auth", errInvalidHostAuth)
+		}
+
+		return auth.MTLS(), nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported auth type %s", errInvalidHostAuth, hostAuth.AuthType())
+}
+
+// serviceAccountTokenSource returns the cached source of the tokens of the service account of the
+// controller for the audience. The tokens are bound to the host, or token exchange endpoint, they
+// are sent to so that they can't be replayed anywhere else.
+func serviceAccountTokenSource(c client.Client, serviceAccount types.NamespacedName, audience string) (auth.TokenSource, error) {
+	if serviceAccount.Name == "" || serviceAccount.Namespace == "" {
+		return nil, fmt.Errorf("%w: the service account of the controller isn't configured", errInvalidHostAuth)
+	}
+
+	return tokenCache.Source("serviceaccount/"+serviceAccount.String()+"/"+audience, &auth.ServiceAccountTokenSource{
+		Client:            c,
+		ServiceAccount:    serviceAccount,
+		Audience:          audience,
+		ExpirationSeconds: serviceAccountTokenLifetime,
+	}), nil
+}
+
+// getBasicAuthToken will fetch the BasicAuthSecret on the MvmCluster, or the secret of its
+// identity, and return the token for the given host.
+func getBasicAuthToken(
+	ctx context.Context,
+	c client.Client,
+	log logr.Logger,
+	mvmCluster *infrav1.MicrovmCluster,
+	addr string,
+) (string, error) {
+	key, err := basicAuthSecretKey(ctx, c, mvmCluster)
+	if err != nil || key == nil {
+		return "", err
+	}
+
+	tokenSecret := &corev1.Secret{}
+	if err := c.Get(ctx, *key, tokenSecret); err != nil {
+		return "", err
+	}
+
+	host := strings.Split(addr, ":")[0]
+	// If it's not there, that's fine; we will log and return an empty string
+	token := string(tokenSecret.Data[host])
+
+	if token == "" {
+		log.Info(
+			"basicAuthToken for host not found in secret", "secret", tokenSecret.Name, "host", host,
+		)
+	}
+
+	return token, nil
+}
+
+// getTLSConfig will fetch the TLSSecretRef on the MvmCluster, or the secret of its identity,
+// and return the TLS config for the client. The TLS config of the host at addr overrides the
+// secret, the CA and the server name.
+func getTLSConfig(
+	ctx context.Context,
+	c client.Client,
+	log logr.Logger,
+	mvmCluster *infrav1.MicrovmCluster,
+	addr string,
+) (*flclient.TLSConfig, error) {
+	hostTLS := hostTLSConfig(mvmCluster, addr)
+
+	secretKey, err := tlsSecretKey(ctx, c, mvmCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if hostTLS.SecretRef != "" {
+		secretKey = &types.NamespacedName{Name: hostTLS.SecretRef, Namespace: mvmCluster.Namespace}
+	}
+
+	if secretKey == nil {
+		if hostTLS.CASecretRef != "" || hostTLS.ServerName != "" {
+			return nil, fmt.Errorf("%w: host %s", errHostTLSWithoutSecret, addr)
+		}
+
+		log.Info("no TLS configuration found. will create insecure connection")
+
+		return nil, nil
+	}
+
+	tlsSecret := &corev1.Secret{}
+	if err := c.Get(ctx, *secretKey, tlsSecret); err != nil {
+		return nil, err
+	}
+
+	certBytes, ok := tlsSecret.Data[tlsCert]
+	if !ok {
+		return nil, &tlsError{tlsCert}
+	}
+
+	keyBytes, ok := tlsSecret.Data[tlsKey]
+	if !ok {
+		return nil, &tlsError{tlsKey}
+	}
+
+	caSecret := tlsSecret
+
+	if hostTLS.CASecretRef != "" {
+		caSecret = &corev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Name: hostTLS.CASecretRef, Namespace: mvmCluster.Namespace}, caSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	caBytes, ok := caSecret.Data[caCert]
+	if !ok {
+		return nil, &tlsError{caCert}
+	}
+
+	return &flclient.TLSConfig{
+		Cert:       certBytes,
+		Key:        keyBytes,
+		CACert:     caBytes,
+		ServerName: hostTLS.ServerName,
+	}, nil
+}
+
+// hostTLSConfig returns the TLS overrides of the host in the static pool with the endpoint addr.
+// An empty config is returned if the host has no overrides.
+func hostTLSConfig(mvmCluster *infrav1.MicrovmCluster, addr string) infrav1.HostTLSConfig {
+	if mvmCluster.Spec.Placement.StaticPool == nil {
+		return infrav1.HostTLSConfig{}
+	}
+
+	for _, host := range mvmCluster.Spec.Placement.StaticPool.Hosts {
+		if host.Endpoint == addr && host.TLS != nil {
+			return *host.TLS
+		}
+	}
+
+	return infrav1.HostTLSConfig{}
+}
+
+// validateCredentials checks that the credentials for connecting to each host of the MvmCluster,
+// including the proxy credentials, can be loaded and that the TLS certificates are valid at now.
+func validateCredentials(
+	ctx context.Context,
+	c client.Client,
+	mvmCluster *infrav1.MicrovmCluster,
+	now time.Time,
+) error {
+	basicAuthKey, err := basicAuthSecretKey(ctx, c, mvmCluster)
+	if err != nil {
+		return err
+	}
+
+	if basicAuthKey != nil {
+		if err := c.Get(ctx, *basicAuthKey, &corev1.Secret{}); err != nil {
+			return fmt.Errorf("getting basic auth secret %s: %w", basicAuthKey, err)
+		}
+	}
+
+	for _, addr := range hostAddresses(mvmCluster) {
+		tlsConfig, err := getTLSConfig(ctx, c, logr.Discard(), mvmCluster, addr)
+		if err != nil {
+			return fmt.Errorf("getting tls config for host %s: %w", addr, err)
+		}
+
+		if _, err := getMicrovmProxy(ctx, c, mvmCluster, addr); err != nil {
+			return fmt.Errorf("getting proxy for host %s: %w", addr, err)
+		}
+
+		if tlsConfig == nil {
+			continue
+		}
+
+		if err := tlsConfig.Validate(now); err != nil {
+			return fmt.Errorf("validating tls config for host %s: %w", addr, err)
+		}
+	}
+
+	return nil
+}
+
+// clientCertificateExpiry returns when the first of the client certificates used to connect to the
+// hosts of the MvmCluster expires, or nil if TLS isn't used.
+func clientCertificateExpiry(
+	ctx context.Context,
+	c client.Client,
+	mvmCluster *infrav1.MicrovmCluster,
+) (*time.Time, error) {
+	var expiry *time.Time
+
+	for _, addr := range hostAddresses(mvmCluster) {
+		tlsConfig, err := getTLSConfig(ctx, c, logr.Discard(), mvmCluster, addr)
+		if err != nil {
+			return nil, fmt.Errorf("getting tls config for host %s: %w", addr, err)
+		}
+
+		if tlsConfig == nil {
+			continue
+		}
+
+		cert, err := tlsConfig.ClientCertificate()
+		if err != nil {
+			return nil, fmt.Errorf("getting client certificate for host %s: %w", addr, err)
+		}
+
+		if expiry == nil || cert.NotAfter.Before(*expiry) {
+			expiry = &cert.NotAfter
+		}
+	}
+
+	return expiry, nil
+}
+
+// CredentialSecrets returns the keys of the secrets that hold the credentials for connecting to the
+// hosts of the MvmCluster, including the secrets of its identity.
+func CredentialSecrets(
+	ctx context.Context,
+	c client.Client,
+	mvmCluster *infrav1.MicrovmCluster,
+) ([]types.NamespacedName, error) {
+	keys := []types.NamespacedName{}
+
+	basicAuthKey, err := basicAuthSecretKey(ctx, c, mvmCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsKey, err := tlsSecretKey(ctx, c, mvmCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range []*types.NamespacedName{basicAuthKey, tlsKey} {
+		if key != nil {
+			keys = append(keys, *key)
+		}
+	}
+
+	if proxy := mvmCluster.Spec.MicrovmProxy; proxy != nil && proxy.CredentialsSecretRef != "" {
+		keys = append(keys, types.NamespacedName{Name: proxy.CredentialsSecretRef, Namespace: mvmCluster.Namespace})
+	}
+
+	for _, addr := range hostAddresses(mvmCluster) {
+		hostTLS := hostTLSConfig(mvmCluster, addr)
+		names := []string{hostTLS.SecretRef, hostTLS.CASecretRef}
+
+		if proxy := hostProxy(mvmCluster, addr); proxy != nil {
+			names = append(names, proxy.CredentialsSecretRef)
+		}
+
+		for _, name := range names {
+			if name != "" {
+				keys = append(keys, types.NamespacedName{Name: name, Namespace: mvmCluster.Namespace})
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// credentialsVersion returns a hash of the resource versions of the credential secrets of the
+// MvmCluster. Missing secrets are included so that creating them changes the version.
+func credentialsVersion(ctx context.Context, c client.Client, mvmCluster *infrav1.MicrovmCluster) (string, error) {
+	keys, err := CredentialSecrets(ctx, c, mvmCluster)
+	if err != nil {
+		return "", err
+	}
+
+	versions := map[string]string{}
+
+	for _, key := range keys {
+		secret := &corev1.Secret{}
+
+		err := c.Get(ctx, key, secret)
+
+		switch {
+		case apierrors.IsNotFound(err):
+			versions[key.String()] = ""
+		case err != nil:
+			return "", fmt.Errorf("getting credentials secret %s: %w", key, err)
+		default:
+			versions[key.String()] = secret.ResourceVersion
+		}
+	}
+
+	return hashObject(versions)
+}
+
+// hostAddresses returns the endpoints of the hosts of the MvmCluster. A single empty address is
+// returned if there are no hosts so that the cluster wide credentials are still checked.
+func hostAddresses(mvmCluster *infrav1.MicrovmCluster) []string {
+	if mvmCluster.Spec.Placement.StaticPool == nil || len(mvmCluster.Spec.Placement.StaticPool.Hosts) == 0 {
+		return []string{""}
+	}
+
+	addrs := make([]string, 0, len(mvmCluster.Spec.Placement.StaticPool.Hosts))
+	for _, host := range mvmCluster.Spec.Placement.StaticPool.Hosts {
+		addrs = append(addrs, host.Endpoint)
+	}
+
+	return addrs
+}
+
+// basicAuthSecretKey returns the key of the secret with the basic auth tokens for the hosts or nil
+// if basic auth isn't used.
+func basicAuthSecretKey(
+	ctx context.Context,
+	c client.Client,
+	mvmCluster *infrav1.MicrovmCluster,
+) (*types.NamespacedName, error) {
+	if mvmCluster.Spec.Auth.AuthType() != infrav1.HostAuthBasic {
+		return nil, nil
+	}
+
+	if mvmCluster.Spec.IdentityRef != nil {
+		identity, err := getClusterIdentity(ctx, c, mvmCluster)
+		if err != nil {
+			return nil, err
+		}
+
+		return secretReferenceKey(identity.Spec.BasicAuthSecretRef), nil
+	}
+
+	placement := mvmCluster.Spec.Placement
+	if placement.StaticPool == nil || placement.StaticPool.BasicAuthSecret == "" {
+		return nil, nil
+	}
+
+	return &types.NamespacedName{Name: placement.StaticPool.BasicAuthSecret, Namespace: mvmCluster.Namespace}, nil
+}
+
+// tlsSecretKey returns the key of the secret with the TLS config for the hosts or nil if TLS isn't used.
+func tlsSecretKey(
+	ctx context.Context,
+	c client.Client,
+	mvmCluster *infrav1.MicrovmCluster,
+) (*types.NamespacedName, error) {
+	if mvmCluster.Spec.IdentityRef != nil {
+		identity, err := getClusterIdentity(ctx, c, mvmCluster)
+		if err != nil {
+			return nil, err
+		}
+
+		return secretReferenceKey(identity.Spec.TLSSecretRef), nil
+	}
+
+	if mvmCluster.Spec.TLSSecretRef == "" {
+		return nil, nil
+	}
+
+	return &types.NamespacedName{Name: mvmCluster.Spec.TLSSecretRef, Namespace: mvmCluster.Namespace}, nil
+}
+
+// getClusterIdentity returns the identity referenced by the MvmCluster if the namespace of the
+// cluster is allowed to use it.
+func getClusterIdentity(
+	ctx context.Context,
+	c client.Client,
+	mvmCluster *infrav1.MicrovmCluster,
+) (*infrav1.MicrovmClusterIdentity, error) {
+	identity := &infrav1.MicrovmClusterIdentity{}
+	if err := c.Get(ctx, client.ObjectKey{Name: mvmCluster.Spec.IdentityRef.Name}, identity); err != nil {
+		return nil, fmt.Errorf("getting cluster identity %s: %w", mvmCluster.Spec.IdentityRef.Name, err)
+	}
+
+	allowed, err := isNamespaceAllowed(ctx, c, identity.Spec.AllowedNamespaces, mvmCluster.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if !allowed {
+		return nil, fmt.Errorf("%w: identity %s can't be used in namespace %s", errIdentityNotAllowed, identity.Name, mvmCluster.Namespace)
+	}
+
+	return identity, nil
+}
+
+// isNamespaceAllowed returns true if the namespace is in the list of allowed namespaces or matches the
+// selector. No namespaces are allowed if allowed is nil and all are allowed if it's empty.
+func isNamespaceAllowed(
+	ctx context.Context,
+	c client.Client,
+	allowed *infrav1.AllowedNamespaces,
+	namespace string,
+) (bool, error) {
+	if allowed == nil {
+		return false, nil
+	}
+
+	if len(allowed.NamespaceList) == 0 && allowed.Selector == nil {
+		return true, nil
+	}
+
+	if slices.Contains(allowed.NamespaceList, namespace) {
+		return true, nil
+	}
+
+	if allowed.Selector == nil {
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(allowed.Selector)
+	if err != nil {
+		return false, fmt.Errorf("parsing allowed namespaces selector: %w", err)
+	}
+
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return false, fmt.Errorf("getting namespace %s: %w", namespace, err)
+	}
+
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+func secretReferenceKey(ref *corev1.SecretReference) *types.NamespacedName {
+	if ref == nil || ref.Name == "" {
+		return nil
+	}
+
+	return &types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}
+}
@@ -12,12 +12,27 @@ var (
 	errMachineRequired       = errors.New("machine required to create scope")
 	errMicrovmMachineRequied = errors.New("microvm machine required to create scope")
 
+	errMachinePoolRequired        = errors.New("machine pool required to create scope")
+	errMicrovmMachinePoolRequired = errors.New("microvm machine pool required to create scope")
+
 	errClientRequired = errors.New("controller-runtime client required to create scope")
 
 	errMissingBootstrapDataSecret = errors.New("missing bootstrap data secret")
 	errMissingBootstrapSecretKey  = errors.New("missing bootstrap secrey value key")
-
-	errFailureDomainNotFound = errors.New("no failure domains found on the cluster")
+	errUnsupportedBootstrapFormat = errors.New("unsupported bootstrap data format")
+	errMissingIgnitionVersion     = errors.New("ignition config has no version")
+	errFragmentsNotSupported      = errors.New("user data fragments are only supported with cloud-init bootstrap data")
+	errInvalidUserDataFragment    = errors.New("user data fragment must reference either a secret or a config map")
+	errMissingUserDataFragmentKey = errors.New("user data fragment key not found")
+
+	errFailureDomainNotFound    = errors.New("no failure domains found on the cluster")
+	errNoFailureDomainAvailable = errors.New("no failure domain of the cluster can be used for the machine")
+	errHostNotAllowed           = errors.New("machine can't be placed on the host")
+	errIdentityNotAllowed       = errors.New("namespace not allowed to use cluster identity")
+	errInvalidHostname          = errors.New("rendered hostname is not a valid hostname")
+	errHostTLSWithoutSecret     = errors.New("host tls config requires a tls secret on the host or the cluster")
+	errInvalidHostAuth          = errors.New("invalid host auth")
+	errMissingProxyCredentials  = errors.New("required key missing from proxy credentials secret")
 )
 
 type tlsError struct {
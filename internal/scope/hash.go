@@ -0,0 +1,24 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+)
+
+// hashObject returns a short hash of the JSON representation of the supplied object. It's
+// used to detect when the spec of a group of microvms has changed.
+func hashObject(obj interface{}) (string, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("marshalling object to hash: %w", err)
+	}
+
+	hasher := fnv.New32a()
+	hasher.Write(data) //nolint: errcheck // hash writes never fail
+
+	return fmt.Sprintf("%08x", hasher.Sum32()), nil
+}
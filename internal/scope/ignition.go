@@ -0,0 +1,112 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+)
+
+const (
+	ignitionHostnamePath = "/etc/hostname"
+	ignitionFileMode     = 0o644
+)
+
+// addIgnitionMetadata adds the hostname and SSH keys to an Ignition config. The config is handled
+// generically so that both the v2 configs produced by the kubeadm bootstrap provider and v3
+// configs are supported.
+func addIgnitionMetadata(config []byte, hostname string, sshKeys []microvm.SSHPublicKey) ([]byte, error) {
+	ignition := map[string]interface{}{}
+	if err := json.Unmarshal(config, &ignition); err != nil {
+		return nil, fmt.Errorf("unmarshalling ignition config: %w", err)
+	}
+
+	version := ""
+	if section, ok := ignition["ignition"].(map[string]interface{}); ok {
+		version, _ = section["version"].(string)
+	}
+
+	if version == "" {
+		return nil, errMissingIgnitionVersion
+	}
+
+	hostnameFile := map[string]interface{}{
+		"path": ignitionHostnamePath,
+		"mode": ignitionFileMode,
+		"contents": map[string]interface{}{
+			"source": "data:," + url.PathEscape(hostname),
+		},
+	}
+
+	if strings.HasPrefix(version, "2.") {
+		hostnameFile["filesystem"] = "root"
+	} else {
+		hostnameFile["overwrite"] = true
+	}
+
+	storage := ignitionSection(ignition, "storage")
+	storage["files"] = append(ignitionList(storage, "files"), hostnameFile)
+
+	if len(sshKeys) > 0 {
+		passwd := ignitionSection(ignition, "passwd")
+		passwd["users"] = addIgnitionSSHKeys(ignitionList(passwd, "users"), sshKeys)
+	}
+
+	updated, err := json.Marshal(ignition)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling ignition config: %w", err)
+	}
+
+	return updated, nil
+}
+
+// addIgnitionSSHKeys adds the SSH keys to the users, adding any users that don't exist yet.
+func addIgnitionSSHKeys(users []interface{}, sshKeys []microvm.SSHPublicKey) []interface{} {
+	for _, key := range sshKeys {
+		var user map[string]interface{}
+
+		for _, existing := range users {
+			if u, ok := existing.(map[string]interface{}); ok && u["name"] == key.User {
+				user = u
+
+				break
+			}
+		}
+
+		if user == nil {
+			user = map[string]interface{}{"name": key.User}
+			users = append(users, user)
+		}
+
+		authorizedKeys := ignitionList(user, "sshAuthorizedKeys")
+		for _, authorizedKey := range key.AuthorizedKeys {
+			authorizedKeys = append(authorizedKeys, authorizedKey)
+		}
+
+		user["sshAuthorizedKeys"] = authorizedKeys
+	}
+
+	return users
+}
+
+// ignitionSection returns the named section of the config, adding it if it doesn't exist.
+func ignitionSection(parent map[string]interface{}, name string) map[string]interface{} {
+	section, ok := parent[name].(map[string]interface{})
+	if !ok {
+		section = map[string]interface{}{}
+		parent[name] = section
+	}
+
+	return section
+}
+
+func ignitionList(parent map[string]interface{}, name string) []interface{} {
+	list, _ := parent[name].([]interface{})
+
+	return list
+}
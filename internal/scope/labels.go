@@ -0,0 +1,69 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+var invalidLabelValueChars = regexp.MustCompile(`[^-A-Za-z0-9_.]`)
+
+// TopologyLabels returns the labels for the Node of the machine that describe where its microvm is
+// placed. The host label identifies the host by its name, or its endpoint if it has no name, and
+// the zone is the zone of the host if it has one. No labels are returned until the microvm has
+// been created.
+func (m *MachineScope) TopologyLabels() (map[string]string, error) {
+	if m.GetProviderID() == "" {
+		return nil, nil
+	}
+
+	failureDomain, err := m.GetFailureDomain()
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{
+		infrav1.HostLabel: SanitizeLabelValue(failureDomain),
+	}
+
+	if m.MvmCluster.Spec.Placement.StaticPool == nil {
+		return labels, nil
+	}
+
+	for _, host := range m.MvmCluster.Spec.Placement.StaticPool.Hosts {
+		if host.Endpoint != failureDomain {
+			continue
+		}
+
+		if host.Name != "" {
+			labels[infrav1.HostLabel] = SanitizeLabelValue(host.Name)
+		}
+
+		if host.Zone != "" {
+			labels[corev1.LabelTopologyZone] = host.Zone
+		}
+	}
+
+	return labels, nil
+}
+
+// SanitizeLabelValue converts a value such as a host endpoint into a valid label value. Characters
+// that aren't allowed are replaced with dashes, for example 10.0.0.1:9090 becomes 10.0.0.1-9090.
+func SanitizeLabelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(value, "-")
+
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+
+	return strings.TrimFunc(value, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+}
@@ -0,0 +1,483 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/userdata"
+	"gopkg.in/yaml.v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/haproxy"
+)
+
+const (
+	// LoadBalancerLabel is the label added to the control plane load balancer microvms with
+	// the name of the cluster.
+	LoadBalancerLabel = "control-plane-load-balancer"
+
+	// LoadBalancerBackend is the name of the HAProxy backend that contains the API servers.
+	LoadBalancerBackend = "kube-apiserver"
+
+	// LoadBalancerServerSlots is the number of servers in the HAProxy backend. The servers that
+	// aren't used by an API server are disabled until the runtime API gives them an address.
+	LoadBalancerServerSlots = 16
+
+	defaultLoadBalancerReplicas = 1
+	defaultAPIServerPort        = 6443
+	defaultRuntimeAPIPort       = 9999
+
+	haproxyConfigPath     = "/etc/haproxy/haproxy.cfg"
+	runtimeCACertPath     = "/etc/haproxy/runtime-ca.crt"
+	runtimeServerCertPath = "/etc/haproxy/runtime.pem"
+	keepalivedConfigPath  = "/etc/keepalived/keepalived.conf"
+	interfacePlaceholder  = "__INTERFACE__"
+	cloudConfigHeader     = "#cloud-config\n"
+	maxVirtualRouterID    = 255
+	maxKeepalivedPriority = 150
+)
+
+var (
+	haproxyConfigTemplate = template.Must(template.New("haproxy").Parse(`global
+  log stdout format raw local0
+  maxconn 4096
+  stats socket ipv4@*:{{ .RuntimePort }} level admin ssl crt {{ .RuntimeCertPath }} ca-file {{ .RuntimeCACertPath }} verify required
+
+defaults
+  mode tcp
+  log global
+  option tcplog
+  timeout connect 5s
+  timeout client 30m
+  timeout server 30m
+
+frontend kube-apiserver
+  bind *:{{ .Port }}
+  default_backend kube-apiserver
+
+backend kube-apiserver
+  option httpchk GET /healthz
+  http-check expect status 200
+  balance roundrobin
+  default-server check check-ssl verify none inter 5s fall 3 rise 2
+{{- range .Servers }}
+  server {{ .Name }} {{ .Address }}{{ if not .Enabled }} disabled{{ end }}
+{{- end }}
+`))
+
+	keepalivedConfigTemplate = template.Must(template.New("keepalived").Parse(`vrrp_instance control_plane {
+  state BACKUP
+  interface {{ .Interface }}
+  virtual_router_id {{ .RouterID }}
+  priority {{ .Priority }}
+  nopreempt
+  advert_int 1
+  virtual_ipaddress {
+    {{ .Address }}
+  }
+}
+`))
+)
+
+// LoadBalancerEnabled returns true if the control plane load balancer is managed by the provider.
+func (cs *ClusterScope) LoadBalancerEnabled() bool {
+	return cs.MvmCluster.Spec.LoadBalancer != nil
+}
+
+// LoadBalancerReplicas returns the number of load balancer microvms the cluster should have.
+func (cs *ClusterScope) LoadBalancerReplicas() int32 {
+	if cs.MvmCluster.Spec.LoadBalancer.Replicas == 0 {
+		return defaultLoadBalancerReplicas
+	}
+
+	return cs.MvmCluster.Spec.LoadBalancer.Replicas
+}
+
+// LoadBalancerStatus returns the status of the load balancer, initialising it if required.
+func (cs *ClusterScope) LoadBalancerStatus() *infrav1.LoadBalancerStatus {
+	if cs.MvmCluster.Status.LoadBalancer == nil {
+		cs.MvmCluster.Status.LoadBalancer = &infrav1.LoadBalancerStatus{}
+	}
+
+	return cs.MvmCluster.Status.LoadBalancer
+}
+
+// LoadBalancerFailureDomains returns the sorted names of the failure domains that the load
+// balancer microvms can be created in. These are the failure domains that allow control
+// plane machines.
+func (cs *ClusterScope) LoadBalancerFailureDomains() []string {
+	names := []string{}
+
+	for name, fd := range cs.MvmCluster.Status.FailureDomains {
+		if fd.ControlPlane {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ControlPlaneEndpoint returns the endpoint for the control plane.
+func (cs *ClusterScope) ControlPlaneEndpoint() clusterv1.APIEndpoint {
+	if !cs.MvmCluster.Spec.ControlPlaneEndpoint.IsZero() {
+		return cs.MvmCluster.Spec.ControlPlaneEndpoint
+	}
+
+	return cs.Cluster.Spec.ControlPlaneEndpoint
+}
+
+// GetControlPlaneBackends returns the sorted addresses of the API servers of the control plane
+// machines of the cluster. Machines that don't have an address yet are ignored.
+func (cs *ClusterScope) GetControlPlaneBackends(ctx context.Context) ([]string, error) {
+	machines := &clusterv1.MachineList{}
+
+	err := cs.client.List(ctx, machines,
+		client.InNamespace(cs.Cluster.Namespace),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: cs.ClusterName()},
+		client.HasLabels{clusterv1.MachineControlPlaneLabel},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing control plane machines: %w", err)
+	}
+
+	port := strconv.Itoa(int(cs.apiServerPort()))
+	backends := []string{}
+
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		if !machine.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		address := machineAddress(machine)
+		if address == "" {
+			cs.V(4).Info("control plane machine has no address yet", "machine", machine.Name)
+
+			continue
+		}
+
+		backends = append(backends, net.JoinHostPort(address, port))
+	}
+
+	sort.Strings(backends)
+
+	return backends, nil
+}
+
+// LoadBalancerSpecHash returns a hash of everything that is used to create the load balancer
+// microvms. If this changes the existing load balancer microvms need to be replaced.
+//
+// The backends aren't part of the hash as they're changed on the running load balancer using the
+// HAProxy runtime API, which is secured by the certificates signed by the runtime CA.
+func (cs *ClusterScope) LoadBalancerSpecHash(runtime *haproxy.Credentials) (string, error) {
+	input := struct {
+		Spec          microvm.VMSpec         `json:"spec"`
+		SSHPublicKeys []microvm.SSHPublicKey `json:"sshPublicKeys,omitempty"`
+		Endpoint      clusterv1.APIEndpoint  `json:"endpoint"`
+		RuntimePort   int32                  `json:"runtimePort"`
+		RuntimeCACert []byte                 `json:"runtimeCACert"`
+	}{
+		Spec:          cs.MvmCluster.Spec.LoadBalancer.Microvm,
+		SSHPublicKeys: cs.MvmCluster.Spec.SSHPublicKeys,
+		Endpoint:      cs.ControlPlaneEndpoint(),
+		RuntimePort:   cs.runtimeAPIPort(),
+		RuntimeCACert: runtime.CACert,
+	}
+
+	return hashObject(input)
+}
+
+// LoadBalancerRuntimeSecretName returns the name of the secret that contains the certificates for
+// the HAProxy runtime API of the load balancer.
+func (cs *ClusterScope) LoadBalancerRuntimeSecretName() string {
+	return cs.MvmCluster.Name + "-lb-runtime"
+}
+
+// LoadBalancerRuntimeAddress returns the address of the HAProxy runtime API. It's reached using the
+// ControlPlaneEndpoint host address, so it's the runtime API of the load balancer microvm that
+// currently has the address.
+func (cs *ClusterScope) LoadBalancerRuntimeAddress() string {
+	return net.JoinHostPort(cs.ControlPlaneEndpoint().Host, strconv.Itoa(int(cs.runtimeAPIPort())))
+}
+
+// GetBasicAuthToken will fetch the BasicAuthSecret on the MvmCluster and
+// and return the token for the given host.
+func (cs *ClusterScope) GetBasicAuthToken(addr string) (string, error) {
+	return getBasicAuthToken(context.TODO(), cs.client, cs.Logger, cs.MvmCluster, addr)
+}
+
+// GetHostAuth returns the client option that authenticates with the host at addr using the auth
+// type configured on the MvmCluster.
+func (cs *ClusterScope) GetHostAuth(addr string) (flclient.Options, error) {
+	return getHostAuth(context.TODO(), cs.client, cs.Logger, cs.MvmCluster, cs.serviceAccount, addr)
+}
+
+// GetTLSConfig will fetch the TLSSecretRef on the MvmCluster, or the TLS overrides of the host at
+// addr, and return the TLS config for the client.
+func (cs *ClusterScope) GetTLSConfig(addr string) (*flclient.TLSConfig, error) {
+	return getTLSConfig(context.TODO(), cs.client, cs.Logger, cs.MvmCluster, addr)
+}
+
+// ValidateCredentials checks that the credentials for connecting to each of the hosts can be loaded
+// and that the TLS certificates are valid.
+func (cs *ClusterScope) ValidateCredentials() error {
+	return validateCredentials(context.TODO(), cs.client, cs.MvmCluster, time.Now())
+}
+
+// CredentialsVersion returns a hash of the resource versions of the secrets with the credentials for
+// connecting to the hosts, which changes when any of the secrets change.
+func (cs *ClusterScope) CredentialsVersion() (string, error) {
+	return credentialsVersion(context.TODO(), cs.client, cs.MvmCluster)
+}
+
+// ClientCertificateExpiry returns when the first of the client certificates used to connect to the
+// hosts expires, or nil if TLS isn't used.
+func (cs *ClusterScope) ClientCertificateExpiry() (*time.Time, error) {
+	return clientCertificateExpiry(context.TODO(), cs.client, cs.MvmCluster)
+}
+
+// GetMicrovmProxy returns the proxy to use when calling the microvm service on the host at addr,
+// or nil if the host is connected to directly.
+func (cs *ClusterScope) GetMicrovmProxy(addr string) (*flclient.Proxy, error) {
+	return getMicrovmProxy(context.TODO(), cs.client, cs.MvmCluster, addr)
+}
+
+// LoadBalancerInstanceScope returns the scope used to create and manage a load balancer microvm
+// that initially forwards traffic to the supplied backends.
+func (cs *ClusterScope) LoadBalancerInstanceScope(
+	instance *infrav1.MicrovmInstance,
+	backends []string,
+	runtime *haproxy.Credentials,
+) *LoadBalancerInstanceScope {
+	return &LoadBalancerInstanceScope{
+		cluster:  cs,
+		instance: instance,
+		backends: backends,
+		runtime:  runtime,
+	}
+}
+
+func (cs *ClusterScope) apiServerPort() int32 {
+	if cs.MvmCluster.Spec.LoadBalancer.APIServerPort == 0 {
+		return defaultAPIServerPort
+	}
+
+	return cs.MvmCluster.Spec.LoadBalancer.APIServerPort
+}
+
+func (cs *ClusterScope) runtimeAPIPort() int32 {
+	if cs.MvmCluster.Spec.LoadBalancer.RuntimeAPIPort == 0 {
+		return defaultRuntimeAPIPort
+	}
+
+	return cs.MvmCluster.Spec.LoadBalancer.RuntimeAPIPort
+}
+
+// virtualRouterID returns the VRRP router id for the cluster. It only needs to be unique
+// amongst the clusters sharing a network so it's derived from the cluster name.
+func (cs *ClusterScope) virtualRouterID() uint32 {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(cs.Namespace() + "/" + cs.ClusterName())) //nolint: errcheck // hash writes never fail
+
+	return hasher.Sum32()%maxVirtualRouterID + 1
+}
+
+// LoadBalancerInstanceScope is the scope for a single control plane load balancer microvm. It
+// satisfies the scope required by the microvm service.
+type LoadBalancerInstanceScope struct {
+	cluster  *ClusterScope
+	instance *infrav1.MicrovmInstance
+	backends []string
+	runtime  *haproxy.Credentials
+}
+
+// Name returns the name of the microvm.
+func (l *LoadBalancerInstanceScope) Name() string {
+	return l.instance.Name
+}
+
+// Namespace returns the namespace of the microvm.
+func (l *LoadBalancerInstanceScope) Namespace() string {
+	return l.cluster.Namespace()
+}
+
+// GetMicrovmSpec returns the spec for the microvm with the mac addresses allocated to the instance.
+func (l *LoadBalancerInstanceScope) GetMicrovmSpec() microvm.VMSpec {
+	return withMACAddresses(l.cluster.MvmCluster.Spec.LoadBalancer.Microvm, l.instance.NetworkInterfaceMACs)
+}
+
+// GetMicrovmCreateSpec returns the flintlock spec used to create the microvm on the host with hostID.
+func (l *LoadBalancerInstanceScope) GetMicrovmCreateSpec(hostID string) (*flintlocktypes.MicroVMSpec, error) {
+	return newMicrovmCreateSpec(l, microvmOptions{hostID: hostID})
+}
+
+// GetInstanceID returns the UID of the microvm.
+func (l *LoadBalancerInstanceScope) GetInstanceID() string {
+	return l.instance.UID
+}
+
+// GetSSHPublicKeys returns the SSH public keys for the microvm.
+func (l *LoadBalancerInstanceScope) GetSSHPublicKeys() []microvm.SSHPublicKey {
+	return l.cluster.MvmCluster.Spec.SSHPublicKeys
+}
+
+// GetLabels returns any user defined or default labels for the microvm.
+func (l *LoadBalancerInstanceScope) GetLabels() map[string]string {
+	labels := map[string]string{}
+
+	for k, v := range l.cluster.MvmCluster.Spec.LoadBalancer.Microvm.Labels {
+		labels[k] = v
+	}
+
+	labels["cluster-name"] = l.cluster.ClusterName()
+	labels[LoadBalancerLabel] = l.cluster.ClusterName()
+
+	return labels
+}
+
+// GetRawBootstrapData returns the cloud-init user data that configures HAProxy and keepalived
+// on the microvm.
+func (l *LoadBalancerInstanceScope) GetRawBootstrapData() (string, error) {
+	endpoint := l.cluster.ControlPlaneEndpoint()
+
+	port := endpoint.Port
+	if port == 0 {
+		port = defaultAPIServerPort
+	}
+
+	haproxyConfig := &bytes.Buffer{}
+	if err := haproxyConfigTemplate.Execute(haproxyConfig, map[string]interface{}{
+		"Port":              port,
+		"RuntimePort":       l.cluster.runtimeAPIPort(),
+		"RuntimeCertPath":   runtimeServerCertPath,
+		"RuntimeCACertPath": runtimeCACertPath,
+		"Servers":           l.servers(),
+	}); err != nil {
+		return "", fmt.Errorf("rendering haproxy config: %w", err)
+	}
+
+	keepalivedConfig := &bytes.Buffer{}
+	if err := keepalivedConfigTemplate.Execute(keepalivedConfig, map[string]interface{}{
+		"Interface": interfacePlaceholder,
+		"RouterID":  l.cluster.virtualRouterID(),
+		"Priority":  l.priority(),
+		"Address":   endpoint.Host,
+	}); err != nil {
+		return "", fmt.Errorf("rendering keepalived config: %w", err)
+	}
+
+	userData := &userdata.UserData{
+		WriteFiles: []userdata.WriteFile{
+			{
+				Path:        haproxyConfigPath,
+				Content:     base64.StdEncoding.EncodeToString(haproxyConfig.Bytes()),
+				Encoding:    "b64",
+				Permissions: "0644",
+			},
+			{
+				Path:        runtimeCACertPath,
+				Content:     base64.StdEncoding.EncodeToString(l.runtime.CACert),
+				Encoding:    "b64",
+				Permissions: "0644",
+			},
+			{
+				Path:        runtimeServerCertPath,
+				Content:     base64.StdEncoding.EncodeToString(l.runtime.ServerCert),
+				Encoding:    "b64",
+				Permissions: "0600",
+			},
+			{
+				Path:        keepalivedConfigPath,
+				Content:     base64.StdEncoding.EncodeToString(keepalivedConfig.Bytes()),
+				Encoding:    "b64",
+				Permissions: "0644",
+			},
+		},
+		RunCommands: []string{
+			fmt.Sprintf(
+				`sed -i "s/%s/$(ip -4 route show default | awk '{print $5; exit}')/" %s`,
+				interfacePlaceholder,
+				keepalivedConfigPath,
+			),
+			"systemctl enable keepalived haproxy",
+			"systemctl restart keepalived haproxy",
+		},
+	}
+
+	data, err := yaml.Marshal(userData)
+	if err != nil {
+		return "", fmt.Errorf("marshalling load balancer user data: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(append([]byte(cloudConfigHeader), data...)), nil
+}
+
+// loadBalancerServer is a server in the HAProxy backend.
+type loadBalancerServer struct {
+	Name    string
+	Address string
+	Enabled bool
+}
+
+// servers returns the server slots of the HAProxy backend. The backends the microvm is created
+// with are enabled and the other slots are disabled until they're given an address using the
+// runtime API.
+func (l *LoadBalancerInstanceScope) servers() []loadBalancerServer {
+	placeholder := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(l.cluster.apiServerPort())))
+	servers := make([]loadBalancerServer, LoadBalancerServerSlots)
+
+	for i := range servers {
+		servers[i] = loadBalancerServer{Name: fmt.Sprintf("cp%d", i), Address: placeholder}
+
+		if i < len(l.backends) {
+			servers[i].Address = l.backends[i]
+			servers[i].Enabled = true
+		}
+	}
+
+	return servers
+}
+
+// priority returns the keepalived priority of the microvm. Each microvm has a different ordinal in
+// the group, so the replicas never have the same priority and the election of the microvm that
+// has the ControlPlaneEndpoint host address doesn't depend on the tie break.
+func (l *LoadBalancerInstanceScope) priority() int32 {
+	priority := maxKeepalivedPriority - l.instance.Ordinal
+	if priority < 1 {
+		return 1
+	}
+
+	return priority
+}
+
+// machineAddress returns the address of the machine that other machines can use to reach
+// it, preferring internal addresses.
+func machineAddress(machine *clusterv1.Machine) string {
+	for _, addrType := range []clusterv1.MachineAddressType{clusterv1.MachineInternalIP, clusterv1.MachineExternalIP} {
+		for _, addr := range machine.Status.Addresses {
+			if addr.Type == addrType && addr.Address != "" {
+				return addr.Address
+			}
+		}
+	}
+
+	return ""
+}
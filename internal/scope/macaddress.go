@@ -0,0 +1,79 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+const (
+	// defaultMACAddressPrefix is a locally administered unicast prefix.
+	defaultMACAddressPrefix = "02"
+
+	macAddressLength = 6
+)
+
+// MACAddressPrefix returns the prefix of the mac addresses to allocate for the microvms of the
+// cluster created on the host. The prefix for the host takes precedence over the prefix for the
+// cluster.
+func MACAddressPrefix(mvmCluster *infrav1.MicrovmCluster, failureDomain string) string {
+	if mvmCluster.Spec.Placement.StaticPool != nil {
+		for _, host := range mvmCluster.Spec.Placement.StaticPool.Hosts {
+			if host.Endpoint == failureDomain && host.MACAddressPrefix != "" {
+				return host.MACAddressPrefix
+			}
+		}
+	}
+
+	if mvmCluster.Spec.MACAddressPrefix != "" {
+		return mvmCluster.Spec.MACAddressPrefix
+	}
+
+	return defaultMACAddressPrefix
+}
+
+// MACAddressRegistryName returns the name of the ConfigMap that records the mac addresses allocated
+// to the microvms of the cluster.
+func MACAddressRegistryName(mvmCluster *infrav1.MicrovmCluster) string {
+	return mvmCluster.Name + "-mac-addresses"
+}
+
+// withMACAddresses returns the spec with the allocated mac addresses set on the network interfaces
+// that don't specify one.
+func withMACAddresses(spec microvm.VMSpec, macs map[string]string) microvm.VMSpec {
+	if len(macs) == 0 {
+		return spec
+	}
+
+	withMACs := spec.DeepCopy()
+
+	for i := range withMACs.NetworkInterfaces {
+		iface := &withMACs.NetworkInterfaces[i]
+
+		if mac, ok := macs[iface.GuestDeviceName]; ok && iface.GuestMAC == "" {
+			iface.GuestMAC = mac
+		}
+	}
+
+	return *withMACs
+}
+
+// randomMACAddress returns a random locally administered unicast mac address. It's used for the
+// network interfaces that weren't allocated a mac address.
+func randomMACAddress() (string, error) {
+	mac := make(net.HardwareAddr, macAddressLength)
+	if _, err := rand.Read(mac); err != nil {
+		return "", fmt.Errorf("generating mac address: %w", err)
+	}
+
+	mac[0] = mac[0]&^0x01 | 0x02
+
+	return mac.String(), nil
+}
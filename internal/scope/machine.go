@@ -5,18 +5,16 @@ package scope
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
-	"hash/crc32"
-	"sort"
+	"net"
 	"strings"
 
 	"github.com/go-logr/logr"
-	flclient "github.com/liquidmetal-dev/controller-pkg/client"
 	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
-	corev1 "k8s.io/api/core/v1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/ptr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/conditions"
@@ -25,16 +23,17 @@ import (
 
 	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/defaults"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
 )
 
 var _ Scoper = &MachineScope{}
 
-const ProviderPrefix = "microvm://"
-
 const (
-	tlsCert = "tls.crt"
-	tlsKey  = "tls.key"
-	caCert  = "ca.crt"
+	ProviderPrefix = "microvm://"
+
+	// MachineUIDLabel is the label added to the microvm of a machine with the uid of the
+	// MicrovmMachine, which identifies the microvm if its provider id wasn't recorded.
+	MachineUIDLabel = "microvmmachine-uid"
 )
 
 type MachineScopeParams struct {
@@ -107,6 +106,14 @@ func WithMachineControllerName(name string) MachineScopeOption {
 	}
 }
 
+// WithMachineServiceAccount sets the service account of the controller, whose tokens are used to
+// authenticate with the hosts with the bearer token auth types.
+func WithMachineServiceAccount(serviceAccount types.NamespacedName) MachineScopeOption {
+	return func(s *MachineScope) {
+		s.serviceAccount = serviceAccount
+	}
+}
+
 type MachineScope struct {
 	logr.Logger
 
@@ -119,7 +126,21 @@ type MachineScope struct {
 	client         client.Client
 	patchHelper    *patch.Helper
 	controllerName string
+	serviceAccount types.NamespacedName
 	ctx            context.Context
+
+	// interfaceAddresses are the addresses allocated from IP address pools keyed by guest device name.
+	interfaceAddresses map[string]InterfaceAddress
+}
+
+// InterfaceAddress is a static address allocated to a network interface from an IP address pool.
+type InterfaceAddress struct {
+	// Address is the address in CIDR notation.
+	Address string
+	// Gateway is the optional default gateway of the network.
+	Gateway string
+	// Nameservers are the optional nameservers to use with the address.
+	Nameservers []string
 }
 
 // Name returns the MicrovmMachine name.
@@ -173,53 +194,106 @@ func (m *MachineScope) Patch() error {
 	return nil
 }
 
-// GetMicrovmSpec returns the spec for the MicroVM.
+// GetMicrovmSpec returns the spec for the MicroVM. Any addresses allocated from IP address
+// pools and any allocated mac addresses are set on the network interfaces.
 func (m *MachineScope) GetMicrovmSpec() microvm.VMSpec {
-	return m.MvmMachine.Spec.VMSpec
+	if len(m.interfaceAddresses) == 0 && len(m.MvmMachine.Status.NetworkInterfaceMACs) == 0 {
+		return m.MvmMachine.Spec.VMSpec
+	}
+
+	spec := m.MvmMachine.Spec.VMSpec.DeepCopy()
+
+	for i := range spec.NetworkInterfaces {
+		iface := &spec.NetworkInterfaces[i]
+
+		if address, ok := m.interfaceAddresses[iface.GuestDeviceName]; ok {
+			iface.Address = address.Address
+		}
+	}
+
+	return withMACAddresses(*spec, m.MvmMachine.Status.NetworkInterfaceMACs)
+}
+
+// GetMicrovmCreateSpec returns the flintlock spec used to create the microvm on the host with
+// hostID. The gateway and nameservers of the addresses allocated from IP address pools are set
+// on the network interfaces, and the rendered hostname and instance metadata are used for the
+// cloud-init meta-data.
+func (m *MachineScope) GetMicrovmCreateSpec(hostID string) (*flintlocktypes.MicroVMSpec, error) {
+	hostname, err := m.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := m.InstanceMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	ignition, err := m.UsesIgnition()
+	if err != nil {
+		return nil, err
+	}
+
+	return newMicrovmCreateSpec(m, microvmOptions{
+		hostID:    hostID,
+		hostname:  hostname,
+		metadata:  metadata,
+		addresses: m.interfaceAddresses,
+		ignition:  ignition,
+	})
+}
+
+// InterfaceAddressClaimName returns the name of the IPAddressClaim for a network interface.
+func (m *MachineScope) InterfaceAddressClaimName(guestDeviceName string) string {
+	return fmt.Sprintf("%s-%s", m.Name(), guestDeviceName)
+}
+
+// SetInterfaceAddress sets the address that has been allocated to a network interface from an IP
+// address pool.
+func (m *MachineScope) SetInterfaceAddress(guestDeviceName string, address InterfaceAddress) {
+	if m.interfaceAddresses == nil {
+		m.interfaceAddresses = map[string]InterfaceAddress{}
+	}
+
+	m.interfaceAddresses[guestDeviceName] = address
 }
 
 // GetLabels returns any user defined or default labels for the microvm.
 func (m *MachineScope) GetLabels() map[string]string {
 	labels := map[string]string{}
 
-	if m.MvmMachine.Spec.VMSpec.Labels != nil {
-		labels = m.MvmMachine.Spec.VMSpec.Labels
+	for k, v := range m.MvmMachine.Spec.VMSpec.Labels {
+		labels[k] = v
 	}
 
 	labels["cluster-name"] = m.ClusterName()
+	labels[MachineUIDLabel] = string(m.MvmMachine.UID)
 
 	return labels
 }
 
+// GetFailureDomain returns the failure domain (i.e. host) of the microvm. The failure domain
+// recorded in the status is used once the host has been chosen. Until then the failure domain of
+// the Machine is used if it's set and the placement constraints allow it, otherwise a failure
+// domain that the machine can be placed on is chosen.
 func (m *MachineScope) GetFailureDomain() (string, error) {
-	if m.Machine.Spec.FailureDomain != nil && *m.Machine.Spec.FailureDomain != "" {
-		return *m.Machine.Spec.FailureDomain, nil
+	if m.MvmMachine.Status.FailureDomain != "" {
+		return m.MvmMachine.Status.FailureDomain, nil
 	}
 
+	requested := ptr.Deref(m.Machine.Spec.FailureDomain, "")
+
+	// The microvm has already been placed if it has a provider id.
 	providerID := m.GetProviderID()
 	if providerID != "" {
-		return m.getFailureDomainFromProviderID(providerID), nil
-	}
+		if requested != "" {
+			return requested, nil
+		}
 
-	// If we've got this far then we need to work out how to get a failure domain. In the future we will make
-	// the strategy configurable for static placement and also add support for the scheduler.
-	failureDomainNames := make([]string, 0, len(m.Cluster.Status.FailureDomains))
-	for fdName := range m.Cluster.Status.FailureDomains {
-		failureDomainNames = append(failureDomainNames, fdName)
-	}
-
-	if len(failureDomainNames) == 0 {
-		return "", errFailureDomainNotFound
-	}
-
-	if len(failureDomainNames) == 1 {
-		return failureDomainNames[0], nil
+		return m.getFailureDomainFromProviderID(providerID), nil
 	}
 
-	sort.Strings(failureDomainNames)
-	pos := int(crc32.ChecksumIEEE([]byte(m.MvmMachine.Name))) % len(failureDomainNames)
-
-	return failureDomainNames[pos], nil
+	return m.placeMachine(requested)
 }
 
 // GetRawBootstrapData will return the contents of the secret that has been created by the
@@ -227,26 +301,36 @@ func (m *MachineScope) GetFailureDomain() (string, error) {
 // be using the Kubeadm bootstrap provider and so this will contain cloud-init configuration
 // that will invoke kubeadm to create or join a cluster.
 func (m *MachineScope) GetRawBootstrapData() (string, error) {
-	if m.Machine.Spec.Bootstrap.DataSecretName == nil {
-		return "", errMissingBootstrapDataSecret
+	hostname, err := m.Hostname()
+	if err != nil {
+		return "", err
 	}
 
-	bootstrapSecret := &corev1.Secret{}
-	secretKey := types.NamespacedName{
-		Namespace: m.Namespace(),
-		Name:      *m.Machine.Spec.Bootstrap.DataSecretName,
-	}
+	return getRawBootstrapData(
+		m.ctx,
+		m.client,
+		m.Namespace(),
+		m.Machine.Spec.Bootstrap.DataSecretName,
+		bootstrapDataOptions{
+			hostname:  hostname,
+			sshKeys:   m.GetSSHPublicKeys(),
+			fragments: m.userDataFragments(),
+			compress:  m.MvmCluster.Spec.CompressUserData,
+		},
+	)
+}
 
-	if err := m.client.Get(m.ctx, secretKey, bootstrapSecret); err != nil {
-		return "", fmt.Errorf("getting bootstrap secret %s: %w", secretKey, err)
-	}
+// userDataFragments returns the user-data fragments for the cluster followed by those for the machine.
+func (m *MachineScope) userDataFragments() []infrav1.UserDataFragment {
+	fragments := make([]infrav1.UserDataFragment, 0, len(m.MvmCluster.Spec.UserDataFragments)+len(m.MvmMachine.Spec.UserDataFragments))
+	fragments = append(fragments, m.MvmCluster.Spec.UserDataFragments...)
 
-	bootstrapData, ok := bootstrapSecret.Data["value"]
-	if !ok {
-		return "", errMissingBootstrapSecretKey
-	}
+	return append(fragments, m.MvmMachine.Spec.UserDataFragments...)
+}
 
-	return base64.StdEncoding.EncodeToString(bootstrapData), nil
+// UsesIgnition returns true if the bootstrap data for the machine is an Ignition config.
+func (m *MachineScope) UsesIgnition() (bool, error) {
+	return usesIgnition(m.ctx, m.client, m.Namespace(), m.Machine.Spec.Bootstrap.DataSecretName)
 }
 
 // SetReady sets any properties/conditions that are used to indicate that the MicrovmMachine is 'Ready'
@@ -268,12 +352,49 @@ func (m *MachineScope) SetNotReady(
 	m.MvmMachine.Status.Ready = false
 }
 
+// SetFailureDomain records the failure domain (i.e. host) chosen for the microvm in the MvmMachine
+// status.
+func (m *MachineScope) SetFailureDomain(failureDomain string) {
+	m.MvmMachine.Status.FailureDomain = failureDomain
+}
+
 // SetProviderID saves the unique microvm and object ID to the MvmMachine spec.
 func (m *MachineScope) SetProviderID(failureDomain, mvmUID string) {
 	providerID := fmt.Sprintf("%s%s/%s", ProviderPrefix, failureDomain, mvmUID)
 	m.MvmMachine.Spec.ProviderID = &providerID
 }
 
+// SetAddresses records the addresses that are statically assigned to the network interfaces of the
+// microvm, including those allocated from IP address pools. Addresses assigned using DHCP are
+// unknown to the provider.
+func (m *MachineScope) SetAddresses() {
+	addresses := []clusterv1.MachineAddress{}
+
+	for _, iface := range m.GetMicrovmSpec().NetworkInterfaces {
+		if iface.Address == "" {
+			continue
+		}
+
+		ip, _, err := net.ParseCIDR(iface.Address)
+		if err != nil {
+			ip = net.ParseIP(iface.Address)
+		}
+
+		if ip == nil {
+			m.Info("ignoring invalid network interface address", "interface", iface.GuestDeviceName, "address", iface.Address)
+
+			continue
+		}
+
+		addresses = append(addresses, clusterv1.MachineAddress{
+			Type:    clusterv1.MachineInternalIP,
+			Address: ip.String(),
+		})
+	}
+
+	m.MvmMachine.Status.Addresses = addresses
+}
+
 // GetProviderID returns the provider if for the machine. If there is no provider id
 // then an empty string will be returned.
 func (m *MachineScope) GetProviderID() string {
@@ -312,75 +433,27 @@ func (m *MachineScope) GetSSHPublicKeys() []microvm.SSHPublicKey {
 // and return the token for the given host.
 // If no secret or no value is found, an empty string is returned.
 func (m *MachineScope) GetBasicAuthToken(addr string) (string, error) {
-	placement := m.MvmCluster.Spec.Placement
-	if placement.StaticPool == nil || placement.StaticPool.BasicAuthSecret == "" {
-		return "", nil
-	}
-
-	tokenSecret := &corev1.Secret{}
-	key := types.NamespacedName{
-		Name:      m.MvmCluster.Spec.Placement.StaticPool.BasicAuthSecret,
-		Namespace: m.MvmCluster.Namespace,
-	}
-
-	if err := m.client.Get(m.ctx, key, tokenSecret); err != nil {
-		return "", err
-	}
-
-	host := strings.Split(addr, ":")[0]
-	// If it's not there, that's fine; we will log and return an empty string
-	token := string(tokenSecret.Data[host])
-
-	if token == "" {
-		m.Info(
-			"basicAuthToken for host not found in secret", "secret", tokenSecret.Name, "host", host,
-		)
-	}
+	return getBasicAuthToken(m.ctx, m.client, m.Logger, m.MvmCluster, addr)
+}
 
-	return token, nil
+// GetHostAuth returns the client option that authenticates with the host at addr using the auth
+// type configured on the MvmCluster.
+func (m *MachineScope) GetHostAuth(addr string) (flclient.Options, error) {
+	return getHostAuth(m.ctx, m.client, m.Logger, m.MvmCluster, m.serviceAccount, addr)
 }
 
-// GetTLSConfig will fetch the TLSSecretRef and CASecretRef on the MvmCluster
-// and return the TLS config for the client.
+// GetTLSConfig will fetch the TLSSecretRef on the MvmCluster, or the TLS overrides
+// of the host at addr, and return the TLS config for the client.
 // If either are not set, it will be assumed that the hosts are not
 // configured will TLS and all client calls will be made without credentials.
-func (m *MachineScope) GetTLSConfig() (*flclient.TLSConfig, error) {
-	if m.MvmCluster.Spec.TLSSecretRef == "" {
-		m.Info("no TLS configuration found. will create insecure connection")
-
-		return nil, nil
-	}
-
-	secretKey := types.NamespacedName{
-		Name:      m.MvmCluster.Spec.TLSSecretRef,
-		Namespace: m.MvmCluster.Namespace,
-	}
-
-	tlsSecret := &corev1.Secret{}
-	if err := m.client.Get(context.TODO(), secretKey, tlsSecret); err != nil {
-		return nil, err
-	}
-
-	certBytes, ok := tlsSecret.Data[tlsCert]
-	if !ok {
-		return nil, &tlsError{tlsCert}
-	}
-
-	keyBytes, ok := tlsSecret.Data[tlsKey]
-	if !ok {
-		return nil, &tlsError{tlsKey}
-	}
-
-	caBytes, ok := tlsSecret.Data[caCert]
-	if !ok {
-		return nil, &tlsError{caCert}
-	}
+func (m *MachineScope) GetTLSConfig(addr string) (*flclient.TLSConfig, error) {
+	return getTLSConfig(m.ctx, m.client, m.Logger, m.MvmCluster, addr)
+}
 
-	return &flclient.TLSConfig{
-		Cert:   certBytes,
-		Key:    keyBytes,
-		CACert: caBytes,
-	}, nil
+// GetMicrovmProxy returns the proxy to use when calling the microvm service on the host at addr,
+// or nil if the host is connected to directly.
+func (m *MachineScope) GetMicrovmProxy(addr string) (*flclient.Proxy, error) {
+	return getMicrovmProxy(m.ctx, m.client, m.MvmCluster, addr)
 }
 
 func (m *MachineScope) getFailureDomainFromProviderID(providerID string) string {
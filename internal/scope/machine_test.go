@@ -1,23 +1,35 @@
 package scope_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
 	"testing"
 
 	. "github.com/onsi/gomega"
 
-	flclient "github.com/liquidmetal-dev/controller-pkg/client"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/pointer"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/yaml"
 
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
 	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
 )
 
@@ -201,11 +213,43 @@ func TestMachineGetTLSConfig(t *testing.T) {
 	}
 	otherTLSSecret := newSecret(tlsSecretName, badData)
 
+	hostTLSSecret := newSecret("hosttlssecret", map[string][]byte{
+		"tls.crt": []byte("hostcert"),
+		"tls.key": []byte("hostkey"),
+		"ca.crt":  []byte("hostca"),
+	})
+	hostCASecret := newSecret("hostcasecret", map[string][]byte{
+		"ca.crt": []byte("otherca"),
+	})
+
+	hostsCluster := newMicrovmClusterWithSpec(clusterName, v1alpha1.MicrovmClusterSpec{
+		TLSSecretRef: tlsSecretName,
+		Placement: infrav1.Placement{
+			StaticPool: &infrav1.StaticPoolPlacement{
+				Hosts: []infrav1.MicrovmHost{
+					{Endpoint: "10.0.0.1:9090", TLS: &infrav1.HostTLSConfig{SecretRef: "hosttlssecret"}},
+					{Endpoint: "10.0.0.2:9090", TLS: &infrav1.HostTLSConfig{CASecretRef: "hostcasecret", ServerName: "host2"}},
+					{Endpoint: "10.0.0.3:9090"},
+				},
+			},
+		},
+	})
+	hostsClusterNoTLS := newMicrovmClusterWithSpec(clusterName, v1alpha1.MicrovmClusterSpec{
+		Placement: infrav1.Placement{
+			StaticPool: &infrav1.StaticPoolPlacement{
+				Hosts: []infrav1.MicrovmHost{
+					{Endpoint: "10.0.0.1:9090", TLS: &infrav1.HostTLSConfig{ServerName: "host1"}},
+				},
+			},
+		},
+	})
+
 	tt := []struct {
 		name        string
 		expected    func(*flclient.TLSConfig, error)
 		initObjects []client.Object
 		cluster     *infrav1.MicrovmCluster
+		addr        string
 	}{
 		{
 			name: "returns the TLS config from the secret",
@@ -252,6 +296,63 @@ func TestMachineGetTLSConfig(t *testing.T) {
 				Expect(err).To(HaveOccurred())
 			},
 		},
+		{
+			name: "when the host has a TLS secret, returns the TLS config from the host secret",
+			initObjects: []client.Object{
+				hostsCluster, tlsSecret, hostTLSSecret,
+			},
+			cluster: hostsCluster,
+			addr:    "10.0.0.1:9090",
+			expected: func(cfg *flclient.TLSConfig, err error) {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg).ToNot(BeNil())
+				Expect(cfg.Cert).To(Equal([]byte("hostcert")))
+				Expect(cfg.Key).To(Equal([]byte("hostkey")))
+				Expect(cfg.CACert).To(Equal([]byte("hostca")))
+				Expect(cfg.ServerName).To(BeEmpty())
+			},
+		},
+		{
+			name: "when the host has a CA and server name, overrides the cluster TLS config",
+			initObjects: []client.Object{
+				hostsCluster, tlsSecret, hostCASecret,
+			},
+			cluster: hostsCluster,
+			addr:    "10.0.0.2:9090",
+			expected: func(cfg *flclient.TLSConfig, err error) {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg).ToNot(BeNil())
+				Expect(cfg.Cert).To(Equal([]byte("foo")))
+				Expect(cfg.Key).To(Equal([]byte("bar")))
+				Expect(cfg.CACert).To(Equal([]byte("otherca")))
+				Expect(cfg.ServerName).To(Equal("host2"))
+			},
+		},
+		{
+			name: "when the host has no TLS config, returns the TLS config of the cluster",
+			initObjects: []client.Object{
+				hostsCluster, tlsSecret,
+			},
+			cluster: hostsCluster,
+			addr:    "10.0.0.3:9090",
+			expected: func(cfg *flclient.TLSConfig, err error) {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg).ToNot(BeNil())
+				Expect(cfg.Cert).To(Equal([]byte("foo")))
+				Expect(cfg.CACert).To(Equal([]byte("baz")))
+			},
+		},
+		{
+			name: "when the host has a server name but there is no TLS secret, returns an error",
+			initObjects: []client.Object{
+				hostsClusterNoTLS,
+			},
+			cluster: hostsClusterNoTLS,
+			addr:    "10.0.0.1:9090",
+			expected: func(cfg *flclient.TLSConfig, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		},
 	}
 
 	for _, tc := range tt {
@@ -267,7 +368,297 @@ func TestMachineGetTLSConfig(t *testing.T) {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			tc.expected(machineScope.GetTLSConfig())
+			tc.expected(machineScope.GetTLSConfig(tc.addr))
+		})
+	}
+}
+
+func TestMachineCredentialsFromIdentity(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	hostName := "hostwiththemost"
+
+	mvmCluster := newMicrovmClusterWithSpec("testcluster", v1alpha1.MicrovmClusterSpec{
+		IdentityRef: &infrav1.MicrovmClusterIdentityReference{
+			Kind: infrav1.MicrovmClusterIdentityKind,
+			Name: "shared",
+		},
+	})
+
+	basicAuthSecret := newSecret("flintlock-tokens", map[string][]byte{hostName: []byte("foo")})
+	basicAuthSecret.Namespace = "platform"
+	tlsSecret := newSecret("flintlock-tls", map[string][]byte{
+		"tls.crt": []byte("cert"),
+		"tls.key": []byte("key"),
+		"ca.crt":  []byte("ca"),
+	})
+	tlsSecret.Namespace = "platform"
+
+	tenantNamespace := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"tenant": "true"}},
+	}
+
+	newIdentity := func(allowed *infrav1.AllowedNamespaces) *infrav1.MicrovmClusterIdentity {
+		return &infrav1.MicrovmClusterIdentity{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+			Spec: infrav1.MicrovmClusterIdentitySpec{
+				AllowedNamespaces:  allowed,
+				BasicAuthSecretRef: &v1.SecretReference{Name: "flintlock-tokens", Namespace: "platform"},
+				TLSSecretRef:       &v1.SecretReference{Name: "flintlock-tls", Namespace: "platform"},
+			},
+		}
+	}
+
+	tt := []struct {
+		name     string
+		identity *infrav1.MicrovmClusterIdentity
+		allowed  bool
+	}{
+		{
+			name:     "all namespaces are allowed",
+			identity: newIdentity(&infrav1.AllowedNamespaces{}),
+			allowed:  true,
+		},
+		{
+			name:     "namespace is in the list",
+			identity: newIdentity(&infrav1.AllowedNamespaces{NamespaceList: []string{"other", "default"}}),
+			allowed:  true,
+		},
+		{
+			name: "namespace matches the selector",
+			identity: newIdentity(&infrav1.AllowedNamespaces{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+			}),
+			allowed: true,
+		},
+		{
+			name:     "no namespaces are allowed",
+			identity: newIdentity(nil),
+		},
+		{
+			name:     "namespace isn't in the list",
+			identity: newIdentity(&infrav1.AllowedNamespaces{NamespaceList: []string{"other"}}),
+		},
+		{
+			name: "namespace doesn't match the selector",
+			identity: newIdentity(&infrav1.AllowedNamespaces{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "false"}},
+			}),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			client := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(mvmCluster, tc.identity, basicAuthSecret, tlsSecret, tenantNamespace).
+				Build()
+			machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+				Client:         client,
+				Cluster:        &clusterv1.Cluster{},
+				MicroVMCluster: mvmCluster,
+				Machine:        &clusterv1.Machine{},
+				MicroVMMachine: &infrav1.MicrovmMachine{},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			token, tokenErr := machineScope.GetBasicAuthToken(hostName)
+			tlsConfig, tlsErr := machineScope.GetTLSConfig(hostName)
+
+			if !tc.allowed {
+				Expect(tokenErr).To(HaveOccurred())
+				Expect(tlsErr).To(HaveOccurred())
+
+				return
+			}
+
+			Expect(tokenErr).NotTo(HaveOccurred())
+			Expect(token).To(Equal("foo"))
+			Expect(tlsErr).NotTo(HaveOccurred())
+			Expect(tlsConfig.Cert).To(Equal([]byte("cert")))
+			Expect(tlsConfig.CACert).To(Equal([]byte("ca")))
+		})
+	}
+}
+
+func TestMachineGetHostAuth(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	serviceAccount := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "capmvm-controller-manager", Namespace: "capmvm-system"},
+	}
+	tlsSecret := newSecret("tlssecret", map[string][]byte{
+		"tls.crt": []byte("cert"),
+		"tls.key": []byte("key"),
+		"ca.crt":  []byte("ca"),
+	})
+
+	tt := []struct {
+		name              string
+		spec              infrav1.MicrovmClusterSpec
+		serviceAccount    types.NamespacedName
+		expectedAudiences []string
+		expectedErr       bool
+	}{
+		{
+			name: "basic auth is used by default",
+		},
+		{
+			name: "service account token",
+			spec: infrav1.MicrovmClusterSpec{
+				Auth: &infrav1.HostAuth{Type: infrav1.HostAuthServiceAccountToken},
+			},
+			serviceAccount:    types.NamespacedName{Name: "capmvm-controller-manager", Namespace: "capmvm-system"},
+			expectedAudiences: []string{"127.0.0.1:9090"},
+		},
+		{
+			name: "service account token without the service account of the controller",
+			spec: infrav1.MicrovmClusterSpec{
+				Auth: &infrav1.HostAuth{Type: infrav1.HostAuthServiceAccountToken},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "service account token for a missing service account",
+			spec: infrav1.MicrovmClusterSpec{
+				Auth: &infrav1.HostAuth{Type: infrav1.HostAuthServiceAccountToken},
+			},
+			serviceAccount: types.NamespacedName{Name: "missing", Namespace: "capmvm-system"},
+			expectedErr:    true,
+		},
+		{
+			name: "mtls with tls",
+			spec: infrav1.MicrovmClusterSpec{
+				Auth:         &infrav1.HostAuth{Type: infrav1.HostAuthMTLS},
+				TLSSecretRef: "tlssecret",
+			},
+		},
+		{
+			name: "mtls without tls",
+			spec: infrav1.MicrovmClusterSpec{
+				Auth: &infrav1.HostAuth{Type: infrav1.HostAuthMTLS},
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			audiences := []string{}
+			mvmCluster := newMicrovmClusterWithSpec("testcluster", tc.spec)
+			client := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(mvmCluster, serviceAccount, tlsSecret).
+				WithInterceptorFuncs(interceptor.Funcs{
+					SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+						if request, ok := subResource.(*authenticationv1.TokenRequest); ok {
+							audiences = append(audiences, request.Spec.Audiences...)
+						}
+
+						return c.SubResource(subResourceName).Create(ctx, obj, subResource, opts...)
+					},
+				}).
+				Build()
+			machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+				Client:         client,
+				Cluster:        &clusterv1.Cluster{},
+				MicroVMCluster: mvmCluster,
+				Machine:        &clusterv1.Machine{},
+				MicroVMMachine: &infrav1.MicrovmMachine{},
+			}, scope.WithMachineServiceAccount(tc.serviceAccount))
+			Expect(err).NotTo(HaveOccurred())
+
+			option, err := machineScope.GetHostAuth("127.0.0.1:9090")
+			if tc.expectedErr {
+				Expect(err).To(HaveOccurred())
+
+				return
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(option).NotTo(BeNil())
+			Expect(audiences).To(ConsistOf(tc.expectedAudiences))
+		})
+	}
+}
+
+func TestMachineGetMicrovmProxy(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	credentials := newSecret("proxycreds", map[string][]byte{
+		"username": []byte("user"),
+		"password": []byte("pass"),
+	})
+
+	mvmCluster := newMicrovmClusterWithSpec("testcluster", v1alpha1.MicrovmClusterSpec{
+		MicrovmProxy: &infrav1.MicrovmProxy{
+			Endpoint: "http://proxy:3128",
+			NoProxy:  []string{"10.1.0.0/16", "10.2.0.1", "direct.example.com", ".internal", "10.3.0.1:9090"},
+		},
+		Placement: infrav1.Placement{
+			StaticPool: &infrav1.StaticPoolPlacement{
+				Hosts: []infrav1.MicrovmHost{
+					{Endpoint: "10.1.0.1:9090", Proxy: &infrav1.MicrovmProxy{Endpoint: "http://bastion:3128", CredentialsSecretRef: "proxycreds"}},
+					{Endpoint: "10.4.0.1:9090", Proxy: &infrav1.MicrovmProxy{Endpoint: "http://bastion:3128", CredentialsSecretRef: "missing"}},
+				},
+			},
+		},
+	})
+
+	tt := []struct {
+		addr        string
+		expected    *flclient.Proxy
+		expectedErr bool
+	}{
+		{addr: "10.0.0.1:9090", expected: &flclient.Proxy{Endpoint: "http://proxy:3128"}},
+		{addr: "10.1.0.2:9090"},
+		{addr: "10.2.0.1:9090"},
+		{addr: "direct.example.com:9090"},
+		{addr: "sub.direct.example.com:9090"},
+		{addr: "host.internal:9090"},
+		{addr: "10.3.0.1:9090"},
+		{addr: "10.3.0.1:9091", expected: &flclient.Proxy{Endpoint: "http://proxy:3128"}},
+		{addr: "notdirect.example.com:9090", expected: &flclient.Proxy{Endpoint: "http://proxy:3128"}},
+		{addr: "10.1.0.1:9090", expected: &flclient.Proxy{Endpoint: "http://bastion:3128", Username: "user", Password: "pass"}},
+		{addr: "10.4.0.1:9090", expectedErr: true},
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(mvmCluster, credentials).Build()
+	machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+		Client:         client,
+		Cluster:        &clusterv1.Cluster{},
+		MicroVMCluster: mvmCluster,
+		Machine:        &clusterv1.Machine{},
+		MicroVMMachine: &infrav1.MicrovmMachine{},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	for _, tc := range tt {
+		t.Run(tc.addr, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			proxy, err := machineScope.GetMicrovmProxy(tc.addr)
+			if tc.expectedErr {
+				Expect(err).To(HaveOccurred())
+
+				return
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proxy).To(Equal(tc.expected))
 		})
 	}
 }
@@ -387,6 +778,649 @@ func TestMachineFailureDomainFromProviderID(t *testing.T) {
 	Expect(failureDomain).To(Equal("fd2"))
 }
 
+func TestMachineFailureDomainFromStatus(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	clusterName := "testcluster"
+	cluster := newCluster(clusterName, []string{"fd1", "fd2"})
+	mvmCluster := newMicrovmCluster(clusterName)
+
+	machineName := "machine-1"
+	machine := newMachine(clusterName, machineName)
+	machine.Spec.FailureDomain = pointer.String("fd1")
+	mvmMachine := newMicrovmMachine(clusterName, machineName, "")
+	mvmMachine.Status.FailureDomain = "fd3"
+
+	initObjects := []client.Object{
+		cluster, mvmCluster, machine, mvmMachine,
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+	machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+		Client:         client,
+		Cluster:        cluster,
+		MicroVMCluster: mvmCluster,
+		Machine:        machine,
+		MicroVMMachine: mvmMachine,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	failureDomain, err := machineScope.GetFailureDomain()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(failureDomain).To(Equal("fd3"), "expected the failure domain recorded in the status to be used")
+}
+
+func TestMachinePlacementConstraints(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	clusterName := "testcluster"
+
+	// placed returns a machine of the cluster that has been placed on the host.
+	placed := func(name, host string, controlPlane bool) *infrav1.MicrovmMachine {
+		mvmMachine := newMicrovmMachine(clusterName, name, "")
+		mvmMachine.Status.FailureDomain = host
+
+		if controlPlane {
+			mvmMachine.Labels[clusterv1.MachineControlPlaneLabel] = ""
+		}
+
+		return mvmMachine
+	}
+
+	tt := []struct {
+		name         string
+		pool         infrav1.StaticPoolPlacement
+		placed       []*infrav1.MicrovmMachine
+		controlPlane bool
+		requested    string
+		expected     []string
+		expectedErr  bool
+	}{
+		{
+			name: "control plane on the host without a control plane machine",
+			pool: infrav1.StaticPoolPlacement{
+				MaxControlPlanePerHost: pointer.Int32(1),
+			},
+			placed:       []*infrav1.MicrovmMachine{placed("cp1", "h2", true), placed("worker1", "h3", false)},
+			controlPlane: true,
+			expected:     []string{"h1"},
+		},
+		{
+			name: "control plane on the selected host",
+			pool: infrav1.StaticPoolPlacement{
+				ControlPlaneHostSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "controlplane"}},
+			},
+			controlPlane: true,
+			expected:     []string{"h2"},
+		},
+		{
+			name:         "control plane on the host that allows control plane machines",
+			pool:         infrav1.StaticPoolPlacement{},
+			controlPlane: true,
+			expected:     []string{"h1", "h2"},
+		},
+		{
+			name: "control plane with every host at the maximum",
+			pool: infrav1.StaticPoolPlacement{
+				MaxControlPlanePerHost: pointer.Int32(1),
+			},
+			placed:       []*infrav1.MicrovmMachine{placed("cp1", "h1", true), placed("cp2", "h2", true)},
+			controlPlane: true,
+			expectedErr:  true,
+		},
+		{
+			name:         "requested host that doesn't allow control plane machines",
+			pool:         infrav1.StaticPoolPlacement{},
+			controlPlane: true,
+			requested:    "h3",
+			expectedErr:  true,
+		},
+		{
+			name: "worker kept off the control plane hosts",
+			pool: infrav1.StaticPoolPlacement{
+				SeparateWorkers: true,
+			},
+			expected: []string{"h3"},
+		},
+		{
+			name: "worker on a control plane host when the others are full",
+			pool: infrav1.StaticPoolPlacement{
+				SeparateWorkers: true,
+			},
+			placed:   []*infrav1.MicrovmMachine{placed("worker1", "h3", false)},
+			expected: []string{"h1", "h2"},
+		},
+		{
+			name:     "worker on the host that isn't full",
+			pool:     infrav1.StaticPoolPlacement{},
+			placed:   []*infrav1.MicrovmMachine{placed("worker1", "h1", false), placed("worker2", "h3", false)},
+			expected: []string{"h2"},
+		},
+		{
+			name:        "requested host that is full",
+			pool:        infrav1.StaticPoolPlacement{},
+			placed:      []*infrav1.MicrovmMachine{placed("worker1", "h3", false)},
+			requested:   "h3",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			pool := tc.pool
+			pool.Hosts = []infrav1.MicrovmHost{
+				{Endpoint: "h1", ControlPlaneAllowed: true, MaxMachines: pointer.Int32(1)},
+				{Endpoint: "h2", ControlPlaneAllowed: true, Labels: map[string]string{"role": "controlplane"}},
+				{Endpoint: "h3", MaxMachines: pointer.Int32(1)},
+			}
+
+			cluster := newCluster(clusterName, []string{"h1", "h2", "h3"})
+			mvmCluster := newMicrovmClusterWithSpec(clusterName, infrav1.MicrovmClusterSpec{
+				Placement: infrav1.Placement{StaticPool: &pool},
+			})
+
+			machine := newMachine(clusterName, "machine")
+			if tc.controlPlane {
+				machine.Labels[clusterv1.MachineControlPlaneLabel] = ""
+			}
+
+			if tc.requested != "" {
+				machine.Spec.FailureDomain = pointer.String(tc.requested)
+			}
+
+			mvmMachine := newMicrovmMachine(clusterName, "machine", "")
+
+			initObjects := []client.Object{cluster, mvmCluster, machine, mvmMachine}
+			for _, other := range tc.placed {
+				initObjects = append(initObjects, other)
+			}
+
+			client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+			machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+				Client:         client,
+				Cluster:        cluster,
+				MicroVMCluster: mvmCluster,
+				Machine:        machine,
+				MicroVMMachine: mvmMachine,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			failureDomain, err := machineScope.GetFailureDomain()
+			if tc.expectedErr {
+				Expect(err).To(HaveOccurred())
+
+				return
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tc.expected).To(ContainElement(failureDomain))
+		})
+	}
+}
+
+func TestMachinePlacementAntiAffinity(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	clusterName := "testcluster"
+
+	// placed returns a machine of the cluster with the label that has been placed on the host.
+	placed := func(name, host, key, value string) *infrav1.MicrovmMachine {
+		mvmMachine := newMicrovmMachine(clusterName, name, "")
+		mvmMachine.Labels[key] = value
+		mvmMachine.Status.FailureDomain = host
+
+		return mvmMachine
+	}
+
+	tt := []struct {
+		name         string
+		antiAffinity *infrav1.AntiAffinity
+		labels       map[string]string
+		placed       []*infrav1.MicrovmMachine
+		expected     []string
+	}{
+		{
+			name:         "host without a machine of the deployment",
+			antiAffinity: &infrav1.AntiAffinity{Type: infrav1.AntiAffinityMachineDeployment},
+			labels:       map[string]string{clusterv1.MachineDeploymentNameLabel: "md1"},
+			placed: []*infrav1.MicrovmMachine{
+				placed("md1-a", "h1", clusterv1.MachineDeploymentNameLabel, "md1"),
+				placed("md1-b", "h3", clusterv1.MachineDeploymentNameLabel, "md1"),
+				placed("md2-a", "h2", clusterv1.MachineDeploymentNameLabel, "md2"),
+			},
+			expected: []string{"h2"},
+		},
+		{
+			name:         "least used host when the deployment is on every host",
+			antiAffinity: &infrav1.AntiAffinity{Type: infrav1.AntiAffinityMachineDeployment},
+			labels:       map[string]string{clusterv1.MachineDeploymentNameLabel: "md1"},
+			placed: []*infrav1.MicrovmMachine{
+				placed("md1-a", "h1", clusterv1.MachineDeploymentNameLabel, "md1"),
+				placed("md1-b", "h2", clusterv1.MachineDeploymentNameLabel, "md1"),
+				placed("md1-c", "h3", clusterv1.MachineDeploymentNameLabel, "md1"),
+				placed("md2-a", "h1", clusterv1.MachineDeploymentNameLabel, "md2"),
+				placed("md2-b", "h3", clusterv1.MachineDeploymentNameLabel, "md2"),
+			},
+			expected: []string{"h2"},
+		},
+		{
+			name:         "host without a machine with the label value",
+			antiAffinity: &infrav1.AntiAffinity{Type: infrav1.AntiAffinityLabelKey, LabelKey: "example.com/group"},
+			labels:       map[string]string{"example.com/group": "a"},
+			placed: []*infrav1.MicrovmMachine{
+				placed("a1", "h1", "example.com/group", "a"),
+				placed("a2", "h2", "example.com/group", "a"),
+				placed("b1", "h3", "example.com/group", "b"),
+			},
+			expected: []string{"h3"},
+		},
+		{
+			name:   "any host without anti-affinity",
+			labels: map[string]string{clusterv1.MachineDeploymentNameLabel: "md1"},
+			placed: []*infrav1.MicrovmMachine{
+				placed("md1-a", "h1", clusterv1.MachineDeploymentNameLabel, "md1"),
+			},
+			expected: []string{"h1", "h2", "h3"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			cluster := newCluster(clusterName, []string{"h1", "h2", "h3"})
+			mvmCluster := newMicrovmClusterWithSpec(clusterName, infrav1.MicrovmClusterSpec{
+				Placement: infrav1.Placement{
+					StaticPool: &infrav1.StaticPoolPlacement{
+						Hosts: []infrav1.MicrovmHost{{Endpoint: "h1"}, {Endpoint: "h2"}, {Endpoint: "h3"}},
+					},
+				},
+			})
+
+			machine := newMachine(clusterName, "machine")
+			for key, value := range tc.labels {
+				machine.Labels[key] = value
+			}
+
+			mvmMachine := newMicrovmMachine(clusterName, "machine", "")
+			mvmMachine.Spec.AntiAffinity = tc.antiAffinity
+
+			initObjects := []client.Object{cluster, mvmCluster, machine, mvmMachine}
+			for _, other := range tc.placed {
+				initObjects = append(initObjects, other)
+			}
+
+			client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+			machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+				Client:         client,
+				Cluster:        cluster,
+				MicroVMCluster: mvmCluster,
+				Machine:        machine,
+				MicroVMMachine: mvmMachine,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			failureDomain, err := machineScope.GetFailureDomain()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tc.expected).To(ContainElement(failureDomain))
+		})
+	}
+}
+
+func TestMachineSetAddresses(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	clusterName := "testcluster"
+	cluster := newCluster(clusterName, []string{"fd1"})
+	mvmCluster := newMicrovmCluster(clusterName)
+
+	machineName := "machine-1"
+	machine := newMachine(clusterName, machineName)
+	mvmMachine := newMicrovmMachine(clusterName, machineName, "")
+	mvmMachine.Spec.NetworkInterfaces = []microvm.NetworkInterface{
+		{GuestDeviceName: "eth0"},
+		{GuestDeviceName: "eth1", Address: "10.0.0.5/24"},
+		{GuestDeviceName: "eth2", Address: "192.168.1.1"},
+	}
+
+	initObjects := []client.Object{
+		cluster, mvmCluster, machine, mvmMachine,
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+	machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+		Client:         client,
+		Cluster:        cluster,
+		MicroVMCluster: mvmCluster,
+		Machine:        machine,
+		MicroVMMachine: mvmMachine,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	machineScope.SetAddresses()
+
+	Expect(mvmMachine.Status.Addresses).To(Equal([]clusterv1.MachineAddress{
+		{Type: clusterv1.MachineInternalIP, Address: "10.0.0.5"},
+		{Type: clusterv1.MachineInternalIP, Address: "192.168.1.1"},
+	}))
+
+	machineScope.SetInterfaceAddress("eth0", scope.InterfaceAddress{Address: "10.0.1.7/24"})
+	machineScope.SetAddresses()
+
+	Expect(mvmMachine.Status.Addresses).To(Equal([]clusterv1.MachineAddress{
+		{Type: clusterv1.MachineInternalIP, Address: "10.0.1.7"},
+		{Type: clusterv1.MachineInternalIP, Address: "10.0.0.5"},
+		{Type: clusterv1.MachineInternalIP, Address: "192.168.1.1"},
+	}))
+	Expect(machineScope.GetMicrovmSpec().NetworkInterfaces[0].Address).To(Equal("10.0.1.7/24"))
+	Expect(mvmMachine.Spec.NetworkInterfaces[0].Address).To(BeEmpty())
+}
+
+func TestMachineGetMicrovmCreateSpec(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	clusterName := "testcluster"
+	cluster := newCluster(clusterName, []string{"fd1"})
+	mvmCluster := newMicrovmCluster(clusterName)
+	mvmCluster.Spec.HostnameTemplate = "{{ .ClusterName }}-{{ .MachineName }}"
+	mvmCluster.Spec.Metadata = map[string]string{"rack": "r1", "vm_host": "ignored"}
+
+	machineName := "machine-1"
+	machine := newMachine(clusterName, machineName)
+	mvmMachine := newMicrovmMachine(clusterName, machineName, "")
+	mvmMachine.Spec.NetworkInterfaces = []microvm.NetworkInterface{
+		{GuestDeviceName: "eth0", GuestMAC: "02:00:00:00:00:01"},
+		{GuestDeviceName: "eth1"},
+	}
+	mvmMachine.Status.FailureDomain = "fd1"
+
+	tt := []struct {
+		name     string
+		format   string
+		ignition bool
+	}{
+		{name: "cloud-config", format: "cloud-config"},
+		{name: "ignition", format: "ignition", ignition: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			bootstrapData := map[string][]byte{"value": []byte("#cloud-config"), "format": []byte(tc.format)}
+			if tc.ignition {
+				bootstrapData["value"] = []byte(`{"ignition":{"version":"3.3.0"}}`)
+			}
+
+			initObjects := []client.Object{
+				cluster, mvmCluster, machine, mvmMachine, newSecret(machineName, bootstrapData),
+			}
+
+			client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+			machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+				Client:         client,
+				Cluster:        cluster,
+				MicroVMCluster: mvmCluster,
+				Machine:        machine,
+				MicroVMMachine: mvmMachine,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			machineScope.SetInterfaceAddress("eth0", scope.InterfaceAddress{
+				Address:     "10.0.1.7/24",
+				Gateway:     "10.0.1.1",
+				Nameservers: []string{"1.1.1.1"},
+			})
+
+			spec, err := machineScope.GetMicrovmCreateSpec("fd1")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(spec.Id).To(Equal(machineName))
+			Expect(spec.Interfaces).To(HaveLen(2))
+			Expect(*spec.Interfaces[0].GuestMac).To(Equal("02:00:00:00:00:01"))
+			Expect(spec.Interfaces[0].Address.Address).To(Equal("10.0.1.7/24"))
+			Expect(*spec.Interfaces[0].Address.Gateway).To(Equal("10.0.1.1"))
+			Expect(spec.Interfaces[0].Address.Nameservers).To(Equal([]string{"1.1.1.1"}))
+			Expect(*spec.Interfaces[1].GuestMac).NotTo(BeEmpty(), "Expect a mac address to be generated")
+			Expect(spec.Interfaces[1].Address).To(BeNil())
+
+			metaData, err := base64.StdEncoding.DecodeString(spec.Metadata["meta-data"])
+			Expect(err).NotTo(HaveOccurred())
+
+			instanceData := map[string]string{}
+			Expect(yaml.Unmarshal(metaData, &instanceData)).To(Succeed())
+			Expect(instanceData).To(HaveKeyWithValue("local_hostname", "testcluster-machine-1"))
+			Expect(instanceData).To(HaveKeyWithValue("vm_host", "fd1"), "Expect the metadata to not replace the provider keys")
+			Expect(instanceData).To(HaveKeyWithValue("rack", "r1"))
+
+			if tc.ignition {
+				Expect(spec.Metadata).NotTo(HaveKey("vendor-data"), "Expect no cloud-init vendor data for ignition")
+
+				return
+			}
+
+			vendorData, err := base64.StdEncoding.DecodeString(spec.Metadata["vendor-data"])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(vendorData)).To(ContainSubstring("hostname: testcluster-machine-1"))
+		})
+	}
+}
+
+func TestMachineGetRawBootstrapData(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	clusterName := "testcluster"
+	machineName := "machine-1"
+	sshKeys := []microvm.SSHPublicKey{
+		{User: "core", AuthorizedKeys: []string{"ssh-ed25519 AAAA"}},
+	}
+
+	tt := []struct {
+		name          string
+		data          map[string][]byte
+		expectedData  string
+		expectedError bool
+		ignition      bool
+	}{
+		{
+			name:         "cloud-config without format",
+			data:         map[string][]byte{"value": []byte("#cloud-config")},
+			expectedData: "#cloud-config",
+		},
+		{
+			name:         "cloud-config",
+			data:         map[string][]byte{"value": []byte("#cloud-config"), "format": []byte("cloud-config")},
+			expectedData: "#cloud-config",
+		},
+		{
+			name: "ignition v2",
+			data: map[string][]byte{
+				"value":  []byte(`{"ignition":{"version":"2.3.0"},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["ssh-rsa BBBB"]}]}}`),
+				"format": []byte("ignition"),
+			},
+			expectedData: `{"ignition":{"version":"2.3.0"},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["ssh-rsa BBBB","ssh-ed25519 AAAA"]}]},` +
+				`"storage":{"files":[{"contents":{"source":"data:,machine-1"},"filesystem":"root","mode":420,"path":"/etc/hostname"}]}}`,
+			ignition: true,
+		},
+		{
+			name: "ignition v3",
+			data: map[string][]byte{
+				"value":  []byte(`{"ignition":{"version":"3.3.0"}}`),
+				"format": []byte("ignition"),
+			},
+			expectedData: `{"ignition":{"version":"3.3.0"},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["ssh-ed25519 AAAA"]}]},` +
+				`"storage":{"files":[{"contents":{"source":"data:,machine-1"},"mode":420,"overwrite":true,"path":"/etc/hostname"}]}}`,
+			ignition: true,
+		},
+		{
+			name:          "invalid ignition",
+			data:          map[string][]byte{"value": []byte("#cloud-config"), "format": []byte("ignition")},
+			expectedError: true,
+			ignition:      true,
+		},
+		{
+			name:          "unsupported format",
+			data:          map[string][]byte{"value": []byte("#cloud-config"), "format": []byte("unknown")},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := newCluster(clusterName, []string{"fd1"})
+			mvmCluster := newMicrovmCluster(clusterName)
+			machine := newMachine(clusterName, machineName)
+			mvmMachine := newMicrovmMachine(clusterName, machineName, "")
+			mvmMachine.Spec.SSHPublicKeys = sshKeys
+
+			initObjects := []client.Object{
+				cluster, mvmCluster, machine, mvmMachine, newSecret(machineName, tc.data),
+			}
+
+			client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+			machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+				Client:         client,
+				Cluster:        cluster,
+				MicroVMCluster: mvmCluster,
+				Machine:        machine,
+				MicroVMMachine: mvmMachine,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			ignition, err := machineScope.UsesIgnition()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ignition).To(Equal(tc.ignition))
+
+			data, err := machineScope.GetRawBootstrapData()
+			if tc.expectedError {
+				Expect(err).To(HaveOccurred())
+
+				return
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+
+			decoded, err := base64.StdEncoding.DecodeString(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(decoded)).To(Equal(tc.expectedData))
+		})
+	}
+}
+
+func TestMachineGetRawBootstrapDataWithFragments(t *testing.T) {
+	RegisterTestingT(t)
+
+	scheme, err := setupScheme()
+	Expect(err).NotTo(HaveOccurred())
+
+	clusterName := "testcluster"
+	machineName := "machine-1"
+
+	cluster := newCluster(clusterName, []string{"fd1"})
+	mvmCluster := newMicrovmCluster(clusterName)
+	mvmCluster.Spec.UserDataFragments = []infrav1.UserDataFragment{
+		{
+			ConfigMapRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "fragments"},
+				Key:                  "packages",
+			},
+		},
+	}
+	machine := newMachine(clusterName, machineName)
+	mvmMachine := newMicrovmMachine(clusterName, machineName, "")
+	mvmMachine.Spec.UserDataFragments = []infrav1.UserDataFragment{
+		{
+			SecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "script"},
+				Key:                  "run.sh",
+			},
+			ContentType: "text/x-shellscript",
+		},
+	}
+
+	fragments := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "fragments", Namespace: "default"},
+		Data:       map[string]string{"packages": "#cloud-config\npackages: [jq]"},
+	}
+
+	initObjects := []client.Object{
+		cluster, mvmCluster, machine, mvmMachine, fragments,
+		newSecret(machineName, map[string][]byte{"value": []byte("#cloud-config\nruncmd: [kubeadm]")}),
+		newSecret("script", map[string][]byte{"run.sh": []byte("#!/bin/sh\necho hello")}),
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+	machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+		Client:         client,
+		Cluster:        cluster,
+		MicroVMCluster: mvmCluster,
+		Machine:        machine,
+		MicroVMMachine: mvmMachine,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	data, err := machineScope.GetRawBootstrapData()
+	Expect(err).NotTo(HaveOccurred())
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	Expect(err).NotTo(HaveOccurred())
+
+	msg, err := mail.ReadMessage(bytes.NewReader(decoded))
+	Expect(err).NotTo(HaveOccurred())
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(mediaType).To(Equal("multipart/mixed"))
+
+	expected := []struct {
+		contentType string
+		content     string
+	}{
+		{contentType: "text/cloud-config", content: "#cloud-config\nruncmd: [kubeadm]"},
+		{contentType: "text/cloud-config", content: "#cloud-config\npackages: [jq]\n"},
+		{contentType: "text/x-shellscript", content: "#!/bin/sh\necho hello"},
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for _, part := range expected {
+		p, err := reader.NextPart()
+		Expect(err).NotTo(HaveOccurred())
+
+		contentType, _, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contentType).To(Equal(part.contentType))
+
+		content, err := io.ReadAll(p)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal(part.content))
+	}
+
+	_, err = reader.NextPart()
+	Expect(err).To(Equal(io.EOF))
+
+	mvmMachine.Spec.UserDataFragments[0].SecretRef.Key = "missing"
+	_, err = machineScope.GetRawBootstrapData()
+	Expect(err).To(HaveOccurred())
+}
+
 func setupScheme() (*runtime.Scheme, error) {
 	scheme := runtime.NewScheme()
 	if err := infrav1.AddToScheme(scheme); err != nil {
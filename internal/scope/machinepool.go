@@ -0,0 +1,398 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expclusterv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/defaults"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+var _ Scoper = &MachinePoolScope{}
+
+// MachinePoolLabel is the label added to each microvm in a pool with the name of the pool.
+const MachinePoolLabel = "machine-pool"
+
+type MachinePoolScopeParams struct {
+	Cluster        *clusterv1.Cluster
+	MicroVMCluster *infrav1.MicrovmCluster
+
+	MachinePool        *expclusterv1.MachinePool
+	MicroVMMachinePool *infrav1.MicrovmMachinePool
+
+	Client  client.Client
+	Context context.Context //nolint: containedctx // don't care
+}
+
+func NewMachinePoolScope(params MachinePoolScopeParams, opts ...MachinePoolScopeOption) (*MachinePoolScope, error) {
+	if params.Cluster == nil {
+		return nil, errClusterRequired
+	}
+
+	if params.MicroVMCluster == nil {
+		return nil, errMicrovmClusterRequired
+	}
+
+	if params.MachinePool == nil {
+		return nil, errMachinePoolRequired
+	}
+
+	if params.MicroVMMachinePool == nil {
+		return nil, errMicrovmMachinePoolRequired
+	}
+
+	if params.Client == nil {
+		return nil, errClientRequired
+	}
+
+	patchHelper, err := patch.NewHelper(params.MicroVMMachinePool, params.Client)
+	if err != nil {
+		return nil, fmt.Errorf("creating patch helper for microvm machine pool: %w", err)
+	}
+
+	scope := &MachinePoolScope{
+		Cluster:        params.Cluster,
+		MvmCluster:     params.MicroVMCluster,
+		MachinePool:    params.MachinePool,
+		MvmMachinePool: params.MicroVMMachinePool,
+		client:         params.Client,
+		controllerName: defaults.ManagerName,
+		Logger:         klogr.New(),
+		patchHelper:    patchHelper,
+		ctx:            params.Context,
+	}
+
+	for _, opt := range opts {
+		opt(scope)
+	}
+
+	return scope, nil
+}
+
+type MachinePoolScopeOption func(*MachinePoolScope)
+
+func WithMachinePoolLogger(logger logr.Logger) MachinePoolScopeOption {
+	return func(s *MachinePoolScope) {
+		s.Logger = logger
+	}
+}
+
+// WithMachinePoolServiceAccount sets the service account of the controller, whose tokens are used
+// to authenticate with the hosts with the bearer token auth types.
+func WithMachinePoolServiceAccount(serviceAccount types.NamespacedName) MachinePoolScopeOption {
+	return func(s *MachinePoolScope) {
+		s.serviceAccount = serviceAccount
+	}
+}
+
+// MachinePoolScope is the scope for reconciling a machine pool.
+type MachinePoolScope struct {
+	logr.Logger
+
+	Cluster    *clusterv1.Cluster
+	MvmCluster *infrav1.MicrovmCluster
+
+	MachinePool    *expclusterv1.MachinePool
+	MvmMachinePool *infrav1.MicrovmMachinePool
+
+	client         client.Client
+	patchHelper    *patch.Helper
+	controllerName string
+	serviceAccount types.NamespacedName
+	ctx            context.Context
+}
+
+// Name returns the MicrovmMachinePool name.
+func (m *MachinePoolScope) Name() string {
+	return m.MvmMachinePool.Name
+}
+
+// Namespace returns the namespace name.
+func (m *MachinePoolScope) Namespace() string {
+	return m.MvmMachinePool.Namespace
+}
+
+// ClusterName returns the name of the cluster.
+func (m *MachinePoolScope) ClusterName() string {
+	return m.Cluster.Name
+}
+
+// ControllerName returns the name of the controller that created the scope.
+func (m *MachinePoolScope) ControllerName() string {
+	return m.controllerName
+}
+
+// Patch persists the resource and status.
+func (m *MachinePoolScope) Patch() error {
+	applicableConditions := []clusterv1.ConditionType{
+		infrav1.MicrovmMachinePoolReadyCondition,
+	}
+
+	conditions.SetSummary(m.MvmMachinePool,
+		conditions.WithConditions(applicableConditions...),
+		conditions.WithStepCounterIf(m.MvmMachinePool.DeletionTimestamp.IsZero()),
+		conditions.WithStepCounter(),
+	)
+
+	err := m.patchHelper.Patch(
+		m.ctx,
+		m.MvmMachinePool,
+		patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
+			clusterv1.ReadyCondition,
+			infrav1.MicrovmMachinePoolReadyCondition,
+		}})
+	if err != nil {
+		return fmt.Errorf("unable to patch machine pool: %w", err)
+	}
+
+	return nil
+}
+
+// DesiredReplicas returns the number of microvms the pool should have.
+func (m *MachinePoolScope) DesiredReplicas() int32 {
+	if m.MachinePool.Spec.Replicas == nil {
+		return 1
+	}
+
+	return *m.MachinePool.Spec.Replicas
+}
+
+// FailureDomains returns the sorted names of the failure domains that the microvms of the
+// pool can be spread across. If the machine pool specifies failure domains then only those
+// known by the cluster are used.
+func (m *MachinePoolScope) FailureDomains() []string {
+	allowed := map[string]bool{}
+	for _, fd := range m.MachinePool.Spec.FailureDomains {
+		allowed[fd] = true
+	}
+
+	names := make([]string, 0, len(m.Cluster.Status.FailureDomains))
+
+	for fdName := range m.Cluster.Status.FailureDomains {
+		if len(allowed) > 0 && !allowed[fdName] {
+			continue
+		}
+
+		names = append(names, fdName)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// SpecHash returns a hash of everything that is used to create the microvms of the pool, including
+// the metadata size limit that they're checked against. If this changes the existing microvms need
+// to be replaced. Settings that aren't used are left out so that the hash only changes when they are.
+func (m *MachinePoolScope) SpecHash(metadataSizeLimit int) (string, error) {
+	input := struct {
+		Spec              microvm.VMSpec             `json:"spec"`
+		SSHPublicKeys     []microvm.SSHPublicKey     `json:"sshPublicKeys,omitempty"`
+		BootstrapSecret   *string                    `json:"bootstrapSecret,omitempty"`
+		UserDataFragments []infrav1.UserDataFragment `json:"userDataFragments,omitempty"`
+		CompressUserData  bool                       `json:"compressUserData,omitempty"`
+		HostnameTemplate  string                     `json:"hostnameTemplate,omitempty"`
+		Metadata          map[string]string          `json:"metadata,omitempty"`
+		MetadataSizeLimit int                        `json:"metadataSizeLimit,omitempty"`
+	}{
+		Spec:              m.MvmMachinePool.Spec.VMSpec,
+		SSHPublicKeys:     m.GetSSHPublicKeys(),
+		BootstrapSecret:   m.MachinePool.Spec.Template.Spec.Bootstrap.DataSecretName,
+		UserDataFragments: m.MvmCluster.Spec.UserDataFragments,
+		CompressUserData:  m.MvmCluster.Spec.CompressUserData,
+		HostnameTemplate:  m.MvmCluster.Spec.HostnameTemplate,
+		Metadata:          m.MvmCluster.Spec.Metadata,
+		MetadataSizeLimit: metadataSizeLimit,
+	}
+
+	return hashObject(input)
+}
+
+// GetSSHPublicKeys will return the SSH public keys for the microvms of the pool. It will take
+// into account precedence rules. If there are no keys then nil will be returned.
+func (m *MachinePoolScope) GetSSHPublicKeys() []microvm.SSHPublicKey {
+	if len(m.MvmMachinePool.Spec.SSHPublicKeys) != 0 {
+		return m.MvmMachinePool.Spec.SSHPublicKeys
+	}
+
+	if len(m.MvmCluster.Spec.SSHPublicKeys) != 0 {
+		return m.MvmCluster.Spec.SSHPublicKeys
+	}
+
+	return nil
+}
+
+// UsesIgnition returns true if the bootstrap data for the pool is an Ignition config.
+func (m *MachinePoolScope) UsesIgnition() (bool, error) {
+	return usesIgnition(m.ctx, m.client, m.Namespace(), m.MachinePool.Spec.Template.Spec.Bootstrap.DataSecretName)
+}
+
+// GetBasicAuthToken will fetch the BasicAuthSecret on the MvmCluster and
+// and return the token for the given host.
+func (m *MachinePoolScope) GetBasicAuthToken(addr string) (string, error) {
+	return getBasicAuthToken(m.ctx, m.client, m.Logger, m.MvmCluster, addr)
+}
+
+// GetHostAuth returns the client option that authenticates with the host at addr using the auth
+// type configured on the MvmCluster.
+func (m *MachinePoolScope) GetHostAuth(addr string) (flclient.Options, error) {
+	return getHostAuth(m.ctx, m.client, m.Logger, m.MvmCluster, m.serviceAccount, addr)
+}
+
+// GetTLSConfig will fetch the TLSSecretRef on the MvmCluster, or the TLS overrides of the host at
+// addr, and return the TLS config for the client.
+func (m *MachinePoolScope) GetTLSConfig(addr string) (*flclient.TLSConfig, error) {
+	return getTLSConfig(m.ctx, m.client, m.Logger, m.MvmCluster, addr)
+}
+
+// GetMicrovmProxy returns the proxy to use when calling the microvm service on the host at addr,
+// or nil if the host is connected to directly.
+func (m *MachinePoolScope) GetMicrovmProxy(addr string) (*flclient.Proxy, error) {
+	return getMicrovmProxy(m.ctx, m.client, m.MvmCluster, addr)
+}
+
+// SetReady sets any properties/conditions that are used to indicate that the MicrovmMachinePool is 'Ready'
+// back to the upstream CAPI machine pool controllers.
+func (m *MachinePoolScope) SetReady() {
+	conditions.MarkTrue(m.MvmMachinePool, infrav1.MicrovmMachinePoolReadyCondition)
+	m.MvmMachinePool.Status.Ready = true
+}
+
+// SetNotReady sets any properties/conditions that are used to indicate that the MicrovmMachinePool is NOT 'Ready'
+// back to the upstream CAPI machine pool controllers.
+func (m *MachinePoolScope) SetNotReady(
+	reason string,
+	severity clusterv1.ConditionSeverity,
+	message string,
+	messageArgs ...interface{},
+) {
+	conditions.MarkFalse(m.MvmMachinePool, infrav1.MicrovmMachinePoolReadyCondition, reason, severity, message, messageArgs...)
+	m.MvmMachinePool.Status.Ready = false
+}
+
+// InstanceScope returns the scope used to create and manage the microvm of a single pool instance.
+func (m *MachinePoolScope) InstanceScope(instance *infrav1.MicrovmInstance) *MachinePoolInstanceScope {
+	return &MachinePoolInstanceScope{
+		pool:     m,
+		instance: instance,
+	}
+}
+
+// MachinePoolInstanceScope is the scope for a single microvm that is a member of a machine pool. It
+// satisfies the scope required by the microvm service.
+type MachinePoolInstanceScope struct {
+	pool     *MachinePoolScope
+	instance *infrav1.MicrovmInstance
+}
+
+// Name returns the name of the microvm.
+func (i *MachinePoolInstanceScope) Name() string {
+	return i.instance.Name
+}
+
+// Namespace returns the namespace of the microvm.
+func (i *MachinePoolInstanceScope) Namespace() string {
+	return i.pool.Namespace()
+}
+
+// GetMicrovmSpec returns the spec for the microvm with the mac addresses allocated to the instance.
+func (i *MachinePoolInstanceScope) GetMicrovmSpec() microvm.VMSpec {
+	return withMACAddresses(i.pool.MvmMachinePool.Spec.VMSpec, i.instance.NetworkInterfaceMACs)
+}
+
+// GetMicrovmCreateSpec returns the flintlock spec used to create the microvm on the host with hostID.
+// The rendered hostname and instance metadata are used for the cloud-init meta-data.
+func (i *MachinePoolInstanceScope) GetMicrovmCreateSpec(hostID string) (*flintlocktypes.MicroVMSpec, error) {
+	hostname, err := i.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := i.InstanceMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	ignition, err := i.pool.UsesIgnition()
+	if err != nil {
+		return nil, err
+	}
+
+	return newMicrovmCreateSpec(i, microvmOptions{
+		hostID:   hostID,
+		hostname: hostname,
+		metadata: metadata,
+		ignition: ignition,
+	})
+}
+
+// GetInstanceID returns the UID of the microvm.
+func (i *MachinePoolInstanceScope) GetInstanceID() string {
+	return i.instance.UID
+}
+
+// GetRawBootstrapData returns the bootstrap data for the microvm.
+func (i *MachinePoolInstanceScope) GetRawBootstrapData() (string, error) {
+	hostname, err := i.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	return getRawBootstrapData(
+		i.pool.ctx,
+		i.pool.client,
+		i.Namespace(),
+		i.pool.MachinePool.Spec.Template.Spec.Bootstrap.DataSecretName,
+		bootstrapDataOptions{
+			hostname:  hostname,
+			sshKeys:   i.GetSSHPublicKeys(),
+			fragments: i.pool.MvmCluster.Spec.UserDataFragments,
+			compress:  i.pool.MvmCluster.Spec.CompressUserData,
+		},
+	)
+}
+
+// GetSSHPublicKeys returns the SSH public keys for the microvm.
+func (i *MachinePoolInstanceScope) GetSSHPublicKeys() []microvm.SSHPublicKey {
+	return i.pool.GetSSHPublicKeys()
+}
+
+// GetLabels returns any user defined or default labels for the microvm.
+func (i *MachinePoolInstanceScope) GetLabels() map[string]string {
+	labels := map[string]string{}
+
+	for k, v := range i.pool.MvmMachinePool.Spec.VMSpec.Labels {
+		labels[k] = v
+	}
+
+	labels["cluster-name"] = i.pool.ClusterName()
+	labels[MachinePoolLabel] = i.pool.Name()
+
+	return labels
+}
+
+// GetProviderID returns the provider id of the microvm. If the microvm hasn't been
+// created yet then an empty string will be returned.
+func (i *MachinePoolInstanceScope) GetProviderID() string {
+	if i.instance.UID == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s%s/%s", ProviderPrefix, i.instance.FailureDomain, i.instance.UID)
+}
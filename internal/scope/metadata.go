@@ -0,0 +1,124 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+// Hostname returns the hostname of the machine. It's rendered from the hostname template for the
+// machine or cluster, or is the name of the machine if there's no template.
+func (m *MachineScope) Hostname() (string, error) {
+	hostnameTemplate := m.MvmCluster.Spec.HostnameTemplate
+	if m.MvmMachine.Spec.HostnameTemplate != "" {
+		hostnameTemplate = m.MvmMachine.Spec.HostnameTemplate
+	}
+
+	if hostnameTemplate == "" {
+		return m.Name(), nil
+	}
+
+	values, err := m.instanceTemplateValues()
+	if err != nil {
+		return "", err
+	}
+
+	return renderHostname(hostnameTemplate, values)
+}
+
+// InstanceMetadata returns the additional keys for the cloud-init meta-data of the machine. The
+// metadata for the cluster is merged with the metadata for the machine, which takes precedence.
+func (m *MachineScope) InstanceMetadata() (map[string]string, error) {
+	if len(m.MvmCluster.Spec.Metadata) == 0 && len(m.MvmMachine.Spec.Metadata) == 0 {
+		return nil, nil
+	}
+
+	values, err := m.instanceTemplateValues()
+	if err != nil {
+		return nil, err
+	}
+
+	return renderInstanceMetadata(values, m.MvmCluster.Spec.Metadata, m.MvmMachine.Spec.Metadata)
+}
+
+func (m *MachineScope) instanceTemplateValues() (infrav1.InstanceTemplateValues, error) {
+	failureDomain, err := m.GetFailureDomain()
+	if err != nil {
+		return infrav1.InstanceTemplateValues{}, err
+	}
+
+	return infrav1.InstanceTemplateValues{
+		ClusterName:   m.ClusterName(),
+		MachineName:   m.Name(),
+		Namespace:     m.Namespace(),
+		FailureDomain: failureDomain,
+	}, nil
+}
+
+// Hostname returns the hostname of the microvm. It's rendered from the hostname template for the
+// cluster, or is the name of the microvm if there's no template.
+func (i *MachinePoolInstanceScope) Hostname() (string, error) {
+	if i.pool.MvmCluster.Spec.HostnameTemplate == "" {
+		return i.Name(), nil
+	}
+
+	return renderHostname(i.pool.MvmCluster.Spec.HostnameTemplate, i.instanceTemplateValues())
+}
+
+// InstanceMetadata returns the additional keys for the cloud-init meta-data of the microvm, which
+// are rendered from the metadata for the cluster.
+func (i *MachinePoolInstanceScope) InstanceMetadata() (map[string]string, error) {
+	if len(i.pool.MvmCluster.Spec.Metadata) == 0 {
+		return nil, nil
+	}
+
+	return renderInstanceMetadata(i.instanceTemplateValues(), i.pool.MvmCluster.Spec.Metadata)
+}
+
+func (i *MachinePoolInstanceScope) instanceTemplateValues() infrav1.InstanceTemplateValues {
+	return infrav1.InstanceTemplateValues{
+		ClusterName:   i.pool.ClusterName(),
+		MachineName:   i.Name(),
+		Namespace:     i.Namespace(),
+		FailureDomain: i.instance.FailureDomain,
+	}
+}
+
+func renderHostname(hostnameTemplate string, values infrav1.InstanceTemplateValues) (string, error) {
+	hostname, err := infrav1.RenderInstanceTemplate(hostnameTemplate, values)
+	if err != nil {
+		return "", fmt.Errorf("rendering hostname template: %w", err)
+	}
+
+	hostname = strings.TrimSpace(hostname)
+	if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+		return "", fmt.Errorf("%w: %s: %s", errInvalidHostname, hostname, strings.Join(errs, ", "))
+	}
+
+	return hostname, nil
+}
+
+// renderInstanceMetadata renders the metadata from each of the sources, the later sources take
+// precedence.
+func renderInstanceMetadata(values infrav1.InstanceTemplateValues, sources ...map[string]string) (map[string]string, error) {
+	metadata := map[string]string{}
+
+	for _, source := range sources {
+		for key, value := range source {
+			rendered, err := infrav1.RenderInstanceTemplate(value, values)
+			if err != nil {
+				return nil, fmt.Errorf("rendering metadata %s: %w", key, err)
+			}
+
+			metadata[key] = rendered
+		}
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/instance"
+	"github.com/liquidmetal-dev/flintlock/client/cloudinit/userdata"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	cloudInitUserDataKey   = "user-data"
+	cloudInitVendorDataKey = "vendor-data"
+	cloudInitMetaDataKey   = "meta-data"
+	cloudInitHeader        = "#cloud-config\n"
+
+	platformLiquidMetal = "liquid_metal"
+	vmHostMetadataKey   = "vm_host"
+	vendorFinalMessage  = "The Liquid Metal booted system is good to go after $UPTIME seconds"
+)
+
+// microvmSource is implemented by the scopes of the microvms that the provider creates.
+type microvmSource interface {
+	Name() string
+	Namespace() string
+	GetMicrovmSpec() microvm.VMSpec
+	GetLabels() map[string]string
+	GetRawBootstrapData() (string, error)
+	GetSSHPublicKeys() []microvm.SSHPublicKey
+}
+
+// microvmOptions are the parts of the flintlock spec of a microvm that aren't in its VMSpec.
+type microvmOptions struct {
+	// hostID is the address of the host the microvm is created on, it's added to the meta-data.
+	hostID string
+	// hostname is the hostname of the guest, it defaults to the name of the microvm.
+	hostname string
+	// metadata are the additional keys for the cloud-init meta-data. They can't replace the keys
+	// that are always set.
+	metadata map[string]string
+	// addresses are the static addresses allocated to the network interfaces, keyed by guest
+	// device name. Their gateway and nameservers are added to the interfaces.
+	addresses map[string]InterfaceAddress
+	// ignition is true if the bootstrap data is an Ignition config, which has the hostname and SSH
+	// keys added to it, so there's no cloud-init vendor data.
+	ignition bool
+}
+
+// newMicrovmCreateSpec builds the flintlock spec used to create a microvm. The bootstrap data is
+// used as the user-data, and the cloud-init meta-data and vendor data are generated from the scope.
+func newMicrovmCreateSpec(s microvmSource, opts microvmOptions) (*flintlocktypes.MicroVMSpec, error) {
+	vmSpec := s.GetMicrovmSpec()
+
+	hostname := opts.hostname
+	if hostname == "" {
+		hostname = s.Name()
+	}
+
+	spec := &flintlocktypes.MicroVMSpec{
+		Id:         s.Name(),
+		Namespace:  s.Namespace(),
+		Labels:     s.GetLabels(),
+		Provider:   &vmSpec.Provider,
+		Vcpu:       int32(vmSpec.VCPU),     //nolint: gosec // validated by the api
+		MemoryInMb: int32(vmSpec.MemoryMb), //nolint: gosec // validated by the api
+		Kernel: &flintlocktypes.Kernel{
+			Image:            vmSpec.Kernel.Image,
+			Filename:         &vmSpec.Kernel.Filename,
+			Cmdline:          vmSpec.KernelCmdLine,
+			AddNetworkConfig: true,
+		},
+		RootVolume: &flintlocktypes.Volume{
+			Id:         vmSpec.RootVolume.ID,
+			IsReadOnly: vmSpec.RootVolume.ReadOnly,
+			Source: &flintlocktypes.VolumeSource{
+				ContainerSource: &vmSpec.RootVolume.Image,
+			},
+		},
+		AdditionalVolumes: convertVolumes(vmSpec.AdditionalVolumes),
+		Metadata:          map[string]string{},
+	}
+
+	if vmSpec.Initrd != nil {
+		spec.Initrd = &flintlocktypes.Initrd{
+			Image:    vmSpec.Initrd.Image,
+			Filename: &vmSpec.Initrd.Filename,
+		}
+	}
+
+	interfaces, err := convertNetworkInterfaces(vmSpec.NetworkInterfaces, opts.addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.Interfaces = interfaces
+
+	userData, err := s.GetRawBootstrapData()
+	if err != nil {
+		return nil, fmt.Errorf("getting user data for microvm: %w", err)
+	}
+
+	spec.Metadata[cloudInitUserDataKey] = userData
+
+	if !opts.ignition {
+		vendorData, err := cloudInitVendorData(hostname, s.GetSSHPublicKeys())
+		if err != nil {
+			return nil, fmt.Errorf("creating vendor data for microvm: %w", err)
+		}
+
+		spec.Metadata[cloudInitVendorDataKey] = vendorData
+	}
+
+	metaData, err := cloudInitMetaData(hostname, opts.hostID, opts.metadata)
+	if err != nil {
+		return nil, fmt.Errorf("creating instance metadata: %w", err)
+	}
+
+	spec.Metadata[cloudInitMetaDataKey] = metaData
+
+	return spec, nil
+}
+
+// convertNetworkInterfaces converts the network interfaces to flintlock network interfaces. The
+// interfaces that don't have a mac address are given a random one.
+func convertNetworkInterfaces(
+	ifaces []microvm.NetworkInterface,
+	addresses map[string]InterfaceAddress,
+) ([]*flintlocktypes.NetworkInterface, error) {
+	converted := make([]*flintlocktypes.NetworkInterface, 0, len(ifaces))
+
+	for i := range ifaces {
+		iface := ifaces[i]
+
+		if iface.GuestMAC == "" {
+			mac, err := randomMACAddress()
+			if err != nil {
+				return nil, err
+			}
+
+			iface.GuestMAC = mac
+		}
+
+		apiIface := &flintlocktypes.NetworkInterface{
+			DeviceId: iface.GuestDeviceName,
+			GuestMac: &iface.GuestMAC,
+		}
+
+		if iface.Address != "" {
+			apiIface.Address = &flintlocktypes.StaticAddress{
+				Address: iface.Address,
+			}
+
+			if address, ok := addresses[iface.GuestDeviceName]; ok {
+				if address.Gateway != "" {
+					apiIface.Address.Gateway = &address.Gateway
+				}
+
+				apiIface.Address.Nameservers = address.Nameservers
+			}
+		}
+
+		switch iface.Type {
+		case microvm.IfaceTypeMacvtap:
+			apiIface.Type = flintlocktypes.NetworkInterface_MACVTAP
+		case microvm.IfaceTypeTap:
+			apiIface.Type = flintlocktypes.NetworkInterface_TAP
+		}
+
+		converted = append(converted, apiIface)
+	}
+
+	return converted, nil
+}
+
+func convertVolumes(volumes []microvm.Volume) []*flintlocktypes.Volume {
+	converted := make([]*flintlocktypes.Volume, 0, len(volumes))
+
+	for i := range volumes {
+		volume := volumes[i]
+
+		apiVolume := &flintlocktypes.Volume{
+			Id:         volume.ID,
+			IsReadOnly: volume.ReadOnly,
+			Source:     &flintlocktypes.VolumeSource{},
+		}
+
+		if volume.Image != "" {
+			apiVolume.Source.ContainerSource = &volume.Image
+		}
+
+		if volume.VirtioFSPath != "" {
+			apiVolume.Source.VirtiofsSource = &volume.VirtioFSPath
+		}
+
+		if volume.MountPoint != "" {
+			apiVolume.MountPoint = &volume.MountPoint
+		}
+
+		converted = append(converted, apiVolume)
+	}
+
+	return converted
+}
+
+// cloudInitVendorData returns the base64 encoded cloud-init vendor data, which sets the hostname
+// and adds the SSH keys.
+func cloudInitVendorData(hostname string, sshKeys []microvm.SSHPublicKey) (string, error) {
+	// TODO: remove the boot command temporary fix after image-builder change #89
+	vendorData := &userdata.UserData{
+		HostName:     hostname,
+		FinalMessage: vendorFinalMessage,
+		BootCommands: []string{
+			"ln -sf /run/systemd/resolve/stub-resolv.conf /etc/resolv.conf",
+		},
+	}
+
+	for _, key := range sshKeys {
+		vendorData.Users = append(vendorData.Users, userdata.User{
+			Name:              key.User,
+			SSHAuthorizedKeys: key.AuthorizedKeys,
+		})
+	}
+
+	data, err := yaml.Marshal(vendorData)
+	if err != nil {
+		return "", fmt.Errorf("marshalling vendor data: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(append([]byte(cloudInitHeader), data...)), nil
+}
+
+// cloudInitMetaData returns the base64 encoded cloud-init meta-data with the additional keys.
+func cloudInitMetaData(hostname, hostID string, metadata map[string]string) (string, error) {
+	metaData := instance.New(
+		instance.WithLocalHostname(hostname),
+		instance.WithPlatform(platformLiquidMetal),
+		instance.WithKeyValue(vmHostMetadataKey, hostID),
+	)
+
+	for key, value := range metadata {
+		if !metaData.HasItem(key) {
+			metaData[key] = value
+		}
+	}
+
+	data, err := yaml.Marshal(metaData)
+	if err != nil {
+		return "", fmt.Errorf("marshalling instance metadata: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,279 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+// hostUsage is the number of machines of the cluster that are placed on a host.
+type hostUsage struct {
+	machines     int
+	controlPlane int
+	group        int
+}
+
+// placement checks the constraints of the static pool when placing a machine.
+type placement struct {
+	pool         *infrav1.StaticPoolPlacement
+	controlPlane bool
+	group        string
+	usage        map[string]hostUsage
+}
+
+// placeMachine returns the failure domain to place the machine on. The requested failure domain is
+// used if it's set and the machine can be placed on it, otherwise one of the failure domains of the
+// cluster that the machine can be placed on is chosen.
+func (m *MachineScope) placeMachine(requested string) (string, error) {
+	p, err := m.newPlacement()
+	if err != nil {
+		return "", err
+	}
+
+	if requested != "" {
+		if err := p.check(requested); err != nil {
+			return "", err
+		}
+
+		return requested, nil
+	}
+
+	failureDomainNames := make([]string, 0, len(m.Cluster.Status.FailureDomains))
+	for fdName := range m.Cluster.Status.FailureDomains {
+		failureDomainNames = append(failureDomainNames, fdName)
+	}
+
+	if len(failureDomainNames) == 0 {
+		return "", errFailureDomainNotFound
+	}
+
+	allowed := []string{}
+
+	for _, fdName := range failureDomainNames {
+		err := p.check(fdName)
+		if err == nil {
+			allowed = append(allowed, fdName)
+
+			continue
+		}
+
+		if !errors.Is(err, errHostNotAllowed) {
+			return "", err
+		}
+	}
+
+	if len(allowed) == 0 {
+		return "", errNoFailureDomainAvailable
+	}
+
+	if !p.controlPlane && p.pool != nil && p.pool.SeparateWorkers {
+		workers, err := p.workerHosts(allowed)
+		if err != nil {
+			return "", err
+		}
+
+		if len(workers) > 0 {
+			allowed = workers
+		}
+	}
+
+	if p.group != "" {
+		allowed = p.leastUsed(allowed)
+	}
+
+	if len(allowed) == 1 {
+		return allowed[0], nil
+	}
+
+	sort.Strings(allowed)
+	pos := int(crc32.ChecksumIEEE([]byte(m.MvmMachine.Name))) % len(allowed)
+
+	return allowed[pos], nil
+}
+
+// newPlacement returns the placement for the machine. The other machines of the cluster are only
+// counted if the static pool is used.
+func (m *MachineScope) newPlacement() (*placement, error) {
+	p := &placement{
+		pool:         m.MvmCluster.Spec.Placement.StaticPool,
+		controlPlane: m.IsControlPlane(),
+		group:        m.antiAffinityGroup(m.Machine.Labels),
+		usage:        map[string]hostUsage{},
+	}
+
+	if p.group == "" {
+		p.group = m.antiAffinityGroup(m.MvmMachine.Labels)
+	}
+
+	if p.pool == nil {
+		return p, nil
+	}
+
+	mvmMachines := &infrav1.MicrovmMachineList{}
+
+	err := m.client.List(m.ctx, mvmMachines,
+		client.InNamespace(m.Namespace()),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: m.ClusterName()},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing the machines of the cluster: %w", err)
+	}
+
+	for i := range mvmMachines.Items {
+		other := &mvmMachines.Items[i]
+		if other.Name == m.MvmMachine.Name {
+			continue
+		}
+
+		failureDomain := other.Status.FailureDomain
+		if failureDomain == "" && other.Spec.ProviderID != nil {
+			failureDomain = m.getFailureDomainFromProviderID(*other.Spec.ProviderID)
+		}
+
+		if failureDomain == "" {
+			continue
+		}
+
+		usage := p.usage[failureDomain]
+		usage.machines++
+
+		if _, ok := other.Labels[clusterv1.MachineControlPlaneLabel]; ok {
+			usage.controlPlane++
+		}
+
+		if p.group != "" && m.antiAffinityGroup(other.Labels) == p.group {
+			usage.group++
+		}
+
+		p.usage[failureDomain] = usage
+	}
+
+	return p, nil
+}
+
+// antiAffinityGroup returns the anti-affinity group of a machine of the cluster with the labels,
+// which is empty if the machine doesn't have anti-affinity or isn't in a group.
+func (m *MachineScope) antiAffinityGroup(labels map[string]string) string {
+	antiAffinity := m.MvmMachine.Spec.AntiAffinity
+	if antiAffinity == nil {
+		return ""
+	}
+
+	switch antiAffinity.Type {
+	case infrav1.AntiAffinityMachineDeployment:
+		return labels[clusterv1.MachineDeploymentNameLabel]
+	case infrav1.AntiAffinityLabelKey:
+		return labels[antiAffinity.LabelKey]
+	default:
+		return ""
+	}
+}
+
+// leastUsed returns the failure domains with the fewest machines of the anti-affinity group, and of
+// those the ones with the fewest machines of the cluster.
+func (p *placement) leastUsed(failureDomains []string) []string {
+	leastUsed := []string{}
+
+	for _, failureDomain := range failureDomains {
+		if len(leastUsed) == 0 {
+			leastUsed = append(leastUsed, failureDomain)
+
+			continue
+		}
+
+		usage, least := p.usage[failureDomain], p.usage[leastUsed[0]]
+
+		switch {
+		case usage.group < least.group,
+			usage.group == least.group && usage.machines < least.machines:
+			leastUsed = []string{failureDomain}
+		case usage.group == least.group && usage.machines == least.machines:
+			leastUsed = append(leastUsed, failureDomain)
+		}
+	}
+
+	return leastUsed
+}
+
+// check returns an error wrapping errHostNotAllowed if the machine can't be placed on the failure
+// domain. There are no constraints for hosts that aren't in the static pool.
+func (p *placement) check(failureDomain string) error {
+	host, ok := p.host(failureDomain)
+	if !ok {
+		return nil
+	}
+
+	usage := p.usage[failureDomain]
+
+	if host.MaxMachines != nil && usage.machines >= int(*host.MaxMachines) {
+		return fmt.Errorf("%w: %s has the maximum number of machines", errHostNotAllowed, failureDomain)
+	}
+
+	if !p.controlPlane {
+		return nil
+	}
+
+	allowed, err := p.pool.AllowsControlPlane(host)
+	if err != nil {
+		return err
+	}
+
+	if !allowed {
+		return fmt.Errorf("%w: %s doesn't allow control plane machines", errHostNotAllowed, failureDomain)
+	}
+
+	if p.pool.MaxControlPlanePerHost != nil && usage.controlPlane >= int(*p.pool.MaxControlPlanePerHost) {
+		return fmt.Errorf("%w: %s has the maximum number of control plane machines", errHostNotAllowed, failureDomain)
+	}
+
+	return nil
+}
+
+// workerHosts returns the failure domains that control plane machines can't be placed on.
+func (p *placement) workerHosts(failureDomains []string) ([]string, error) {
+	workers := []string{}
+
+	for _, failureDomain := range failureDomains {
+		host, ok := p.host(failureDomain)
+		if !ok {
+			workers = append(workers, failureDomain)
+
+			continue
+		}
+
+		controlPlane, err := p.pool.AllowsControlPlane(host)
+		if err != nil {
+			return nil, err
+		}
+
+		if !controlPlane {
+			workers = append(workers, failureDomain)
+		}
+	}
+
+	return workers, nil
+}
+
+// host returns the host of the static pool for the failure domain.
+func (p *placement) host(failureDomain string) (infrav1.MicrovmHost, bool) {
+	if p.pool == nil {
+		return infrav1.MicrovmHost{}, false
+	}
+
+	for _, host := range p.pool.Hosts {
+		if host.Endpoint == failureDomain {
+			return host, true
+		}
+	}
+
+	return infrav1.MicrovmHost{}, false
+}
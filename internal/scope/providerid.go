@@ -97,6 +97,19 @@ func (p *ProviderID) ID() string {
 	return p.id
 }
 
+// Host returns the host segment of a microvm ProviderID, which is the address of the flintlock
+// host for a ProviderID of the form microvm://<host>/<id>. It's empty if there's no host segment.
+func (p *ProviderID) Host() string {
+	segments := strings.TrimPrefix(p.original, p.cloudProvider+"://")
+
+	lastSlashIndex := strings.LastIndex(segments, "/")
+	if lastSlashIndex == -1 {
+		return ""
+	}
+
+	return segments[:lastSlashIndex]
+}
+
 // Equals returns true if this ProviderID string matches another ProviderID string.
 //
 // Deprecated: This method is going to be removed in a future release.
@@ -0,0 +1,127 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+)
+
+const (
+	proxyUsernameKey = "username"
+	proxyPasswordKey = "password"
+)
+
+// getMicrovmProxy returns the proxy used to connect to the host at addr, or nil if the host is
+// connected to directly. The proxy of the host takes precedence over the proxy of the MvmCluster.
+func getMicrovmProxy(
+	ctx context.Context,
+	c client.Client,
+	mvmCluster *infrav1.MicrovmCluster,
+	addr string,
+) (*flclient.Proxy, error) {
+	proxy := hostProxy(mvmCluster, addr)
+	if proxy == nil {
+		proxy = mvmCluster.Spec.MicrovmProxy
+		if proxy == nil || matchesNoProxy(proxy.NoProxy, addr) {
+			return nil, nil
+		}
+	}
+
+	clientProxy := &flclient.Proxy{Endpoint: proxy.Endpoint}
+
+	if proxy.CredentialsSecretRef == "" {
+		return clientProxy, nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: proxy.CredentialsSecretRef, Namespace: mvmCluster.Namespace}, secret); err != nil {
+		return nil, fmt.Errorf("getting proxy credentials secret %s: %w", proxy.CredentialsSecretRef, err)
+	}
+
+	username, ok := secret.Data[proxyUsernameKey]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errMissingProxyCredentials, proxyUsernameKey)
+	}
+
+	clientProxy.Username = string(username)
+	clientProxy.Password = string(secret.Data[proxyPasswordKey])
+
+	return clientProxy, nil
+}
+
+// hostProxy returns the proxy override of the host in the static pool with the endpoint addr.
+func hostProxy(mvmCluster *infrav1.MicrovmCluster, addr string) *infrav1.MicrovmProxy {
+	if mvmCluster.Spec.Placement.StaticPool == nil {
+		return nil
+	}
+
+	for _, host := range mvmCluster.Spec.Placement.StaticPool.Hosts {
+		if host.Endpoint == addr {
+			return host.Proxy
+		}
+	}
+
+	return nil
+}
+
+// matchesNoProxy returns true if addr matches one of the entries of the no proxy list.
+func matchesNoProxy(noProxy []string, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+
+			continue
+		}
+
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+
+			continue
+		}
+
+		domain := strings.TrimPrefix(entryHost, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
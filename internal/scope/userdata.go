@@ -0,0 +1,186 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package scope
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+const (
+	cloudConfigContentType = "text/cloud-config"
+	shellScriptContentType = "text/x-shellscript"
+	gzipContentType        = "application/x-gzip"
+
+	// cloudInitMergeType makes cloud-init append to the lists in the bootstrap data, for example
+	// write_files and runcmd, instead of replacing them with the lists in a fragment.
+	cloudInitMergeType = "list(append)+dict(no_replace,recurse_list)+str()"
+)
+
+// addUserDataFragments combines the bootstrap data with the user-data fragments into a MIME
+// multi-part archive that is processed by cloud-init.
+func addUserDataFragments(
+	ctx context.Context,
+	c client.Client,
+	namespace string,
+	bootstrapData []byte,
+	fragments []infrav1.UserDataFragment,
+) ([]byte, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	bootstrapContentType := cloudConfigContentType
+	if bytes.HasPrefix(bootstrapData, []byte("#!")) {
+		bootstrapContentType = shellScriptContentType
+	}
+
+	if err := writeUserDataPart(writer, bootstrapContentType, bootstrapData); err != nil {
+		return nil, err
+	}
+
+	for i := range fragments {
+		data, err := getUserDataFragment(ctx, c, namespace, &fragments[i])
+		if err != nil {
+			return nil, err
+		}
+
+		contentType := fragments[i].ContentType
+		if contentType == "" {
+			contentType = cloudConfigContentType
+		}
+
+		if err := writeUserDataPart(writer, contentType, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return closeMultipart(writer, body)
+}
+
+// compressUserData gzip compresses the user-data and puts it in a MIME multi-part archive with an
+// application/x-gzip part, which cloud-init decompresses before processing its content.
+func compressUserData(userData []byte) ([]byte, error) {
+	compressed := &bytes.Buffer{}
+
+	gzipWriter := gzip.NewWriter(compressed)
+	if _, err := gzipWriter.Write(userData); err != nil {
+		return nil, fmt.Errorf("compressing user data: %w", err)
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("compressing user data: %w", err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", gzipContentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", `attachment; filename="user-data.gz"`)
+	header.Set("MIME-Version", "1.0")
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, fmt.Errorf("creating user data part: %w", err)
+	}
+
+	encoder := base64.NewEncoder(base64.StdEncoding, part)
+	if _, err := encoder.Write(compressed.Bytes()); err != nil {
+		return nil, fmt.Errorf("writing user data part: %w", err)
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("writing user data part: %w", err)
+	}
+
+	return closeMultipart(writer, body)
+}
+
+// closeMultipart closes the writer and adds the headers of the archive to its body.
+func closeMultipart(writer *multipart.Writer, body *bytes.Buffer) ([]byte, error) {
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("closing multi-part user data: %w", err)
+	}
+
+	archive := &bytes.Buffer{}
+	fmt.Fprintf(archive, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n", writer.Boundary())
+	fmt.Fprintf(archive, "MIME-Version: 1.0\r\n\r\n")
+	archive.Write(body.Bytes())
+
+	return archive.Bytes(), nil
+}
+
+func writeUserDataPart(writer *multipart.Writer, contentType string, data []byte) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType+"; charset=\"utf-8\"")
+	header.Set("MIME-Version", "1.0")
+
+	if contentType == cloudConfigContentType {
+		header.Set("Merge-Type", cloudInitMergeType)
+	}
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("creating user data part: %w", err)
+	}
+
+	if _, err := part.Write(data); err != nil {
+		return fmt.Errorf("writing user data part: %w", err)
+	}
+
+	return nil
+}
+
+// getUserDataFragment reads the content of a fragment from the referenced Secret or ConfigMap.
+func getUserDataFragment(
+	ctx context.Context,
+	c client.Client,
+	namespace string,
+	fragment *infrav1.UserDataFragment,
+) ([]byte, error) {
+	switch {
+	case fragment.SecretRef != nil && fragment.ConfigMapRef == nil:
+		secret := &corev1.Secret{}
+		key := client.ObjectKey{Namespace: namespace, Name: fragment.SecretRef.Name}
+
+		if err := c.Get(ctx, key, secret); err != nil {
+			return nil, fmt.Errorf("getting user data fragment secret %s: %w", key, err)
+		}
+
+		data, ok := secret.Data[fragment.SecretRef.Key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s in secret %s", errMissingUserDataFragmentKey, fragment.SecretRef.Key, key)
+		}
+
+		return data, nil
+	case fragment.ConfigMapRef != nil && fragment.SecretRef == nil:
+		configMap := &corev1.ConfigMap{}
+		key := client.ObjectKey{Namespace: namespace, Name: fragment.ConfigMapRef.Name}
+
+		if err := c.Get(ctx, key, configMap); err != nil {
+			return nil, fmt.Errorf("getting user data fragment config map %s: %w", key, err)
+		}
+
+		data, ok := configMap.Data[fragment.ConfigMapRef.Key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s in config map %s", errMissingUserDataFragmentKey, fragment.ConfigMapRef.Key, key)
+		}
+
+		return []byte(strings.TrimSpace(data) + "\n"), nil
+	default:
+		return nil, errInvalidUserDataFragment
+	}
+}
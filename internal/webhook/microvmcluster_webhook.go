@@ -46,6 +46,15 @@ func (r *MicrovmCluster) ValidateCreate(_ context.Context, obj runtime.Object) (
 	}
 
 	allErrs := cluster.Spec.Placement.Validate()
+	allErrs = append(allErrs, cluster.Spec.ValidateMACAddressPrefix()...)
+	allErrs = append(allErrs, cluster.Spec.ValidateInstanceMetadata()...)
+	allErrs = append(allErrs, cluster.Spec.ValidateIdentityRef()...)
+	allErrs = append(allErrs, cluster.Spec.ValidateAuth()...)
+	allErrs = append(allErrs, cluster.Spec.ValidateProxy()...)
+	if cluster.Spec.LoadBalancer != nil {
+		allErrs = append(allErrs, cluster.Spec.LoadBalancer.Validate()...)
+	}
+
 	if len(allErrs) > 0 {
 		warnings = append(warnings, fmt.Sprintf("cannot create microvm cluster %s", cluster.GetName()))
 		return warnings, apierrors.NewInvalid(
@@ -64,7 +73,38 @@ func (r *MicrovmCluster) ValidateDelete(_ context.Context, _ runtime.Object) (ad
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
-func (r *MicrovmCluster) ValidateUpdate(_ context.Context, _ runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+func (r *MicrovmCluster) ValidateUpdate(_ context.Context, oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	cluster, ok := newObj.(*infrav1.MicrovmCluster)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MicrovmCluster but got %T", newObj))
+	}
+
+	oldCluster, ok := oldObj.(*infrav1.MicrovmCluster)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MicrovmCluster but got %T", oldObj))
+	}
+
+	allErrs := cluster.Spec.ValidateMACAddressPrefix()
+	allErrs = append(allErrs, cluster.Spec.ValidateInstanceMetadata()...)
+	allErrs = append(allErrs, cluster.Spec.ValidateIdentityRef()...)
+	allErrs = append(allErrs, cluster.Spec.ValidateAuth()...)
+	allErrs = append(allErrs, cluster.Spec.ValidateProxyUpdate(&oldCluster.Spec)...)
+	if cluster.Spec.Placement.StaticPool != nil {
+		allErrs = append(allErrs, cluster.Spec.Placement.Validate()...)
+	}
+
+	if cluster.Spec.LoadBalancer != nil {
+		allErrs = append(allErrs, cluster.Spec.LoadBalancer.Validate()...)
+	}
+
+	if len(allErrs) > 0 {
+		return nil, apierrors.NewInvalid(
+			cluster.GroupVersionKind().GroupKind(),
+			cluster.Name,
+			allErrs,
+		)
+	}
+
 	return nil, nil
 }
 
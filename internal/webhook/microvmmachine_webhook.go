@@ -8,7 +8,7 @@ import (
 	"reflect"
 	"context"
 	"k8s.io/apimachinery/pkg/runtime"
-	// "k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -40,6 +40,19 @@ var (
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
 func (r *MicrovmMachine) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	machine, ok := obj.(*infrav1.MicrovmMachine)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MicrovmMachine but got %T", obj))
+	}
+
+	if allErrs := machine.Spec.Validate(field.NewPath("spec")); len(allErrs) > 0 {
+		return nil, apierrors.NewInvalid(
+			machine.GroupVersionKind().GroupKind(),
+			machine.Name,
+			allErrs,
+		)
+	}
+
 	return nil, nil
 }
 
@@ -71,12 +84,11 @@ func (r *MicrovmMachine) ValidateUpdate(_ context.Context, oldObj, newObj runtim
 
 // Default satisfies the defaulting webhook interface.
 func (r *MicrovmMachine) Default(_ context.Context, obj runtime.Object) error {
-	machine, ok := obj.(*infrav1.MicrovmMachine)
-	if !ok {
+	// The mac addresses of the network interfaces are no longer defaulted here, they are allocated
+	// by the controller so that they are unique.
+	if _, ok := obj.(*infrav1.MicrovmMachine); !ok {
 		return apierrors.NewBadRequest(fmt.Sprintf("expected a MicrovmMachine but got a %T", obj))
 	}
-	
-	infrav1.SetObjectDefaults_MicrovmMachine(machine)
-	
+
 	return nil
 }
@@ -0,0 +1,83 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+var machinePoolLog = logf.Log.WithName("microvmmachinepool-resource")
+
+type MicrovmMachinePool struct{}
+
+func (r *MicrovmMachinePool) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&infrav1.MicrovmMachinePool{}).
+		WithValidator(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-infrastructure-cluster-x-k8s-io-v1alpha1-microvmmachinepool,mutating=false,failurePolicy=fail,matchPolicy=Equivalent,groups=infrastructure.cluster.x-k8s.io,resources=microvmmachinepools,versions=v1alpha1,name=validation.microvmmachinepool.infrastructure.cluster.x-k8s.io,sideEffects=None,admissionReviewVersions=v1
+
+var _ webhook.CustomValidator = &MicrovmMachinePool{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
+func (r *MicrovmMachinePool) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	pool, ok := obj.(*infrav1.MicrovmMachinePool)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MicrovmMachinePool but got %T", obj))
+	}
+
+	machinePoolLog.Info("validate create", "name", pool.Name)
+
+	return nil, validateMachinePool(pool)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
+func (r *MicrovmMachinePool) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+func (r *MicrovmMachinePool) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	pool, ok := newObj.(*infrav1.MicrovmMachinePool)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MicrovmMachinePool but got %T", newObj))
+	}
+
+	machinePoolLog.Info("validate update", "name", pool.Name)
+
+	return nil, validateMachinePool(pool)
+}
+
+// validateMachinePool checks that the spec can be shared by all the microvms in the pool.
+func validateMachinePool(pool *infrav1.MicrovmMachinePool) error {
+	var allErrs field.ErrorList
+
+	for i, iface := range pool.Spec.NetworkInterfaces {
+		if iface.GuestMAC != "" {
+			allErrs = append(allErrs, field.Forbidden(
+				field.NewPath("spec", "networkInterfaces").Index(i).Child("guestMac"),
+				"a mac address can't be set as it would be shared by every microvm in the pool",
+			))
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(infrav1.GroupVersion.WithKind("MicrovmMachinePool").GroupKind(), pool.Name, allErrs)
+}
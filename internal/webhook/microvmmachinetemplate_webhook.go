@@ -4,9 +4,11 @@
 package webhook
 
 import (
-	// "fmt"
+	"fmt"
 	"context"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -32,6 +34,22 @@ var _ webhook.CustomValidator = &MicrovmMachineTemplate{}
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
 func (r *MicrovmMachineTemplate) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	template, ok := obj.(*infrav1.MicrovmMachineTemplate)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MicrovmMachineTemplate but got %T", obj))
+	}
+
+	allErrs := template.Spec.Template.Spec.Validate(field.NewPath("spec", "template", "spec"))
+	allErrs = append(allErrs, template.Spec.Validate(field.NewPath("spec"))...)
+
+	if len(allErrs) > 0 {
+		return nil, apierrors.NewInvalid(
+			template.GroupVersionKind().GroupKind(),
+			template.Name,
+			allErrs,
+		)
+	}
+
 	return nil, nil
 }
 
@@ -42,5 +60,19 @@ func (r *MicrovmMachineTemplate) ValidateDelete(_ context.Context, _ runtime.Obj
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
 func (r *MicrovmMachineTemplate) ValidateUpdate(_ context.Context, _ runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	template, ok := newObj.(*infrav1.MicrovmMachineTemplate)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MicrovmMachineTemplate but got %T", newObj))
+	}
+
+	// The node labels and taints can be changed as they're only used by the cluster autoscaler.
+	if allErrs := template.Spec.Validate(field.NewPath("spec")); len(allErrs) > 0 {
+		return nil, apierrors.NewInvalid(
+			template.GroupVersionKind().GroupKind(),
+			template.Name,
+			allErrs,
+		)
+	}
+
 	return nil, nil
 }
@@ -24,9 +24,9 @@ import (
 	"os"
 	"time"
 
-	client "github.com/liquidmetal-dev/controller-pkg/client"
 	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
@@ -36,19 +36,23 @@ import (
 	"k8s.io/klog/v2"
 	"k8s.io/utils/ptr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/controllers/clustercache"
+	"sigs.k8s.io/cluster-api/controllers/remote"
 	expclusterv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util/flags"
 	"sigs.k8s.io/cluster-api/util/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
-	
+
 	webhookMicro "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/webhook"
 
 	//+kubebuilder:scaffold:imports
 	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/controllers"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
 	"github.com/liquidmetal-dev/cluster-api-provider-microvm/version"
 )
 
@@ -58,6 +62,7 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 	_ = clusterv1.AddToScheme(scheme)
 	_ = expclusterv1.AddToScheme(scheme)
+	_ = ipamv1.AddToScheme(scheme)
 	//+kubebuilder:scaffold:scheme
 
 	_ = "comment can't be at the end of the function"
@@ -77,6 +82,11 @@ var (
 	webhookCertDir              string
 	microvmClusterConcurrency   int
 	microvmMachineConcurrency   int
+	enableMachinePools          bool
+	microvmMetadataSizeLimit    int
+	certExpiryWarningWindow     time.Duration
+	serviceAccountNamespace     string
+	serviceAccountName          string
 	webhookPort                 int
 	syncPeriod                  time.Duration
 	leaderElectionLeaseDuration time.Duration
@@ -94,6 +104,9 @@ const (
 	defaultSyncPeriod          = 10 * time.Minute
 	defaultWebhookPort         = 9443
 	defaultEventBurstSize      = 100
+	// defaultMetadataSizeLimit disables the metadata size check, the limit of the firecracker
+	// metadata service is 51200 bytes.
+	defaultMetadataSizeLimit = 0
 )
 
 func initFlags(fs *pflag.FlagSet) {
@@ -172,6 +185,36 @@ func initFlags(fs *pflag.FlagSet) {
 		"Number of MicrovmMachines to process simultaneously",
 	)
 
+	fs.BoolVar(&enableMachinePools,
+		"enable-machine-pools",
+		false,
+		"Enable support for MicrovmMachinePools, requires the MachinePool feature to be enabled in Cluster API",
+	)
+
+	fs.IntVar(&microvmMetadataSizeLimit,
+		"microvm-metadata-size-limit",
+		defaultMetadataSizeLimit,
+		"Maximum size in bytes of all the metadata of a microvm as JSON, including the base64 encoded user-data, vendor-data and meta-data. The firecracker metadata service limit is 51200 bytes. Disabled by default (0)",
+	)
+
+	fs.DurationVar(&certExpiryWarningWindow,
+		"cert-expiry-warning-window",
+		controllers.DefaultCertificateExpiryWarningWindow,
+		"How long before a client certificate for the flintlock hosts expires that a warning condition is set on the MicrovmCluster",
+	)
+
+	fs.StringVar(&serviceAccountNamespace,
+		"service-account-namespace",
+		"",
+		"The namespace of the service account of the controller, whose tokens are used to authenticate with the flintlock hosts with the ServiceAccountToken and TokenExchange auth types",
+	)
+
+	fs.StringVar(&serviceAccountName,
+		"service-account-name",
+		"",
+		"The name of the service account of the controller, whose tokens are used to authenticate with the flintlock hosts with the ServiceAccountToken and TokenExchange auth types",
+	)
+
 	fs.DurationVar(&syncPeriod,
 		"sync-period",
 		defaultSyncPeriod,
@@ -318,23 +361,74 @@ func setupReconcilers(ctx context.Context, mgr ctrl.Manager) error {
 		RecoverPanic:            ptr.To[bool](true),
 	}
 
+	serviceAccount := types.NamespacedName{Namespace: serviceAccountNamespace, Name: serviceAccountName}
+
 	if err := (&controllers.MicrovmClusterReconciler{
 		Client:           mgr.GetClient(),
 		Scheme:           mgr.GetScheme(),
 		Recorder:         mgr.GetEventRecorderFor("microvmcluster-controller"),
 		WatchFilterValue: watchFilterValue,
+		MvmClientFunc:    flclient.NewClient,
+		ServiceAccount:   serviceAccount,
+
+		CertificateExpiryWarningWindow: certExpiryWarningWindow,
 	}).SetupWithManager(ctx, mgr, managerOptions); err != nil {
 		return fmt.Errorf("unable to create microvm cluster controller: %w", err)
 	}
 
 	if err := (&controllers.MicrovmMachineReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("microvmmachine-controller"),
+		WatchFilterValue:  watchFilterValue,
+		MvmClientFunc:     flclient.NewClient,
+		MetadataSizeLimit: microvmMetadataSizeLimit,
+		ServiceAccount:    serviceAccount,
+	}).SetupWithManager(ctx, mgr, managerOptions); err != nil {
+		return fmt.Errorf("unable to create microvm machine controller: %w", err)
+	}
+
+	clusterCache, err := clustercache.SetupWithManager(ctx, mgr, clustercache.Options{
+		SecretClient:     mgr.GetClient(),
+		WatchFilterValue: watchFilterValue,
+		Cache: clustercache.CacheOptions{
+			Indexes: []clustercache.CacheOptionsIndex{clustercache.NodeProviderIDIndex},
+		},
+		Client: clustercache.ClientOptions{
+			UserAgent: remote.DefaultClusterAPIUserAgent("cluster-api-provider-microvm-manager"),
+		},
+	}, managerOptions)
+	if err != nil {
+		return fmt.Errorf("unable to create cluster cache: %w", err)
+	}
+
+	if err := (&controllers.MicrovmNodeReconciler{
 		Client:           mgr.GetClient(),
-		Scheme:           mgr.GetScheme(),
-		Recorder:         mgr.GetEventRecorderFor("microvmmachine-controller"),
 		WatchFilterValue: watchFilterValue,
-		MvmClientFunc:    client.NewFlintlockClient,
+		ClusterCache:     clusterCache,
 	}).SetupWithManager(ctx, mgr, managerOptions); err != nil {
-		return fmt.Errorf("unable to create microvm machine controller: %w", err)
+		return fmt.Errorf("unable to create microvm node controller: %w", err)
+	}
+
+	if err := (&controllers.MicrovmMachineTemplateReconciler{
+		Client:           mgr.GetClient(),
+		WatchFilterValue: watchFilterValue,
+	}).SetupWithManager(ctx, mgr, managerOptions); err != nil {
+		return fmt.Errorf("unable to create microvm machine template controller: %w", err)
+	}
+
+	if enableMachinePools {
+		if err := (&controllers.MicrovmMachinePoolReconciler{
+			Client:            mgr.GetClient(),
+			Scheme:            mgr.GetScheme(),
+			Recorder:          mgr.GetEventRecorderFor("microvmmachinepool-controller"),
+			WatchFilterValue:  watchFilterValue,
+			MvmClientFunc:     flclient.NewClient,
+			MetadataSizeLimit: microvmMetadataSizeLimit,
+			ServiceAccount:    serviceAccount,
+		}).SetupWithManager(ctx, mgr, managerOptions); err != nil {
+			return fmt.Errorf("unable to create microvm machine pool controller: %w", err)
+		}
 	}
 
 	return nil
@@ -353,6 +447,12 @@ func setupWebhooks(mgr ctrl.Manager) error {
 		return fmt.Errorf("unable to setup MicrovmMachineTemplate webhook:%w", err)
 	}
 
+	if enableMachinePools {
+		if err := (&webhookMicro.MicrovmMachinePool{}).SetupWebhookWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to setup MicrovmMachinePool webhook:%w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,125 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// The flintlocksim binary runs an in-memory flintlock host, which can be used as a host of a
+// MicrovmCluster to try out the full lifecycle of a cluster without bare metal. The microvms
+// never boot so the clusters don't become ready.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"github.com/spf13/pflag"
+
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/test/flintlocksim"
+)
+
+var errInvalidClientCA = errors.New("no certificates found in client ca")
+
+type options struct {
+	address         string
+	basicAuthToken  string
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsClientCAFile string
+	createDelay     time.Duration
+	deleteDelay     time.Duration
+	failPrefix      string
+}
+
+func main() {
+	opts := options{}
+
+	pflag.StringVar(&opts.address, "address", "127.0.0.1:9090", "The address to serve the flintlock api on.")
+	pflag.StringVar(&opts.basicAuthToken, "basic-auth-token", "", "Require clients to use basic auth with this token.")
+	pflag.StringVar(&opts.tlsCertFile, "tls-cert-file", "", "The certificate to serve the api over TLS with.")
+	pflag.StringVar(&opts.tlsKeyFile, "tls-key-file", "", "The key of the TLS certificate.")
+	pflag.StringVar(&opts.tlsClientCAFile, "tls-client-ca-file", "",
+		"Require clients to authenticate with a certificate signed by this CA.")
+	pflag.DurationVar(&opts.createDelay, "create-delay", 5*time.Second, "How long new microvms stay PENDING.")
+	pflag.DurationVar(&opts.deleteDelay, "delete-delay", 5*time.Second, "How long deleted microvms stay DELETING.")
+	pflag.StringVar(&opts.failPrefix, "fail-prefix", "",
+		"Microvms with a name that starts with this prefix move to FAILED instead of CREATED.")
+	pflag.Parse()
+
+	if err := run(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "flintlocksim: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts options) error {
+	serverOpts := []flintlocksim.Option{
+		flintlocksim.WithBasicAuth(opts.basicAuthToken),
+		flintlocksim.WithCreateDelay(opts.createDelay),
+		flintlocksim.WithDeleteDelay(opts.deleteDelay),
+	}
+
+	if opts.failPrefix != "" {
+		serverOpts = append(serverOpts, flintlocksim.WithCreateFailure(func(spec *flintlocktypes.MicroVMSpec) bool {
+			return strings.HasPrefix(spec.GetId(), opts.failPrefix)
+		}))
+	}
+
+	if opts.tlsCertFile != "" {
+		tlsConfig, err := loadTLS(opts)
+		if err != nil {
+			return err
+		}
+
+		serverOpts = append(serverOpts, flintlocksim.WithTLS(tlsConfig))
+	}
+
+	server := flintlocksim.New(serverOpts...)
+
+	address, err := server.Start(opts.address)
+	if err != nil {
+		return err
+	}
+	defer server.Stop()
+
+	fmt.Fprintf(os.Stdout, "serving flintlock api on %s\n", address)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	return nil
+}
+
+func loadTLS(opts options) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(opts.tlsCertFile, opts.tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading tls certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if opts.tlsClientCAFile != "" {
+		caCert, err := os.ReadFile(opts.tlsClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client ca: %w", err)
+		}
+
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caCert) {
+			return nil, errInvalidClientCA
+		}
+
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
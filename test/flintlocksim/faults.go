@@ -0,0 +1,139 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package flintlocksim
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Fault is injected into a request to simulate an unreliable host or network.
+type Fault struct {
+	// Delay is how long the request waits before it's handled. The request is abandoned if the
+	// client gives up while it's waiting.
+	Delay time.Duration
+	// Err is returned to the client instead of the response.
+	Err error
+	// Handled handles the request before Err is returned, as if the response was lost on the
+	// way back to the client. Streams are dropped after the first microvm has been sent.
+	Handled bool
+}
+
+// FaultFunc returns the fault to inject into a request for the gRPC method, which is one of
+// the flintlockv1.MicroVM_*_FullMethodName constants. The zero Fault injects nothing. It's
+// called concurrently by the requests.
+type FaultFunc func(method string) Fault
+
+// WithFaults injects the faults returned by f into the requests.
+func WithFaults(f FaultFunc) Option {
+	return func(s *Server) {
+		s.faults = f
+	}
+}
+
+// SetFaults replaces the function used to inject faults into the requests. Faults are no longer
+// injected if f is nil.
+func (s *Server) SetFaults(f FaultFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = f
+}
+
+// fault returns the fault to inject into a request for the method.
+func (s *Server) fault(method string) Fault {
+	s.mu.Lock()
+	faults := s.faults
+	s.mu.Unlock()
+
+	if faults == nil {
+		return Fault{}
+	}
+
+	return faults(method)
+}
+
+// delay waits for the delay of the fault or until the request is cancelled.
+func (f Fault) delay(ctx context.Context) error {
+	if f.Delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(f.Delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (s *Server) faultUnary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	fault := s.fault(info.FullMethod)
+
+	if err := fault.delay(ctx); err != nil {
+		return nil, err
+	}
+
+	if fault.Err == nil {
+		return handler(ctx, req)
+	}
+
+	if fault.Handled {
+		_, _ = handler(ctx, req)
+	}
+
+	return nil, fault.Err
+}
+
+func (s *Server) faultStream(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	fault := s.fault(info.FullMethod)
+
+	if err := fault.delay(stream.Context()); err != nil {
+		return err
+	}
+
+	if fault.Err == nil {
+		return handler(srv, stream)
+	}
+
+	if !fault.Handled {
+		return fault.Err
+	}
+
+	return handler(srv, &droppedStream{ServerStream: stream, err: fault.Err})
+}
+
+// droppedStream fails after the first message has been sent.
+type droppedStream struct {
+	grpc.ServerStream
+
+	err  error
+	sent bool
+}
+
+// SendMsg sends the first message and fails for the rest.
+func (d *droppedStream) SendMsg(m interface{}) error {
+	if d.sent {
+		return d.err
+	}
+
+	d.sent = true
+
+	return d.ServerStream.SendMsg(m)
+}
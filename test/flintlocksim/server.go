@@ -0,0 +1,435 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package flintlocksim contains an in-memory flintlock host. It implements the flintlock microvm
+// gRPC service so the controllers can be tested against a real connection without running
+// flintlock on bare metal. The microvms only exist in memory and move through their states based
+// on time, which can be controlled with a fake clock.
+package flintlocksim
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/utils/clock"
+)
+
+var errServerStarted = errors.New("server has already been started")
+
+// FailureFunc decides if the creation of a microvm fails.
+type FailureFunc func(spec *flintlocktypes.MicroVMSpec) bool
+
+// Option configures the server.
+type Option func(*Server)
+
+// WithBasicAuth requires the clients to authenticate using basic auth with the token.
+func WithBasicAuth(token string) Option {
+	return func(s *Server) {
+		s.basicAuthToken = token
+	}
+}
+
+// WithTLS serves the requests over TLS. Set ClientAuth and ClientCAs in the config to require
+// the clients to authenticate with a certificate.
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tls = cfg
+	}
+}
+
+// WithCreateDelay sets how long a new microvm stays PENDING before it's CREATED.
+func WithCreateDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.createDelay = d
+	}
+}
+
+// WithDeleteDelay sets how long a microvm stays DELETING before it's removed.
+func WithDeleteDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.deleteDelay = d
+	}
+}
+
+// WithCreateFailure moves the microvms that f returns true for to FAILED instead of CREATED.
+func WithCreateFailure(f FailureFunc) Option {
+	return func(s *Server) {
+		s.createFailure = f
+	}
+}
+
+// WithClock sets the clock used for the state transitions.
+func WithClock(c clock.PassiveClock) Option {
+	return func(s *Server) {
+		s.clock = c
+	}
+}
+
+// microvm is a microvm stored by the server.
+type microvm struct {
+	mvm       *flintlocktypes.MicroVM
+	createdAt time.Time
+	deletedAt *time.Time
+	fails     bool
+	// forced is set when the state was set with SetState, which stops the normal transitions.
+	forced bool
+}
+
+// Server is an in-memory flintlock host.
+type Server struct {
+	flintlockv1.UnimplementedMicroVMServer
+
+	basicAuthToken string
+	tls            *tls.Config
+	createDelay    time.Duration
+	deleteDelay    time.Duration
+	createFailure  FailureFunc
+	clock          clock.PassiveClock
+	faults         FaultFunc
+
+	mu       sync.Mutex
+	microvms map[string]*microvm
+	grpc     *grpc.Server
+}
+
+// New creates a server. New microvms are CREATED straight away and deleted microvms are
+// removed straight away unless delays are set.
+func New(opts ...Option) *Server {
+	s := &Server{
+		clock:    clock.RealClock{},
+		microvms: map[string]*microvm{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Start listens on address and serves the requests in the background. It returns the address
+// the server is listening on, which is useful when address uses port 0.
+func (s *Server) Start(address string) (string, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return "", fmt.Errorf("listening on %s: %w", address, err)
+	}
+
+	go func() {
+		_ = s.Serve(listener)
+	}()
+
+	return listener.Addr().String(), nil
+}
+
+// Serve serves the requests from the listener until the server is stopped.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.grpc != nil {
+		s.mu.Unlock()
+
+		return errServerStarted
+	}
+
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.authUnary, s.faultUnary),
+		grpc.ChainStreamInterceptor(s.authStream, s.faultStream),
+	}
+
+	if s.tls != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(s.tls)))
+	}
+
+	s.grpc = grpc.NewServer(serverOpts...)
+	flintlockv1.RegisterMicroVMServer(s.grpc, s)
+	s.mu.Unlock()
+
+	return s.grpc.Serve(listener)
+}
+
+// Stop stops the server and closes the open connections.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.grpc != nil {
+		s.grpc.Stop()
+	}
+}
+
+// CreateMicroVM stores a new microvm in the PENDING state.
+func (s *Server) CreateMicroVM(
+	_ context.Context,
+	req *flintlockv1.CreateMicroVMRequest,
+) (*flintlockv1.CreateMicroVMResponse, error) {
+	if req.GetMicrovm() == nil {
+		return nil, status.Error(codes.InvalidArgument, "microvm spec is required")
+	}
+
+	spec, ok := proto.Clone(req.GetMicrovm()).(*flintlocktypes.MicroVMSpec)
+	if !ok {
+		return nil, status.Error(codes.Internal, "copying microvm spec")
+	}
+
+	if spec.GetId() == "" {
+		spec.Id = uuid.NewString()
+	}
+
+	if spec.GetNamespace() == "" {
+		return nil, status.Error(codes.InvalidArgument, "microvm namespace is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.prune(now)
+
+	for _, existing := range s.microvms {
+		if existing.mvm.GetSpec().GetId() == spec.GetId() && existing.mvm.GetSpec().GetNamespace() == spec.GetNamespace() {
+			return nil, status.Errorf(codes.AlreadyExists, "microvm %s/%s already exists", spec.GetNamespace(), spec.GetId())
+		}
+	}
+
+	uid := uuid.NewString()
+	spec.Uid = &uid
+	spec.CreatedAt = timestamppb.New(now)
+
+	stored := &microvm{
+		mvm: &flintlocktypes.MicroVM{
+			Version: 1,
+			Spec:    spec,
+			Status:  &flintlocktypes.MicroVMStatus{},
+		},
+		createdAt: now,
+		fails:     s.createFailure != nil && s.createFailure(spec),
+	}
+	s.microvms[uid] = stored
+
+	return &flintlockv1.CreateMicroVMResponse{Microvm: s.snapshot(stored, now)}, nil
+}
+
+// DeleteMicroVM moves the microvm to the DELETING state. Deleting a microvm that doesn't exist
+// isn't an error.
+func (s *Server) DeleteMicroVM(_ context.Context, req *flintlockv1.DeleteMicroVMRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.prune(now)
+
+	stored, ok := s.microvms[req.GetUid()]
+	if ok && (stored.deletedAt == nil || stored.forced) {
+		stored.forced = false
+		stored.deletedAt = &now
+		stored.mvm.Spec.DeletedAt = timestamppb.New(now)
+		s.prune(now)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// GetMicroVM returns the microvm with the uid.
+func (s *Server) GetMicroVM(_ context.Context, req *flintlockv1.GetMicroVMRequest) (*flintlockv1.GetMicroVMResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.prune(now)
+
+	stored, ok := s.microvms[req.GetUid()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "microvm spec %s not found", req.GetUid())
+	}
+
+	return &flintlockv1.GetMicroVMResponse{Microvm: s.snapshot(stored, now)}, nil
+}
+
+// ListMicroVMs returns the microvms in the namespace, or all the microvms if the namespace is
+// empty. The microvms can also be filtered by name.
+func (s *Server) ListMicroVMs(
+	_ context.Context,
+	req *flintlockv1.ListMicroVMsRequest,
+) (*flintlockv1.ListMicroVMsResponse, error) {
+	return &flintlockv1.ListMicroVMsResponse{Microvm: s.list(req)}, nil
+}
+
+// ListMicroVMsStream sends the same microvms as ListMicroVMs as a stream.
+func (s *Server) ListMicroVMsStream(
+	req *flintlockv1.ListMicroVMsRequest,
+	stream grpc.ServerStreamingServer[flintlockv1.ListMessage],
+) error {
+	for _, mvm := range s.list(req) {
+		if err := stream.Send(&flintlockv1.ListMessage{Microvm: mvm}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetState forces the microvm with the uid into a state. The microvm stays in the state until
+// it's deleted or the state is set again.
+func (s *Server) SetState(uid string, state flintlocktypes.MicroVMStatus_MicroVMState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.microvms[uid]
+	if !ok {
+		return status.Errorf(codes.NotFound, "microvm spec %s not found", uid)
+	}
+
+	stored.mvm.Status.State = state
+	stored.forced = true
+
+	return nil
+}
+
+// ResetState undoes SetState so the microvm with the uid moves through its states normally.
+func (s *Server) ResetState(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.microvms[uid]
+	if !ok {
+		return status.Errorf(codes.NotFound, "microvm spec %s not found", uid)
+	}
+
+	stored.forced = false
+
+	return nil
+}
+
+// MicroVMs returns a copy of all the microvms on the host.
+func (s *Server) MicroVMs() []*flintlocktypes.MicroVM {
+	return s.list(&flintlockv1.ListMicroVMsRequest{})
+}
+
+func (s *Server) list(req *flintlockv1.ListMicroVMsRequest) []*flintlocktypes.MicroVM {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.prune(now)
+
+	result := []*flintlocktypes.MicroVM{}
+
+	for _, stored := range s.microvms {
+		spec := stored.mvm.GetSpec()
+		if req.GetNamespace() != "" && spec.GetNamespace() != req.GetNamespace() {
+			continue
+		}
+
+		if req.Name != nil && spec.GetId() != req.GetName() {
+			continue
+		}
+
+		result = append(result, s.snapshot(stored, now))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetSpec().GetUid() < result[j].GetSpec().GetUid()
+	})
+
+	return result
+}
+
+// prune removes the microvms that have finished deleting.
+func (s *Server) prune(now time.Time) {
+	for uid, stored := range s.microvms {
+		if !stored.forced && stored.deletedAt != nil && !now.Before(stored.deletedAt.Add(s.deleteDelay)) {
+			delete(s.microvms, uid)
+		}
+	}
+}
+
+// snapshot updates the state of the microvm for the current time and returns a copy.
+func (s *Server) snapshot(stored *microvm, now time.Time) *flintlocktypes.MicroVM {
+	switch {
+	case stored.forced:
+	case stored.deletedAt != nil:
+		stored.mvm.Status.State = flintlocktypes.MicroVMStatus_DELETING
+	case now.Before(stored.createdAt.Add(s.createDelay)):
+		stored.mvm.Status.State = flintlocktypes.MicroVMStatus_PENDING
+	case stored.fails:
+		stored.mvm.Status.State = flintlocktypes.MicroVMStatus_FAILED
+	default:
+		stored.mvm.Status.State = flintlocktypes.MicroVMStatus_CREATED
+	}
+
+	mvm, _ := proto.Clone(stored.mvm).(*flintlocktypes.MicroVM)
+
+	return mvm
+}
+
+func (s *Server) authUnary(
+	ctx context.Context,
+	req interface{},
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *Server) authStream(
+	srv interface{},
+	stream grpc.ServerStream,
+	_ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := s.authenticate(stream.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, stream)
+}
+
+// authenticate checks the basic auth token in the request if the server requires one.
+func (s *Server) authenticate(ctx context.Context) error {
+	if s.basicAuthToken == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "request unauthenticated with basic")
+	}
+
+	scheme, encoded, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return status.Error(codes.Unauthenticated, "request unauthenticated with basic")
+	}
+
+	token, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || subtle.ConstantTimeCompare(token, []byte(s.basicAuthToken)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid auth token")
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package flintlocksim_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/test/flintlocksim"
+)
+
+func startServer(g *WithT, opts ...flintlocksim.Option) (*flintlocksim.Server, string) {
+	server := flintlocksim.New(opts...)
+
+	address, err := server.Start("127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	return server, address
+}
+
+func createRequest(name string) *flintlockv1.CreateMicroVMRequest {
+	return &flintlockv1.CreateMicroVMRequest{
+		Microvm: &flintlocktypes.MicroVMSpec{
+			Id:        name,
+			Namespace: "ns1",
+			Labels:    map[string]string{"cluster.x-k8s.io/cluster-name": "cluster1"},
+		},
+	}
+}
+
+func getState(ctx context.Context, g *WithT, client flclient.Client, uid string) flintlocktypes.MicroVMStatus_MicroVMState {
+	resp, err := client.GetMicroVM(ctx, &flintlockv1.GetMicroVMRequest{Uid: uid})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	return resp.GetMicrovm().GetStatus().GetState()
+}
+
+func TestServerLifecycle(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	clock := clocktesting.NewFakePassiveClock(time.Now())
+	server, address := startServer(g,
+		flintlocksim.WithClock(clock),
+		flintlocksim.WithCreateDelay(time.Minute),
+		flintlocksim.WithDeleteDelay(time.Minute),
+	)
+	defer server.Stop()
+
+	client, err := flclient.NewClient(address)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer client.Close()
+
+	created, err := client.CreateMicroVM(ctx, createRequest("mvm1"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(created.GetMicrovm().GetStatus().GetState()).To(Equal(flintlocktypes.MicroVMStatus_PENDING))
+
+	uid := created.GetMicrovm().GetSpec().GetUid()
+	g.Expect(uid).NotTo(BeEmpty())
+
+	_, err = client.CreateMicroVM(ctx, createRequest("mvm1"))
+	g.Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+
+	clock.SetTime(clock.Now().Add(time.Minute))
+	g.Expect(getState(ctx, g, client, uid)).To(Equal(flintlocktypes.MicroVMStatus_CREATED))
+
+	list, err := client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{Namespace: "ns1", Name: ptr.To("mvm1")})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(list.GetMicrovm()).To(HaveLen(1))
+
+	list, err = client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{Namespace: "ns2"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(list.GetMicrovm()).To(BeEmpty())
+
+	_, err = client.DeleteMicroVM(ctx, &flintlockv1.DeleteMicroVMRequest{Uid: uid})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(getState(ctx, g, client, uid)).To(Equal(flintlocktypes.MicroVMStatus_DELETING))
+
+	clock.SetTime(clock.Now().Add(time.Minute))
+
+	_, err = client.GetMicroVM(ctx, &flintlockv1.GetMicroVMRequest{Uid: uid})
+	g.Expect(status.Code(err)).To(Equal(codes.NotFound))
+	g.Expect(err.Error()).To(ContainSubstring("not found"))
+	g.Expect(server.MicroVMs()).To(BeEmpty())
+}
+
+func TestServerFailures(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	server, address := startServer(g, flintlocksim.WithCreateFailure(func(spec *flintlocktypes.MicroVMSpec) bool {
+		return strings.HasPrefix(spec.GetId(), "fail")
+	}))
+	defer server.Stop()
+
+	client, err := flclient.NewClient(address)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer client.Close()
+
+	failed, err := client.CreateMicroVM(ctx, createRequest("fail1"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(failed.GetMicrovm().GetStatus().GetState()).To(Equal(flintlocktypes.MicroVMStatus_FAILED))
+
+	created, err := client.CreateMicroVM(ctx, createRequest("mvm1"))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	uid := created.GetMicrovm().GetSpec().GetUid()
+	g.Expect(getState(ctx, g, client, uid)).To(Equal(flintlocktypes.MicroVMStatus_CREATED))
+
+	g.Expect(server.SetState(uid, flintlocktypes.MicroVMStatus_FAILED)).To(Succeed())
+	g.Expect(getState(ctx, g, client, uid)).To(Equal(flintlocktypes.MicroVMStatus_FAILED))
+
+	_, err = client.DeleteMicroVM(ctx, &flintlockv1.DeleteMicroVMRequest{Uid: uid})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(server.MicroVMs()).To(HaveLen(1))
+}
+
+func TestServerAuth(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	certs, err := flintlocksim.NewCertificates("127.0.0.1")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	serverTLS, err := certs.ServerTLS()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	server, address := startServer(g, flintlocksim.WithBasicAuth("secret"), flintlocksim.WithTLS(serverTLS))
+	defer server.Stop()
+
+	clientTLS := &flclient.TLSConfig{Cert: certs.ClientCert, Key: certs.ClientKey, CACert: certs.CACert}
+
+	tt := []struct {
+		name     string
+		opts     []flclient.Options
+		expected codes.Code
+	}{
+		{
+			name:     "valid credentials",
+			opts:     []flclient.Options{flclient.WithTLS(clientTLS), flclient.WithBasicAuth("secret")},
+			expected: codes.OK,
+		},
+		{
+			name:     "invalid token",
+			opts:     []flclient.Options{flclient.WithTLS(clientTLS), flclient.WithBasicAuth("wrong")},
+			expected: codes.Unauthenticated,
+		},
+		{
+			name:     "missing token",
+			opts:     []flclient.Options{flclient.WithTLS(clientTLS)},
+			expected: codes.Unauthenticated,
+		},
+		{
+			name:     "without tls",
+			opts:     []flclient.Options{flclient.WithBasicAuth("secret")},
+			expected: codes.Unavailable,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			client, err := flclient.NewClient(address, tc.opts...)
+			g.Expect(err).NotTo(HaveOccurred())
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+
+			_, err = client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{})
+			g.Expect(status.Code(err)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	server, address := startServer(g)
+	defer server.Stop()
+
+	client, err := flclient.NewClient(address)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer client.Close()
+
+	unavailable := status.Error(codes.Unavailable, "host is flapping")
+
+	server.SetFaults(func(method string) flintlocksim.Fault {
+		switch method {
+		case flintlockv1.MicroVM_CreateMicroVM_FullMethodName:
+			return flintlocksim.Fault{Err: unavailable, Handled: true}
+		case flintlockv1.MicroVM_GetMicroVM_FullMethodName:
+			return flintlocksim.Fault{Delay: time.Minute}
+		case flintlockv1.MicroVM_ListMicroVMs_FullMethodName:
+			return flintlocksim.Fault{Err: unavailable}
+		default:
+			return flintlocksim.Fault{Err: unavailable, Handled: true}
+		}
+	})
+
+	// The microvm is created even though the response is lost.
+	_, err = client.CreateMicroVM(ctx, createRequest("mvm1"))
+	g.Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	_, err = client.CreateMicroVM(ctx, createRequest("mvm2"))
+	g.Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	g.Expect(server.MicroVMs()).To(HaveLen(2))
+
+	_, err = client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{})
+	g.Expect(status.Code(err)).To(Equal(codes.Unavailable))
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	_, err = client.GetMicroVM(timeoutCtx, &flintlockv1.GetMicroVMRequest{Uid: server.MicroVMs()[0].GetSpec().GetUid()})
+	g.Expect(status.Code(err)).To(Equal(codes.DeadlineExceeded))
+
+	stream, err := client.ListMicroVMsStream(ctx, &flintlockv1.ListMicroVMsRequest{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = stream.Recv()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = stream.Recv()
+	g.Expect(status.Code(err)).To(Equal(codes.Unavailable))
+
+	server.SetFaults(nil)
+
+	list, err := client.ListMicroVMs(ctx, &flintlockv1.ListMicroVMsRequest{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(list.GetMicrovm()).To(HaveLen(2))
+
+	uid := list.GetMicrovm()[0].GetSpec().GetUid()
+	g.Expect(server.SetState(uid, flintlocktypes.MicroVMStatus_PENDING)).To(Succeed())
+	g.Expect(getState(ctx, g, client, uid)).To(Equal(flintlocktypes.MicroVMStatus_PENDING))
+	g.Expect(server.ResetState(uid)).To(Succeed())
+	g.Expect(getState(ctx, g, client, uid)).To(Equal(flintlocktypes.MicroVMStatus_CREATED))
+}
@@ -0,0 +1,359 @@
+//go:build integration
+
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/test/flintlocksim"
+)
+
+const (
+	// chaosSeedEnv sets the seed of the chaos test so a failing run can be repeated.
+	chaosSeedEnv = "CHAOS_SEED"
+
+	chaosMachines      = 6
+	chaosEarlyDeletes  = 4
+	chaosRestarts      = 3
+	chaosErrorRate     = 0.2
+	chaosLostRate      = 0.5
+	chaosMaxDelay      = 300 * time.Millisecond
+	chaosFlapInterval  = 200 * time.Millisecond
+	chaosDeleteTimeout = 2 * time.Minute
+)
+
+// chaosStates are the states the microvms are forced into out of order.
+var chaosStates = []flintlocktypes.MicroVMStatus_MicroVMState{
+	flintlocktypes.MicroVMStatus_PENDING,
+	flintlocktypes.MicroVMStatus_FAILED,
+	flintlocktypes.MicroVMStatus_DELETING,
+}
+
+// chaos injects random faults into the requests to the simulated hosts and forces the microvms
+// into random states.
+type chaos struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newChaos(seed uint64) *chaos {
+	return &chaos{rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// fault delays every request and fails some of them. Half of the failed requests are handled by
+// the host, as if the response was lost.
+func (c *chaos) fault(method string) flintlocksim.Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fault := flintlocksim.Fault{
+		Delay: time.Duration(c.rand.Int64N(int64(chaosMaxDelay))),
+	}
+
+	if c.rand.Float64() < chaosErrorRate {
+		fault.Err = status.Errorf(codes.Unavailable, "injected fault for %s", method)
+		fault.Handled = c.rand.Float64() < chaosLostRate
+	}
+
+	return fault
+}
+
+// flapStates forces a random microvm in the namespace into a random state for a short time until
+// the context is cancelled.
+func (c *chaos) flapStates(ctx context.Context, namespace string) {
+	ticker := time.NewTicker(chaosFlapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		host := hosts[c.rand.IntN(len(hosts))]
+		state := chaosStates[c.rand.IntN(len(chaosStates))]
+		pick := c.rand.Int()
+		c.mu.Unlock()
+
+		microvms := microvmsInNamespace(host, namespace)
+		if len(microvms) == 0 {
+			continue
+		}
+
+		uid := microvms[pick%len(microvms)].GetSpec().GetUid()
+		if err := host.SetState(uid, state); err != nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(chaosFlapInterval / 2):
+		}
+
+		_ = host.ResetState(uid)
+	}
+}
+
+// checkInvariants returns the invariants that the machines in the namespace break:
+//   - a machine has at most one microvm across all the hosts.
+//   - the provider id of a machine that isn't being deleted is a microvm on the host in the id.
+func checkInvariants(ctx context.Context, namespace string) ([]string, error) {
+	mvmMachines := &infrav1.MicrovmMachineList{}
+	if err := k8sClient.List(ctx, mvmMachines, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	// The microvms are listed after the machines so any provider id is for a microvm that has
+	// already been created.
+	microvms := map[string][]string{}
+
+	for i, host := range hosts {
+		for _, mvm := range microvmsInNamespace(host, namespace) {
+			name := mvm.GetSpec().GetId()
+			microvms[name] = append(microvms[name], scope.ProviderPrefix+hostAddresses[i]+"/"+mvm.GetSpec().GetUid())
+		}
+	}
+
+	var violations []string
+
+	for _, mvmMachine := range mvmMachines.Items {
+		ids := microvms[mvmMachine.Name]
+		if len(ids) > 1 {
+			violations = append(violations, fmt.Sprintf("machine %s has %d microvms: %v", mvmMachine.Name, len(ids), ids))
+		}
+
+		providerID := mvmMachine.Spec.ProviderID
+		if providerID == nil || !mvmMachine.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		if !slices.Contains(ids, *providerID) {
+			violations = append(violations, fmt.Sprintf("machine %s has provider id %s without a microvm", mvmMachine.Name, *providerID))
+		}
+	}
+
+	return violations, nil
+}
+
+// invariantResults are the results of checking the invariants until the test is done.
+type invariantResults struct {
+	// checks is the number of times the invariants were checked.
+	checks int
+	// violations are the distinct invariants that were broken.
+	violations []string
+	// errs are the distinct errors of the checks that couldn't run.
+	errs []string
+}
+
+// checkInvariantsUntilDone checks the invariants of the machines in the namespace every interval
+// until the context is cancelled.
+func checkInvariantsUntilDone(ctx context.Context, namespace string) invariantResults {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	results := invariantResults{}
+	violations := map[string]bool{}
+	errs := map[string]bool{}
+
+	for {
+		found, err := checkInvariants(ctx, namespace)
+
+		switch {
+		case ctx.Err() != nil:
+			// The check was interrupted by the end of the test.
+		case err != nil:
+			errs[err.Error()] = true
+		default:
+			results.checks++
+
+			for _, violation := range found {
+				violations[violation] = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			results.violations = slices.Sorted(maps.Keys(violations))
+			results.errs = slices.Sorted(maps.Keys(errs))
+
+			return results
+		case <-ticker.C:
+		}
+	}
+}
+
+// hasMicrovm returns true if a microvm has been created for the machine on any of the hosts.
+func hasMicrovm(namespace, name string) bool {
+	for _, host := range hosts {
+		for _, mvm := range microvmsInNamespace(host, namespace) {
+			if mvm.GetSpec().GetId() == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// chaosSeed returns the seed from the environment or a new one.
+func chaosSeed(t *testing.T) uint64 {
+	t.Helper()
+
+	seed := uint64(time.Now().UnixNano())
+
+	if value := os.Getenv(chaosSeedEnv); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			t.Fatalf("invalid %s: %s", chaosSeedEnv, err)
+		}
+
+		seed = parsed
+	}
+
+	t.Logf("chaos seed %d, set %s to repeat the run", seed, chaosSeedEnv)
+
+	return seed
+}
+
+// TestChaosMachineLifecycle creates and deletes machines while the hosts are flapping, the
+// responses are lost or slow, the microvms change state out of order and the controllers are
+// restarted while they create the microvms. Some machines are deleted while the faults are active,
+// as soon as their microvms are created and before they're adopted. The invariants are checked
+// throughout and must have been checked successfully, every other machine must become ready once the
+// faults stop and no microvm may be left once they're deleted.
+func TestChaosMachineLifecycle(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	c := newChaos(chaosSeed(t))
+
+	namespace := createNamespace(t, g)
+	cluster, _ := createCluster(g, namespace, false)
+	markInfrastructureReady(g, cluster)
+
+	for _, host := range hosts {
+		host.SetFaults(c.fault)
+	}
+
+	t.Cleanup(func() {
+		for _, host := range hosts {
+			host.SetFaults(nil)
+		}
+	})
+
+	flapCtx, stopFlapping := context.WithCancel(ctx)
+	defer stopFlapping()
+
+	go c.flapStates(flapCtx, namespace)
+
+	checkCtx, stopChecking := context.WithCancel(ctx)
+	checked := make(chan invariantResults)
+
+	go func() {
+		checked <- checkInvariantsUntilDone(checkCtx, namespace)
+	}()
+
+	// Restart the controllers after every few machines, once they've started reconciling the last
+	// one, so they're restarted while they're creating the microvms.
+	mvmMachines := []*infrav1.MicrovmMachine{}
+	perRestart := chaosMachines / chaosRestarts
+
+	for i := range chaosMachines {
+		name := fmt.Sprintf("machine%d", i)
+		mvmMachine := createMachine(g, cluster, name, hostAddresses[i%len(hostAddresses)])
+		mvmMachines = append(mvmMachines, mvmMachine)
+
+		if (i+1)%perRestart == 0 {
+			g.Eventually(func(g Gomega) {
+				g.Expect(getMicrovmMachine(g, mvmMachine).Finalizers).To(ContainElement(infrav1.MachineFinalizer))
+			}, timeout, interval).Should(Succeed(), "machine %s wasn't reconciled", mvmMachine.Name)
+
+			restartManager(t)
+		}
+	}
+
+	// Delete machines as soon as their microvm has been created while the faults are still active,
+	// so some are deleted after their microvm is created but before its provider id is saved.
+	deletedEarly := []*infrav1.MicrovmMachine{}
+
+	for i := range chaosEarlyDeletes {
+		name := fmt.Sprintf("early%d", i)
+		mvmMachine := createMachine(g, cluster, name, hostAddresses[i%len(hostAddresses)])
+		deletedEarly = append(deletedEarly, mvmMachine)
+
+		g.Eventually(func() bool { return hasMicrovm(namespace, name) }, requeueTimeout, interval).
+			Should(BeTrue(), "microvm of machine %s wasn't created", name)
+		g.Expect(k8sClient.Delete(ctx, mvmMachine)).To(Succeed())
+
+		if i == chaosEarlyDeletes/2 {
+			restartManager(t)
+		}
+	}
+
+	// Keep the faults active until every machine has had its microvm created through them.
+	for _, mvmMachine := range mvmMachines {
+		g.Eventually(func() bool { return hasMicrovm(namespace, mvmMachine.Name) }, chaosDeleteTimeout, interval).
+			Should(BeTrue(), "microvm of machine %s wasn't created", mvmMachine.Name)
+	}
+
+	stopFlapping()
+
+	for _, host := range hosts {
+		host.SetFaults(nil)
+
+		for _, mvm := range microvmsInNamespace(host, namespace) {
+			_ = host.ResetState(mvm.GetSpec().GetUid())
+		}
+	}
+
+	for _, mvmMachine := range mvmMachines {
+		g.Eventually(func(g Gomega) {
+			g.Expect(getMicrovmMachine(g, mvmMachine).Status.Ready).To(BeTrue())
+		}, requeueTimeout, interval).Should(Succeed(), "machine %s didn't become ready", mvmMachine.Name)
+	}
+
+	for _, mvmMachine := range mvmMachines {
+		g.Expect(k8sClient.Delete(ctx, mvmMachine)).To(Succeed())
+	}
+
+	for _, mvmMachine := range append(deletedEarly, mvmMachines...) {
+		g.Eventually(isDeleted(mvmMachine), chaosDeleteTimeout, interval).Should(BeTrue(),
+			"finalizer of machine %s wasn't removed", mvmMachine.Name)
+	}
+
+	stopChecking()
+
+	results := <-checked
+	g.Expect(results.errs).To(BeEmpty(), "invariants couldn't be checked:\n%s", strings.Join(results.errs, "\n"))
+	g.Expect(results.checks).To(BeNumerically(">", 0), "invariants were never checked")
+	g.Expect(results.violations).To(BeEmpty(), "invariants broken:\n%s", strings.Join(results.violations, "\n"))
+
+	// The deleted microvms are removed by the hosts straight away.
+	for _, host := range hosts {
+		g.Expect(microvmsInNamespace(host, namespace)).To(BeEmpty(), "microvms were left on the host")
+	}
+}
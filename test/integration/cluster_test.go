@@ -0,0 +1,78 @@
+//go:build integration
+
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package integration_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+func TestClusterReady(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	namespace := createNamespace(t, g)
+	_, mvmCluster := createCluster(g, namespace, false)
+
+	g.Eventually(func(g Gomega) {
+		g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(mvmCluster), mvmCluster)).To(Succeed())
+		g.Expect(mvmCluster.Status.Ready).To(BeTrue())
+		g.Expect(mvmCluster.Finalizers).To(ContainElement(infrav1.ClusterFinalizer))
+		g.Expect(conditions.IsTrue(mvmCluster, infrav1.LoadBalancerAvailableCondition)).To(BeTrue())
+	}, timeout, interval).Should(Succeed())
+
+	g.Expect(mvmCluster.Status.FailureDomains).To(HaveLen(len(hostAddresses)))
+
+	for _, address := range hostAddresses {
+		g.Expect(mvmCluster.Status.FailureDomains).To(HaveKey(address))
+		g.Expect(mvmCluster.Status.FailureDomains[address].ControlPlane).To(BeTrue())
+	}
+}
+
+func TestClusterWebhookRejectsInvalidSpec(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	namespace := createNamespace(t, g)
+
+	mvmCluster := &infrav1.MicrovmCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testClusterName,
+			Namespace: namespace,
+		},
+		Spec: infrav1.MicrovmClusterSpec{
+			MicrovmProxy: &infrav1.MicrovmProxy{Endpoint: "not a url"},
+		},
+	}
+
+	err := k8sClient.Create(ctx, mvmCluster)
+	g.Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected the webhook to reject the cluster: %v", err)
+}
+
+func TestClusterDelete(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	namespace := createNamespace(t, g)
+	_, mvmCluster := createCluster(g, namespace, false)
+
+	g.Eventually(func(g Gomega) {
+		g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(mvmCluster), mvmCluster)).To(Succeed())
+		g.Expect(mvmCluster.Finalizers).To(ContainElement(infrav1.ClusterFinalizer))
+	}, timeout, interval).Should(Succeed())
+
+	g.Expect(k8sClient.Delete(ctx, mvmCluster)).To(Succeed())
+	g.Eventually(isDeleted(mvmCluster), timeout, interval).Should(BeTrue())
+}
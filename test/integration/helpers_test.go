@@ -0,0 +1,254 @@
+//go:build integration
+
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package integration_test
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/liquidmetal-dev/controller-pkg/types/microvm"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/test/flintlocksim"
+)
+
+const testClusterName = "cluster1"
+
+// createNamespace creates a namespace for a test, which is deleted when the test finishes.
+func createNamespace(t *testing.T, g *WithT) string {
+	t.Helper()
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "capmvm-it-"}}
+	g.Expect(k8sClient.Create(context.Background(), ns)).To(Succeed())
+
+	t.Cleanup(func() {
+		_ = k8sClient.Delete(context.Background(), ns)
+	})
+
+	return ns.Name
+}
+
+// createCluster creates a Cluster and a MicrovmCluster placed on all the simulated hosts.
+func createCluster(g *WithT, namespace string, paused bool) (*clusterv1.Cluster, *infrav1.MicrovmCluster) {
+	ctx := context.Background()
+
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testClusterName,
+			Namespace: namespace,
+		},
+		Spec: clusterv1.ClusterSpec{
+			Paused: paused,
+			InfrastructureRef: &corev1.ObjectReference{
+				APIVersion: infrav1.GroupVersion.String(),
+				Kind:       "MicrovmCluster",
+				Name:       testClusterName,
+				Namespace:  namespace,
+			},
+		},
+	}
+	g.Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+
+	hostSpecs := make([]infrav1.MicrovmHost, 0, len(hostAddresses))
+	for _, address := range hostAddresses {
+		hostSpecs = append(hostSpecs, infrav1.MicrovmHost{
+			Endpoint:            address,
+			ControlPlaneAllowed: true,
+		})
+	}
+
+	mvmCluster := &infrav1.MicrovmCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testClusterName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				clusterv1.ClusterNameLabel: testClusterName,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: clusterv1.GroupVersion.String(),
+					Kind:       "Cluster",
+					Name:       cluster.Name,
+					UID:        cluster.UID,
+				},
+			},
+		},
+		Spec: infrav1.MicrovmClusterSpec{
+			ControlPlaneEndpoint: clusterv1.APIEndpoint{
+				Host: "192.168.8.15",
+				Port: 6443,
+			},
+			Placement: infrav1.Placement{
+				StaticPool: &infrav1.StaticPoolPlacement{
+					Hosts: hostSpecs,
+				},
+			},
+		},
+	}
+	g.Expect(k8sClient.Create(ctx, mvmCluster)).To(Succeed())
+
+	return cluster, mvmCluster
+}
+
+// markInfrastructureReady does the job of the CAPI cluster controller once the MicrovmCluster
+// is ready, so the machines can be created.
+func markInfrastructureReady(g *WithT, cluster *clusterv1.Cluster) {
+	ctx := context.Background()
+	mvmCluster := &infrav1.MicrovmCluster{}
+
+	g.Eventually(func(g Gomega) {
+		g.Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: testClusterName}, mvmCluster)).
+			To(Succeed())
+		g.Expect(mvmCluster.Status.Ready).To(BeTrue())
+	}, timeout, interval).Should(Succeed())
+
+	g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(cluster), cluster)).To(Succeed())
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	cluster.Status.InfrastructureReady = true
+	cluster.Status.FailureDomains = mvmCluster.Status.FailureDomains
+	g.Expect(k8sClient.Status().Patch(ctx, cluster, patch)).To(Succeed())
+}
+
+// createMachine creates a Machine with its bootstrap data and a MicrovmMachine. The failure
+// domain is picked by the provider if it's empty.
+func createMachine(g *WithT, cluster *clusterv1.Cluster, name, failureDomain string) *infrav1.MicrovmMachine {
+	return createMachineWithLabels(g, cluster, name, failureDomain, nil)
+}
+
+// createMachineWithLabels creates a machine like createMachine with the labels added to both the
+// Machine and the MicrovmMachine.
+func createMachineWithLabels(
+	g *WithT,
+	cluster *clusterv1.Cluster,
+	name, failureDomain string,
+	extraLabels map[string]string,
+) *infrav1.MicrovmMachine {
+	ctx := context.Background()
+
+	labels := map[string]string{
+		clusterv1.ClusterNameLabel: cluster.Name,
+	}
+
+	for k, v := range extraLabels {
+		labels[k] = v
+	}
+
+	bootstrap := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-bootstrap",
+			Namespace: cluster.Namespace,
+		},
+		Data: map[string][]byte{
+			"value": []byte("#cloud-config\n"),
+		},
+	}
+	g.Expect(k8sClient.Create(ctx, bootstrap)).To(Succeed())
+
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cluster.Namespace,
+			Labels:    labels,
+		},
+		Spec: clusterv1.MachineSpec{
+			ClusterName: cluster.Name,
+			Bootstrap: clusterv1.Bootstrap{
+				DataSecretName: ptr.To(bootstrap.Name),
+			},
+			InfrastructureRef: corev1.ObjectReference{
+				APIVersion: infrav1.GroupVersion.String(),
+				Kind:       "MicrovmMachine",
+				Name:       name,
+				Namespace:  cluster.Namespace,
+			},
+		},
+	}
+
+	if failureDomain != "" {
+		machine.Spec.FailureDomain = ptr.To(failureDomain)
+	}
+
+	g.Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+
+	mvmMachine := &infrav1.MicrovmMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cluster.Namespace,
+			Labels:    maps.Clone(labels),
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: clusterv1.GroupVersion.String(),
+					Kind:       "Machine",
+					Name:       machine.Name,
+					UID:        machine.UID,
+				},
+			},
+		},
+		Spec: infrav1.MicrovmMachineSpec{
+			VMSpec: microvm.VMSpec{
+				VCPU:     2,
+				MemoryMb: 2048,
+				RootVolume: microvm.Volume{
+					Image: "docker.io/richardcase/ubuntu-bionic-test:cloudimage_v0.0.1",
+				},
+				Kernel: microvm.ContainerFileSource{
+					Image:    "docker.io/richardcase/ubuntu-bionic-kernel:0.0.11",
+					Filename: "vmlinuz",
+				},
+				NetworkInterfaces: []microvm.NetworkInterface{
+					{
+						GuestDeviceName: "eth0",
+						Type:            microvm.IfaceTypeMacvtap,
+					},
+				},
+			},
+		},
+	}
+	g.Expect(k8sClient.Create(ctx, mvmMachine)).To(Succeed())
+
+	return mvmMachine
+}
+
+// getMicrovmMachine gets the latest version of the MicrovmMachine.
+func getMicrovmMachine(g Gomega, mvmMachine *infrav1.MicrovmMachine) *infrav1.MicrovmMachine {
+	latest := &infrav1.MicrovmMachine{}
+	g.Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(mvmMachine), latest)).To(Succeed())
+
+	return latest
+}
+
+// isDeleted returns true once the object has been removed from the API server.
+func isDeleted(obj client.Object) func() bool {
+	return func() bool {
+		err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(obj), obj)
+
+		return apierrors.IsNotFound(err)
+	}
+}
+
+// microvmsInNamespace returns the microvms on the host that were created for the namespace.
+func microvmsInNamespace(host *flintlocksim.Server, namespace string) []*flintlocktypes.MicroVM {
+	result := []*flintlocktypes.MicroVM{}
+
+	for _, mvm := range host.MicroVMs() {
+		if mvm.GetSpec().GetNamespace() == namespace {
+			result = append(result, mvm)
+		}
+	}
+
+	return result
+}
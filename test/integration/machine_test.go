@@ -0,0 +1,160 @@
+//go:build integration
+
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	flintlockv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+	flintlocktypes "github.com/liquidmetal-dev/flintlock/api/types"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/scope"
+)
+
+// requeueTimeout is long enough for the controllers to requeue a machine that is waiting for
+// its microvm to change.
+const requeueTimeout = 45 * time.Second
+
+func TestMachineCreate(t *testing.T) {
+	g := NewWithT(t)
+
+	namespace := createNamespace(t, g)
+	cluster, _ := createCluster(g, namespace, false)
+	markInfrastructureReady(g, cluster)
+
+	mvmMachine := createMachine(g, cluster, "machine1", hostAddresses[0])
+
+	g.Eventually(func(g Gomega) {
+		latest := getMicrovmMachine(g, mvmMachine)
+		g.Expect(latest.Status.Ready).To(BeTrue())
+		g.Expect(latest.Status.FailureDomain).To(Equal(hostAddresses[0]))
+		g.Expect(conditions.IsTrue(latest, infrav1.MicrovmReadyCondition)).To(BeTrue())
+		g.Expect(latest.Finalizers).To(ContainElement(infrav1.MachineFinalizer))
+	}, timeout, interval).Should(Succeed())
+
+	microvms := microvmsInNamespace(hosts[0], namespace)
+	g.Expect(microvms).To(HaveLen(1))
+	g.Expect(microvms[0].GetSpec().GetId()).To(Equal("machine1"))
+	g.Expect(microvms[0].GetSpec().GetMetadata()).To(HaveKey("user-data"))
+
+	latest := getMicrovmMachine(g, mvmMachine)
+	g.Expect(latest.Spec.ProviderID).To(HaveValue(Equal(
+		fmt.Sprintf("%s%s/%s", scope.ProviderPrefix, hostAddresses[0], microvms[0].GetSpec().GetUid()),
+	)))
+}
+
+func TestMachineFailureDomainSpread(t *testing.T) {
+	g := NewWithT(t)
+
+	namespace := createNamespace(t, g)
+	cluster, _ := createCluster(g, namespace, false)
+	markInfrastructureReady(g, cluster)
+
+	machinesPerHost := 2
+	mvmMachines := []*infrav1.MicrovmMachine{}
+
+	for i := range machinesPerHost * len(hostAddresses) {
+		failureDomain := hostAddresses[i%len(hostAddresses)]
+		mvmMachines = append(mvmMachines, createMachine(g, cluster, fmt.Sprintf("machine%d", i), failureDomain))
+	}
+
+	for i, mvmMachine := range mvmMachines {
+		g.Eventually(func(g Gomega) {
+			latest := getMicrovmMachine(g, mvmMachine)
+			g.Expect(latest.Status.Ready).To(BeTrue())
+			g.Expect(latest.Spec.ProviderID).To(HaveValue(HavePrefix(
+				scope.ProviderPrefix + hostAddresses[i%len(hostAddresses)] + "/",
+			)))
+		}, timeout, interval).Should(Succeed())
+	}
+
+	for _, host := range hosts {
+		g.Expect(microvmsInNamespace(host, namespace)).To(HaveLen(machinesPerHost))
+	}
+}
+
+func TestMachinePauseAndUnpause(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	namespace := createNamespace(t, g)
+	cluster, _ := createCluster(g, namespace, false)
+	markInfrastructureReady(g, cluster)
+
+	g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(cluster), cluster)).To(Succeed())
+	patch := client.MergeFrom(cluster.DeepCopy())
+	cluster.Spec.Paused = true
+	g.Expect(k8sClient.Patch(ctx, cluster, patch)).To(Succeed())
+
+	mvmMachine := createMachine(g, cluster, "machine1", hostAddresses[0])
+
+	g.Consistently(func(g Gomega) {
+		latest := getMicrovmMachine(g, mvmMachine)
+		g.Expect(latest.Finalizers).To(BeEmpty())
+		g.Expect(microvmsInNamespace(hosts[0], namespace)).To(BeEmpty())
+	}, 3*time.Second, interval).Should(Succeed())
+
+	g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(cluster), cluster)).To(Succeed())
+	patch = client.MergeFrom(cluster.DeepCopy())
+	cluster.Spec.Paused = false
+	g.Expect(k8sClient.Patch(ctx, cluster, patch)).To(Succeed())
+
+	g.Eventually(func(g Gomega) {
+		latest := getMicrovmMachine(g, mvmMachine)
+		g.Expect(latest.Status.Ready).To(BeTrue())
+		g.Expect(microvmsInNamespace(hosts[0], namespace)).To(HaveLen(1))
+	}, timeout, interval).Should(Succeed())
+}
+
+func TestMachineDelete(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	namespace := createNamespace(t, g)
+	cluster, _ := createCluster(g, namespace, false)
+	markInfrastructureReady(g, cluster)
+
+	deleted := createMachine(g, cluster, "machine1", hostAddresses[0])
+	held := createMachine(g, cluster, "machine2", hostAddresses[1])
+
+	for _, mvmMachine := range []*infrav1.MicrovmMachine{deleted, held} {
+		g.Eventually(func(g Gomega) {
+			g.Expect(getMicrovmMachine(g, mvmMachine).Status.Ready).To(BeTrue())
+		}, timeout, interval).Should(Succeed())
+	}
+
+	// The microvm is removed straight away so the finalizer is removed without waiting.
+	g.Expect(k8sClient.Delete(ctx, deleted)).To(Succeed())
+	g.Eventually(isDeleted(deleted), timeout, interval).Should(BeTrue())
+	g.Expect(microvmsInNamespace(hosts[0], namespace)).To(BeEmpty())
+
+	// A microvm that is stuck deleting keeps the finalizer on the machine.
+	microvms := microvmsInNamespace(hosts[1], namespace)
+	g.Expect(microvms).To(HaveLen(1))
+
+	uid := microvms[0].GetSpec().GetUid()
+	g.Expect(hosts[1].SetState(uid, flintlocktypes.MicroVMStatus_DELETING)).To(Succeed())
+	g.Expect(k8sClient.Delete(ctx, held)).To(Succeed())
+
+	g.Consistently(func(g Gomega) {
+		latest := getMicrovmMachine(g, held)
+		g.Expect(latest.DeletionTimestamp).NotTo(BeNil())
+		g.Expect(latest.Finalizers).To(ContainElement(infrav1.MachineFinalizer))
+	}, 3*time.Second, interval).Should(Succeed())
+
+	_, err := hosts[1].DeleteMicroVM(ctx, &flintlockv1.DeleteMicroVMRequest{Uid: uid})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Eventually(isDeleted(held), requeueTimeout, interval).Should(BeTrue())
+}
@@ -0,0 +1,76 @@
+//go:build integration
+
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+package integration_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+)
+
+func TestPlacementControlPlaneConstraints(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	namespace := createNamespace(t, g)
+	cluster, mvmCluster := createCluster(g, namespace, false)
+
+	// Only the second host is used for the control plane, and only for one machine.
+	g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(mvmCluster), mvmCluster)).To(Succeed())
+	patch := client.MergeFrom(mvmCluster.DeepCopy())
+	pool := mvmCluster.Spec.Placement.StaticPool
+	pool.Hosts[1].Labels = map[string]string{"role": "controlplane"}
+	pool.ControlPlaneHostSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"role": "controlplane"}}
+	pool.MaxControlPlanePerHost = ptr.To[int32](1)
+	pool.SeparateWorkers = true
+	g.Expect(k8sClient.Patch(ctx, mvmCluster, patch)).To(Succeed())
+
+	g.Eventually(func(g Gomega) {
+		g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(mvmCluster), mvmCluster)).To(Succeed())
+		g.Expect(mvmCluster.Status.FailureDomains[hostAddresses[0]].ControlPlane).To(BeFalse())
+		g.Expect(mvmCluster.Status.FailureDomains[hostAddresses[1]].ControlPlane).To(BeTrue())
+	}, timeout, interval).Should(Succeed())
+
+	markInfrastructureReady(g, cluster)
+
+	controlPlaneLabels := map[string]string{clusterv1.MachineControlPlaneLabel: ""}
+
+	worker := createMachine(g, cluster, "worker1", "")
+	controlPlane := createMachineWithLabels(g, cluster, "controlplane1", "", controlPlaneLabels)
+
+	for mvmMachine, host := range map[*infrav1.MicrovmMachine]string{
+		worker:       hostAddresses[0],
+		controlPlane: hostAddresses[1],
+	} {
+		g.Eventually(func(g Gomega) {
+			latest := getMicrovmMachine(g, mvmMachine)
+			g.Expect(latest.Status.Ready).To(BeTrue())
+			g.Expect(latest.Status.FailureDomain).To(Equal(host))
+		}, timeout, interval).Should(Succeed())
+	}
+
+	// The only control plane host already has the maximum number of control plane machines.
+	blocked := createMachineWithLabels(g, cluster, "controlplane2", "", controlPlaneLabels)
+
+	g.Eventually(func(g Gomega) {
+		latest := getMicrovmMachine(g, blocked)
+		g.Expect(latest.Status.FailureDomain).To(BeEmpty())
+		g.Expect(conditions.GetReason(latest, infrav1.MicrovmReadyCondition)).To(Equal(infrav1.MicrovmPlacementFailedReason))
+	}, timeout, interval).Should(Succeed())
+
+	// A machine that was never placed can still be deleted.
+	g.Expect(k8sClient.Delete(ctx, blocked)).To(Succeed())
+	g.Eventually(isDeleted(blocked), timeout, interval).Should(BeTrue())
+}
@@ -0,0 +1,301 @@
+//go:build integration
+
+// Copyright 2021 Weaveworks or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package integration_test runs the controllers and webhooks against a real API server started
+// with envtest and flintlock hosts simulated with flintlocksim.
+package integration_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expclusterv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	infrav1 "github.com/liquidmetal-dev/cluster-api-provider-microvm/api/v1alpha1"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/controllers"
+	flclient "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/flintlock"
+	webhookMicro "github.com/liquidmetal-dev/cluster-api-provider-microvm/internal/webhook"
+	"github.com/liquidmetal-dev/cluster-api-provider-microvm/test/flintlocksim"
+)
+
+const (
+	// numHosts is the number of simulated flintlock hosts each cluster is placed on.
+	numHosts = 2
+
+	timeout  = 30 * time.Second
+	interval = 250 * time.Millisecond
+)
+
+var (
+	scheme = runtime.NewScheme()
+
+	// k8sClient reads straight from the API server so the tests don't see stale objects.
+	k8sClient client.Client
+
+	// hosts are the simulated flintlock hosts and hostAddresses are their endpoints.
+	hosts         []*flintlocksim.Server
+	hostAddresses []string
+
+	suiteCtx    context.Context
+	restConfig  *rest.Config
+	webhookOpts envtest.WebhookInstallOptions
+	stopManager func()
+)
+
+func init() {
+	_ = infrav1.AddToScheme(scheme)
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = clusterv1.AddToScheme(scheme)
+	_ = expclusterv1.AddToScheme(scheme)
+	_ = ipamv1.AddToScheme(scheme)
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(runSuite(m))
+}
+
+func runSuite(m *testing.M) int {
+	ctrl.SetLogger(klog.Background())
+
+	code, err := setupSuite(m)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "integration suite: %s\n", err)
+
+		return 1
+	}
+
+	return code
+}
+
+func setupSuite(m *testing.M) (int, error) {
+	capiDir, err := moduleDir("sigs.k8s.io/cluster-api")
+	if err != nil {
+		return 0, err
+	}
+
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "config", "crd", "bases"),
+			filepath.Join(capiDir, "config", "crd", "bases"),
+		},
+		ErrorIfCRDPathMissing: true,
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			Paths: []string{filepath.Join("..", "..", "config", "webhook")},
+		},
+	}
+
+	cfg, err := testEnv.Start()
+	if err != nil {
+		return 0, fmt.Errorf("starting test environment: %w", err)
+	}
+
+	defer func() {
+		_ = testEnv.Stop()
+	}()
+
+	for range numHosts {
+		server := flintlocksim.New()
+
+		address, err := server.Start("127.0.0.1:0")
+		if err != nil {
+			return 0, fmt.Errorf("starting flintlock host: %w", err)
+		}
+		defer server.Stop()
+
+		hosts = append(hosts, server)
+		hostAddresses = append(hostAddresses, address)
+	}
+
+	ctx, cancel := context.WithCancel(ctrl.SetupSignalHandler())
+	defer cancel()
+
+	suiteCtx = ctx
+	restConfig = cfg
+	webhookOpts = testEnv.WebhookInstallOptions
+
+	stopManager, err = startManager(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		stopManager()
+	}()
+
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return 0, fmt.Errorf("creating client: %w", err)
+	}
+
+	// The API server of the workload clusters is checked by listing its nodes.
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	if err := k8sClient.Create(ctx, node); err != nil {
+		return 0, fmt.Errorf("creating node: %w", err)
+	}
+
+	return m.Run(), nil
+}
+
+// startManager starts a manager running the controllers and webhooks. The returned function
+// stops the manager and waits for it to finish.
+func startManager(ctx context.Context) (func(), error) {
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:  scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    webhookOpts.LocalServingHost,
+			Port:    webhookOpts.LocalServingPort,
+			CertDir: webhookOpts.LocalServingCertDir,
+		}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating manager: %w", err)
+	}
+
+	if err := setupManager(ctx, mgr); err != nil {
+		return nil, err
+	}
+
+	mgrCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if err := mgr.Start(mgrCtx); err != nil {
+			fmt.Fprintf(os.Stderr, "running manager: %s\n", err)
+		}
+	}()
+
+	stop := func() {
+		cancel()
+		<-done
+	}
+
+	if err := waitForWebhooks(webhookOpts); err != nil {
+		stop()
+
+		return nil, err
+	}
+
+	return stop, nil
+}
+
+// restartManager stops the running manager and starts a new one, as if the controller pod was
+// restarted. Anything the controllers were doing is abandoned.
+func restartManager(t *testing.T) {
+	t.Helper()
+
+	stopManager()
+
+	stop, err := startManager(suiteCtx)
+	if err != nil {
+		t.Fatalf("restarting manager: %s", err)
+	}
+
+	stopManager = stop
+}
+
+// setupManager adds the controllers and webhooks to the manager. The workload clusters are
+// the test environment itself.
+func setupManager(ctx context.Context, mgr ctrl.Manager) error {
+	options := controller.Options{
+		RecoverPanic: ptr.To(true),
+		// The controllers are created again when the manager is restarted.
+		SkipNameValidation: ptr.To(true),
+	}
+
+	remoteClient := func(_ context.Context, _ string, _ client.Client, _ client.ObjectKey) (client.Client, error) {
+		return mgr.GetClient(), nil
+	}
+
+	if err := (&controllers.MicrovmClusterReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor("microvmcluster-controller"),
+		MvmClientFunc:      flclient.NewClient,
+		RemoteClientGetter: remoteClient,
+	}).SetupWithManager(ctx, mgr, options); err != nil {
+		return fmt.Errorf("creating microvm cluster controller: %w", err)
+	}
+
+	if err := (&controllers.MicrovmMachineReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Recorder:      mgr.GetEventRecorderFor("microvmmachine-controller"),
+		MvmClientFunc: flclient.NewClient,
+	}).SetupWithManager(ctx, mgr, options); err != nil {
+		return fmt.Errorf("creating microvm machine controller: %w", err)
+	}
+
+	if err := (&webhookMicro.MicrovmCluster{}).SetupWebhookWithManager(mgr); err != nil {
+		return fmt.Errorf("creating microvm cluster webhook: %w", err)
+	}
+
+	if err := (&webhookMicro.MicrovmMachine{}).SetupWebhookWithManager(mgr); err != nil {
+		return fmt.Errorf("creating microvm machine webhook: %w", err)
+	}
+
+	if err := (&webhookMicro.MicrovmMachineTemplate{}).SetupWebhookWithManager(mgr); err != nil {
+		return fmt.Errorf("creating microvm machine template webhook: %w", err)
+	}
+
+	if err := (&webhookMicro.MicrovmMachinePool{}).SetupWebhookWithManager(mgr); err != nil {
+		return fmt.Errorf("creating microvm machine pool webhook: %w", err)
+	}
+
+	return nil
+}
+
+// waitForWebhooks waits until the webhook server accepts connections.
+func waitForWebhooks(opts envtest.WebhookInstallOptions) error {
+	address := net.JoinHostPort(opts.LocalServingHost, fmt.Sprint(opts.LocalServingPort))
+	dialer := &net.Dialer{Timeout: time.Second}
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(interval) {
+		//nolint:gosec // The webhook server uses a self-signed certificate.
+		conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			conn.Close()
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("webhook server at %s didn't start", address)
+}
+
+// moduleDir returns the directory of a module dependency, which contains its CRDs.
+func moduleDir(module string) (string, error) {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", module).Output()
+	if err != nil {
+		return "", fmt.Errorf("finding directory of %s: %w", module, err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}